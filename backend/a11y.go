@@ -0,0 +1,14 @@
+package main
+
+import "net/http"
+
+// clientPrefs reads the accessibility preferences a panel sends with each
+// request: motion=reduce (zoom-init.js sets it from prefers-reduced-motion,
+// browsers may also send the Sec-CH-Prefers-Reduced-Motion client hint) and
+// audio=off for panels that must stay silent.
+func clientPrefs(r *http.Request) (reducedMotion, muted bool) {
+	q := r.URL.Query()
+	reducedMotion = q.Get("motion") == "reduce" || r.Header.Get("Sec-CH-Prefers-Reduced-Motion") == "reduce"
+	muted = q.Get("audio") == "off"
+	return reducedMotion, muted
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TriggerEvent describes a room whose default poll just passed.
+type TriggerEvent struct {
+	Tenant       string    `json:"tenant,omitempty"`
+	MeetingID    string    `json:"meeting_id"`
+	RoomID       string    `json:"room_id"`
+	Participants int       `json:"participants"`
+	Votes        int       `json:"votes"`
+	At           time.Time `json:"at"`
+}
+
+// TriggerAction is one step run when a room's threshold fires. Rooms run
+// their configured actions in order; a failing action is logged and the
+// rest still run.
+type TriggerAction interface {
+	Run(ctx context.Context, ev TriggerEvent) error
+}
+
+// triggerActions are the actions a room can list, by name.
+var triggerActions = map[string]TriggerAction{
+	"ending":      showEndingScreen{},
+	"end_meeting": endZoomMeeting{},
+	"chat":        sendChat{},
+	"webhook":     callWebhook{},
+	"mqtt":        publishMQTT{},
+}
+
+// actionSettings are the deployment-wide action settings from Config.
+var actionSettings struct {
+	defaults   []string
+	webhookURL string
+	chatURL    string
+	mqttURL    string
+	mqttTopic  string
+}
+
+var actionHTTP = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	actionSettings.defaults = []string{"ending"}
+}
+
+// parseActionList splits a comma separated action list and rejects unknown
+// names.
+func parseActionList(s string) ([]string, error) {
+	names := splitList(s)
+	for _, name := range names {
+		if _, ok := triggerActions[name]; !ok {
+			return nil, fmt.Errorf("unknown trigger action %q", name)
+		}
+	}
+	return names, nil
+}
+
+// configureTriggerActions applies the action settings from cfg.
+func configureTriggerActions(cfg *Config) error {
+	defaults, err := parseActionList(cfg.TriggerActions)
+	if err != nil {
+		return err
+	}
+	actionSettings.defaults = defaults
+	actionSettings.webhookURL = cfg.TriggerWebhookURL
+	actionSettings.chatURL = cfg.ChatWebhookURL
+	actionSettings.mqttURL = cfg.MQTTURL
+	actionSettings.mqttTopic = cfg.MQTTTopic
+	return nil
+}
+
+// roomActions returns the room's action list, or the deployment default.
+func roomActions(ctx context.Context, roomID string) []string {
+	names, err := RoomActions(ctx, roomID)
+	if err != nil {
+		logf(ctx, "RoomActions error: %v", err)
+	}
+	if names == nil {
+		return actionSettings.defaults
+	}
+	return names
+}
+
+// showsEnding reports whether the room's actions include the ending screen.
+func showsEnding(actions []string) bool {
+	return slices.Contains(actions, "ending")
+}
+
+// runTriggerActions runs the room's actions in the background so the poll
+// that fired the trigger is not held up by slow webhooks.
+func runTriggerActions(ctx context.Context, zCtx *ZoomAuthContext, s *RoomSettings, participants, votes int) {
+	ev := TriggerEvent{
+		Tenant:       zCtx.Tenant,
+		MeetingID:    zCtx.Mid,
+		RoomID:       zCtx.RoomID(),
+		Participants: participants,
+		Votes:        votes,
+		At:           clock.Now(),
+	}
+	names := roomActions(ctx, ev.RoomID)
+	if slices.Contains(names, "end_meeting") && !s.feature(ev.RoomID, "end_meeting") {
+		logf(ctx, "end_meeting is disabled for this room, skipping it")
+		names = slices.DeleteFunc(slices.Clone(names), func(n string) bool { return n == "end_meeting" })
+	}
+	reqID := requestIDFrom(ctx)
+	logf(ctx, "Room triggered, running actions %v", names)
+	metrics.Add("rooms_triggered", 1)
+
+	// Recorded here rather than in the background, which must not read
+	// the store or the clock once the request is done
+	bg := context.WithValue(context.Background(), requestIDKey, reqID)
+	if err := RecordTrigger(bg, ev); err != nil {
+		logf(bg, "RecordTrigger error: %v", err)
+	}
+	countTrigger(bg, ev.Tenant, ev.At)
+
+	go runRecovered("trigger-actions", func() {
+		for _, name := range names {
+			actx, cancel := context.WithTimeout(bg, 15*time.Second)
+			err := triggerActions[name].Run(actx, ev)
+			cancel()
+			if err != nil {
+				metrics.Add("trigger_action_errors", 1)
+				logf(bg, "Trigger action %s failed: %v", name, err)
+			}
+		}
+	})
+}
+
+// showEndingScreen is rendered by the polling response itself: panels show
+// the ending screen once the room has triggered if this action is listed.
+type showEndingScreen struct{}
+
+func (showEndingScreen) Run(ctx context.Context, ev TriggerEvent) error { return nil }
+
+// endZoomMeeting ends the meeting for everyone through the Zoom API.
+type endZoomMeeting struct{}
+
+func (endZoomMeeting) Run(ctx context.Context, ev TriggerEvent) error {
+	api := zoomAPIFor(ev.Tenant)
+	if api == nil {
+		return fmt.Errorf("zoom api is not configured")
+	}
+	return api.put(ctx, "/meetings/"+escapeMeetingID(ev.MeetingID)+"/status", map[string]string{"action": "end"})
+}
+
+// postJSON posts payload as JSON. With a secret, the body's HMAC-SHA256 is
+// sent in X-Hotaru-Signature so receivers can verify it.
+func postJSON(ctx context.Context, url string, payload any, header http.Header, secret string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Hotaru-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := actionHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// sendChat posts a message to a Slack-compatible incoming webhook (Slack,
+// Zoom Team Chat incoming webhooks, Mattermost, ...).
+type sendChat struct{}
+
+func (sendChat) Run(ctx context.Context, ev TriggerEvent) error {
+	if actionSettings.chatURL == "" {
+		return fmt.Errorf("CHAT_WEBHOOK_URL is not set")
+	}
+	text := fmt.Sprintf("蛍の光が流れました（%d 人中 %d 人が帰りたい）", ev.Participants, ev.Votes)
+	return postJSON(ctx, actionSettings.chatURL, map[string]string{"text": text}, nil, "")
+}
+
+// callWebhook posts the TriggerEvent as JSON, signed with
+// TRIGGER_WEBHOOK_SECRET when set. The secret is read per call so provider
+// rotations apply.
+type callWebhook struct{}
+
+func (callWebhook) Run(ctx context.Context, ev TriggerEvent) error {
+	if actionSettings.webhookURL == "" {
+		return fmt.Errorf("TRIGGER_WEBHOOK_URL is not set")
+	}
+	return postJSON(ctx, actionSettings.webhookURL, ev, http.Header{"X-Hotaru-Event": {"room.triggered"}}, secretValue("TRIGGER_WEBHOOK_SECRET"))
+}
+
+// publishMQTT publishes the TriggerEvent to MQTT_TOPIC, with {room} replaced
+// by the room ID, e.g. to switch on office lights.
+type publishMQTT struct{}
+
+func (publishMQTT) Run(ctx context.Context, ev TriggerEvent) error {
+	if actionSettings.mqttURL == "" {
+		return fmt.Errorf("MQTT_URL is not set")
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	topic := strings.ReplaceAll(actionSettings.mqttTopic, "{room}", ev.RoomID)
+	return mqttPublish(ctx, actionSettings.mqttURL, topic, payload)
+}
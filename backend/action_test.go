@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestParseActionList(t *testing.T) {
+	names, err := parseActionList(" webhook, ending ,mqtt")
+	if err != nil || len(names) != 3 || names[0] != "webhook" || names[1] != "ending" {
+		t.Errorf("parseActionList = %v, %v", names, err)
+	}
+	if _, err := parseActionList("ending,fireworks"); err == nil {
+		t.Error("unknown action accepted")
+	}
+	if names, err := parseActionList(""); err != nil || names != nil {
+		t.Errorf("empty list = %v, %v", names, err)
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		readPacket := func() (byte, []byte) {
+			h, _ := r.ReadByte()
+			n, mul := 0, 1
+			for {
+				b, _ := r.ReadByte()
+				n += int(b&0x7f) * mul
+				mul *= 128
+				if b&0x80 == 0 {
+					break
+				}
+			}
+			body := make([]byte, n)
+			io.ReadFull(r, body)
+			return h, body
+		}
+		if h, _ := readPacket(); h != 0x10 {
+			t.Errorf("first packet %x, want CONNECT", h)
+			return
+		}
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		if h, body := readPacket(); h == 0x30 {
+			got <- body
+		}
+	}()
+
+	if err := mqttPublish(context.Background(), "tcp://"+ln.Addr().String(), "hotaru/r1/triggered", []byte(`{"votes":3}`)); err != nil {
+		t.Fatalf("mqttPublish: %v", err)
+	}
+	body := <-got
+	want := "\x00\x13hotaru/r1/triggered" + `{"votes":3}`
+	if string(body) != want {
+		t.Errorf("PUBLISH body %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hasAdminToken reports whether r has token as its bearer token.
+func hasAdminToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// adminToken is the admin token in effect: the secret provider's, looked
+// up on every request so a rotated token is taken without a restart, or
+// else the configured one. Empty disables the /admin/ endpoints.
+func adminToken(configured string) string {
+	if v, ok := providedSecrets.Load("ADMIN_TOKEN"); ok && v.(string) != "" {
+		return v.(string)
+	}
+	return configured
+}
+
+// adminOnly lets through requests with the admin token in effect, see
+// adminToken, as their bearer token.
+func adminOnly(configured string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := adminToken(configured)
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !hasAdminToken(r, token) {
+			metrics.Add("admin_unauthorized", 1)
+			httpError(w, r.Context(), "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logf(r.Context(), "Admin request: %s %s", r.Method, r.URL.Path)
+		next(w, r)
+	}
+}
+
+const redacted = "REDACTED"
+
+// redactURL keeps a URL's scheme and host and drops what could carry a
+// secret: the password, and for webhooks the path and query as well.
+func redactURL(s string, keepPath bool) string {
+	u, err := url.Parse(s)
+	if s == "" || err != nil {
+		return s
+	}
+	if !keepPath && (u.Path != "" || u.RawQuery != "") {
+		u.Path, u.RawPath, u.RawQuery = "/"+redacted, "", ""
+	}
+	return u.Redacted()
+}
+
+// redactURLList redacts each URL of a comma-separated list.
+func redactURLList(s string) string {
+	urls := splitList(s)
+	for i, u := range urls {
+		urls[i] = redactURL(u, true)
+	}
+	return strings.Join(urls, ",")
+}
+
+// redactRegionPeers redacts the Redis URL of each name=url pair.
+func redactRegionPeers(s string) string {
+	pairs := splitList(s)
+	for i, pair := range pairs {
+		if name, u, ok := strings.Cut(pair, "="); ok {
+			pairs[i] = name + "=" + redactURL(strings.TrimSpace(u), true)
+		} else {
+			pairs[i] = redacted
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// redactedConfig returns cfg with its secrets blanked out.
+func redactedConfig(cfg Config) Config {
+	cfg.RedisURL = redactURL(cfg.RedisURL, true)
+	cfg.RedisReplicaURLs = redactURLList(cfg.RedisReplicaURLs)
+	cfg.RegionPeers = redactRegionPeers(cfg.RegionPeers)
+	cfg.MQTTURL = redactURL(cfg.MQTTURL, true)
+	cfg.KafkaRESTURL = redactURL(cfg.KafkaRESTURL, true)
+	// Endpoints and addresses carry no secret of their own, but may have
+	// credentials written into them all the same
+	cfg.DynamoEndpoint = redactURL(cfg.DynamoEndpoint, true)
+	cfg.ArchiveURL = redactURL(cfg.ArchiveURL, true)
+	cfg.ArchiveEndpoint = redactURL(cfg.ArchiveEndpoint, true)
+	cfg.AssetBaseURL = redactURL(cfg.AssetBaseURL, true)
+	cfg.PublicURL = redactURL(cfg.PublicURL, true)
+	cfg.ReportSMTPURL = redactURL(cfg.ReportSMTPURL, true)
+	cfg.TriggerWebhookURL = redactURL(cfg.TriggerWebhookURL, false)
+	cfg.ChatWebhookURL = redactURL(cfg.ChatWebhookURL, false)
+	cfg.ReportChatWebhookURL = redactURL(cfg.ReportChatWebhookURL, false)
+	cfg.ICSURL = redactURL(cfg.ICSURL, false) // secret addresses carry a key in the path
+	if adminToken(cfg.AdminToken) != "" {
+		cfg.AdminToken = redacted
+	}
+	return cfg
+}
+
+// handleAdminConfig serves GET /admin/config: the configuration this
+// instance runs with, secrets redacted, and what it made of it, to tell
+// why one instance behaves differently from another. After a reload it
+// shows the reloaded configuration.
+func handleAdminConfig(started *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := started
+		if live := liveConfig.Load(); live != nil {
+			cfg = live
+		}
+		nTenants := 0
+		if list := tenants.Load(); list != nil {
+			nTenants = len(*list)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]any{
+			"config": redactedConfig(*cfg),
+			// Percent of rooms each feature flag is on for
+			"feature_flags":    featureRollout.Load(),
+			"default_settings": defaultSettings.Load(),
+			"trigger_actions":  actionSettings.defaults,
+			"store":            currentStore().Name(),
+			// Panels poll for state; there is no push channel to pick
+			"updates":     "polling",
+			"tenants":     nTenants,
+			"dev_bypass":  devBypass,
+			"environment": cfg.Environment,
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // time zones also work on images without zoneinfo
+)
+
+// AfterHoursStage lowers the room's threshold from From, a local time of
+// day as HH:MM, until the next stage or midnight. Meetings running late
+// need fewer votes to end.
+type AfterHoursStage struct {
+	From             string  `json:"from"`
+	ThresholdPercent float64 `json:"threshold_percent"`
+}
+
+// parseClock parses HH:MM into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("time %q: want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseAfterHours parses "18:00=40,20:00=30".
+func parseAfterHours(s string) ([]AfterHoursStage, error) {
+	var stages []AfterHoursStage
+	for _, item := range splitList(s) {
+		from, pct, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("after hours stage %q: want HH:MM=percent", item)
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil {
+			return nil, fmt.Errorf("after hours stage %q: bad percent", item)
+		}
+		stages = append(stages, AfterHoursStage{From: strings.TrimSpace(from), ThresholdPercent: p})
+	}
+	return stages, validateAfterHours(stages)
+}
+
+// validateAfterHours requires stages to start later and later in the day,
+// each with a threshold in (0, 100].
+func validateAfterHours(stages []AfterHoursStage) error {
+	prev := -1
+	for _, st := range stages {
+		from, err := parseClock(st.From)
+		if err != nil {
+			return fmt.Errorf("after hours stage: %w", err)
+		}
+		if st.ThresholdPercent <= 0 || st.ThresholdPercent > 100 {
+			return fmt.Errorf("after hours stage %s: threshold_percent must be in (0, 100]", st.From)
+		}
+		if from <= prev {
+			return fmt.Errorf("after hours stage %s: times must increase", st.From)
+		}
+		prev = from
+	}
+	return nil
+}
+
+var locations sync.Map // time zone name -> *time.Location
+
+// loadLocation is time.LoadLocation, cached: rooms look up their zone on
+// every poll.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+func validateTimeZone(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, err := loadLocation(name); err != nil {
+		return fmt.Errorf("time_zone %q: unknown time zone", name)
+	}
+	return nil
+}
+
+// location returns the time zone of the settings, or the server's.
+func (s *RoomSettings) location() *time.Location {
+	if s.TimeZone != "" {
+		if loc, err := loadLocation(s.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// thresholdAt returns the threshold in effect at now: ThresholdPercent, or
+// the latest after hours stage that started today if it is lower.
+func (s *RoomSettings) thresholdAt(now time.Time) float64 {
+	pct := s.ThresholdPercent
+	if len(s.AfterHours) == 0 {
+		return pct
+	}
+	local := now.In(s.location())
+	minute := local.Hour()*60 + local.Minute()
+	for i := len(s.AfterHours) - 1; i >= 0; i-- {
+		st := s.AfterHours[i]
+		if from, err := parseClock(st.From); err == nil && from <= minute {
+			return min(pct, st.ThresholdPercent)
+		}
+	}
+	return pct
+}
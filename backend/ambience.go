@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AmbienceStage changes the panel's mood once the gauge reaches Percent,
+// building up to the ending screen.
+type AmbienceStage struct {
+	Percent float64 `json:"percent"`
+	Name    string  `json:"name"` // key into ambienceStageNames
+}
+
+// ambienceStageNames are the stages templates/gauge.html can render.
+// Stages are cumulative, so the fireflies stay when the lights dim.
+var ambienceStageNames = map[string]bool{"fireflies": true, "dim": true}
+
+// animatedAmbience are left out for clients that asked for reduced motion.
+var animatedAmbience = map[string]bool{"fireflies": true}
+
+// parseAmbienceStages parses "25:fireflies,40:dim". Percentages must be
+// strictly increasing within (0, 100].
+func parseAmbienceStages(s string) ([]AmbienceStage, error) {
+	var stages []AmbienceStage
+	for _, item := range splitList(s) {
+		pct, name, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("ambience stage %q: want percent:name", item)
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil {
+			return nil, fmt.Errorf("ambience stage %q: bad percent", item)
+		}
+		stages = append(stages, AmbienceStage{Percent: p, Name: strings.TrimSpace(name)})
+	}
+	return stages, validateAmbienceStages(stages)
+}
+
+func validateAmbienceStages(stages []AmbienceStage) error {
+	for i, st := range stages {
+		if st.Percent <= 0 || st.Percent > 100 {
+			return fmt.Errorf("ambience stage %s: percent must be in (0, 100]", st.Name)
+		}
+		if !ambienceStageNames[st.Name] {
+			return fmt.Errorf("unknown ambience stage %q", st.Name)
+		}
+		if i > 0 && st.Percent <= stages[i-1].Percent {
+			return fmt.Errorf("ambience stage %s: percentages must increase", st.Name)
+		}
+	}
+	return nil
+}
+
+// generateAmbienceHTML renders every stage the gauge has reached. The
+// wrapper's data-stage names the latest one; it is empty below the first.
+func generateAmbienceHTML(stages []AmbienceStage, fill float64, reducedMotion bool) string {
+	var reached []string
+	for _, st := range stages {
+		if fill < st.Percent {
+			break
+		}
+		if reducedMotion && animatedAmbience[st.Name] {
+			continue
+		}
+		reached = append(reached, st.Name)
+	}
+	if len(reached) == 0 {
+		return ""
+	}
+	return renderFragment(defaultLocale, "ambience", map[string]any{
+		"Current": reached[len(reached)-1],
+		"Stages":  reached,
+	})
+}
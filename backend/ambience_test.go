@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAmbienceStages(t *testing.T) {
+	stages, err := parseAmbienceStages("20:fireflies, 45:dim")
+	if err != nil || len(stages) != 2 || stages[0].Percent != 20 || stages[1].Name != "dim" {
+		t.Errorf("parseAmbienceStages = %+v, %v", stages, err)
+	}
+
+	for _, bad := range []string{"40:dim,25:fireflies", "25:fireflies,25:dim", "0:dim", "120:dim", "25:disco", "fireflies"} {
+		if _, err := parseAmbienceStages(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestGenerateAmbienceHTML(t *testing.T) {
+	stages := []AmbienceStage{{25, "fireflies"}, {40, "dim"}}
+
+	if got := generateAmbienceHTML(stages, 10, false); got != "" {
+		t.Errorf("below the first stage: %q", got)
+	}
+	got := generateAmbienceHTML(stages, 30, false)
+	if !strings.Contains(got, `data-stage="fireflies"`) || strings.Contains(got, "ambience-dim") {
+		t.Errorf("fireflies stage: %q", got)
+	}
+	got = generateAmbienceHTML(stages, 40, false)
+	if !strings.Contains(got, `data-stage="dim"`) || !strings.Contains(got, "ambience-fireflies") {
+		t.Errorf("dim stage should keep the fireflies: %q", got)
+	}
+
+	// Reduced motion drops the animated fireflies but keeps the static dim
+	if got := generateAmbienceHTML(stages, 30, true); got != "" {
+		t.Errorf("reduced motion fireflies stage: %q", got)
+	}
+	got = generateAmbienceHTML(stages, 40, true)
+	if !strings.Contains(got, `data-stage="dim"`) || strings.Contains(got, "ambience-fireflies") {
+		t.Errorf("reduced motion dim stage: %q", got)
+	}
+}
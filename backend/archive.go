@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Room archival: the archive job bundles the history of rooms nobody has
+// used for ARCHIVE_IDLE (events, vote timeline, meeting report) into a
+// gzipped JSON object in S3 or GCS and then removes the room from the
+// store, instead of leaving it to expire. Objects are laid out by the day
+// they were archived, so the retention purge only lists old days.
+
+// archiveLookback is how many days past the retention the purge looks for
+// objects, so days missed while the job was not running are still removed.
+const archiveLookback = 7
+
+// archiveBatch is the most rooms one run archives; the rest wait for the
+// next run.
+const archiveBatch = 500
+
+// roomArchive writes closed rooms to an object store.
+type roomArchive struct {
+	store  objectStore
+	prefix string
+	// retention is how long objects are kept, 0 for ever
+	retention time.Duration
+	// idle is how long a room must go unused to count as closed
+	idle time.Duration
+}
+
+// archiver is set when ARCHIVE_URL is; rooms are then tracked for the
+// archive job.
+var archiver *roomArchive
+
+// startArchive opens the archive of ARCHIVE_URL, if set.
+func startArchive(cfg *Config) error {
+	if cfg.ArchiveURL == "" {
+		return nil
+	}
+	if cfg.ArchiveIdle <= 0 || cfg.ArchiveIdle >= roomTTL {
+		return fmt.Errorf("ARCHIVE_IDLE must be between 0 and %v", roomTTL)
+	}
+	if cfg.ArchiveRetention < 0 {
+		return fmt.Errorf("ARCHIVE_RETENTION must not be negative")
+	}
+	store, prefix, err := openObjectStore(cfg.ArchiveURL, cfg.ArchiveEndpoint)
+	if err != nil {
+		return fmt.Errorf("ARCHIVE_URL: %w", err)
+	}
+	archiver = &roomArchive{store: store, prefix: prefix, retention: cfg.ArchiveRetention, idle: cfg.ArchiveIdle}
+	log.Printf("Archiving rooms idle for %v to %s.", cfg.ArchiveIdle, cfg.ArchiveURL)
+	return nil
+}
+
+// archivedRoom is the content of an archive object.
+type archivedRoom struct {
+	Room       string         `json:"room"`
+	ArchivedAt time.Time      `json:"archived_at"`
+	OpenedAt   time.Time      `json:"opened_at,omitzero"`
+	FirstVote  time.Time      `json:"first_vote_at,omitzero"`
+	Events     []RoomEvent    `json:"events"`
+	Samples    []VoteSample   `json:"samples"`
+	Report     *MeetingReport `json:"report"`
+}
+
+// objectKey is where the room archived at t is written. Tenant rooms have
+// a slash in their ID, which is escaped to keep one object per room.
+func (a *roomArchive) objectKey(room string, t time.Time) string {
+	return a.prefix + t.UTC().Format("2006/01/02/") + url.PathEscape(room) + ".json.gz"
+}
+
+// runArchive archives and purges the rooms that went idle, then deletes
+// the objects past the retention. It does nothing without ARCHIVE_URL.
+func runArchive(ctx context.Context, _ *ScheduledJob) error {
+	a := archiver
+	if a == nil {
+		return nil
+	}
+	now := clock.Now()
+	rooms, err := ArchiveCandidates(ctx, now.Add(-a.idle), archiveBatch)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	archived := 0
+	for _, room := range rooms {
+		if err := a.archiveRoom(ctx, room, now); err != nil {
+			metrics.Add("archive_errors", 1)
+			errs = append(errs, fmt.Errorf("room %q: %w", room, err))
+			continue
+		}
+		archived++
+	}
+	metrics.Add("archive_rooms", int64(archived))
+	if archived > 0 {
+		log.Printf("Archived %d room(s)", archived)
+	}
+	if err := a.expire(ctx, now); err != nil {
+		metrics.Add("archive_errors", 1)
+		errs = append(errs, fmt.Errorf("retention: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// archiveRoom writes the room's history to the archive and purges it from
+// the store. Rooms that expired in the meantime are only forgotten.
+func (a *roomArchive) archiveRoom(ctx context.Context, room string, now time.Time) error {
+	r := archivedRoom{Room: room, ArchivedAt: now}
+	var err error
+	if r.OpenedAt, r.FirstVote, err = RoomTimeline(ctx, room); err != nil {
+		return err
+	}
+	if r.Events, err = RoomEventsSince(ctx, room, "0-0"); err != nil {
+		return err
+	}
+	if r.Samples, err = VoteSamples(ctx, room); err != nil {
+		return err
+	}
+	if r.Report, err = RoomReport(ctx, room); err != nil {
+		return err
+	}
+	if len(r.Events) > 0 || len(r.Samples) > 0 || r.Report != nil {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if err := json.NewEncoder(zw).Encode(&r); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if err := a.store.put(ctx, a.objectKey(room, now), buf.Bytes(), "application/gzip"); err != nil {
+			return err
+		}
+	}
+	return PurgeRoom(ctx, room)
+}
+
+// expire deletes the objects of the days past the retention.
+func (a *roomArchive) expire(ctx context.Context, now time.Time) error {
+	if a.retention <= 0 {
+		return nil
+	}
+	var errs []error
+	last := now.Add(-a.retention).UTC()
+	for i := 1; i <= archiveLookback; i++ {
+		day := a.prefix + last.AddDate(0, 0, -i).Format("2006/01/02/")
+		keys, err := a.store.list(ctx, day)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, day) {
+				continue
+			}
+			if err := a.store.delete(ctx, key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(keys) > 0 {
+			log.Printf("Deleted %d archived room(s) of %s", len(keys), strings.TrimPrefix(day, a.prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
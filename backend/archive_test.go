@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves the PUT, ListObjectsV2 and DELETE calls of s3Store for
+// one bucket, path-style.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/archive/")
+	if !ok && r.URL.Path != "/archive" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		var body bytes.Buffer
+		body.ReadFrom(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body.Bytes()) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body.Bytes()
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		var res s3ListResult
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				res.Contents = append(res.Contents, struct {
+					Key string `xml:"Key"`
+				}{k})
+			}
+		}
+		xml.NewEncoder(w).Encode(&res)
+	}
+}
+
+func TestArchiveRooms(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			fc := useFakeClock(t)
+			s3 := &fakeS3{objects: map[string][]byte{}}
+			srv := httptest.NewServer(s3)
+			defer srv.Close()
+			t.Setenv("AWS_REGION", "ap-northeast-1")
+			t.Setenv("AWS_ACCESS_KEY_ID", "id")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			objects, prefix, err := openObjectStore("s3://archive/rooms", srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archiver = &roomArchive{store: objects, prefix: prefix, retention: 30 * 24 * time.Hour, idle: time.Hour}
+			t.Cleanup(func() { archiver = nil })
+			useMemoryStore(t)
+			ctx := context.Background()
+
+			room := "t1/" + store
+			AddParticipant(ctx, room, "u1")
+			Vote(ctx, room, "u1")
+			AppendRoomEvent(ctx, room, eventTriggered, clock.Now())
+			SaveReport(ctx, room, &MeetingReport{Tenant: "t1", RoomID: room, TriggeredAt: clock.Now()})
+			busy := "busy-" + store
+			AppendRoomEvent(ctx, busy, eventSnoozed, clock.Now())
+			// Rooms without a history are left to expire
+			AddParticipant(ctx, "quiet-"+store, "u1")
+
+			fc.Advance(50 * time.Minute)
+			AddParticipant(ctx, busy, "u2")
+			fc.Advance(20 * time.Minute)
+			if err := runArchive(ctx, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			key := "rooms/2026/04/01/t1%2F" + store + ".json.gz"
+			if len(s3.objects) != 1 || s3.objects[key] == nil {
+				t.Fatalf("objects = %v", s3.objects)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(s3.objects[key]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got archivedRoom
+			if err := json.NewDecoder(zr).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Room != room || len(got.Events) != 1 || got.Events[0].Type != eventTriggered || got.Report == nil || got.OpenedAt.IsZero() {
+				t.Errorf("archived = %+v", got)
+			}
+
+			if total, _, _, _ := CheckTriggerStatus(ctx, room); total != 0 {
+				t.Errorf("purged room has %d participants", total)
+			}
+			if events, _ := RoomEventsSince(ctx, busy, "0-0"); len(events) != 1 {
+				t.Errorf("busy room has %d events", len(events))
+			}
+			if store == "redis" {
+				if r, _ := RoomReport(ctx, room); r == nil {
+					t.Error("report purged with the room")
+				}
+			}
+
+			// The busy room goes once it is idle too; nothing is written twice
+			fc.Advance(time.Hour)
+			runArchive(ctx, nil)
+			if len(s3.objects) != 2 {
+				t.Errorf("%d objects after the second run", len(s3.objects))
+			}
+
+			// Objects are deleted once their whole day is past the retention
+			s3.objects["rooms/2026/03/01/old.json.gz"] = []byte{}
+			s3.objects["rooms/2026/03/02/kept.json.gz"] = []byte{}
+			runArchive(ctx, nil)
+			if _, ok := s3.objects["rooms/2026/03/01/old.json.gz"]; ok {
+				t.Error("expired object kept")
+			}
+			if _, ok := s3.objects["rooms/2026/03/02/kept.json.gz"]; !ok {
+				t.Error("object deleted before the retention")
+			}
+		})
+	}
+}
+
+func TestArchiveGCS(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/archive/o":
+			objects[r.URL.Query().Get("name")] = r.Header.Get("Content-Type")
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/archive/o":
+			var names []map[string]string
+			for name := range objects {
+				names = append(names, map[string]string{"name": name})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"items": names})
+		case r.Method == http.MethodDelete:
+			delete(objects, strings.TrimPrefix(r.URL.Path, "/storage/v1/b/archive/o/"))
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("GCP_ACCESS_TOKEN", "tok")
+	gcs, prefix, err := openObjectStore("gs://archive", srv.URL)
+	if err != nil || prefix != "" {
+		t.Fatalf("open = %q, %v", prefix, err)
+	}
+	ctx := context.Background()
+	if err := gcs.put(ctx, "2026/04/01/t1%2Fm1.json.gz", []byte("x"), "application/gzip"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := gcs.list(ctx, "2026/")
+	if err != nil || len(keys) != 1 || keys[0] != "2026/04/01/t1%2Fm1.json.gz" {
+		t.Fatalf("list = %v, %v", keys, err)
+	}
+	if err := gcs.delete(ctx, keys[0]); err != nil || len(objects) != 0 {
+		t.Errorf("delete = %v, left %v", err, objects)
+	}
+
+	if _, _, err := openObjectStore("ftp://archive", ""); err == nil {
+		t.Error("ftp URL accepted")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Custom ending audio: hosts upload their own closing jingle, which a
+// room's settings can pick instead of its theme's music. Files are named
+// by their content hash, so they never change and are cached for good,
+// and kept per tenant in a directory or bucket (AUDIO_STORE).
+
+// audioTypes are the accepted formats by file extension.
+var audioTypes = map[string]struct {
+	contentType string
+	sniff       func(b []byte) bool
+}{
+	"mp3": {"audio/mpeg", func(b []byte) bool {
+		// An ID3 tag or an MPEG frame sync
+		return bytes.HasPrefix(b, []byte("ID3")) || len(b) > 1 && b[0] == 0xff && b[1]&0xe0 == 0xe0
+	}},
+	"ogg": {"audio/ogg", func(b []byte) bool { return bytes.HasPrefix(b, []byte("OggS")) }},
+	"wav": {"audio/wav", func(b []byte) bool {
+		return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WAVE"
+	}},
+	"m4a": {"audio/mp4", func(b []byte) bool { return len(b) >= 8 && string(b[4:8]) == "ftyp" }},
+}
+
+// audioExt returns the extension of an accepted content type.
+func audioExt(contentType string) (string, bool) {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	for ext, t := range audioTypes {
+		if t.contentType == ct || ct == "audio/x-wav" && ext == "wav" || ct == "audio/x-m4a" && ext == "m4a" {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+// audioIDPattern matches the IDs of uploaded audio: the content hash and
+// the extension.
+var audioIDPattern = regexp.MustCompile(`^[0-9a-f]{32}\.(mp3|ogg|wav|m4a)$`)
+
+func validateAudio(id string) error {
+	if id != "" && !audioIDPattern.MatchString(id) {
+		return fmt.Errorf("audio %q is not an uploaded audio ID", id)
+	}
+	return nil
+}
+
+// audioCacheSize is how many bytes of audio are kept in memory, so the
+// burst of panels fetching the music when a room triggers does not go to
+// the store.
+const audioCacheSize = 64 << 20
+
+// audioLibrary keeps the uploaded audio.
+type audioLibrary struct {
+	store    objectStore
+	prefix   string
+	maxBytes int64
+
+	mu     sync.Mutex
+	cache  map[string][]byte
+	cached int64
+}
+
+// audioFiles is set when AUDIO_STORE is.
+var audioFiles *audioLibrary
+
+// startAudioLibrary opens AUDIO_STORE, a directory or an s3:// or gs:// URL.
+func startAudioLibrary(cfg *Config) error {
+	if cfg.AudioStore == "" {
+		return nil
+	}
+	if cfg.AudioMaxBytes <= 0 {
+		return fmt.Errorf("AUDIO_MAX_BYTES must be positive")
+	}
+	lib := &audioLibrary{maxBytes: cfg.AudioMaxBytes, cache: make(map[string][]byte)}
+	var err error
+	if strings.HasPrefix(cfg.AudioStore, "s3://") || strings.HasPrefix(cfg.AudioStore, "gs://") {
+		lib.store, lib.prefix, err = openObjectStore(cfg.AudioStore, cfg.ArchiveEndpoint)
+	} else {
+		lib.store, err = openDirStore(cfg.AudioStore)
+	}
+	if err != nil {
+		return fmt.Errorf("AUDIO_STORE: %w", err)
+	}
+	audioFiles = lib
+	log.Printf("Custom ending audio is kept in %s.", cfg.AudioStore)
+	return nil
+}
+
+// audioTenantDir is the directory of the tenant's audio; "_" outside
+// multi-tenant deployments, as tenant IDs cannot be empty.
+func audioTenantDir(tenant string) string {
+	if tenant == "" {
+		return "_"
+	}
+	return tenant
+}
+
+func (l *audioLibrary) key(tenant, id string) string {
+	return l.prefix + audioTenantDir(tenant) + "/" + id
+}
+
+// audioURL is where panels fetch the tenant's audio id, relative to the
+// page like the bundled music.
+func audioURL(tenant, id string) string {
+	return "api/audio/" + url.PathEscape(audioTenantDir(tenant)) + "/" + id
+}
+
+// get returns the tenant's audio id, from memory when it can.
+func (l *audioLibrary) get(ctx context.Context, tenant, id string) ([]byte, error) {
+	key := l.key(tenant, id)
+	l.mu.Lock()
+	b, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+	b, err := l.store.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	if _, ok := l.cache[key]; !ok && l.cached+int64(len(b)) <= audioCacheSize {
+		l.cache[key] = b
+		l.cached += int64(len(b))
+	}
+	l.mu.Unlock()
+	return b, nil
+}
+
+// list returns the IDs of the tenant's audio.
+func (l *audioLibrary) list(ctx context.Context, tenant string) ([]string, error) {
+	dir := l.key(tenant, "")
+	keys, err := l.store.list(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, key := range keys {
+		if id := strings.TrimPrefix(key, dir); audioIDPattern.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// audioInfo describes one uploaded audio in the API.
+type audioInfo struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// handleAudio serves POST /api/audio, which uploads the request body as
+// an ending audio of the host's tenant, and GET /api/audio, which lists
+// them. Put the returned id in the room settings' audio to use it.
+func handleAudio(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+	lib := audioFiles
+	if lib == nil {
+		httpError(w, ctx, "Not Found: audio uploads are not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ids, err := lib.list(ctx, zCtx.Tenant)
+		if err != nil {
+			logf(ctx, "Audio list error: %v", err)
+			httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		list := make([]audioInfo, len(ids))
+		for i, id := range ids {
+			list[i] = audioInfo{ID: id, URL: audioURL(zCtx.Tenant, id)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		ext, ok := audioExt(r.Header.Get("Content-Type"))
+		if !ok {
+			httpError(w, ctx, "Unsupported Media Type: send audio/mpeg, audio/ogg, audio/wav or audio/mp4", http.StatusUnsupportedMediaType)
+			return
+		}
+		var body bytes.Buffer
+		if _, err := body.ReadFrom(http.MaxBytesReader(w, r.Body, lib.maxBytes)); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				httpError(w, ctx, fmt.Sprintf("Request Entity Too Large: audio must be at most %d bytes", lib.maxBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			httpError(w, ctx, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if !audioTypes[ext].sniff(body.Bytes()) {
+			httpError(w, ctx, "Unsupported Media Type: the file is not "+audioTypes[ext].contentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		sum := sha256.Sum256(body.Bytes())
+		id := hex.EncodeToString(sum[:16]) + "." + ext
+		if err := lib.store.put(ctx, lib.key(zCtx.Tenant, id), body.Bytes(), audioTypes[ext].contentType); err != nil {
+			logf(ctx, "Audio upload error: %v", err)
+			httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		logf(ctx, "Uploaded ending audio %s (%d bytes)", id, body.Len())
+		metrics.Add("audio_uploads", 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(audioInfo{ID: id, URL: audioURL(zCtx.Tenant, id)})
+
+	default:
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAudioFile serves GET /api/audio/{tenant}/{id} to the panels'
+// audio element, which sends no Zoom context; the IDs are content hashes
+// nobody can guess. Range requests are answered, so players can seek and
+// start before the whole file is in.
+func handleAudioFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	lib := audioFiles
+	id := r.PathValue("id")
+	tenant := r.PathValue("tenant")
+	if tenant == "_" {
+		tenant = ""
+	}
+	if lib == nil || !audioIDPattern.MatchString(id) || tenant == "." || tenant == ".." || strings.ContainsAny(tenant, "/: ") {
+		httpError(w, ctx, "Not Found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := lib.get(ctx, tenant, id)
+	if errors.Is(err, os.ErrNotExist) {
+		httpError(w, ctx, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logf(ctx, "Audio read error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", audioTypes[strings.TrimPrefix(path.Ext(id), ".")].contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(id, path.Ext(id))+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+}
+
+// roomAudio returns the URL of the room's uploaded audio, or "" for the
+// theme's music.
+func roomAudio(tenant string, s *RoomSettings) string {
+	if s.Audio == "" || audioFiles == nil {
+		return ""
+	}
+	return audioURL(tenant, s.Audio)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAudioUpload(t *testing.T) {
+	ts := newTestServer(t)
+	if err := startAudioLibrary(&Config{AudioStore: t.TempDir(), AudioMaxBytes: 1024}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audioFiles = nil })
+
+	send := func(method, path, role, ctype string, body []byte, header ...string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path+"?roomId=audio-room&pid=p1&role="+role, bytes.NewReader(body))
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, b
+	}
+
+	mp3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00}, 100)...)
+	if resp, _ := send(http.MethodPost, "/api/audio", "", "audio/mpeg", mp3); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("participant upload: status %d, want 403", resp.StatusCode)
+	}
+	if resp, _ := send(http.MethodPost, "/api/audio", "host", "audio/mpeg", []byte("<html>not audio</html>")); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("html upload: status %d, want 415", resp.StatusCode)
+	}
+	if resp, _ := send(http.MethodPost, "/api/audio", "host", "video/mp4", mp3); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("video upload: status %d, want 415", resp.StatusCode)
+	}
+	if resp, _ := send(http.MethodPost, "/api/audio", "host", "audio/mpeg", append(mp3, make([]byte, 1024)...)); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload: status %d, want 413", resp.StatusCode)
+	}
+
+	resp, body := send(http.MethodPost, "/api/audio", "host", "audio/mpeg", mp3)
+	var up audioInfo
+	json.Unmarshal(body, &up)
+	if resp.StatusCode != http.StatusCreated || !audioIDPattern.MatchString(up.ID) || up.URL != "api/audio/_/"+up.ID {
+		t.Fatalf("upload: status %d, %s", resp.StatusCode, body)
+	}
+	if _, body := send(http.MethodGet, "/api/audio", "host", "", nil); !strings.Contains(string(body), up.ID) {
+		t.Errorf("list = %s", body)
+	}
+
+	resp, body = send(http.MethodGet, "/"+up.URL, "", "", nil, "Range", "bytes=0-9")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, mp3[:10]) || resp.Header.Get("Content-Type") != "audio/mpeg" ||
+		!strings.Contains(resp.Header.Get("Cache-Control"), "immutable") {
+		t.Errorf("range: status %d, %v, %q", resp.StatusCode, resp.Header, body)
+	}
+	if resp, _ := send(http.MethodGet, "/api/audio/other/"+up.ID, "", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("other tenant's audio: status %d, want 404", resp.StatusCode)
+	}
+
+	// The room's settings pick it over the theme's music
+	const path = "/api/rooms/audio-room/settings"
+	if resp, _ := send(http.MethodPut, path, "host", "application/json", []byte(`{"audio":"`+strings.Repeat("0", 32)+`.mp3"}`)); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown audio: status %d, want 400", resp.StatusCode)
+	}
+	if resp, body := send(http.MethodPut, path, "host", "application/json", []byte(`{"audio":"`+up.ID+`"}`)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT: status %d, %s", resp.StatusCode, body)
+	}
+	if body := ts.queryClient("audio-room", "p1").poll(); !strings.Contains(body, `data-audio="`+up.URL+`"`) {
+		t.Errorf("panel does not play the upload:\n%s", body)
+	}
+}
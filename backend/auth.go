@@ -4,129 +4,272 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 // ZoomAuthContext holds the decoded JWT payload from Zoom
 type ZoomAuthContext struct {
 	UID string `json:"uid"` // Unique user ID
 	Mid string `json:"mid"` // Meeting ID
+
+	// Role is the user's meeting role from the context's role or
+	// attendrole field, lowercased (host, cohost, attendee, panelist, ...)
+	Role string `json:"role"`
+
+	// Tenant is the ID of the Zoom app the context was issued for, empty in
+	// single-tenant deployments
+	Tenant string `json:"-"`
+
+	// Locale is the panel language negotiated for this request
+	Locale string `json:"-"`
+
+	// ReducedMotion and Muted are the client's accessibility preferences,
+	// see clientPrefs
+	ReducedMotion bool `json:"-"`
+	Muted         bool `json:"-"`
+
+	// LastEventID is the newest room event the panel has seen, from the
+	// Last-Event-ID header
+	LastEventID string `json:"-"`
+
+	// Idle is how long the panel's user has not touched it, from the
+	// X-Idle-Seconds header; 0 when unknown
+	Idle time.Duration `json:"-"`
+
+	// HTMX is set for requests sent by the panel's htmx, which are told
+	// about failures with an error frame fragment
+	HTMX bool `json:"-"`
+
+	// ShownETag is the ETag of the state the panel shows, from
+	// If-None-Match; states of large rooms have ETags, see renderPolicy
+	ShownETag string `json:"-"`
+
+	// verifiedFrom is the Zoom context this request was just verified
+	// from, empty when it resumed a session or used DEV_BYPASS
+	verifiedFrom string
+}
+
+// IsHost reports whether the user may use host-only features.
+func (z *ZoomAuthContext) IsHost() bool {
+	switch z.Role {
+	case "host", "cohost", "co-host":
+		return true
+	}
+	return false
 }
 
-func getZoomClientSecret() string {
-	// In production, this MUST be set
-	secret := strings.TrimSpace(os.Getenv("ZOOM_CLIENT_SECRET"))
-	if secret == "" {
-		log.Println("WARNING: ZOOM_CLIENT_SECRET is not set. Using dummy secret for development.")
-		return "dummy_secret_for_local_dev"
+// RoomID is the store key for the meeting, namespaced by tenant so two
+// organizations can never share a room.
+func (z *ZoomAuthContext) RoomID() string {
+	if z.Tenant == "" {
+		return z.Mid
 	}
-	return secret
+	return z.Tenant + "/" + z.Mid
 }
 
 // decodeBase64URL decodes base64url strings with or without padding
 func decodeBase64URL(s string) ([]byte, error) {
-	// Add padding if missing
-	if m := len(s) % 4; m != 0 {
-		s += strings.Repeat("=", 4-m)
+	if strings.HasSuffix(s, "=") {
+		return base64.URLEncoding.DecodeString(s)
 	}
-	return base64.URLEncoding.DecodeString(s)
+	return base64.RawURLEncoding.DecodeString(s)
 }
 
-// VerifyZoomContext decrypts the x-zoom-app-context header (AES-256-GCM) and returns the extracted Context
-func VerifyZoomContext(appContext string) (*ZoomAuthContext, error) {
-	if appContext == "" {
-		return nil, fmt.Errorf("missing x-zoom-app-context header")
-	}
+// zoomContextFrame is the binary layout of a decoded x-zoom-app-context:
+// ivLength(1) | iv | aadLength(2, LE) | aad | cipherTextLength(4, LE) | cipherText | authTag
+type zoomContextFrame struct {
+	IV         []byte
+	AAD        []byte
+	CipherText []byte
+	AuthTag    []byte
 
-	secret := getZoomClientSecret()
+	// Sealed is CipherText followed by AuthTag, the form cipher.AEAD
+	// opens. It shares the input's backing array and must not be written.
+	Sealed []byte
+}
 
-	b, err := decodeBase64URL(appContext)
-	if err != nil {
-		return nil, fmt.Errorf("base64 decode error: %w", err)
-	}
+const (
+	gcmTagSize           = 16
+	gcmStandardNonceSize = 12
+)
+
+// parseZoomContextFrame splits a decoded context into its parts. Length fields
+// are compared against the remaining bytes rather than added to the offset so
+// crafted values cannot overflow or slice out of range.
+func parseZoomContextFrame(b []byte) (*zoomContextFrame, error) {
+	var f zoomContextFrame
 
 	if len(b) < 1 {
 		return nil, fmt.Errorf("context payload too short (no ivLength)")
 	}
+	ivLength := int(b[0])
+	b = b[1:]
 
-	offset := 0
-	ivLength := int(b[offset])
-	offset += 1
-
-	if len(b) < offset+ivLength {
+	if ivLength == 0 {
+		return nil, fmt.Errorf("context payload has empty iv")
+	}
+	if len(b) < ivLength {
 		return nil, fmt.Errorf("context payload too short (iv)")
 	}
-	iv := b[offset : offset+ivLength]
-	offset += ivLength
+	f.IV, b = b[:ivLength], b[ivLength:]
 
-	if len(b) < offset+2 {
+	if len(b) < 2 {
 		return nil, fmt.Errorf("context payload too short (aadLength)")
 	}
-	aadLength := int(binary.LittleEndian.Uint16(b[offset : offset+2]))
-	offset += 2
+	aadLength := uint64(binary.LittleEndian.Uint16(b[:2]))
+	b = b[2:]
 
-	if len(b) < offset+aadLength {
+	if uint64(len(b)) < aadLength {
 		return nil, fmt.Errorf("context payload too short (aad)")
 	}
-	aad := b[offset : offset+aadLength]
-	offset += aadLength
+	f.AAD, b = b[:aadLength], b[aadLength:]
 
-	if len(b) < offset+4 {
+	if len(b) < 4 {
 		return nil, fmt.Errorf("context payload too short (cipherTextLength)")
 	}
-	cipherTextLength := int(binary.LittleEndian.Uint32(b[offset : offset+4]))
-	offset += 4
+	cipherTextLength := uint64(binary.LittleEndian.Uint32(b[:4]))
+	b = b[4:]
 
-	if len(b) < offset+cipherTextLength {
+	if uint64(len(b)) < cipherTextLength {
 		return nil, fmt.Errorf("context payload too short (cipherText)")
 	}
-	cipherText := b[offset : offset+cipherTextLength]
-	offset += cipherTextLength
+	f.Sealed = b
+	f.CipherText, b = b[:cipherTextLength], b[cipherTextLength:]
 
-	// The remaining bytes are the auth tag (usually 16 bytes for GCM)
-	authTag := b[offset:]
+	// The remaining bytes are the GCM auth tag
+	if len(b) != gcmTagSize {
+		return nil, fmt.Errorf("context payload has invalid auth tag length %d", len(b))
+	}
+	f.AuthTag = b
+
+	return &f, nil
+}
+
+// maxContextAEADs bounds contextAEADs. Secrets only change on rotation, so
+// more entries mean IV lengths no Zoom client sends, or many reloads.
+const maxContextAEADs = 16
+
+type contextAEADKey struct {
+	secret string
+	ivLen  int
+}
+
+// contextAEADs caches the AES-GCM instance for each client secret and IV
+// length, so verifying a context neither hashes the secret nor expands the
+// AES key again. cipher.AEAD is safe for concurrent use.
+var contextAEADs = struct {
+	sync.RWMutex
+	m map[contextAEADKey]cipher.AEAD
+}{m: map[contextAEADKey]cipher.AEAD{}}
+
+// contextAEAD returns the AES-GCM instance contexts encrypted with secret
+// and an IV of ivLen bytes are opened with.
+func contextAEAD(secret string, ivLen int) (cipher.AEAD, error) {
+	key := contextAEADKey{secret, ivLen}
+	contextAEADs.RLock()
+	aead, ok := contextAEADs.m[key]
+	contextAEADs.RUnlock()
+	if ok {
+		return aead, nil
+	}
 
 	// Zoom uses AES-256-GCM using SHA-256 of client_secret as the key
 	hash := sha256.Sum256([]byte(secret))
-
 	block, err := aes.NewCipher(hash[:])
 	if err != nil {
 		return nil, err
 	}
+	aead, err = cipher.NewGCMWithNonceSize(block, ivLen)
+	if err != nil {
+		return nil, err
+	}
+	contextAEADs.Lock()
+	if len(contextAEADs.m) >= maxContextAEADs {
+		clear(contextAEADs.m)
+	}
+	contextAEADs.m[key] = aead
+	contextAEADs.Unlock()
+	return aead, nil
+}
 
-	aesgcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+// decryptZoomContextFrame opens frame with the key derived from secret,
+// appending the plain text to dst. frame is only read, so it can be tried
+// with one secret after another.
+func decryptZoomContextFrame(dst []byte, frame *zoomContextFrame, secret string) ([]byte, error) {
+	aesgcm, err := contextAEAD(secret, len(frame.IV))
 	if err != nil {
 		return nil, err
 	}
+	plainText, err := aesgcm.Open(dst, frame.IV, frame.Sealed, frame.AAD)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt failed: %w", err)
+	}
+	return plainText, nil
+}
 
-	// Go cipher.Open expects ciphertext and authTag to be concatenated
-	cTextWithTag := append(cipherText, authTag...)
+// VerifyZoomContext decrypts the x-zoom-app-context header (AES-256-GCM) and returns the extracted Context
+func VerifyZoomContext(appContext string) (*ZoomAuthContext, error) {
+	return verifyZoomContextWith(appContext, zoomClientSecrets())
+}
 
-	plainText, err := aesgcm.Open(nil, iv, cTextWithTag, aad)
+// verifyZoomContextWith is VerifyZoomContext against an explicit secret set,
+// current first.
+func verifyZoomContextWith(appContext string, secrets []string) (*ZoomAuthContext, error) {
+	if appContext == "" {
+		return nil, fmt.Errorf("missing x-zoom-app-context header")
+	}
+
+	b, err := decodeBase64URL(appContext)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt failed: %w", err)
+		return nil, fmt.Errorf("base64 decode error: %w", err)
 	}
 
-	// Parse JSON payload
-	var payload map[string]interface{}
+	frame, err := parseZoomContextFrame(b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try the current secret first, then previous ones still inside their
+	// rotation window
+	buf := make([]byte, 0, len(frame.CipherText))
+	var plainText []byte
+	err = fmt.Errorf("no client secrets configured")
+	for _, secret := range secrets {
+		plainText, err = decryptZoomContextFrame(buf, frame, secret)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON payload. Fields of other types are ignored, as if absent.
+	var payload map[string]json.RawMessage
 	if err := json.Unmarshal(plainText, &payload); err != nil {
 		return nil, fmt.Errorf("json parse failed: %w", err)
 	}
-
-	var ctx ZoomAuthContext
-	if uid, ok := payload["uid"].(string); ok {
-		ctx.UID = uid
+	field := func(key string) string {
+		var s string
+		json.Unmarshal(payload[key], &s)
+		return s
 	}
-	if mid, ok := payload["mid"].(string); ok {
-		ctx.Mid = mid
+
+	ctx := ZoomAuthContext{UID: field("uid"), Mid: field("mid")}
+	for _, key := range []string{"role", "attendrole"} {
+		if role := field(key); role != "" {
+			ctx.Role = strings.ToLower(role)
+			break
+		}
 	}
 
 	if ctx.Mid == "" || ctx.UID == "" {
@@ -136,44 +279,135 @@ func VerifyZoomContext(appContext string) (*ZoomAuthContext, error) {
 	return &ctx, nil
 }
 
+// EncryptZoomContext is the inverse of VerifyZoomContext: it encrypts payload
+// with the given client secret using Zoom's framing. Intended for local testing.
+func EncryptZoomContext(secret string, payload []byte) (string, error) {
+	aesgcm, err := contextAEAD(secret, gcmStandardNonceSize)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aesgcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	sealed := aesgcm.Seal(nil, iv, payload, nil)
+	cipherText := sealed[:len(sealed)-aesgcm.Overhead()]
+	authTag := sealed[len(sealed)-aesgcm.Overhead():]
+
+	// ivLength(1) | iv | aadLength(2) | aad | cipherTextLength(4) | cipherText | authTag
+	b := make([]byte, 0, 1+len(iv)+2+4+len(sealed))
+	b = append(b, byte(len(iv)))
+	b = append(b, iv...)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(cipherText)))
+	b = append(b, cipherText...)
+	b = append(b, authTag...)
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// devBypass lets loopback clients without a Zoom context pick their identity
+// via the roomId/pid query params. Never enabled in production.
+var devBypass bool
+
 // AuthMiddleware extracts Zoom context from HTTP requests/WebSockets
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		appContext := r.Header.Get("x-zoom-app-context")
-		if appContext == "" {
-			appContext = r.URL.Query().Get("zoom_context")
+		zCtx := authenticate(r)
+		if zCtx == nil {
+			httpError(w, r.Context(), "Unauthorized", http.StatusUnauthorized)
+			return
 		}
-		if appContext == "" {
-			if cookie, err := r.Cookie("zoom_context"); err == nil {
-				appContext = cookie.Value
+		setAccessUID(r.Context(), zCtx.UID)
+		if denied(r, zCtx) {
+			metrics.Add("denied_requests", 1)
+			panelError(w, r.Context(), zCtx, frameDenied, http.StatusForbidden)
+			return
+		}
+		if zCtx.verifiedFrom != "" && resumeTTL > 0 {
+			if token := issueResumeToken(r.Context(), zCtx, zCtx.verifiedFrom); token != "" {
+				w.Header().Set(resumeHeader, token)
 			}
 		}
 
-		mid := r.URL.Query().Get("roomId")
-		if mid == "" {
-			mid = "public-room"
+		ctx := context.WithValue(r.Context(), "zoomCtx", zCtx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// forwarded reports whether r came through a proxy or a tunnel such as
+// ngrok, which connects from loopback on behalf of anyone on the internet.
+func forwarded(r *http.Request) bool {
+	for name := range r.Header {
+		switch name {
+		case "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-Ip", "Forwarded":
+			return true
 		}
-		uid := r.URL.Query().Get("pid")
-		if uid == "" {
-			uid = "anonymous-user"
+		if strings.HasPrefix(name, "Ngrok-") {
+			return true
 		}
+	}
+	return false
+}
 
-		if appContext != "" {
-			zCtx, err := VerifyZoomContext(appContext)
-			if err == nil {
-				mid = zCtx.Mid
-				uid = zCtx.UID
-				log.Printf("[DEBUG] Zoom Auth Successful. UID: %s, Mid: %s", uid, mid)
-			} else {
-				log.Printf("[DEBUG] Verification failed, ignoring format: %v", err)
-			}
+// authenticate returns the caller's verified Zoom context, or nil.
+func authenticate(r *http.Request) *ZoomAuthContext {
+	appContext := r.Header.Get("x-zoom-app-context")
+	if appContext == "" {
+		appContext = r.URL.Query().Get("zoom_context")
+	}
+	if appContext == "" {
+		if cookie, err := r.Cookie("zoom_context"); err == nil {
+			appContext = cookie.Value
 		}
+	}
 
-		// Always allow connection (ultra-permissive fallback logic)
-		ctx := context.WithValue(r.Context(), "zoomCtx", &ZoomAuthContext{
-			Mid: mid,
-			UID: uid,
-		})
-		next.ServeHTTP(w, r.WithContext(ctx))
+	candidates := tenantsFor(r)
+
+	// A panel reconnecting with a live resume token skips verification
+	zCtx := resumeContext(r, appContext)
+	how := "resume token"
+	if zCtx == nil && appContext != "" {
+		verified, err := verifyTenantContext(appContext, candidates)
+		if err == nil {
+			zCtx = verified
+			zCtx.verifiedFrom = appContext
+			how = "Zoom context"
+		} else {
+			logf(r.Context(), "Zoom context verification failed: %v", err)
+		}
+	}
+
+	if zCtx == nil {
+		if !devBypass || !clientIP(r).IsLoopback() || forwarded(r) {
+			return nil
+		}
+
+		// DEV_BYPASS: trust roomId/pid/role query params from loopback clients
+		zCtx = &ZoomAuthContext{
+			Mid:  r.URL.Query().Get("roomId"),
+			UID:  r.URL.Query().Get("pid"),
+			Role: strings.ToLower(r.URL.Query().Get("role")),
+		}
+		if zCtx.Mid == "" {
+			zCtx.Mid = "public-room"
+		}
+		if zCtx.UID == "" {
+			zCtx.UID = "anonymous-user"
+		}
+		if len(candidates) == 1 {
+			zCtx.Tenant = candidates[0].ID
+		}
+		how = "DEV_BYPASS"
 	}
+	debugf(r.Context(), "Authenticated by %s: tenant %q, role %q", how, zCtx.Tenant, zCtx.Role)
+	zCtx.Locale = negotiateLocale(r)
+	zCtx.ReducedMotion, zCtx.Muted = clientPrefs(r)
+	zCtx.LastEventID = r.Header.Get("Last-Event-ID")
+	zCtx.HTMX = r.Header.Get("HX-Request") == "true"
+	zCtx.ShownETag = r.Header.Get("If-None-Match")
+	zCtx.Idle = idleFor(r)
+	return zCtx
 }
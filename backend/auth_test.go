@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptZoomContextRoundTrip(t *testing.T) {
+	t.Setenv("ZOOM_CLIENT_SECRET", "test_secret")
+
+	appContext, err := EncryptZoomContext("test_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+
+	zCtx, err := VerifyZoomContext(appContext)
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if zCtx.UID != "u1" || zCtx.Mid != "m1" {
+		t.Errorf("expected u1/m1, got %s/%s", zCtx.UID, zCtx.Mid)
+	}
+
+	// A context encrypted with another secret must be rejected
+	other, _ := EncryptZoomContext("other_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	if _, err := VerifyZoomContext(other); err == nil {
+		t.Errorf("expected verification with wrong secret to fail")
+	}
+}
+
+func mustEncrypt(t testing.TB, secret string, payload string) []byte {
+	t.Helper()
+	appContext, err := EncryptZoomContext(secret, []byte(payload))
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	b, err := decodeBase64URL(appContext)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	return b
+}
+
+func TestParseZoomContextFrameTruncated(t *testing.T) {
+	b := mustEncrypt(t, "test_secret", `{"uid":"u1","mid":"m1"}`)
+
+	if _, err := parseZoomContextFrame(b); err != nil {
+		t.Fatalf("expected full frame to parse, got %v", err)
+	}
+
+	// Every strict prefix of a valid frame must be rejected without panicking
+	for n := 0; n < len(b); n++ {
+		if _, err := parseZoomContextFrame(b[:n]); err == nil {
+			t.Errorf("expected error for frame truncated to %d bytes", n)
+		}
+	}
+
+	// Trailing garbage changes the auth tag length and must be rejected too
+	if _, err := parseZoomContextFrame(append(b[:len(b):len(b)], 0)); err == nil {
+		t.Errorf("expected error for frame with trailing byte")
+	}
+}
+
+func TestParseZoomContextFrameOversizedLengths(t *testing.T) {
+	b := mustEncrypt(t, "test_secret", `{"uid":"u1","mid":"m1"}`)
+	ivLength := int(b[0])
+	aadOffset := 1 + ivLength
+	ctOffset := aadOffset + 2 // aad is empty in generated contexts
+
+	cases := map[string]func(f []byte){
+		"iv":           func(f []byte) { f[0] = 0xff },
+		"empty iv":     func(f []byte) { f[0] = 0 },
+		"aad":          func(f []byte) { f[aadOffset], f[aadOffset+1] = 0xff, 0xff },
+		"cipherText":   func(f []byte) { copy(f[ctOffset:], []byte{0xff, 0xff, 0xff, 0xff}) },
+		"cipherText+1": func(f []byte) { f[ctOffset]++ },
+	}
+
+	for name, mutate := range cases {
+		f := append([]byte(nil), b...)
+		mutate(f)
+		if _, err := parseZoomContextFrame(f); err == nil {
+			t.Errorf("%s: expected error for oversized length field", name)
+		}
+	}
+}
+
+func FuzzParseZoomContextFrame(f *testing.F) {
+	f.Add(mustEncrypt(f, "test_secret", `{"uid":"u1","mid":"m1"}`))
+	f.Add([]byte{})
+	f.Add([]byte{12})
+	f.Add([]byte{1, 0, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		frame, err := parseZoomContextFrame(b)
+		if err != nil {
+			return
+		}
+		// A successful parse must account for every input byte
+		n := 1 + len(frame.IV) + 2 + len(frame.AAD) + 4 + len(frame.CipherText) + len(frame.AuthTag)
+		if n != len(b) {
+			t.Errorf("parsed %d bytes from %d byte input", n, len(b))
+		}
+	})
+}
+
+func FuzzVerifyZoomContext(f *testing.F) {
+	f.Setenv("ZOOM_CLIENT_SECRET", "test_secret")
+	valid, _ := EncryptZoomContext("test_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	f.Add(valid)
+	f.Add("")
+	f.Add("AA")
+	f.Add("DAAAAAAAAAAAAAAAAAAAAAAA")
+
+	f.Fuzz(func(t *testing.T, appContext string) {
+		// Only checks that arbitrary input never panics
+		VerifyZoomContext(appContext)
+	})
+}
+
+func TestZoomSecretRotation(t *testing.T) {
+	t.Setenv("ZOOM_CLIENT_SECRET", "new_secret")
+	t.Setenv("ZOOM_CLIENT_PREVIOUS_SECRETS", "old_secret, older_secret")
+
+	for _, secret := range []string{"new_secret", "old_secret", "older_secret"} {
+		appContext, _ := EncryptZoomContext(secret, []byte(`{"uid":"u1","mid":"m1"}`))
+		if _, err := VerifyZoomContext(appContext); err != nil {
+			t.Errorf("%s: expected context to verify, got %v", secret, err)
+		}
+	}
+
+	retired, _ := EncryptZoomContext("retired_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	if _, err := VerifyZoomContext(retired); err == nil {
+		t.Errorf("expected retired secret to be rejected")
+	}
+
+	// A secrets file replaces the env vars and is hot-reloadable
+	path := filepath.Join(t.TempDir(), "secrets")
+	os.WriteFile(path, []byte("# current first\nfile_secret\n"), 0o600)
+	if err := loadSecretsFile(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	t.Cleanup(func() { managedSecrets.Store(nil) })
+
+	if got := getZoomClientSecret(); got != "file_secret" {
+		t.Errorf("expected file secret to be current, got %q", got)
+	}
+	old, _ := EncryptZoomContext("new_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	if _, err := VerifyZoomContext(old); err == nil {
+		t.Errorf("expected env secret to be replaced by the file")
+	}
+
+	os.WriteFile(path, []byte("rotated_secret\nfile_secret\n"), 0o600)
+	loadSecretsFile(path)
+	fromFile, _ := EncryptZoomContext("file_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	if _, err := VerifyZoomContext(fromFile); err != nil {
+		t.Errorf("expected previous file secret to remain valid after rotation, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("\n"), 0o600)
+	if err := loadSecretsFile(path); err == nil {
+		t.Errorf("expected empty secrets file to be rejected")
+	}
+	if got := getZoomClientSecret(); got != "rotated_secret" {
+		t.Errorf("expected failed reload to keep previous secrets, got %q", got)
+	}
+}
+
+func TestZoomContextRole(t *testing.T) {
+	t.Setenv("ZOOM_CLIENT_SECRET", "role_secret")
+	for payload, host := range map[string]bool{
+		`{"uid":"u","mid":"m","attendrole":"coHost"}`:   true,
+		`{"uid":"u","mid":"m","role":"host"}`:           true,
+		`{"uid":"u","mid":"m","attendrole":"attendee"}`: false,
+		`{"uid":"u","mid":"m"}`:                         false,
+	} {
+		appContext, err := EncryptZoomContext("role_secret", []byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zCtx, err := VerifyZoomContext(appContext)
+		if err != nil {
+			t.Fatalf("%s: %v", payload, err)
+		}
+		if zCtx.IsHost() != host {
+			t.Errorf("%s: IsHost() = %t, want %t", payload, zCtx.IsHost(), host)
+		}
+	}
+}
+
+func TestVerifyZoomContextLeavesInputAlone(t *testing.T) {
+	b := mustEncrypt(t, "old_secret", `{"uid":"u1","mid":"m1"}`)
+	frame, err := parseZoomContextFrame(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := bytes.Clone(b)
+
+	// The wrong secret first, as with a context from before a rotation
+	buf := make([]byte, 0, len(frame.CipherText))
+	if _, err := decryptZoomContextFrame(buf, frame, "new_secret"); err == nil {
+		t.Fatal("opened with the wrong secret")
+	}
+	plain, err := decryptZoomContextFrame(buf, frame, "old_secret")
+	if err != nil || string(plain) != `{"uid":"u1","mid":"m1"}` {
+		t.Fatalf("decrypt = %q, %v", plain, err)
+	}
+	if !bytes.Equal(b, orig) {
+		t.Error("decrypting wrote to the input")
+	}
+
+	// Odd IV lengths from crafted contexts do not grow the key cache
+	for n := range 4 * maxContextAEADs {
+		contextAEAD("old_secret", n+1)
+	}
+	contextAEADs.RLock()
+	defer contextAEADs.RUnlock()
+	if len(contextAEADs.m) > maxContextAEADs {
+		t.Errorf("%d cached keys", len(contextAEADs.m))
+	}
+}
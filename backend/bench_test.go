@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var roomSizes = []int{10, 100, 1000}
+
+type storeBackend struct {
+	name  string
+	setup func(b *testing.B)
+}
+
+var storeBackends = []storeBackend{
+	{"memory", func(b *testing.B) { useRedis = false }},
+	{"redis", func(b *testing.B) {
+		mr, client := setupTestRedis()
+		rdb = client
+		b.Cleanup(func() {
+			client.Close()
+			mr.Close()
+			rdb = nil
+			useRedis = false
+		})
+	}},
+}
+
+func BenchmarkGenerateGaugeHTML(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		generateGaugeHTML(defaultLocale, float64(i%100), i%100 == 99, defaultSettings.Load())
+	}
+}
+
+// BenchmarkVoteAndCheck measures one vote followed by the trigger evaluation,
+// the work done per POST /api/vote.
+func BenchmarkVoteAndCheck(b *testing.B) {
+	ctx := context.Background()
+	for _, backend := range storeBackends {
+		for _, size := range roomSizes {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, size), func(b *testing.B) {
+				backend.setup(b)
+				room := fmt.Sprintf("bench-vote-%s-%d", backend.name, size)
+				for p := 0; p < size; p++ {
+					AddParticipant(ctx, room, fmt.Sprintf("u%d", p))
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// Cycle through a third of the room: the threshold is never reached and most votes are duplicates
+					Vote(ctx, room, fmt.Sprintf("u%d", i%(size/3+1)))
+					CheckTriggerStatus(ctx, room)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRoomPollRound measures one polling round of a room: every
+// participant fetching /api/state once. This is the polling equivalent of a
+// broadcast fan-out.
+func BenchmarkRoomPollRound(b *testing.B) {
+	for _, backend := range storeBackends {
+		for _, size := range roomSizes {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, size), func(b *testing.B) {
+				backend.setup(b)
+				room := fmt.Sprintf("bench-poll-%s-%d", backend.name, size)
+				enableDevBypass(b)
+				handler := AuthMiddleware(handleGetState)
+
+				reqs := make([]*http.Request, size)
+				for p := range reqs {
+					reqs[p] = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/state?roomId=%s&pid=u%d", room, p), nil)
+					reqs[p].RemoteAddr = "127.0.0.1:50000"
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for _, req := range reqs {
+						handler(httptest.NewRecorder(), req)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkVerifyZoomContext(b *testing.B) {
+	b.Setenv("ZOOM_CLIENT_SECRET", "new_secret")
+	b.Setenv("ZOOM_CLIENT_PREVIOUS_SECRETS", "old_secret")
+	payload := []byte(`{"uid":"u1","mid":"m1","role":"host","ts":1760000000000}`)
+	for _, secret := range []string{"new_secret", "old_secret"} {
+		appContext, err := EncryptZoomContext(secret, payload)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(secret, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := VerifyZoomContext(appContext); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// BenchmarkDay is one day of the cross-tenant pool: the summed rollups of
+// the tenants that opted in, without saying which tenants they were.
+type BenchmarkDay struct {
+	Date          string `json:"date"`
+	Tenants       int    `json:"tenants"`
+	Held          int    `json:"held"`
+	Meetings      int    `json:"meetings"`
+	LengthBuckets []int  `json:"length_buckets"`
+}
+
+func (d *BenchmarkDay) add(ru ReportRollup) {
+	d.Tenants++
+	d.Held += ru.Held
+	d.Meetings += ru.Meetings
+	if d.LengthBuckets == nil {
+		d.LengthBuckets = make([]int, len(lengthBucketMinutes)+1)
+	}
+	for i, n := range ru.LengthBuckets {
+		if i < len(d.LengthBuckets) {
+			d.LengthBuckets[i] += n
+		}
+	}
+}
+
+// benchmarkMinTenants is how many tenants must contribute to a day before
+// it enters the pool, so no single tenant's figures can be read back out.
+const benchmarkMinTenants = 3
+
+// benchmarkPeriod is the window compared by the stats API.
+const benchmarkPeriod = 28 * 24 * time.Hour
+
+// benchmarkOptIn reports whether the tenant shares its aggregates. Only
+// tenants that contribute may compare themselves to the pool.
+func benchmarkOptIn(tenant string) bool {
+	t := tenantByID(tenant)
+	return t != nil && t.Benchmark
+}
+
+// runBenchmark rebuilds the pool's last rollupDays days from the rollups
+// of the opted-in tenants. A tenant that opts out leaves those days on
+// the next run; older days stay, as they cannot be traced back to it.
+func runBenchmark(ctx context.Context, _ *ScheduledJob) error {
+	today := startOfDay(clock.Now())
+	pool := map[string]*BenchmarkDay{}
+	for i := 1; i <= rollupDays; i++ {
+		date := today.AddDate(0, 0, -i).Format("2006-01-02")
+		pool[date] = &BenchmarkDay{Date: date}
+	}
+	for _, tenant := range scheduledTenants() {
+		if !benchmarkOptIn(tenant) {
+			continue
+		}
+		rollups, err := Rollups(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		for _, ru := range rollups {
+			if d, ok := pool[ru.Date]; ok {
+				d.add(ru)
+			}
+		}
+	}
+	for date, d := range pool {
+		if d.Tenants < benchmarkMinTenants {
+			d = nil
+		}
+		if err := PutBenchmarkDay(ctx, date, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BenchmarkFigures compares meetings over the benchmark period.
+type BenchmarkFigures struct {
+	// Tenants is the most tenants that contributed to a day, pool only
+	Tenants int `json:"tenants,omitempty"`
+
+	Held        int     `json:"meetings_held"`
+	Triggered   int     `json:"meetings_triggered"`
+	TriggerRate float64 `json:"trigger_rate"`
+
+	// LengthDistribution is the share of triggered meetings per length
+	// bucket; the last bucket has no upper bound
+	LengthDistribution []LengthShare `json:"length_distribution"`
+}
+
+type LengthShare struct {
+	MaxMinutes int     `json:"max_minutes,omitempty"`
+	Share      float64 `json:"share"`
+}
+
+func benchmarkFigures(days []BenchmarkDay) *BenchmarkFigures {
+	var sum BenchmarkDay
+	f := &BenchmarkFigures{}
+	for _, d := range days {
+		f.Tenants = max(f.Tenants, d.Tenants)
+		sum.add(ReportRollup{Held: d.Held, Meetings: d.Meetings, LengthBuckets: d.LengthBuckets})
+	}
+	f.Held, f.Triggered = sum.Held, sum.Meetings
+	if f.Held > 0 {
+		f.TriggerRate = min(float64(f.Triggered)/float64(f.Held), 1)
+	}
+	for i, n := range sum.LengthBuckets {
+		ls := LengthShare{}
+		if i < len(lengthBucketMinutes) {
+			ls.MaxMinutes = lengthBucketMinutes[i]
+		}
+		if f.Triggered > 0 {
+			ls.Share = float64(n) / float64(f.Triggered)
+		}
+		f.LengthDistribution = append(f.LengthDistribution, ls)
+	}
+	return f
+}
+
+// handleBenchmark serves GET /api/stats/benchmark to hosts of opted-in
+// tenants: their own figures over the last four weeks next to the pool's.
+// The pool is null until enough tenants contribute.
+func handleBenchmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() || !benchmarkOptIn(zCtx.Tenant) {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	since := startOfDay(clock.Now().Add(-benchmarkPeriod)).Format("2006-01-02")
+	rollups, err := Rollups(ctx, zCtx.Tenant)
+	var pool []BenchmarkDay
+	if err == nil {
+		pool, err = BenchmarkDays(ctx)
+	}
+	if err != nil {
+		logf(ctx, "benchmark error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var own, pooled []BenchmarkDay
+	for _, ru := range rollups {
+		if ru.Date >= since {
+			own = append(own, BenchmarkDay{Held: ru.Held, Meetings: ru.Meetings, LengthBuckets: ru.LengthBuckets})
+		}
+	}
+	for _, d := range pool {
+		if d.Date >= since {
+			pooled = append(pooled, d)
+		}
+	}
+	resp := struct {
+		Since     string            `json:"since"`
+		Tenant    *BenchmarkFigures `json:"tenant"`
+		Benchmark *BenchmarkFigures `json:"benchmark"`
+	}{Since: since, Tenant: benchmarkFigures(own)}
+	if len(pooled) > 0 {
+		resp.Benchmark = benchmarkFigures(pooled)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
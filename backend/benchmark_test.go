@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkPool(t *testing.T) {
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+	useTenants(t, []*Tenant{
+		{ID: "bench-a", Benchmark: true},
+		{ID: "bench-b", Benchmark: true},
+		{ID: "bench-c", Benchmark: true},
+		{ID: "bench-private"},
+	})
+
+	yesterday := startOfDay(fc.now).AddDate(0, 0, -1).Format("2006-01-02")
+	earlier := startOfDay(fc.now).AddDate(0, 0, -2).Format("2006-01-02")
+	for _, tenant := range []string{"bench-a", "bench-b", "bench-c", "bench-private"} {
+		SaveRollup(ctx, tenant, ReportRollup{Date: yesterday, Held: 4, Meetings: 2, LengthBuckets: []int{0, 0, 0, 1, 1, 0, 0}})
+	}
+	SaveRollup(ctx, "bench-a", ReportRollup{Date: earlier, Held: 1, Meetings: 1, LengthBuckets: []int{1, 0, 0, 0, 0, 0, 0}})
+
+	if err := runBenchmark(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	days, _ := BenchmarkDays(ctx)
+	if len(days) != 1 {
+		t.Fatalf("pool = %+v", days)
+	}
+	// The day one tenant contributed to stays out of the pool
+	if d := days[0]; d.Date != yesterday || d.Tenants != 3 || d.Held != 12 || d.Meetings != 6 || d.LengthBuckets[3] != 3 {
+		t.Errorf("pool day = %+v", d)
+	}
+
+	f := benchmarkFigures(days)
+	if f.TriggerRate != 0.5 || f.LengthDistribution[3] != (LengthShare{MaxMinutes: 60, Share: 0.5}) || f.LengthDistribution[6].MaxMinutes != 0 {
+		t.Errorf("figures = %+v", f)
+	}
+
+	// An opt-out drops the recent days below the minimum
+	(*tenants.Load())[2].Benchmark = false
+	runBenchmark(ctx, nil)
+	if days, _ := BenchmarkDays(ctx); len(days) != 0 {
+		t.Errorf("pool after opt-out = %+v", days)
+	}
+	PutBenchmarkDay(ctx, yesterday, nil)
+}
+
+func TestBenchmarkAPI(t *testing.T) {
+	ts := newTestServer(t)
+	fc := useFakeClock(t)
+	useTenants(t, []*Tenant{
+		{ID: "bench-in", Secrets: []string{"in_secret"}, Benchmark: true},
+		{ID: "bench-out", Secrets: []string{"out_secret"}},
+	})
+	ctx := context.Background()
+	date := startOfDay(fc.now).AddDate(0, 0, -3).Format("2006-01-02")
+	SaveRollup(ctx, "bench-in", ReportRollup{Date: date, Held: 2, Meetings: 1, LengthBuckets: []int{0, 1, 0, 0, 0, 0, 0}})
+	SaveRollup(ctx, "bench-in", ReportRollup{Date: startOfDay(fc.now.Add(-60 * 24 * time.Hour)).Format("2006-01-02"), Held: 9, Meetings: 9})
+
+	get := func(secret, role string) (int, map[string]json.RawMessage) {
+		appContext, _ := EncryptZoomContext(secret, []byte(`{"uid":"h1","mid":"m1","role":"`+role+`"}`))
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/stats/benchmark", nil)
+		req.Header.Set("x-zoom-app-context", appContext)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]json.RawMessage
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if code, _ := get("out_secret", "host"); code != http.StatusForbidden {
+		t.Errorf("tenant without opt-in: %d", code)
+	}
+	if code, _ := get("in_secret", "attendee"); code != http.StatusForbidden {
+		t.Errorf("attendee: %d", code)
+	}
+	code, body := get("in_secret", "host")
+	if code != http.StatusOK {
+		t.Fatalf("host: %d", code)
+	}
+	var own BenchmarkFigures
+	json.Unmarshal(body["tenant"], &own)
+	if own.Held != 2 || own.TriggerRate != 0.5 || own.LengthDistribution[1].Share != 1 {
+		t.Errorf("own figures = %+v", own)
+	}
+	if string(body["benchmark"]) != "null" {
+		t.Errorf("benchmark without a pool = %s", body["benchmark"])
+	}
+}
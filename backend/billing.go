@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Billing export: the billing job turns each tenant's metering into meter
+// events of Stripe's usage-based billing, sent to the Billing Meter Events
+// API with STRIPE_API_KEY, and/or written as JSON lines to BILLING_EXPORT
+// for a pipeline of one's own. Every run reports, per tenant with a
+// Stripe customer:
+//
+//   - <prefix>triggers: the triggers so far this month, for a meter with
+//     the "last" aggregation
+//   - <prefix>active_meetings and <prefix>participants: what is connected
+//     at the run, which a "sum" meter turns into meeting-hours and
+//     participant-hours with the default hourly schedule
+//
+// Identifiers are derived from the tenant and the run's minute and sent
+// as idempotency keys, so Stripe counts an event sent twice once.
+
+// MeterEvent is a Stripe meter event, as POST /v1/billing/meter_events
+// takes it and as BILLING_EXPORT lines are written.
+type MeterEvent struct {
+	EventName  string            `json:"event_name"`
+	Identifier string            `json:"identifier"`
+	Timestamp  int64             `json:"timestamp"` // Unix seconds
+	Payload    map[string]string `json:"payload"`
+}
+
+var (
+	stripeAPIURL = "https://api.stripe.com"
+	stripeHTTP   = &http.Client{Timeout: 10 * time.Second}
+)
+
+// billingExport is where the billing job sends meter events.
+type billingExport struct {
+	stripeKey string
+	store     objectStore // nil without BILLING_EXPORT
+	prefix    string
+	// eventPrefix starts every event name, e.g. "hotaru_"
+	eventPrefix string
+	// customer is the Stripe customer of a single-tenant deployment
+	customer string
+}
+
+// biller is set when STRIPE_API_KEY or BILLING_EXPORT is.
+var biller *billingExport
+
+// startBilling sets up the billing export, if configured.
+func startBilling(cfg *Config) error {
+	key := secretValue("STRIPE_API_KEY")
+	if key == "" && cfg.BillingExport == "" {
+		return nil
+	}
+	b := &billingExport{stripeKey: key, eventPrefix: cfg.StripeEventPrefix, customer: cfg.StripeCustomer}
+	if cfg.BillingExport != "" {
+		var err error
+		if strings.HasPrefix(cfg.BillingExport, "s3://") || strings.HasPrefix(cfg.BillingExport, "gs://") {
+			b.store, b.prefix, err = openObjectStore(cfg.BillingExport, cfg.ArchiveEndpoint)
+		} else {
+			b.store, err = openDirStore(cfg.BillingExport)
+		}
+		if err != nil {
+			return fmt.Errorf("BILLING_EXPORT: %w", err)
+		}
+	}
+	biller = b
+	if key != "" {
+		log.Printf("Sending usage to Stripe as meter events.")
+	}
+	if cfg.BillingExport != "" {
+		log.Printf("Exporting usage for billing to %s.", cfg.BillingExport)
+	}
+	return nil
+}
+
+// stripeCustomer returns the tenant's Stripe customer ID, or "" when the
+// tenant is not billed.
+func (b *billingExport) stripeCustomer(tenant string) string {
+	if tenant == "" {
+		return b.customer
+	}
+	if t := tenantByID(tenant); t != nil {
+		return t.StripeCustomer
+	}
+	return ""
+}
+
+// meterEvents are the events of the tenant's usage as of run.
+func (b *billingExport) meterEvents(u TenantUsage, customer string, run time.Time) []MeterEvent {
+	var events []MeterEvent
+	for _, m := range []struct {
+		name  string
+		value int
+	}{
+		{"triggers", u.Triggers},
+		{"active_meetings", u.ActiveMeetings},
+		{"participants", u.Participants},
+	} {
+		name := b.eventPrefix + m.name
+		events = append(events, MeterEvent{
+			EventName:  name,
+			Identifier: cmp.Or(u.Tenant, "_") + "-" + name + "-" + run.UTC().Format("20060102T1504Z"),
+			Timestamp:  run.Unix(),
+			Payload:    map[string]string{"stripe_customer_id": customer, "value": strconv.Itoa(m.value)},
+		})
+	}
+	return events
+}
+
+// runBilling reports the usage of every billed tenant. It does nothing
+// unless the billing export is configured.
+func runBilling(ctx context.Context, _ *ScheduledJob) error {
+	b := biller
+	if b == nil {
+		return nil
+	}
+	run := clock.Now().Truncate(time.Minute)
+	var events []MeterEvent
+	var errs []error
+	for _, tenant := range scheduledTenants() {
+		customer := b.stripeCustomer(tenant)
+		if customer == "" {
+			continue
+		}
+		u, err := tenantUsage(ctx, tenant, usageMonth(run))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", tenant, err))
+			continue
+		}
+		events = append(events, b.meterEvents(u, customer, run)...)
+	}
+	if len(events) == 0 {
+		return errors.Join(errs...)
+	}
+
+	if b.store != nil {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, e := range events {
+			enc.Encode(e)
+		}
+		key := b.prefix + run.UTC().Format("2006/01/02/150405") + ".jsonl"
+		if err := b.store.put(ctx, key, buf.Bytes(), "application/x-ndjson"); err != nil {
+			errs = append(errs, fmt.Errorf("billing export: %w", err))
+		}
+	}
+	if b.stripeKey != "" {
+		sent := 0
+		for _, e := range events {
+			if err := b.sendToStripe(ctx, e); err != nil {
+				metrics.Add("billing_errors", 1)
+				errs = append(errs, fmt.Errorf("%s: %w", e.Identifier, err))
+				continue
+			}
+			sent++
+		}
+		metrics.Add("billing_events", int64(sent))
+	}
+	return errors.Join(errs...)
+}
+
+// sendToStripe creates the meter event. The identifier doubles as the
+// idempotency key, so a request retried within a day is answered with the
+// first response.
+func (b *billingExport) sendToStripe(ctx context.Context, e MeterEvent) error {
+	form := url.Values{
+		"event_name": {e.EventName},
+		"identifier": {e.Identifier},
+		"timestamp":  {strconv.FormatInt(e.Timestamp, 10)},
+	}
+	for k, v := range e.Payload {
+		form.Set("payload["+k+"]", v)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stripeAPIURL+"/v1/billing/meter_events", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.stripeKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", e.Identifier)
+	resp, err := stripeHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return fmt.Errorf("stripe: %s: %s", resp.Status, body.Error.Message)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBillingExport(t *testing.T) {
+	useRedis = false
+	useMemoryStore(t)
+	meteredPanels.Clear()
+	fc := useFakeClock(t)
+	fc.now = time.Date(2026, 10, 16, 10, 5, 30, 0, time.UTC)
+	ctx := context.Background()
+	useTenants(t, []*Tenant{
+		{ID: "paid", Secrets: []string{"s"}, StripeCustomer: "cus_123"},
+		{ID: "free", Secrets: []string{"s"}},
+	})
+
+	var mu sync.Mutex
+	var forms []url.Values
+	failing := false
+	stripe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/billing/meter_events" || r.Header.Get("Authorization") != "Bearer sk_test" || r.Header.Get("Idempotency-Key") != r.PostFormValue("identifier") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "No active meter found"}}`))
+			return
+		}
+		forms = append(forms, r.PostForm)
+		w.Write([]byte(`{"object": "billing.meter_event"}`))
+	}))
+	defer stripe.Close()
+	oldURL := stripeAPIURL
+	stripeAPIURL = stripe.URL
+	store, err := openDirStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	biller = &billingExport{stripeKey: "sk_test", store: store, eventPrefix: "hotaru_"}
+	t.Cleanup(func() { stripeAPIURL, biller = oldURL, nil })
+
+	for _, uid := range []string{"u1", "u2"} {
+		admitConnection(ctx, &ZoomAuthContext{Tenant: "paid", Mid: "m1", UID: uid})
+	}
+	admitConnection(ctx, &ZoomAuthContext{Tenant: "free", Mid: "m2", UID: "u3"})
+	countTrigger(ctx, "paid", fc.now)
+	if err := runBilling(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, f := range forms {
+		if f.Get("payload[stripe_customer_id]") != "cus_123" || f.Get("timestamp") != "1792145100" {
+			t.Errorf("meter event %v", f)
+		}
+		got[f.Get("event_name")] = f.Get("payload[value]")
+	}
+	if len(forms) != 3 || got["hotaru_triggers"] != "1" || got["hotaru_active_meetings"] != "1" || got["hotaru_participants"] != "2" {
+		t.Errorf("sent %v", got)
+	}
+	if id := forms[0].Get("identifier"); id != "paid-hotaru_triggers-20261016T1005Z" {
+		t.Errorf("identifier %q", id)
+	}
+
+	// The same events, one per line
+	b, err := store.get(ctx, "2026/10/16/100500.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var e MeterEvent
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[0]), &e) != nil || e.Payload["stripe_customer_id"] != "cus_123" {
+		t.Errorf("export:\n%s", b)
+	}
+
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	fc.Advance(time.Hour)
+	if err := runBilling(ctx, nil); err == nil || !strings.Contains(err.Error(), "No active meter found") {
+		t.Errorf("Stripe failure: %v", err)
+	}
+}
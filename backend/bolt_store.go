@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltDocs = []byte("docs")
+
+// boltTable keeps a localStore's documents in a bbolt file. It is pure Go,
+// so it works in binaries built without cgo. Each value is the expiry as
+// Unix milliseconds, 0 for never, and the version, both 8 bytes
+// big-endian, followed by the document's JSON.
+type boltTable struct {
+	db *bolt.DB
+}
+
+func openBoltTable(path string) (*boltTable, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltDocs)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltTable{db: db}, nil
+}
+
+func decodeBoltItem(v []byte) tableItem {
+	if len(v) < 16 {
+		return tableItem{}
+	}
+	it := tableItem{
+		data:    bytes.Clone(v[16:]),
+		version: int64(binary.BigEndian.Uint64(v[8:])),
+	}
+	if ms := int64(binary.BigEndian.Uint64(v)); ms != 0 {
+		it.expires = time.UnixMilli(ms)
+	}
+	return it
+}
+
+// version returns the version of the value at key, 0 for none.
+func (t *boltTable) version(b *bolt.Bucket, key []byte) int64 {
+	return decodeBoltItem(b.Get(key)).version
+}
+
+func (t *boltTable) get(_ context.Context, key string) (tableItem, error) {
+	var it tableItem
+	err := t.db.View(func(tx *bolt.Tx) error {
+		it = decodeBoltItem(tx.Bucket(boltDocs).Get([]byte(key)))
+		return nil
+	})
+	return it, err
+}
+
+func (t *boltTable) put(_ context.Context, key string, data []byte, expires time.Time, version int64) error {
+	return t.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDocs)
+		if t.version(b, []byte(key)) != version {
+			return errVersionChanged
+		}
+		var ms int64
+		if !expires.IsZero() {
+			ms = expires.UnixMilli()
+		}
+		v := make([]byte, 16, 16+len(data))
+		binary.BigEndian.PutUint64(v, uint64(ms))
+		binary.BigEndian.PutUint64(v[8:], uint64(version+1))
+		return b.Put([]byte(key), append(v, data...))
+	})
+}
+
+func (t *boltTable) del(_ context.Context, key string, version int64) error {
+	return t.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDocs)
+		if t.version(b, []byte(key)) != version {
+			return errVersionChanged
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (t *boltTable) scan(_ context.Context, prefix string, fn func(key string, it tableItem) bool) error {
+	return t.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltDocs).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if !fn(string(k), decodeBoltItem(v)) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (t *boltTable) sweep(_ context.Context, now time.Time) error {
+	return t.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDocs)
+		var dead [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if it := decodeBoltItem(v); it.version == 0 || expired(it.expires, now) {
+				dead = append(dead, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range dead {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (t *boltTable) close() error {
+	return t.db.Close()
+}
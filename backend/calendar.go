@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// calendarSource reads the calendar of a meeting's host, so the panel and
+// the reports use the schedule people actually booked rather than the
+// length typed into Zoom.
+type calendarSource interface {
+	// Events returns the events on host's calendar overlapping from..to.
+	Events(ctx context.Context, host string, from, to time.Time) ([]calendarEvent, error)
+}
+
+// calendarEvent is a timed event; all-day events are left out.
+type calendarEvent struct {
+	Start, End time.Time
+	// Text is everything a Zoom join link or meeting ID may be written in:
+	// location, description and conference links
+	Text string
+}
+
+var (
+	calendarHTTP     = &http.Client{Timeout: 5 * time.Second}
+	defaultCalendar  atomic.Pointer[calendarSource]
+	tenantCalendars  sync.Map // tenant ID -> calendarSource
+	calendarLookback = 12 * time.Hour
+
+	// backToBackGap is the most time between the meeting's end and the
+	// next event for the two to be back to back
+	backToBackGap = 5 * time.Minute
+)
+
+// calendarFor returns the calendar of a tenant, or the deployment-wide one.
+// Nil means no calendar is connected.
+func calendarFor(tenant string) calendarSource {
+	if tenant != "" {
+		if c, ok := tenantCalendars.Load(tenant); ok {
+			return c.(calendarSource)
+		}
+		return nil
+	}
+	if c := defaultCalendar.Load(); c != nil {
+		return *c
+	}
+	return nil
+}
+
+// configuredCalendar returns the deployment's calendar: Google Calendar
+// with GOOGLE_CALENDAR_CREDENTIALS, Outlook with MS_GRAPH_TENANT_ID, an
+// iCalendar feed with ICS_URL, or nil.
+func configuredCalendar(cfg *Config) (calendarSource, error) {
+	if cfg.ICSRefresh > 0 {
+		icsRefresh = cfg.ICSRefresh
+	}
+	google, graph := secretValue("GOOGLE_CALENDAR_CREDENTIALS"), cfg.GraphTenantID != ""
+	switch {
+	case !atMostOne(google != "", graph, cfg.ICSURL != ""):
+		return nil, fmt.Errorf("connect one of Google Calendar, Microsoft Graph or an ICS feed")
+	case cfg.ICSURL != "":
+		return icsFeed(cfg.ICSURL), nil
+	case google != "":
+		return parseGoogleCalendarCredentials(google, cfg.GoogleCalendarID)
+	case graph:
+		return newGraphCalendar(graphCredentials{
+			TenantID:     cfg.GraphTenantID,
+			ClientID:     cfg.GraphClientID,
+			ClientSecret: secretValue("MS_GRAPH_CLIENT_SECRET"),
+			Calendar:     cfg.GraphCalendar,
+		})
+	}
+	return nil, nil
+}
+
+// atMostOne reports whether no more than one of the calendars is set.
+func atMostOne(set ...bool) bool {
+	n := 0
+	for _, ok := range set {
+		if ok {
+			n++
+		}
+	}
+	return n <= 1
+}
+
+// applyCalendar finds the host's event for the meeting, the one mentioning
+// the meeting number that starts closest to now, and takes the meeting's
+// start and scheduled length from it, and the start of the host's next
+// event, noting when that leaves no break. info is left alone when the
+// meeting is not on the calendar.
+func applyCalendar(ctx context.Context, cal calendarSource, info *MeetingInfo, now time.Time) error {
+	if info.HostEmail == "" || info.Number == "" {
+		return nil
+	}
+	events, err := cal.Events(ctx, info.HostEmail, now.Add(-calendarLookback), now.Add(calendarLookback))
+	if err != nil {
+		return err
+	}
+	var match *calendarEvent
+	for i, e := range events {
+		// "Meeting ID: 812 3456 7890"
+		if !strings.Contains(strings.ReplaceAll(e.Text, " ", ""), info.Number) {
+			continue
+		}
+		if match == nil || e.Start.Sub(now).Abs() < match.Start.Sub(now).Abs() {
+			match = &events[i]
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	info.StartTime, info.Duration = match.Start, match.End.Sub(match.Start)
+	info.NextMeeting = time.Time{}
+	for i, e := range events {
+		if &events[i] != match && e.Start.After(match.Start) && (info.NextMeeting.IsZero() || e.Start.Before(info.NextMeeting)) {
+			info.NextMeeting = e.Start
+		}
+	}
+	info.BackToBack = !info.NextMeeting.IsZero() && info.NextMeeting.Sub(match.End) <= backToBackGap
+	return nil
+}
+
+// nextMeetingSoon reports whether the host's next event starts within
+// backToBackGap of now, or already has. False for a nil info.
+func (info *MeetingInfo) nextMeetingSoon(now time.Time) bool {
+	return info != nil && !info.NextMeeting.IsZero() && !now.Before(info.NextMeeting.Add(-backToBackGap))
+}
+
+func doCalendarRequest(req *http.Request, out any) error {
+	resp, err := calendarHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// chaosTimeoutError looks like a network timeout so callers exercise the same
+// handling they would for a real slow Redis.
+type chaosTimeoutError struct{}
+
+func (chaosTimeoutError) Error() string   { return "chaos: injected redis timeout" }
+func (chaosTimeoutError) Timeout() bool   { return true }
+func (chaosTimeoutError) Temporary() bool { return true }
+
+var errChaosTimeout net.Error = chaosTimeoutError{}
+
+// chaosHook randomly fails or delays Redis commands. For test and staging only.
+type chaosHook struct {
+	errorRate  float64       // probability that a command fails with a timeout
+	maxLatency time.Duration // upper bound of random delay added to each command
+}
+
+func (h chaosHook) inject(ctx context.Context) error {
+	if h.maxLatency > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(h.maxLatency)))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if h.errorRate > 0 && rand.Float64() < h.errorRate {
+		return errChaosTimeout
+	}
+	return nil
+}
+
+func (h chaosHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h chaosHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.inject(ctx); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h chaosHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.inject(ctx); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// enableRedisChaos installs the fault-injection hook on the global client.
+func enableRedisChaos(errorRate float64, maxLatency time.Duration) {
+	if rdb == nil || (errorRate <= 0 && maxLatency <= 0) {
+		return
+	}
+	log.Printf("WARNING: chaos mode enabled, injecting Redis failures (error rate %.2f, latency up to %v)", errorRate, maxLatency)
+	for _, client := range redisClients() {
+		client.AddHook(chaosHook{errorRate: errorRate, maxLatency: maxLatency})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChaosRefusedInProduction(t *testing.T) {
+	for _, cfg := range []*Config{
+		{Environment: "production", ChaosErrorRate: 0.1},
+		{Environment: "production", ChaosLatency: time.Second},
+	} {
+		if err := cfg.validateEnvironment(); err == nil {
+			t.Errorf("chaos %+v accepted in production", cfg)
+		}
+	}
+	staging := &Config{Environment: "staging", ChaosErrorRate: 0.1, ChaosLatency: time.Second}
+	if err := staging.validateEnvironment(); err != nil {
+		t.Errorf("chaos refused in staging: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const usage = `Usage: hotaruend <command> [flags]
+
+Commands:
+  serve             Run the HTTP server (default)
+  check-config      Validate configuration and Redis connectivity
+  generate-context  Print an encrypted x-zoom-app-context for local testing
+
+Run "hotaruend <command> -h" for command flags.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run dispatches to a subcommand and returns the process exit code.
+func run(args []string) int {
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = cmdServe(args)
+	case "check-config":
+		err = cmdCheckConfig(args)
+	case "generate-context":
+		err = cmdGenerateContext(args)
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+func cmdServe(args []string) error {
+	cfg, err := loadConfigArgs("serve", args)
+	if err != nil {
+		return err
+	}
+	reloadArgs = args
+	return serve(cfg)
+}
+
+func cmdCheckConfig(args []string) error {
+	cfg, err := loadConfigArgs("check-config", args)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	report := func(ok bool, format string, a ...any) {
+		mark := "ok  "
+		if !ok {
+			mark = "FAIL"
+			failed = true
+		}
+		fmt.Printf("[%s] %s\n", mark, fmt.Sprintf(format, a...))
+	}
+
+	if err := cfg.validateEnvironment(); err != nil {
+		report(false, "%v", err)
+	} else {
+		report(true, "environment: %s (dev bypass %t, dev tools %t)", cfg.Environment, cfg.DevBypass, cfg.DevTools)
+	}
+	report(cfg.Port != "", "port: %q", cfg.Port)
+
+	if _, err := os.Stat(filepath.Join(cfg.FrontendDir, "index.html")); err != nil {
+		report(false, "frontend: %v", err)
+	} else {
+		report(true, "frontend: serving %s", cfg.FrontendDir)
+	}
+
+	if err := cfg.validateTLS(); err != nil {
+		report(false, "%v", err)
+	} else if cfg.AutocertHosts != "" {
+		report(true, "tls: autocert for %v", splitList(cfg.AutocertHosts))
+	} else if cfg.TLSCertFile != "" {
+		_, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		report(err == nil, "tls: static certificate: %v", errOrOK(err))
+	} else {
+		report(true, "tls: disabled, expecting a TLS-terminating proxy")
+	}
+
+	if proxies, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		report(false, "trusted proxies: %v", err)
+	} else {
+		report(true, "trusted proxies: %d configured", len(proxies))
+	}
+
+	if cfg.TenantsFile != "" {
+		list, err := readTenantsFile(cfg.TenantsFile)
+		report(err == nil, "tenants file: %d tenant(s): %v", len(list), errOrOK(err))
+	}
+
+	if jobs, err := readScheduleFile(cfg.ScheduleFile); err != nil {
+		report(false, "schedule: %v", err)
+	} else {
+		report(true, "schedule: %d job(s)", len(jobs))
+	}
+
+	if cfg.SecretProvider != "" {
+		provider, err := newSecretProvider(cfg.SecretProvider)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err = refreshSecrets(ctx, provider)
+			cancel()
+		}
+		report(err == nil, "secret provider %s: %v", cfg.SecretProvider, errOrOK(err))
+	} else if cfg.ZoomSecretsFile != "" {
+		secrets, err := readSecretsFile(cfg.ZoomSecretsFile)
+		report(err == nil, "zoom secrets file: %d secret(s): %v", len(secrets), errOrOK(err))
+	} else if strings.TrimSpace(os.Getenv("ZOOM_CLIENT_SECRET")) == "" {
+		report(false, "ZOOM_CLIENT_SECRET is not set")
+	} else {
+		report(true, "ZOOM_CLIENT_SECRET is set (+%d previous)", len(splitList(os.Getenv("ZOOM_CLIENT_PREVIOUS_SECRETS"))))
+	}
+
+	if cfg.ZoomAccountID != "" || cfg.ZoomAPIClientID != "" {
+		api := newZoomAPIClient(zoomAPICredentials{
+			AccountID:    cfg.ZoomAccountID,
+			ClientID:     cfg.ZoomAPIClientID,
+			ClientSecret: secretValue("ZOOM_API_CLIENT_SECRET"),
+		})
+		if api == nil {
+			report(false, "zoom api: ZOOM_ACCOUNT_ID, ZOOM_API_CLIENT_ID and ZOOM_API_CLIENT_SECRET are all required")
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, err := api.accessToken(ctx)
+			cancel()
+			report(err == nil, "zoom api: token: %v", errOrOK(err))
+		}
+	}
+
+	if cfg.ArchiveURL != "" {
+		_, _, err := openObjectStore(cfg.ArchiveURL, cfg.ArchiveEndpoint)
+		report(err == nil, "archive: %s: %v", cfg.ArchiveURL, errOrOK(err))
+	}
+	if strings.HasPrefix(cfg.BillingExport, "s3://") || strings.HasPrefix(cfg.BillingExport, "gs://") {
+		_, _, err := openObjectStore(cfg.BillingExport, cfg.ArchiveEndpoint)
+		report(err == nil, "billing export: %s: %v", cfg.BillingExport, errOrOK(err))
+	}
+
+	backend, storePath, err := cfg.storeBackend()
+	switch {
+	case err != nil:
+		report(false, "store: %v", err)
+	case backend == storeMemory:
+		report(true, "store: in memory, nothing is kept across restarts")
+	case backend == storeDynamo:
+		d, err := newDynamoTable(cfg)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = d.describe(ctx)
+			cancel()
+		}
+		report(err == nil, "dynamodb: table %s: %v", cfg.DynamoTable, errOrOK(err))
+		if os.Getenv("UID_HASH_KEY") == "" && cfg.SecretProvider == "" {
+			report(cfg.Environment != "production", "UID_HASH_KEY is not set; votes are only deduplicated per instance")
+		}
+	case backend != storeRedis:
+		report(true, "store: %s file at %s", backend, storePath)
+	default:
+		opt, err := cfg.redisOptions()
+		if err != nil {
+			report(false, "redis: invalid settings: %v", err)
+		} else {
+			client := redis.NewClient(opt)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := client.Ping(ctx).Err()
+			cancel()
+			client.Close()
+			report(err == nil, "redis: ping %s (key prefix %q, pool size %d): %v", opt.Addr, cfg.RedisKeyPrefix, client.Options().PoolSize, errOrOK(err))
+		}
+		if os.Getenv("UID_HASH_KEY") == "" && cfg.SecretProvider == "" {
+			report(cfg.Environment != "production", "UID_HASH_KEY is not set; votes are only deduplicated per instance")
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("configuration has errors")
+	}
+	return nil
+}
+
+func errOrOK(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+func cmdGenerateContext(args []string) error {
+	fs := flag.NewFlagSet("generate-context", flag.ContinueOnError)
+	uid := fs.String("uid", "local-user", "participant uid")
+	mid := fs.String("mid", "local-meeting", "meeting id")
+	secret := fs.String("secret", "", "client secret (default: the current ZOOM_CLIENT_SECRET)")
+	tenant := fs.String("tenant", "", "use the current secret of this tenant from TENANTS_FILE")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *secret == "" && *tenant != "" {
+		list, err := readTenantsFile(envOr("TENANTS_FILE", ""))
+		if err != nil {
+			return err
+		}
+		for _, t := range list {
+			if t.ID == *tenant {
+				*secret = t.Secrets[0]
+			}
+		}
+		if *secret == "" {
+			return fmt.Errorf("tenant %q not found", *tenant)
+		}
+	}
+
+	if *secret == "" {
+		if path := envOr("ZOOM_CLIENT_SECRETS_FILE", ""); path != "" {
+			if err := loadSecretsFile(path); err != nil {
+				return err
+			}
+		}
+		*secret = getZoomClientSecret()
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"uid": *uid,
+		"mid": *mid,
+		"ts":  clock.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+
+	appContext, err := EncryptZoomContext(*secret, payload)
+	if err != nil {
+		return err
+	}
+	fmt.Println(appContext)
+	return nil
+}
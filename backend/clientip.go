@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the networks whose X-Forwarded-For / X-Real-IP headers
+// are believed (e.g. ngrok, an ALB). Empty means headers are ignored.
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses a comma separated list of CIDRs or bare IPs.
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
+
+// fromTrustedProxy reports whether the direct peer is a trusted proxy, i.e.
+// whether its forwarding headers may be used.
+func fromTrustedProxy(r *http.Request) bool {
+	return isTrusted(remoteAddr(r))
+}
+
+// clientIP returns the real client address. Forwarding headers are only
+// honored when the direct peer is a trusted proxy; X-Forwarded-For is walked
+// right to left, skipping further trusted hops, so a client cannot spoof its
+// address by sending its own header.
+func clientIP(r *http.Request) netip.Addr {
+	addr := remoteAddr(r)
+	if !isTrusted(addr) {
+		return addr
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			addr = hop.Unmap()
+			if !isTrusted(addr) {
+				return addr
+			}
+		}
+		return addr
+	}
+
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.Unmap()
+	}
+	return addr
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	prefixes, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	prev := trustedProxies
+	trustedProxies = prefixes
+	t.Cleanup(func() { trustedProxies = prev })
+
+	cases := []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"direct client", "203.0.113.5:1234", "", "", "203.0.113.5"},
+		{"untrusted peer spoofing xff", "203.0.113.5:1234", "1.2.3.4", "", "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:80", "198.51.100.7", "", "198.51.100.7"},
+		{"client-supplied hop before proxy", "10.1.2.3:80", "1.2.3.4, 198.51.100.7", "", "198.51.100.7"},
+		{"chain of trusted proxies", "10.1.2.3:80", "198.51.100.7, 192.0.2.1, 10.9.9.9", "", "198.51.100.7"},
+		{"x-real-ip", "192.0.2.1:443", "", "198.51.100.8", "198.51.100.8"},
+		{"garbage xff", "10.1.2.3:80", "not-an-ip", "", "10.1.2.3"},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := clientIP(r).String(); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("expected invalid CIDR to be rejected")
+	}
+}
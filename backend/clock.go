@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// Clock abstracts wall-clock access so TTLs and other time-based rules can be
+// tested deterministically. All time lookups in the server go through clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+var clock Clock = realClock{}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced Clock for tests.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func useFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	fc := newFakeClock(time.Date(2026, 4, 1, 18, 0, 0, 0, time.UTC))
+	prev := clock
+	clock = fc
+	t.Cleanup(func() { clock = prev })
+	return fc
+}
+
+func TestMemRoomTTL(t *testing.T) {
+	fc := useFakeClock(t)
+	useRedis = false
+	ctx := context.Background()
+	roomID := "memTTLRoom"
+
+	AddParticipant(ctx, roomID, "u1")
+	Vote(ctx, roomID, "u1")
+
+	// Activity inside the TTL keeps the room alive
+	fc.Advance(23 * time.Hour)
+	AddParticipant(ctx, roomID, "u2")
+	fc.Advance(23 * time.Hour)
+
+	total, votes, _, _ := CheckTriggerStatus(ctx, roomID)
+	if total != 2 || votes != 1 {
+		t.Errorf("expected room to survive with 2/1, got %d/%d", total, votes)
+	}
+
+	fc.Advance(25 * time.Hour)
+	local.sweep(ctx)
+	if memDocStored(docRoom, roomID) {
+		t.Errorf("expected expired room to be swept")
+	}
+
+	total, votes, triggered, _ := CheckTriggerStatus(ctx, roomID)
+	if total != 0 || votes != 0 || triggered {
+		t.Errorf("expected fresh room after expiry, got %d/%d/%t", total, votes, triggered)
+	}
+}
@@ -0,0 +1,217 @@
+// Command loadtest simulates many panel clients against a running server.
+//
+// Each client joins a room by polling /api/state like the frontend does,
+// a fraction of them vote at a random point inside the vote window, and the
+// tool reports latency percentiles for gauge updates (poll and vote round
+// trips) and for trigger propagation (time from the first response that shows
+// a room as triggered until every client in that room has seen it).
+//
+// Clients identify themselves with roomId/pid query params, so the target
+// server must run with DEV_BYPASS=true and the tool must reach it over
+// loopback, not through a proxy.
+//
+//	go run ./cmd/loadtest -url http://localhost:8080 -clients 200 -rooms 10
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	polls    []time.Duration
+	votes    []time.Duration
+	propag   []time.Duration
+	errors   int
+	trigTime map[string]time.Time // room -> first time any client saw it triggered
+}
+
+func (r *recorder) add(dst *[]time.Duration, d time.Duration) {
+	r.mu.Lock()
+	*dst = append(*dst, d)
+	r.mu.Unlock()
+}
+
+func (r *recorder) fail() {
+	r.mu.Lock()
+	r.errors++
+	r.mu.Unlock()
+}
+
+// sawTrigger records the moment a client first observed its room triggered.
+func (r *recorder) sawTrigger(room string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	first, ok := r.trigTime[room]
+	if !ok {
+		r.trigTime[room] = at
+		first = at
+	}
+	r.propag = append(r.propag, at.Sub(first))
+}
+
+type client struct {
+	http   *http.Client
+	base   string
+	room   string
+	pid    string
+	voteAt time.Duration // zero means this client never votes
+}
+
+func (c *client) endpoint(path string) string {
+	q := url.Values{}
+	q.Set("roomId", c.room)
+	q.Set("pid", c.pid)
+	return c.base + path + "?" + q.Encode()
+}
+
+// do issues one request and reports whether the returned gauge is triggered.
+func (c *client) do(ctx context.Context, method, path string) (bool, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path), nil)
+	if err != nil {
+		return false, 0, err
+	}
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	elapsed := time.Since(start)
+	if err != nil {
+		return false, elapsed, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, elapsed, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return strings.Contains(string(body), `data-triggered="true"`), elapsed, nil
+}
+
+func (c *client) run(ctx context.Context, poll time.Duration, rec *recorder) {
+	start := time.Now()
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	voted := c.voteAt == 0
+	for {
+		var triggered bool
+		var err error
+		var d time.Duration
+
+		if !voted && time.Since(start) >= c.voteAt {
+			voted = true
+			triggered, d, err = c.do(ctx, http.MethodPost, "/api/vote")
+			if err == nil {
+				rec.add(&rec.votes, d)
+			}
+		} else {
+			triggered, d, err = c.do(ctx, http.MethodGet, "/api/state")
+			if err == nil {
+				rec.add(&rec.polls, d)
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			rec.fail()
+		} else if triggered {
+			rec.sawTrigger(c.room, time.Now())
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func report(name string, ds []time.Duration) {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	fmt.Printf("%-12s n=%-6d p50=%-10v p90=%-10v p99=%-10v max=%v\n", name, len(ds),
+		percentile(ds, 0.50).Round(time.Microsecond),
+		percentile(ds, 0.90).Round(time.Microsecond),
+		percentile(ds, 0.99).Round(time.Microsecond),
+		percentile(ds, 1).Round(time.Microsecond))
+}
+
+func main() {
+	base := flag.String("url", "http://localhost:8080", "server base URL")
+	clients := flag.Int("clients", 100, "number of simulated clients")
+	rooms := flag.Int("rooms", 10, "number of rooms to spread clients across")
+	poll := flag.Duration("poll", 2*time.Second, "polling interval (the frontend uses 2s)")
+	voteRatio := flag.Float64("vote-ratio", 0.6, "fraction of clients that vote")
+	voteWindow := flag.Duration("vote-window", 20*time.Second, "votes are spread uniformly over this window")
+	duration := flag.Duration("duration", time.Minute, "maximum test duration")
+	flag.Parse()
+
+	if *clients < 1 || *rooms < 1 {
+		log.Fatal("clients and rooms must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	rec := &recorder{trigTime: map[string]time.Time{}}
+	httpClient := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *clients},
+	}
+	runID := rand.Intn(1_000_000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < *clients; i++ {
+		c := &client{
+			http: httpClient,
+			base: strings.TrimRight(*base, "/"),
+			room: fmt.Sprintf("loadtest-%d-%d", runID, i%*rooms),
+			pid:  fmt.Sprintf("loadtest-%d", i),
+		}
+		if rand.Float64() < *voteRatio {
+			c.voteAt = time.Duration(rand.Int63n(int64(*voteWindow))) + time.Millisecond
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Stagger joins across one poll interval like real panels opening
+			time.Sleep(time.Duration(rand.Int63n(int64(*poll))))
+			c.run(ctx, *poll, rec)
+		}()
+	}
+	wg.Wait()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	fmt.Printf("clients=%d rooms=%d triggered=%d errors=%d\n", *clients, *rooms, len(rec.trigTime), rec.errors)
+	report("poll", rec.polls)
+	report("vote", rec.votes)
+	report("propagation", rec.propag)
+
+	if rec.errors > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// These tests are meant for go test -race: many panels of one room join,
+// vote, leave and poll at once, as they do through several instances.
+
+func TestPresenceContention(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			useFakeClock(t)
+			ctx := context.Background()
+			room := "contention-" + store
+			PurgeRoom(ctx, room) // left over from an earlier -count run
+
+			// Every panel polls a while; then half of them vote and the
+			// other half leave
+			const panels = 16
+			var wg sync.WaitGroup
+			var fired atomic.Int32
+			for i := range panels {
+				uid := "p" + strconv.Itoa(i)
+				wg.Go(func() {
+					for range 20 {
+						if _, err := JoinRoom(ctx, room, uid, defaultPoll); err != nil {
+							t.Error(err)
+							return
+						}
+						_, _, _, ok, err := evaluatePoll(ctx, room, defaultPoll)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if ok {
+							fired.Add(1)
+						}
+					}
+					if i%2 == 1 {
+						if err := RemoveParticipant(ctx, room, uid); err != nil {
+							t.Error(err)
+						}
+					} else if _, err := Vote(ctx, room, uid); err != nil && !errors.Is(err, errPollPassed) {
+						t.Error(err)
+					}
+				})
+			}
+			wg.Wait()
+
+			if _, _, _, ok, _ := evaluatePoll(ctx, room, defaultPoll); ok {
+				fired.Add(1)
+			}
+			total, _, passed, err := CheckTriggerStatus(ctx, room)
+			if err != nil || total != panels/2 || !passed {
+				t.Errorf("status = %d participants, passed %t, %v", total, passed, err)
+			}
+			if n := fired.Load(); n != 1 {
+				t.Errorf("fired %d times", n)
+			}
+		})
+	}
+}
+
+// TestStatusCacheContention polls a room from many goroutines while others
+// join and leave it, and drops every Redis connection halfway, under the
+// invalidation subscriber's read loop and the writes publishing to it.
+func TestStatusCacheContention(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	useFakeClock(t) // the cache never expires: only invalidation refreshes it
+	ctx := context.Background()
+	const room = "contention-cache"
+
+	useStatusSubscription(t)
+	roomStatusesFor(room)
+	subscribed := func() bool {
+		n, _ := client.Publish(ctx, statusChannel(room), "nobody").Result()
+		return n > 0
+	}
+	eventually(t, "the subscriber", subscribed)
+
+	const panels = 8
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := range panels {
+		uid := "p" + strconv.Itoa(i)
+		wg.Go(func() {
+			// Errors are expected while Redis is down
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				JoinRoom(ctx, room, uid, defaultPoll)
+				CheckTriggerStatus(ctx, room)
+				RemoveParticipant(ctx, room, uid)
+				CheckTriggerStatus(ctx, room)
+			}
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	mr.Close()
+	time.Sleep(20 * time.Millisecond)
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// The subscriber came back, and the cache holds no count from before
+	// the last writes
+	eventually(t, "the subscriber to resubscribe", subscribed)
+	for i := range panels {
+		if _, err := JoinRoom(ctx, room, "p"+strconv.Itoa(i), defaultPoll); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total, _, _, err := CheckTriggerStatus(ctx, room); err != nil || total != panels {
+		t.Errorf("status = %d participants, %v", total, err)
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jsonSchema is the part of JSON Schema protocol.schema.json uses.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	Pattern              string                 `json:"pattern"`
+	Format               string                 `json:"format"`
+	Minimum              *float64               `json:"minimum"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+}
+
+func loadProtocolSchema(t *testing.T) *jsonSchema {
+	t.Helper()
+	var s jsonSchema
+	if err := json.Unmarshal(protocolSchema, &s); err != nil {
+		t.Fatalf("protocol.schema.json: %v", err)
+	}
+	return &s
+}
+
+// validate returns where v breaks definition def of root.
+func (root *jsonSchema) validate(def string, v any) []string {
+	s, ok := root.Defs[def]
+	if !ok {
+		return []string{"no definition " + def}
+	}
+	return root.check(s, v, def)
+}
+
+func (root *jsonSchema) check(s *jsonSchema, v any, at string) []string {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		if !ok || root.Defs[name] == nil {
+			return []string{at + ": unresolved $ref " + s.Ref}
+		}
+		return root.check(root.Defs[name], v, at)
+	}
+	if len(s.AnyOf) > 0 {
+		for _, alt := range s.AnyOf {
+			if len(root.check(alt, v, at)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: %v matches no alternative", at, v)}
+	}
+	if s.Enum != nil && !slices.Contains(s.Enum, v) {
+		return []string{fmt.Sprintf("%s: %v is not one of %v", at, v, s.Enum)}
+	}
+
+	var errs []string
+	fail := func(format string, args ...any) []string {
+		return append(errs, at+": "+fmt.Sprintf(format, args...))
+	}
+	switch s.Type {
+	case "":
+	case "null":
+		if v != nil {
+			return fail("want null, got %v", v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("want a boolean, got %v", v)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("want a string, got %v", v)
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			errs = fail("%q does not match %s", str, s.Pattern)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				errs = fail("%q is not a date-time", str)
+			}
+		}
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			return fail("want a %s, got %v", s.Type, v)
+		}
+		if s.Type == "integer" && n != math.Trunc(n) {
+			errs = fail("want an integer, got %v", n)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			errs = fail("%v is below %v", n, *s.Minimum)
+		}
+	case "array":
+		list, ok := v.([]any)
+		if !ok {
+			return fail("want an array, got %v", v)
+		}
+		for i, item := range list {
+			if s.Items != nil {
+				errs = append(errs, root.check(s.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fail("want an object, got %v", v)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = fail("missing %q", name)
+			}
+		}
+		var extra jsonSchema
+		closed := string(s.AdditionalProperties) == "false"
+		if !closed && s.AdditionalProperties != nil {
+			json.Unmarshal(s.AdditionalProperties, &extra)
+		}
+		for name, val := range obj {
+			switch prop := s.Properties[name]; {
+			case prop != nil:
+				errs = append(errs, root.check(prop, val, at+"."+name)...)
+			case closed:
+				errs = fail("%q is not in the schema", name)
+			case s.AdditionalProperties != nil:
+				errs = append(errs, root.check(&extra, val, at+"."+name)...)
+			}
+		}
+	default:
+		return fail("unsupported type %q", s.Type)
+	}
+	return errs
+}
+
+// conforms fails t unless body is a def.
+func (root *jsonSchema) conforms(t *testing.T, def string, body []byte) {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Errorf("%s: %v in %s", def, err, body)
+		return
+	}
+	for _, err := range root.validate(def, v) {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestProtocolSchemaRefs(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	var walk func(s *jsonSchema, at string)
+	walk = func(s *jsonSchema, at string) {
+		if s == nil {
+			return
+		}
+		if name, ok := strings.CutPrefix(s.Ref, "#/$defs/"); s.Ref != "" && (!ok || schema.Defs[name] == nil) {
+			t.Errorf("%s: unresolved $ref %s", at, s.Ref)
+		}
+		for name, p := range s.Properties {
+			walk(p, at+"."+name)
+		}
+		for _, alt := range s.AnyOf {
+			walk(alt, at)
+		}
+		walk(s.Items, at+"[]")
+	}
+	for name, def := range schema.Defs {
+		walk(def, name)
+	}
+
+	// The schema is served as is
+	rec := httptest.NewRecorder()
+	handleProtocolSchema(rec, httptest.NewRequest(http.MethodGet, "/api/protocol.schema.json", nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), protocolSchema) {
+		t.Errorf("served schema: %d", rec.Code)
+	}
+}
+
+// sourceStrings returns the string literals in file picked by pick.
+func sourceStrings(t *testing.T, file string, pick func(n ast.Node) *ast.BasicLit) []any {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []any
+	ast.Inspect(f, func(n ast.Node) bool {
+		if lit := pick(n); lit != nil && lit.Kind == token.STRING {
+			s, _ := strconv.Unquote(lit.Value)
+			out = append(out, s)
+		}
+		return true
+	})
+	return out
+}
+
+// TestProtocolEnums checks the header values in the schema against every
+// value the source can send, so a new one cannot be added unlisted.
+func TestProtocolEnums(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	// The values of the const block declaring member
+	constBlock := func(member string) func(n ast.Node) *ast.BasicLit {
+		var block *ast.GenDecl
+		return func(n ast.Node) *ast.BasicLit {
+			if gd, ok := n.(*ast.GenDecl); ok && gd.Tok == token.CONST && block == nil {
+				for _, spec := range gd.Specs {
+					if slices.ContainsFunc(spec.(*ast.ValueSpec).Names, func(id *ast.Ident) bool { return id.Name == member }) {
+						block = gd
+					}
+				}
+			}
+			if vs, ok := n.(*ast.ValueSpec); ok && block != nil && slices.Contains(block.Specs, ast.Spec(vs)) && len(vs.Values) == 1 {
+				lit, _ := vs.Values[0].(*ast.BasicLit)
+				return lit
+			}
+			return nil
+		}
+	}
+	frames := func(n ast.Node) *ast.BasicLit {
+		if cl, ok := n.(*ast.CompositeLit); ok && len(cl.Elts) > 0 {
+			if id, ok := cl.Type.(*ast.Ident); ok && id.Name == "ErrorFrame" {
+				lit, _ := cl.Elts[0].(*ast.BasicLit)
+				return lit
+			}
+		}
+		return nil
+	}
+	for _, c := range []struct {
+		def  string
+		want []any
+	}{
+		{"VoteAck", sourceStrings(t, "vote_ack.go", constBlock("ackAccepted"))},
+		{"ErrorCode", sourceStrings(t, "errorframe.go", frames)},
+		{"ExportEvent", sourceStrings(t, "export.go", constBlock("exportJoin"))},
+	} {
+		enum := schema.Defs[c.def].Enum
+		if c.def == "ExportEvent" {
+			enum = schema.Defs[c.def].Properties["type"].Enum
+		}
+		slices.SortFunc(enum, func(a, b any) int { return strings.Compare(a.(string), b.(string)) })
+		slices.SortFunc(c.want, func(a, b any) int { return strings.Compare(a.(string), b.(string)) })
+		if len(c.want) == 0 || !slices.Equal(enum, c.want) {
+			t.Errorf("%s: schema lists %v, the source sends %v", c.def, enum, c.want)
+		}
+	}
+}
+
+// TestProtocolContract runs a meeting through every JSON endpoint and
+// trigger action and checks what the server sends against the schema.
+func TestProtocolContract(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	ts := newTestServer(t)
+	useFakeClock(t)
+	if err := startAudioLibrary(&Config{AudioStore: t.TempDir(), AudioMaxBytes: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audioFiles = nil })
+
+	// Trigger actions post to these
+	received := make(chan [2]string, 4)
+	receiver := func(def string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- [2]string{def, string(body)}
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	old := actionSettings
+	t.Cleanup(func() { actionSettings = old })
+	actionSettings.webhookURL = receiver("TriggerEvent").URL
+	actionSettings.chatURL = receiver("ChatMessage").URL
+
+	// The records as the proxy gets them, not decoded into ExportEvent
+	var exported []json.RawMessage
+	var exportMu sync.Mutex
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Records []struct {
+				Value json.RawMessage `json:"value"`
+			} `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		exportMu.Lock()
+		for _, rec := range body.Records {
+			exported = append(exported, rec.Value)
+		}
+		exportMu.Unlock()
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer proxySrv.Close()
+	if err := startEventExport(&Config{KafkaRESTURL: proxySrv.URL, KafkaTopic: "room-events", KafkaExportFormat: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	defer closeEventExport(context.Background())
+
+	const room = "contract-room"
+	send := func(method, path, role, ctype string, body []byte) (int, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path+"?"+url.Values{"roomId": {room}, "pid": {"host"}, "role": {role}}.Encode(), bytes.NewReader(body))
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, b
+	}
+	expect := func(def string, wantStatus int) func(int, []byte) {
+		return func(status int, body []byte) {
+			t.Helper()
+			if status != wantStatus {
+				t.Errorf("%s: status %d, want %d: %s", def, status, wantStatus, body)
+				return
+			}
+			schema.conforms(t, def, body)
+		}
+	}
+
+	host := ts.queryClient(room, "host")
+	host.role = "host"
+	alice, bob, carol := ts.queryClient(room, "alice"), ts.queryClient(room, "bob"), ts.queryClient(room, "carol")
+	for _, c := range []*testClient{host, alice, bob, carol} {
+		c.poll()
+	}
+	if status, body := host.post("/api/actions", url.Values{"actions": {"ending,webhook,chat"}}); status != http.StatusOK {
+		t.Fatalf("set actions: %d %s", status, body)
+	}
+
+	expect("ThemeList", http.StatusOK)(send(http.MethodGet, "/api/themes", "", "", nil))
+	mp3 := append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00}, 100)...)
+	status, body := send(http.MethodPost, "/api/audio", "host", "audio/mpeg", mp3)
+	expect("AudioInfo", http.StatusCreated)(status, body)
+	var up audioInfo
+	json.Unmarshal(body, &up)
+	expect("AudioList", http.StatusOK)(send(http.MethodGet, "/api/audio", "host", "", nil))
+
+	settings := "/api/rooms/" + room + "/settings"
+	expect("SettingsResponse", http.StatusOK)(send(http.MethodGet, settings, "host", "", nil))
+	update := `{"threshold_percent":50,"min_presence_seconds":0,"departure_rule":"vote","gauge_stages":[{"percent":0,"text":"まだ早い"}],` +
+		`"ambience_stages":[{"percent":30,"name":"dim"}],"theme":"dark","audio":"` + up.ID + `","features":{"snooze":true}}`
+	expect("SettingsResponse", http.StatusOK)(send(http.MethodPut, settings, "host", "application/json", []byte(update)))
+
+	host.post("/api/named", url.Values{"enabled": {"true"}})
+	alice.post("/api/vote", url.Values{"display_name": {"Alice"}})
+	status, denied := host.post("/api/rooms/"+room+"/deny", url.Values{"name": {"Alice"}})
+	expect("DenyResponse", http.StatusOK)(status, []byte(denied))
+
+	bob.vote()
+	carol.vote()
+	for range 2 {
+		select {
+		case got := <-received:
+			schema.conforms(t, got[0], []byte(got[1]))
+		case <-time.After(5 * time.Second):
+			t.Fatal("trigger actions were not called")
+		}
+	}
+	expect("MeetingReport", http.StatusOK)(send(http.MethodGet, "/api/rooms/"+room+"/report", "host", "", nil))
+	expect("SeriesStats", http.StatusOK)(send(http.MethodGet, "/api/rooms/"+room+"/series", "host", "", nil))
+
+	closeEventExport(context.Background())
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	if len(exported) == 0 {
+		t.Error("no events were exported")
+	}
+	for _, value := range exported {
+		schema.conforms(t, "ExportEvent", value)
+	}
+}
+
+func TestProtocolBenchmark(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	ts := newTestServer(t)
+	fc := useFakeClock(t)
+	useTenants(t, []*Tenant{{ID: "contract", Secrets: []string{"contract_secret"}, Benchmark: true}})
+	date := startOfDay(fc.now).AddDate(0, 0, -2).Format("2006-01-02")
+	SaveRollup(context.Background(), "contract", ReportRollup{Date: date, Held: 3, Meetings: 2, LengthBuckets: []int{0, 1, 1, 0, 0, 0, 0}})
+
+	appContext, _ := EncryptZoomContext("contract_secret", []byte(`{"uid":"h1","mid":"m1","role":"host"}`))
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/stats/benchmark", nil)
+	req.Header.Set("x-zoom-app-context", appContext)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("benchmark: %d %s", resp.StatusCode, body)
+	}
+	schema.conforms(t, "BenchmarkResponse", body)
+}
+
+func TestProtocolUsage(t *testing.T) {
+	schema := loadProtocolSchema(t)
+	ts := newTestServer(t)
+	useTenants(t, []*Tenant{{ID: "contract", Secrets: []string{"contract_secret"}, Quota: &UsageQuota{Participants: 50}}})
+
+	get := func(role string) (int, []byte) {
+		appContext, _ := EncryptZoomContext("contract_secret", []byte(`{"uid":"h1","mid":"m1","role":"`+role+`"}`))
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/usage", nil)
+		req.Header.Set("x-zoom-app-context", appContext)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+	if code, _ := get("attendee"); code != http.StatusForbidden {
+		t.Errorf("usage for an attendee: %d", code)
+	}
+	code, body := get("host")
+	if code != http.StatusOK {
+		t.Fatalf("usage: %d %s", code, body)
+	}
+	schema.conforms(t, "TenantUsage", body)
+}
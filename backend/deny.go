@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+var (
+	frameDenied       = ErrorFrame{"denied", "ホストによってこの会議から外されました", false}
+	frameNameRequired = ErrorFrame{"name_required", "外す参加者の名前を入力してください", false}
+	frameNameUnknown  = ErrorFrame{"name_unknown", "その名前を共有した参加者はいません", false}
+)
+
+// handleRoomDeny serves /api/rooms/{id}/deny to the room's host. POST with
+// a name removes the participants who shared that display name in named
+// mode, with their votes, and keeps them out of the room; DELETE lets
+// everyone removed back in. Only named participants can be told apart,
+// so anonymous ones cannot be removed.
+func handleRoomDeny(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() || r.PathValue("id") != zCtx.Mid {
+		panelError(w, ctx, zCtx, frameForbidden, http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		name := cleanDisplayName(r.FormValue("name"))
+		if name == "" {
+			panelError(w, ctx, zCtx, frameNameRequired, http.StatusBadRequest)
+			return
+		}
+		uids, err := NamedParticipants(ctx, zCtx.RoomID(), name)
+		if err != nil {
+			logf(ctx, "NamedParticipants error: %v", err)
+			panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+			return
+		}
+		if len(uids) == 0 {
+			panelError(w, ctx, zCtx, frameNameUnknown, http.StatusNotFound)
+			return
+		}
+		for _, uid := range uids {
+			if err := DenyParticipant(ctx, zCtx.RoomID(), uid); err != nil {
+				logf(ctx, "DenyParticipant error: %v", err)
+				panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		logf(ctx, "%d participant(s) removed by host", len(uids))
+		departed(ctx, zCtx)
+		metrics.Add("participants_denied", int64(len(uids)))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"removed": len(uids)})
+
+	case http.MethodDelete:
+		if err := ClearDenied(ctx, zCtx.RoomID()); err != nil {
+			logf(ctx, "ClearDenied error: %v", err)
+			panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// denied reports whether the request comes from a participant the host
+// removed from the room. Hosts cannot lock themselves out, and a store
+// error lets the request through.
+func denied(r *http.Request, zCtx *ZoomAuthContext) bool {
+	if zCtx.IsHost() {
+		return false
+	}
+	d, err := IsDenied(r.Context(), zCtx.RoomID(), zCtx.UID)
+	if err != nil {
+		logf(r.Context(), "IsDenied error: %v", err)
+		return false
+	}
+	return d
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRoomDeny(t *testing.T) {
+	ts := newTestServer(t)
+	host := ts.queryClient("deny-room", "host")
+	host.role = "host"
+	alice := ts.queryClient("deny-room", "alice")
+	bob := ts.queryClient("deny-room", "bob")
+	for _, c := range []*testClient{host, alice, bob} {
+		c.poll()
+	}
+	host.post("/api/named", url.Values{"enabled": {"true"}})
+	alice.post("/api/vote", url.Values{"display_name": {"Alice"}})
+
+	pollStatus := func(c *testClient) int {
+		q := url.Values{"roomId": {c.room}, "pid": {c.pid}}
+		resp, err := ts.Client().Get(ts.URL + "/api/state?" + q.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status, _ := bob.post("/api/rooms/deny-room/deny", url.Values{"name": {"Alice"}}); status != http.StatusForbidden {
+		t.Errorf("attendee removing: %d", status)
+	}
+	if status, _ := host.post("/api/rooms/deny-room/deny", url.Values{"name": {"Nobody"}}); status != http.StatusNotFound {
+		t.Errorf("unknown name: %d", status)
+	}
+	// The host's panel is told why a removal failed
+	for name, want := range map[string]string{"": "Enter the name of the participant to remove", "Nobody": "No participant shared that name"} {
+		q := url.Values{"roomId": {"deny-room"}, "pid": {"host"}, "role": {"host"}, "lang": {"en"}}
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/rooms/deny-room/deny?"+q.Encode(), strings.NewReader(url.Values{"name": {name}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("HX-Request", "true")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), `class="error-frame"`) || !strings.Contains(string(body), want) {
+			t.Errorf("name %q: %d, %s\n%s", name, resp.StatusCode, resp.Header.Get(errorCodeHeader), body)
+		}
+	}
+	if status, body := host.post("/api/rooms/deny-room/deny", url.Values{"name": {"Alice"}}); status != http.StatusOK || body != "{\"removed\":1}\n" {
+		t.Fatalf("removing Alice: %d %s", status, body)
+	}
+
+	// Alice's vote is gone and she cannot come back
+	if n, votes, _, _ := CheckTriggerStatus(context.Background(), "deny-room"); n != 2 || votes != 0 {
+		t.Errorf("after removal: %d participants, %d votes", n, votes)
+	}
+	if status := pollStatus(alice); status != http.StatusForbidden {
+		t.Errorf("removed participant polling: %d", status)
+	}
+	if n, _, _, _ := CheckTriggerStatus(context.Background(), "deny-room"); n != 2 {
+		t.Errorf("removed participant rejoined: %d participants", n)
+	}
+	if status := pollStatus(bob); status != http.StatusOK {
+		t.Errorf("others polling: %d", status)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/rooms/deny-room/deny?roomId=deny-room&pid=host&role=host", nil)
+	if resp, err := ts.Client().Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("clearing the deny list: %v %v", resp, err)
+	}
+	if status := pollStatus(alice); status != http.StatusOK {
+		t.Errorf("after clearing: %d", status)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// handleDevZoomContext encrypts an arbitrary {uid, mid, role, ts} payload with
+// the configured client secret (the tenant's, when multi-tenant) using Zoom's framing, so the real
+// VerifyZoomContext path can be exercised without the Zoom client.
+//
+//	GET  /dev/zoom-context?uid=u1&mid=m1&role=host  sets the zoom_context cookie and redirects to /
+//	POST /dev/zoom-context {"uid":"u1","mid":"m1"}  returns {"context":"..."}
+func handleDevZoomContext(w http.ResponseWriter, r *http.Request) {
+	payload := map[string]any{}
+
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		for _, key := range []string{"uid", "mid", "role"} {
+			if v := q.Get(key); v != "" {
+				payload[key] = v
+			}
+		}
+		if ts, err := strconv.ParseInt(q.Get("ts"), 10, 64); err == nil {
+			payload["ts"] = ts
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&payload); err != nil {
+			httpError(w, r.Context(), "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := payload["ts"]; !ok {
+		payload["ts"] = clock.Now().UnixMilli()
+	}
+
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		httpError(w, r.Context(), "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, err := secretForRequest(r)
+	if err != nil {
+		httpError(w, r.Context(), "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	appContext, err := EncryptZoomContext(secret, plain)
+	if err != nil {
+		logf(r.Context(), "EncryptZoomContext error: %v", err)
+		httpError(w, r.Context(), "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "zoom_context",
+		Value:    appContext,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if r.Method == http.MethodGet {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"context": appContext})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DynamoDB store: for AWS deployments without Redis, such as several
+// instances behind a load balancer or on Lambda, each document of the
+// localStore is one item of a DynamoDB table holding its JSON. Every write
+// is conditional on the version that was read, so instances changing the
+// same document cannot overwrite each other: the loser reads it again and
+// redoes its change. That keeps votes deduplicated and lets exactly one
+// instance mark a poll passed, as the Redis scripts do. A room's
+// participants, votes and leaves are items of their own, so people joining
+// and voting at once do not race for the room's item. Items carry their
+// expiry in expires_at, to be set as the table's TTL attribute; DynamoDB
+// can take a while to delete expired items, so they also read as missing.
+//
+// The table's partition key pk is the item's key up to its first space and
+// its sort key sk the whole key, so a room's participants or a poll's votes
+// share a partition and are read with one Query rather than a Scan.
+
+// dynamoTable talks to one DynamoDB table over its JSON API. Credentials
+// come from the standard AWS_* env vars, like the aws secret provider.
+type dynamoTable struct {
+	client                             *http.Client
+	endpoint, region, table            string
+	accessKey, secretKey, sessionToken string
+}
+
+// dynamoAttr is a DynamoDB attribute value of type S or N.
+type dynamoAttr struct {
+	S string `json:"S,omitempty"`
+	N string `json:"N,omitempty"`
+}
+
+type dynamoItem map[string]dynamoAttr
+
+func dynamoNumber(n int64) dynamoAttr {
+	return dynamoAttr{N: strconv.FormatInt(n, 10)}
+}
+
+// dynamoError is an error response of the DynamoDB API.
+type dynamoError struct {
+	Type    string // without the namespace, e.g. ConditionalCheckFailedException
+	Message string
+}
+
+func (e *dynamoError) Error() string {
+	return "dynamodb: " + e.Type + ": " + e.Message
+}
+
+// conditionFailed reports whether err is a write refused by its condition.
+func conditionFailed(err error) bool {
+	var de *dynamoError
+	return errors.As(err, &de) && de.Type == "ConditionalCheckFailedException"
+}
+
+func newDynamoTable(cfg *Config) (*dynamoTable, error) {
+	d := &dynamoTable{
+		client:       &http.Client{Timeout: 5 * time.Second},
+		region:       envOr("AWS_REGION", envOr("AWS_DEFAULT_REGION", "")),
+		table:        cfg.DynamoTable,
+		accessKey:    envOr("AWS_ACCESS_KEY_ID", ""),
+		secretKey:    envOr("AWS_SECRET_ACCESS_KEY", ""),
+		sessionToken: envOr("AWS_SESSION_TOKEN", ""),
+		endpoint:     strings.TrimRight(cfg.DynamoEndpoint, "/"),
+	}
+	if d.region == "" || d.accessKey == "" || d.secretKey == "" {
+		return nil, fmt.Errorf("dynamodb: AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	if d.endpoint == "" {
+		d.endpoint = "https://dynamodb." + d.region + ".amazonaws.com"
+	}
+	return d, nil
+}
+
+// initDynamo keeps the store in the table of cfg, checking it can be read.
+func initDynamo(cfg *Config) error {
+	d, err := newDynamoTable(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.describe(ctx); err != nil {
+		return err
+	}
+	local = newLocalStore(storeDynamo, newTableKV(d))
+	log.Printf("Keeping the store in DynamoDB table %s (%s).", d.table, d.region)
+	return nil
+}
+
+// call sends one DynamoDB API request and decodes the response into out.
+func (d *dynamoTable) call(ctx context.Context, op string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	if d.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.sessionToken)
+	}
+	signAWSRequest(req, body, d.accessKey, d.secretKey, d.region, "dynamodb", clock.Now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		metrics.Add("dynamodb_errors", 1)
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &e)
+		if e.Type == "" {
+			e.Type = resp.Status
+		}
+		err := &dynamoError{Type: e.Type[strings.LastIndex(e.Type, "#")+1:], Message: e.Message}
+		if !conditionFailed(err) {
+			metrics.Add("dynamodb_errors", 1)
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// describe checks the table has the keys pk and sk.
+func (d *dynamoTable) describe(ctx context.Context) error {
+	var out struct {
+		Table struct {
+			KeySchema []struct {
+				AttributeName, KeyType string
+			}
+		}
+	}
+	if err := d.call(ctx, "DescribeTable", map[string]any{"TableName": d.table}, &out); err != nil {
+		return err
+	}
+	keys := make(map[string]string)
+	for _, k := range out.Table.KeySchema {
+		keys[k.KeyType] = k.AttributeName
+	}
+	if keys["HASH"] != "pk" || keys["RANGE"] != "sk" {
+		return fmt.Errorf("dynamodb: table %s needs the partition key pk and the sort key sk (string)", d.table)
+	}
+	return nil
+}
+
+// dynamoKey is the primary key of the item at key, see the table layout
+// above.
+func dynamoKey(key string) dynamoItem {
+	pk, _, _ := strings.Cut(key, " ")
+	return dynamoItem{"pk": {S: pk}, "sk": {S: key}}
+}
+
+// dynamoTableItem reads an item, returning the zero tableItem for nil.
+func dynamoTableItem(item dynamoItem) tableItem {
+	if item == nil {
+		return tableItem{}
+	}
+	it := tableItem{data: []byte(item["data"].S)}
+	it.version, _ = strconv.ParseInt(item["version"].N, 10, 64)
+	if exp, err := strconv.ParseInt(item["expires_at"].N, 10, 64); err == nil {
+		it.expires = time.Unix(exp, 0)
+	}
+	return it
+}
+
+// versionCondition makes a write conditional on the item's version, 0 for
+// a missing item.
+func versionCondition(in map[string]any, version int64) {
+	if version == 0 {
+		in["ConditionExpression"] = "attribute_not_exists(pk)"
+		return
+	}
+	in["ConditionExpression"] = "#v = :v"
+	in["ExpressionAttributeNames"] = map[string]string{"#v": "version"}
+	in["ExpressionAttributeValues"] = dynamoItem{":v": dynamoNumber(version)}
+}
+
+// versionError turns a refused condition into errVersionChanged.
+func versionError(err error) error {
+	if conditionFailed(err) {
+		return errVersionChanged
+	}
+	return err
+}
+
+func (d *dynamoTable) get(ctx context.Context, key string) (tableItem, error) {
+	var out struct {
+		Item dynamoItem `json:"Item"`
+	}
+	err := d.call(ctx, "GetItem", map[string]any{
+		"TableName":      d.table,
+		"Key":            dynamoKey(key),
+		"ConsistentRead": true,
+	}, &out)
+	if err != nil {
+		return tableItem{}, err
+	}
+	return dynamoTableItem(out.Item), nil
+}
+
+func (d *dynamoTable) put(ctx context.Context, key string, data []byte, expires time.Time, version int64) error {
+	item := dynamoKey(key)
+	item["data"] = dynamoAttr{S: string(data)}
+	item["version"] = dynamoNumber(version + 1)
+	if !expires.IsZero() {
+		// Rounded up, so the item does not read as expired early
+		item["expires_at"] = dynamoNumber(expires.Add(time.Second - 1).Unix())
+	}
+	in := map[string]any{"TableName": d.table, "Item": item}
+	versionCondition(in, version)
+	return versionError(d.call(ctx, "PutItem", in, nil))
+}
+
+func (d *dynamoTable) del(ctx context.Context, key string, version int64) error {
+	in := map[string]any{"TableName": d.table, "Key": dynamoKey(key)}
+	versionCondition(in, version)
+	return versionError(d.call(ctx, "DeleteItem", in, nil))
+}
+
+// scan queries the partition of a prefix with a space, such as a room's
+// participants, and goes through the whole table otherwise.
+func (d *dynamoTable) scan(ctx context.Context, prefix string, fn func(key string, it tableItem) bool) error {
+	op, in := "Scan", map[string]any{
+		"TableName":                 d.table,
+		"FilterExpression":          "begins_with(sk, :p)",
+		"ExpressionAttributeValues": dynamoItem{":p": {S: prefix}},
+		"ConsistentRead":            true,
+	}
+	if pk, _, ok := strings.Cut(prefix, " "); ok {
+		op, in["KeyConditionExpression"] = "Query", "pk = :pk AND begins_with(sk, :p)"
+		in["ExpressionAttributeValues"] = dynamoItem{":pk": {S: pk}, ":p": {S: prefix}}
+		delete(in, "FilterExpression")
+	}
+	for {
+		var out struct {
+			Items            []dynamoItem `json:"Items"`
+			LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+		}
+		if err := d.call(ctx, op, in, &out); err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			if !fn(item["sk"].S, dynamoTableItem(item)) {
+				return nil
+			}
+		}
+		if out.LastEvaluatedKey == nil {
+			return nil
+		}
+		in["ExclusiveStartKey"] = out.LastEvaluatedKey
+	}
+}
+
+// sweep leaves expired items to the table's TTL.
+func (d *dynamoTable) sweep(context.Context, time.Time) error {
+	return nil
+}
+
+func (d *dynamoTable) close() error {
+	return nil
+}
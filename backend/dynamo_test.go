@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDynamo serves the calls of dynamoTable from a map by sort key,
+// evaluating the few expressions it sends. Scan and Query return pages of
+// scanPage items.
+type fakeDynamo struct {
+	mu      sync.Mutex
+	items   map[string]dynamoItem
+	puts    int
+	queries int
+}
+
+const scanPage = 2
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Key                       dynamoItem
+		Item                      dynamoItem
+		ConditionExpression       string
+		FilterExpression          string
+		KeyConditionExpression    string
+		ExpressionAttributeValues dynamoItem
+		ExclusiveStartKey         dynamoItem
+	}
+	json.NewDecoder(r.Body).Decode(&in)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vals := in.ExpressionAttributeValues
+	condition := func(sk string) bool {
+		cur, exists := f.items[sk]
+		switch in.ConditionExpression {
+		case "attribute_not_exists(pk)":
+			return !exists
+		case "#v = :v":
+			return exists && cur["version"].N == vals[":v"].N
+		}
+		return in.ConditionExpression == ""
+	}
+	conditionFailed := func() {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`))
+	}
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.") {
+	case "DescribeTable":
+		w.Write([]byte(`{"Table":{"KeySchema":[{"AttributeName":"pk","KeyType":"HASH"},{"AttributeName":"sk","KeyType":"RANGE"}]}}`))
+	case "GetItem":
+		json.NewEncoder(w).Encode(map[string]any{"Item": f.items[in.Key["sk"].S]})
+	case "PutItem":
+		if !condition(in.Item["sk"].S) {
+			conditionFailed()
+			return
+		}
+		f.items[in.Item["sk"].S] = in.Item
+		f.puts++
+		w.Write([]byte(`{}`))
+	case "DeleteItem":
+		if !condition(in.Key["sk"].S) {
+			conditionFailed()
+			return
+		}
+		delete(f.items, in.Key["sk"].S)
+		w.Write([]byte(`{}`))
+	case "Scan", "Query":
+		match := func(item dynamoItem) bool { return strings.HasPrefix(item["sk"].S, vals[":p"].S) }
+		switch {
+		case r.Header.Get("X-Amz-Target") == "DynamoDB_20120810.Query" && in.KeyConditionExpression == "pk = :pk AND begins_with(sk, :p)":
+			f.queries++
+			match = func(item dynamoItem) bool {
+				return item["pk"].S == vals[":pk"].S && strings.HasPrefix(item["sk"].S, vals[":p"].S)
+			}
+		case in.FilterExpression != "begins_with(sk, :p)":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		keys := slices.Sorted(maps.Keys(f.items))
+		if in.ExclusiveStartKey != nil {
+			i, _ := slices.BinarySearch(keys, in.ExclusiveStartKey["sk"].S)
+			keys = keys[min(i+1, len(keys)):]
+		}
+		out := map[string]any{}
+		if len(keys) > scanPage {
+			keys = keys[:scanPage]
+			out["LastEvaluatedKey"] = dynamoKey(keys[scanPage-1])
+		}
+		items := []dynamoItem{}
+		for _, k := range keys {
+			if match(f.items[k]) {
+				items = append(items, f.items[k])
+			}
+		}
+		out["Items"] = items
+		json.NewEncoder(w).Encode(out)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// newStore returns a store on the fake table, as another instance would
+// have.
+func (f *fakeDynamo) newStore(srv *httptest.Server) *localStore {
+	return newLocalStore(storeDynamo, newTableKV(&dynamoTable{client: srv.Client(), endpoint: srv.URL, region: "ap-northeast-1", table: "rooms", accessKey: "id", secretKey: "secret"}))
+}
+
+// useFakeDynamo keeps the store in a fake table, returning a store of a
+// second instance sharing it.
+func useFakeDynamo(t *testing.T) (*fakeDynamo, *localStore) {
+	f := &fakeDynamo{items: map[string]dynamoItem{}}
+	srv := httptest.NewServer(f)
+	useRedis = false
+	prev := local
+	local = f.newStore(srv)
+	t.Cleanup(func() {
+		local = prev
+		srv.Close()
+	})
+	return f, f.newStore(srv)
+}
+
+func TestDynamoRooms(t *testing.T) {
+	fc := useFakeClock(t)
+	f, _ := useFakeDynamo(t)
+	ctx := context.Background()
+	room := "dynamo-room"
+
+	AddParticipant(ctx, room, "u1")
+	AddParticipant(ctx, room, "u2")
+	AddParticipant(ctx, room, "u3")
+	if added, err := Vote(ctx, room, "u1"); err != nil || !added {
+		t.Fatalf("vote = %t, %v", added, err)
+	}
+	if added, _ := Vote(ctx, room, "u1"); added {
+		t.Error("duplicate vote added")
+	}
+	if _, ok := f.items[keyPrefix+docRoom+":"+room]; !ok {
+		t.Error("room not kept in the table")
+	}
+
+	// Panels polling again only extend the expiry, which is not written
+	puts := f.puts
+	AddParticipant(ctx, room, "u2")
+	CheckTriggerStatus(ctx, room)
+	if f.puts != puts {
+		t.Errorf("%d writes for a poll", f.puts-puts)
+	}
+
+	// Votes from many instances at once are all kept, and one instance
+	// fires the poll
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uid := "v" + strconv.Itoa(i)
+			if err := AddParticipant(ctx, room, uid); err != nil {
+				t.Error(err)
+			}
+			if _, err := Vote(ctx, room, uid); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	var mu sync.Mutex
+	fired := 0
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, ok, err := evaluatePoll(ctx, room, defaultPoll)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				fired++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	total, votes, passed, err := CheckTriggerStatus(ctx, room)
+	if err != nil || total != 11 || votes != 9 || !passed || fired != 1 {
+		t.Errorf("status = %d/%d %t, fired %d, %v", votes, total, passed, fired, err)
+	}
+
+	// An expired item reads as a new room even before DynamoDB deletes it
+	fc.Advance(roomTTL)
+	if total, _, _, _ := CheckTriggerStatus(ctx, room); total != 0 {
+		t.Errorf("expired room has %d participants", total)
+	}
+	if err := AddParticipant(ctx, room, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if total, votes, _, _ := CheckTriggerStatus(ctx, room); total != 1 || votes != 0 {
+		t.Errorf("reused room = %d/%d", votes, total)
+	}
+}
+
+func TestDynamoConcurrentJoins(t *testing.T) {
+	useFakeClock(t)
+	f, other := useFakeDynamo(t)
+	ctx := context.Background()
+	room := "crowded-room"
+	AddParticipant(ctx, room, "host")
+	Vote(ctx, room, "host")
+
+	// A crowd joining and voting through two instances at once writes
+	// one item each, so none of them conflicts, fails or is lost
+	conflicts := metricInt("store_write_conflicts")
+	var wg sync.WaitGroup
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := []*localStore{local, other}[i%2]
+			uid := "p" + strconv.Itoa(i)
+			if joined, err := s.JoinRoom(ctx, room, uid, nil); err != nil || !joined {
+				t.Errorf("join %s = %t, %v", uid, joined, err)
+			}
+			if added, err := s.PollVote(ctx, room, defaultPoll, uid); err != nil || !added {
+				t.Errorf("vote %s = %t, %v", uid, added, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := metricInt("store_write_conflicts") - conflicts; n != 0 {
+		t.Errorf("%d write conflicts", n)
+	}
+	queries := f.queries
+	if total, votes, _, _ := CheckTriggerStatus(ctx, room); total != 41 || votes != 41 {
+		t.Errorf("status = %d/%d", votes, total)
+	}
+	if f.queries == queries {
+		t.Error("room counted without a query")
+	}
+}
+
+func TestDynamoClaims(t *testing.T) {
+	fc := useFakeClock(t)
+	useFakeDynamo(t)
+	ctx := context.Background()
+
+	if ok, err := AcquireLeadership(ctx, "a", 30*time.Second); err != nil || !ok {
+		t.Fatalf("a = %t, %v", ok, err)
+	}
+	if ok, _ := AcquireLeadership(ctx, "b", 30*time.Second); ok {
+		t.Error("b took a held lead")
+	}
+	if ok, _ := AcquireLeadership(ctx, "a", 30*time.Second); !ok {
+		t.Error("a could not renew")
+	}
+	fc.Advance(time.Minute)
+	if ok, _ := AcquireLeadership(ctx, "b", 30*time.Second); !ok {
+		t.Error("b could not take an expired lead")
+	}
+
+	due := clock.Now()
+	if ok, err := ClaimJobRun(ctx, "digest", due); err != nil || !ok {
+		t.Fatalf("first claim = %t, %v", ok, err)
+	}
+	if ok, _ := ClaimJobRun(ctx, "digest", due); ok {
+		t.Error("run claimed twice")
+	}
+}
+
+func TestDynamoSharedState(t *testing.T) {
+	fc := useFakeClock(t)
+	_, other := useFakeDynamo(t)
+	ctx := context.Background()
+	room := "shared-room"
+
+	// Rate limits count the actions made through either instance
+	local.CountAction(ctx, room, "vote", "u1", time.Minute)
+	if n, _, err := other.CountAction(ctx, room, "vote", "u1", time.Minute); err != nil || n != 2 {
+		t.Errorf("actions = %d, %v", n, err)
+	}
+
+	// A vote's idempotency key is taken once
+	if _, first, _ := local.ClaimVoteKey(ctx, room, "u1", "k", time.Minute); !first {
+		t.Error("key not claimed")
+	}
+	local.SettleVoteKey(ctx, room, "u1", "k", "ack")
+	if ack, first, _ := other.ClaimVoteKey(ctx, room, "u1", "k", time.Minute); first || ack != "ack" {
+		t.Errorf("claimed again: %t, ack %q", first, ack)
+	}
+
+	// A panel resumes on another instance
+	local.SaveResumeSession(ctx, "hash", &resumeSession{UID: "u1", Mid: room}, time.Hour)
+	if s, _ := other.ResumeSession(ctx, "hash", time.Hour); s == nil || s.UID != "u1" {
+		t.Errorf("session = %+v", s)
+	}
+
+	// Quotas count every instance's panels and triggers
+	local.MarkConnected(ctx, "acme", room, "u1", clock.Now(), clock.Now().Add(-time.Hour))
+	other.MarkConnected(ctx, "acme", room, "u2", clock.Now(), clock.Now().Add(-time.Hour))
+	if cu, _ := local.TenantConnections(ctx, "acme", room, "u2", clock.Now().Add(-time.Minute)); cu.Participants != 2 || !cu.Connected {
+		t.Errorf("connections = %+v", cu)
+	}
+	local.IncrMonthlyTriggers(ctx, "acme", "2026-04")
+	other.IncrMonthlyTriggers(ctx, "acme", "2026-04")
+	if n, _ := local.MonthlyTriggers(ctx, "acme", "2026-04"); n != 2 {
+		t.Errorf("triggers = %d", n)
+	}
+
+	// Reports written by one are read by the other
+	r := &MeetingReport{Tenant: "acme", RoomID: room, TriggeredAt: clock.Now()}
+	if err := local.SaveReport(ctx, room, r, r); err != nil {
+		t.Fatal(err)
+	}
+	if reports, _ := other.ReportsBetween(ctx, "acme", time.Time{}, clock.Now().Add(time.Second)); len(reports) != 1 {
+		t.Errorf("%d reports", len(reports))
+	}
+
+	// Each instance's heartbeat shows on the other's dashboard
+	st := instanceStatus{ID: "a", Seen: clock.Now()}
+	local.PutInstanceStatus(ctx, &st, clock.Now().Add(-instanceStale))
+	if list, _ := other.InstanceStatuses(ctx); len(list) != 1 || list[0].ID != "a" {
+		t.Errorf("instances = %+v", list)
+	}
+
+	// Both list the rooms, over several pages of the scan
+	for _, uid := range []string{"u1", "u2", "u3"} {
+		local.JoinRoom(ctx, room, uid, nil)
+		other.JoinRoom(ctx, "other-"+uid, uid, nil)
+	}
+	if rooms, _, err := other.LiveRooms(ctx, 10); err != nil || len(rooms) != 4 {
+		t.Errorf("rooms = %+v, %v", rooms, err)
+	}
+
+	// A room left on one instance is closed by one of them
+	for _, uid := range []string{"u1", "u2", "u3"} {
+		other.LeaveParticipant(ctx, room, uid, clock.Now())
+	}
+	fc.Advance(time.Second)
+	if mids, _ := local.ClosingRooms(ctx, clock.Now(), 10); !slices.Contains(mids, room) {
+		t.Fatalf("closing rooms = %q", mids)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	claimed := 0
+	for _, s := range []*localStore{local, other} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			empty, uids, err := s.ClaimEmptyRoom(ctx, room, clock.Now())
+			if err != nil {
+				t.Error(err)
+			}
+			if empty {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+				if len(uids) != 3 {
+					t.Errorf("uids = %q", uids)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if claimed != 1 {
+		t.Errorf("room claimed %d times", claimed)
+	}
+	if err := other.PurgeRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+	if rooms, _, _ := local.LiveRooms(ctx, 10); len(rooms) != 3 {
+		t.Errorf("rooms after the purge = %+v", rooms)
+	}
+	if _, first, _ := other.ClaimVoteKey(ctx, room, "u1", "k", time.Minute); !first {
+		t.Error("vote key kept after the purge")
+	}
+}
+
+func TestDynamoStoreBackend(t *testing.T) {
+	cfg := &Config{StoreBackend: storeDynamo}
+	if _, _, err := cfg.storeBackend(); err == nil {
+		t.Error("dynamodb without a table accepted")
+	}
+	cfg.DynamoTable = "rooms"
+	if backend, _, err := cfg.storeBackend(); err != nil || backend != storeDynamo {
+		t.Errorf("backend = %q, %v", backend, err)
+	}
+}
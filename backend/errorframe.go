@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// ErrorFrame tells a panel why one of its requests was turned away, so
+// the user sees a reason instead of a button that does nothing. Message
+// is the catalog key, translated when the frame is rendered.
+type ErrorFrame struct {
+	Code      string
+	Message   string
+	Retryable bool // trying again later may work
+}
+
+var (
+	frameUnavailable    = ErrorFrame{"unavailable", "サーバーに接続できません。再試行しています", true}
+	frameForbidden      = ErrorFrame{"forbidden", "この操作はホストだけが行えます", false}
+	frameDisabled       = ErrorFrame{"disabled", "この機能はこの会議では使えません", false}
+	frameRateLimited    = ErrorFrame{"rate_limited", "投票が多すぎます。少し待ってから押してください", true}
+	frameRoomTriggered  = ErrorFrame{"room_triggered", "投票できません: 会議は既に終了扱いです", false}
+	frameNotTriggered   = ErrorFrame{"not_triggered", "延長できるのは終了が決まったあとだけです", false}
+	framePollNotRunning = ErrorFrame{"poll_not_running", "投票できません: この投票は締め切られました", false}
+	frameUnknownPoll    = ErrorFrame{"unknown_poll", "この投票は使えません", false}
+	frameQuotaExceeded  = ErrorFrame{"quota_exceeded", "ご契約のプランの利用上限に達しています", true}
+)
+
+// Error frame headers, for clients that do not render the fragment
+const (
+	errorCodeHeader      = "X-Error-Code"
+	errorRetryableHeader = "X-Error-Retryable"
+)
+
+func (f ErrorFrame) setHeaders(w http.ResponseWriter) {
+	w.Header().Set(errorCodeHeader, f.Code)
+	w.Header().Set(errorRetryableHeader, strconv.FormatBool(f.Retryable))
+}
+
+// render returns the frame as the "error_frame" fragment. With oob it is
+// swapped into the panel's #error-frame alongside a successful response.
+func (f ErrorFrame) render(ctx context.Context, lang string, oob bool) string {
+	return renderFragment(lang, "error_frame", map[string]any{
+		"Frame":     f,
+		"OOB":       oob,
+		"RequestID": requestIDFrom(ctx),
+	})
+}
+
+// panelError fails a panel request with status and f. htmx requests get
+// the frame as the body; other clients get httpError's plain text, with
+// the frame's code and retryable flag in headers either way.
+func panelError(w http.ResponseWriter, ctx context.Context, zCtx *ZoomAuthContext, f ErrorFrame, status int) {
+	metrics.Add("error_frames", 1)
+	f.setHeaders(w)
+	if !zCtx.HTMX {
+		httpError(w, ctx, http.StatusText(status)+": "+f.Code, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write([]byte(f.render(ctx, zCtx.Locale, false)))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestErrorFrames(t *testing.T) {
+	ts := newTestServer(t)
+
+	send := func(path, pid string, htmx bool) (*http.Response, string) {
+		q := url.Values{"roomId": {"frame-room"}, "pid": {pid}, "lang": {"en"}}
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path+"?"+q.Encode(), nil)
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// A panel trying a host action is told why it was turned away
+	resp, body := send("/api/reset", "a", true)
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get(errorCodeHeader) != "forbidden" || resp.Header.Get(errorRetryableHeader) != "false" {
+		t.Errorf("reset: %d, headers %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.Contains(body, `class="error-frame"`) || !strings.Contains(body, "Only the host can do this") {
+		t.Errorf("reset frame:\n%s", body)
+	}
+	// Other clients keep the plain text error, with the same headers
+	resp, body = send("/api/reset", "a", false)
+	if resp.Header.Get(errorCodeHeader) != "forbidden" || !strings.HasPrefix(body, "Forbidden: forbidden") {
+		t.Errorf("plain reset: %v\n%s", resp.Header, body)
+	}
+
+	// A vote after the ending gets the state with the frame out of band
+	ts.queryClient("frame-room", "a").vote()
+	resp, body = send("/api/vote", "a", true)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(errorCodeHeader) != "room_triggered" {
+		t.Errorf("late vote: %d, headers %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.Contains(body, `hx-swap-oob="innerHTML"`) || !strings.Contains(body, "Cannot vote: the meeting has already been voted to end") || !strings.Contains(body, `id="gauge-container"`) {
+		t.Errorf("late vote body:\n%s", body)
+	}
+
+	// Store failures are worth a retry
+	ts.mr.SetError("down")
+	resp, body = send("/api/vote", "b", true)
+	ts.mr.SetError("")
+	if resp.Header.Get(errorRetryableHeader) != "true" || !strings.Contains(body, `data-retryable="true"`) {
+		t.Errorf("store down: %d, %v\n%s", resp.StatusCode, resp.Header, body)
+	}
+}
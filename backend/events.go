@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoomEvent is something that happened in a room. Panels keep the ID of
+// the newest event they have seen and send it back as Last-Event-ID, so a
+// panel that was away learns what it missed.
+type RoomEvent struct {
+	ID   string    `json:"id"` // Redis stream ID, increasing within a room
+	Type string    `json:"type"`
+	At   time.Time `json:"at"`
+}
+
+// Room event types
+const (
+	eventTriggered   = "triggered"
+	eventReset       = "reset"
+	eventSnoozed     = "snoozed"
+	eventPollStarted = "poll_started"
+	eventPollClosed  = "poll_closed"
+)
+
+// maxRoomEvents is how many events a room keeps for replay.
+const maxRoomEvents = 50
+
+// recordRoomEvent appends an event to the room's log. Failures only cost
+// a replay, so they are logged and otherwise ignored.
+func recordRoomEvent(ctx context.Context, roomID, typ string) {
+	if _, err := AppendRoomEvent(ctx, roomID, typ, clock.Now()); err != nil {
+		logf(ctx, "AppendRoomEvent error: %v", err)
+	}
+}
+
+// parseStreamID splits a stream ID "ms-seq"; malformed parts are 0.
+func parseStreamID(id string) (ms, seq uint64) {
+	m, s, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(m, 10, 64)
+	seq, _ = strconv.ParseUint(s, 10, 64)
+	return ms, seq
+}
+
+// streamIDLess orders stream IDs numerically.
+func streamIDLess(a, b string) bool {
+	am, as := parseStreamID(a)
+	bm, bs := parseStreamID(b)
+	return am < bm || am == bm && as < bs
+}
+
+// replayEvents renders what the panel missed since its Last-Event-ID and
+// the marker carrying the newest event ID. Only what the current state no
+// longer shows is replayed: an ending that was reset or snoozed before the
+// panel came back.
+func replayEvents(ctx context.Context, zCtx *ZoomAuthContext, showingEnding bool) string {
+	events, err := RoomEventsSince(ctx, zCtx.RoomID(), zCtx.LastEventID)
+	if err != nil {
+		logf(ctx, "RoomEventsSince error: %v", err)
+		return ""
+	}
+	// "0-0" marks a room without events, so whatever comes next is new
+	latest := cmp.Or(zCtx.LastEventID, "0-0")
+	if n := len(events); n > 0 {
+		latest = events[n-1].ID
+	}
+	var missed []string
+	if zCtx.LastEventID != "" && !showingEnding {
+		for _, e := range events {
+			if e.Type == eventTriggered {
+				missed = append(missed, e.At.In(time.Local).Format("15:04"))
+			}
+		}
+	}
+	metrics.Add("events_replayed", int64(len(missed)))
+	return renderFragment(zCtx.Locale, "room_events", map[string]any{
+		"Latest":    latest,
+		"Triggered": missed,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomEventLog(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			useFakeClock(t)
+			ctx := context.Background()
+			room := "event-log-" + store
+
+			if events, _ := RoomEventsSince(ctx, room, ""); len(events) != 0 {
+				t.Errorf("empty room events = %+v", events)
+			}
+			first, _ := AppendRoomEvent(ctx, room, eventTriggered, clock.Now())
+			second, _ := AppendRoomEvent(ctx, room, eventReset, clock.Now())
+			if !streamIDLess(first, second) {
+				t.Errorf("IDs not increasing: %s, %s", first, second)
+			}
+			if events, _ := RoomEventsSince(ctx, room, ""); len(events) != 1 || events[0].ID != second {
+				t.Errorf("newest = %+v", events)
+			}
+			events, _ := RoomEventsSince(ctx, room, first)
+			if len(events) != 1 || events[0].Type != eventReset || !events[0].At.Equal(clock.Now().Truncate(time.Millisecond)) {
+				t.Errorf("since first = %+v", events)
+			}
+			if events, _ := RoomEventsSince(ctx, room, "0-0"); len(events) != 2 {
+				t.Errorf("since 0-0 = %+v", events)
+			}
+		})
+	}
+
+	// The in-memory log keeps the newest maxRoomEvents
+	useRedis = false
+	for range maxRoomEvents + 3 {
+		AppendRoomEvent(context.Background(), "event-log-trim", eventSnoozed, time.Now())
+	}
+	if events, _ := RoomEventsSince(context.Background(), "event-log-trim", "0-0"); len(events) != maxRoomEvents {
+		t.Errorf("kept %d events", len(events))
+	}
+}
+
+var lastEventIDPattern = regexp.MustCompile(`data-last-event-id="([^"]*)"`)
+
+func TestMissedEndingReplay(t *testing.T) {
+	ts := newTestServer(t)
+	host := ts.queryClient("replay-room", "host")
+	host.role = "host"
+	away := ts.queryClient("replay-room", "away")
+	voter := ts.queryClient("replay-room", "voter")
+
+	host.poll()
+	voter.poll()
+	m := lastEventIDPattern.FindStringSubmatch(away.poll())
+	if m == nil || m[1] != "0-0" {
+		t.Fatalf("marker = %v", m)
+	}
+	away.lastEventID = m[1]
+
+	// The room ends and is reset while the panel is away
+	host.vote()
+	assertGauge(t, "voter", voter.vote(), "100.0%", true)
+	host.do(http.MethodPost, "/api/reset")
+
+	body := away.poll()
+	if !strings.Contains(body, `class="missed-event"`) {
+		t.Errorf("missed ending not replayed:\n%s", body)
+	}
+	m = lastEventIDPattern.FindStringSubmatch(body)
+	if m == nil || m[1] == "0-0" {
+		t.Fatalf("marker after replay = %v", m)
+	}
+	away.lastEventID = m[1]
+	if body := away.poll(); strings.Contains(body, "missed-event") {
+		t.Errorf("replayed twice:\n%s", body)
+	}
+
+	// A panel joining later gets no replay
+	late := ts.queryClient("replay-room", "late")
+	if body := late.poll(); strings.Contains(body, "missed-event") {
+		t.Errorf("new panel got a replay:\n%s", body)
+	}
+}
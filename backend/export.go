@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Event export: room lifecycle events (join, leave, vote, trigger) are
+// published to a Kafka topic for the data team's pipelines, through a
+// Kafka REST Proxy (Confluent REST Proxy, Redpanda's HTTP proxy), which
+// keeps this dependency-free like the MQTT action. Events are queued in
+// memory and sent in batches by one goroutine, apart from the room status
+// and region channels, so a slow or missing proxy never holds up a panel.
+// When the queue is full, events are dropped and counted.
+
+// Exported event types
+const (
+	exportJoin    = "join"
+	exportLeave   = "leave"
+	exportVote    = "vote"
+	exportTrigger = "trigger"
+)
+
+// ExportEvent is one exported room event. Every field is always present,
+// so the same JSON is a valid record of exportAvroSchema.
+type ExportEvent struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	At     int64  `json:"at"` // Unix milliseconds
+	Tenant string `json:"tenant"`
+	// Meeting is the Zoom meeting ID
+	Meeting string `json:"meeting"`
+	// Participant is the pseudonymized uid, empty for trigger
+	Participant string `json:"participant"`
+	// Poll is the poll voted in or passed, empty for join and leave
+	Poll string `json:"poll"`
+	// Participants and Votes are the counts that passed the poll, 0 except
+	// for trigger
+	Participants int    `json:"participants"`
+	Votes        int    `json:"votes"`
+	Region       string `json:"region"`
+
+	room string // the record key
+}
+
+// exportAvroSchema is ExportEvent as an Avro schema, sent with each batch
+// when KAFKA_EXPORT_FORMAT=avro so the proxy registers it.
+const exportAvroSchema = `{
+  "type": "record",
+  "name": "RoomEvent",
+  "namespace": "hotaru.export",
+  "fields": [
+    {"name": "id", "type": "string"},
+    {"name": "type", "type": "string"},
+    {"name": "at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "tenant", "type": "string", "default": ""},
+    {"name": "meeting", "type": "string"},
+    {"name": "participant", "type": "string", "default": ""},
+    {"name": "poll", "type": "string", "default": ""},
+    {"name": "participants", "type": "int", "default": 0},
+    {"name": "votes", "type": "int", "default": 0},
+    {"name": "region", "type": "string", "default": ""}
+  ]
+}`
+
+const (
+	// exportQueueSize is how many events wait for the proxy before new
+	// ones are dropped
+	exportQueueSize = 10000
+	// exportBatchSize is the most events sent in one request
+	exportBatchSize = 500
+	// exportLinger is how long a batch waits to fill up
+	exportLinger = time.Second
+	// exportRetries is how often a failed batch is sent again before it
+	// is dropped
+	exportRetries = 3
+)
+
+// eventExporter sends queued events to the topic of a Kafka REST Proxy.
+type eventExporter struct {
+	url         string // the proxy's /topics/{topic}
+	contentType string
+	avro        bool
+	client      *http.Client
+	queue       chan ExportEvent
+	stop, done  chan struct{}
+}
+
+var exporter atomic.Pointer[eventExporter]
+
+// startEventExport starts exporting events when KAFKA_REST_URL is set.
+func startEventExport(cfg *Config) error {
+	if cfg.KafkaRESTURL == "" {
+		return nil
+	}
+	if cfg.KafkaTopic == "" {
+		return fmt.Errorf("KAFKA_TOPIC must be set with KAFKA_REST_URL")
+	}
+	e := &eventExporter{
+		url:    strings.TrimRight(cfg.KafkaRESTURL, "/") + "/topics/" + url.PathEscape(cfg.KafkaTopic),
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan ExportEvent, exportQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	switch cfg.KafkaExportFormat {
+	case "json":
+		e.contentType = "application/vnd.kafka.json.v2+json"
+	case "avro":
+		e.contentType = "application/vnd.kafka.avro.v2+json"
+		e.avro = true
+	default:
+		return fmt.Errorf("KAFKA_EXPORT_FORMAT must be json or avro, got %q", cfg.KafkaExportFormat)
+	}
+	exporter.Store(e)
+	goSafe("event-export", e.run)
+	log.Printf("Exporting room events to Kafka topic %s (%s).", cfg.KafkaTopic, cfg.KafkaExportFormat)
+	return nil
+}
+
+// closeEventExport sends the events still queued, waiting at most until
+// ctx is done.
+func closeEventExport(ctx context.Context) {
+	e := exporter.Swap(nil)
+	if e == nil {
+		return
+	}
+	close(e.stop)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		log.Printf("Event export: %d events not sent at shutdown", len(e.queue))
+	}
+}
+
+// exportRoomEvent queues an event of the room for export. uid is the
+// participant's pseudonymized uid, as the store keeps it.
+func exportRoomEvent(zCtx *ZoomAuthContext, typ, uid, pollID string, participants, votes int) {
+	e := exporter.Load()
+	if e == nil {
+		return
+	}
+	ev := ExportEvent{
+		ID:           newRequestID(),
+		Type:         typ,
+		At:           clock.Now().UnixMilli(),
+		Tenant:       zCtx.Tenant,
+		Meeting:      zCtx.Mid,
+		Participant:  uid,
+		Poll:         pollID,
+		Participants: participants,
+		Votes:        votes,
+		Region:       region,
+		room:         zCtx.RoomID(),
+	}
+	select {
+	case e.queue <- ev:
+	default:
+		metrics.Add("export_dropped", 1)
+	}
+}
+
+// run sends the queue in batches until stopped, then sends what is left.
+func (e *eventExporter) run() {
+	defer close(e.done)
+	for {
+		var batch []ExportEvent
+		select {
+		case ev := <-e.queue:
+			batch = append(batch, ev)
+		case <-e.stop:
+			for len(e.queue) > 0 {
+				batch = batch[:0]
+				for len(batch) < exportBatchSize && len(e.queue) > 0 {
+					batch = append(batch, <-e.queue)
+				}
+				e.send(batch)
+			}
+			return
+		}
+		linger := time.NewTimer(exportLinger)
+	fill:
+		for len(batch) < exportBatchSize {
+			select {
+			case ev := <-e.queue:
+				batch = append(batch, ev)
+			case <-linger.C:
+				break fill
+			case <-e.stop:
+				break fill
+			}
+		}
+		linger.Stop()
+		e.send(batch)
+	}
+}
+
+// send posts one batch, retrying with a growing pause, and drops it if the
+// proxy keeps failing.
+func (e *eventExporter) send(batch []ExportEvent) {
+	type record struct {
+		Key   string      `json:"key"`
+		Value ExportEvent `json:"value"`
+	}
+	body := struct {
+		KeySchema   string   `json:"key_schema,omitempty"`
+		ValueSchema string   `json:"value_schema,omitempty"`
+		Records     []record `json:"records"`
+	}{Records: make([]record, len(batch))}
+	for i, ev := range batch {
+		// Keyed by room, so a room's events stay in order on one partition
+		body.Records[i] = record{Key: ev.room, Value: ev}
+	}
+	if e.avro {
+		body.KeySchema, body.ValueSchema = `"string"`, exportAvroSchema
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Event export error: %v", err)
+		return
+	}
+
+	for try := 0; ; try++ {
+		err = e.post(b)
+		if err == nil {
+			metrics.Add("export_events", int64(len(batch)))
+			return
+		}
+		metrics.Add("export_errors", 1)
+		if try == exportRetries {
+			break
+		}
+		time.Sleep(time.Duration(1<<try) * time.Second)
+	}
+	metrics.Add("export_dropped", int64(len(batch)))
+	log.Printf("Event export: dropped %d events: %v", len(batch), err)
+}
+
+func (e *eventExporter) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", e.contentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", req.URL.Host, resp.Status)
+	}
+	// The proxy answers 200 even when some records failed
+	var res struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	for _, o := range res.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("record refused: %s", o.Error)
+		}
+	}
+	return nil
+}
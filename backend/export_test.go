@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeRESTProxy records the records posted to a Kafka REST Proxy.
+type fakeRESTProxy struct {
+	mu      sync.Mutex
+	paths   []string
+	schema  string
+	records []struct {
+		Key   string      `json:"key"`
+		Value ExportEvent `json:"value"`
+	}
+}
+
+func (f *fakeRESTProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ValueSchema string `json:"value_schema"`
+		Records     []struct {
+			Key   string      `json:"key"`
+			Value ExportEvent `json:"value"`
+		} `json:"records"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.Path+" "+r.Header.Get("Content-Type"))
+	f.schema = body.ValueSchema
+	f.records = append(f.records, body.Records...)
+	f.mu.Unlock()
+	w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+}
+
+func TestEventExport(t *testing.T) {
+	ts := newTestServer(t)
+	proxy := &fakeRESTProxy{}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+	if err := startEventExport(&Config{KafkaRESTURL: srv.URL, KafkaTopic: "room-events", KafkaExportFormat: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	defer closeEventExport(context.Background())
+
+	a := ts.queryClient("export-room", "a")
+	b := ts.queryClient("export-room", "b")
+	c := ts.queryClient("export-room", "c")
+	a.poll()
+	a.poll()
+	b.poll()
+	c.poll()
+	a.vote()
+	a.vote()
+	b.vote()
+	closeEventExport(context.Background())
+
+	var types []string
+	for _, r := range proxy.records {
+		if r.Key != "export-room" || r.Value.Meeting != "export-room" || r.Value.ID == "" || r.Value.At == 0 {
+			t.Errorf("record = %+v", r)
+		}
+		types = append(types, r.Value.Type)
+	}
+	want := []string{exportJoin, exportJoin, exportJoin, exportVote, exportVote, exportTrigger}
+	if len(types) != len(want) {
+		t.Fatalf("types = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("types = %v, want %v", types, want)
+		}
+	}
+	if p := proxy.records[0].Value.Participant; p == "" || p == "a" {
+		t.Errorf("participant = %q, want it pseudonymized", p)
+	}
+	if tr := proxy.records[5].Value; tr.Participants != 3 || tr.Votes != 2 || tr.Poll != defaultPollID {
+		t.Errorf("trigger = %+v", tr)
+	}
+	if proxy.paths[0] != "/topics/room-events application/vnd.kafka.json.v2+json" {
+		t.Errorf("posted to %q", proxy.paths[0])
+	}
+}
+
+func TestEventExportAvro(t *testing.T) {
+	proxy := &fakeRESTProxy{}
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+	if err := startEventExport(&Config{KafkaRESTURL: srv.URL, KafkaTopic: "room-events", KafkaExportFormat: "avro"}); err != nil {
+		t.Fatal(err)
+	}
+	exportRoomEvent(&ZoomAuthContext{Mid: "m1", Tenant: "t1"}, exportLeave, "p1", "", 0, 0)
+	closeEventExport(context.Background())
+
+	if len(proxy.records) != 1 || proxy.records[0].Key != "t1/m1" {
+		t.Fatalf("records = %+v", proxy.records)
+	}
+	var schema struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(proxy.schema), &schema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	// The schema lists every field the records carry
+	var record map[string]any
+	b, _ := json.Marshal(proxy.records[0].Value)
+	json.Unmarshal(b, &record)
+	if len(schema.Fields) != len(record) {
+		t.Errorf("schema has %d fields, records %d", len(schema.Fields), len(record))
+	}
+	for _, f := range schema.Fields {
+		if _, ok := record[f.Name]; !ok {
+			t.Errorf("schema field %s not in the record", f.Name)
+		}
+	}
+
+	if err := startEventExport(&Config{KafkaRESTURL: srv.URL, KafkaTopic: "t", KafkaExportFormat: "protobuf"}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// featureFlags are the features that can be switched on or off per
+// deployment, tenant or room, so risky ones can be rolled out gradually.
+var featureFlags = map[string]string{
+	"snooze":          "あと5分 snooze and its countdown",
+	"named_mode":      "non-anonymous voter list",
+	"end_meeting":     "hard end through the end_meeting trigger action",
+	"seasonal_themes": "seasonal settings from SEASONS_FILE",
+}
+
+// builtinRollout is the rollout before FEATURE_FLAGS is applied.
+var builtinRollout = map[string]int{
+	"snooze":          100,
+	"named_mode":      100,
+	"end_meeting":     100,
+	"seasonal_themes": 100,
+}
+
+// featureRollout is the deployment default for each flag: the percentage
+// of rooms it is enabled in. Set from FEATURE_FLAGS, and replaced on a
+// config reload.
+var featureRollout atomic.Pointer[map[string]int]
+
+func init() {
+	featureRollout.Store(&builtinRollout)
+}
+
+// parseFeatureFlags parses "snooze=off,end_meeting=10%" over the built-in
+// rollout. Values are on, off or a percentage of rooms.
+func parseFeatureFlags(s string) (map[string]int, error) {
+	rollout := maps.Clone(builtinRollout)
+	for _, item := range splitList(s) {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("feature flag %q: want name=on|off|N%%", item)
+		}
+		if _, known := featureFlags[name]; !known {
+			return nil, fmt.Errorf("unknown feature flag %q", name)
+		}
+		switch value = strings.TrimSpace(value); value {
+		case "on", "true":
+			rollout[name] = 100
+		case "off", "false":
+			rollout[name] = 0
+		default:
+			pct, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || !strings.HasSuffix(value, "%") || pct < 0 || pct > 100 {
+				return nil, fmt.Errorf("feature flag %q: want on, off or 0%%-100%%", item)
+			}
+			rollout[name] = pct
+		}
+	}
+	return rollout, nil
+}
+
+func validateFeatures(features map[string]bool) error {
+	for name := range features {
+		if _, ok := featureFlags[name]; !ok {
+			return fmt.Errorf("unknown feature flag %q", name)
+		}
+	}
+	return nil
+}
+
+// inRollout reports whether roomID falls in the first pct percent of rooms
+// for flag. Each flag buckets rooms independently, and a room stays in the
+// same bucket as the percentage grows.
+func inRollout(flag, roomID string, pct int) bool {
+	if pct >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(flag + ":" + roomID))
+	return int(h.Sum32()%100) < pct
+}
+
+// feature reports whether flag is enabled for the room: an explicit room or
+// tenant setting wins, else the deployment rollout decides.
+func (s *RoomSettings) feature(roomID, flag string) bool {
+	if on, ok := s.Features[flag]; ok {
+		return on
+	}
+	return inRollout(flag, roomID, (*featureRollout.Load())[flag])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	rollout, err := parseFeatureFlags("snooze=off, end_meeting=10%")
+	if err != nil || rollout["snooze"] != 0 || rollout["end_meeting"] != 10 || rollout["named_mode"] != 100 {
+		t.Errorf("parseFeatureFlags = %v, %v", rollout, err)
+	}
+	for _, bad := range []string{"stay_votes=on", "snooze", "snooze=maybe", "snooze=120%", "snooze=10"} {
+		if _, err := parseFeatureFlags(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestFeatureRollout(t *testing.T) {
+	in10, in50 := 0, 0
+	for i := range 1000 {
+		room := fmt.Sprintf("room-%d", i)
+		a, b := inRollout("end_meeting", room, 10), inRollout("end_meeting", room, 50)
+		if a && !b {
+			t.Fatalf("%s is in the 10%% rollout but not in 50%%", room)
+		}
+		if a {
+			in10++
+		}
+		if b {
+			in50++
+		}
+	}
+	if in10 < 50 || in10 > 150 || in50 < 400 || in50 > 600 {
+		t.Errorf("rollout sizes 10%%: %d, 50%%: %d of 1000 rooms", in10, in50)
+	}
+	if inRollout("snooze", "r", 0) || !inRollout("snooze", "r", 100) {
+		t.Error("0% and 100% rollouts must be exact")
+	}
+
+	prev := featureRollout.Load()
+	t.Cleanup(func() { featureRollout.Store(prev) })
+	featureRollout.Store(&map[string]int{"snooze": 0})
+	tenant := RoomSettings{Features: map[string]bool{"snooze": true, "named_mode": false}}
+	room := RoomSettings{Features: map[string]bool{"named_mode": true}}
+	s := room.over(tenant.over(*defaultSettings.Load()))
+	if !s.feature("r", "snooze") || !s.feature("r", "named_mode") {
+		t.Errorf("explicit settings must win over the rollout: %v", s.Features)
+	}
+}
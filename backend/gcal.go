@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleCalendarCredentials are a Google credentials file as downloaded
+// from the Cloud console or written by gcloud: a service account key, or
+// an OAuth client's refresh token (type authorized_user).
+type googleCalendarCredentials struct {
+	Type string `json:"type"` // service_account or authorized_user
+
+	ClientEmail string `json:"client_email"` // service_account
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	ClientID     string `json:"client_id"` // authorized_user
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+
+	// Calendar is the calendar to read, e.g. a meeting room's; empty for
+	// the host's own, which a service account reads through domain-wide
+	// delegation
+	Calendar string `json:"calendar,omitempty"`
+}
+
+var (
+	googleCalendarBaseURL = "https://www.googleapis.com/calendar/v3"
+	googleTokenURL        = "https://oauth2.googleapis.com/token"
+)
+
+const googleCalendarScope = "https://www.googleapis.com/auth/calendar.readonly"
+
+// googleCalendar reads events with the Calendar API. Tokens are cached per
+// user the service account acts as.
+type googleCalendar struct {
+	creds googleCalendarCredentials
+	key   *rsa.PrivateKey
+
+	mu     sync.Mutex
+	tokens map[string]googleToken // subject -> token
+}
+
+type googleToken struct {
+	token  string
+	expiry time.Time
+}
+
+// newGoogleCalendar checks creds, parsed from a credentials file.
+func newGoogleCalendar(creds googleCalendarCredentials) (*googleCalendar, error) {
+	c := &googleCalendar{creds: creds, tokens: map[string]googleToken{}}
+	switch creds.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(creds.PrivateKey))
+		if block == nil || creds.ClientEmail == "" {
+			return nil, fmt.Errorf("google calendar: service account key without client_email or private_key")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("google calendar: private_key is not an RSA key")
+		}
+		c.key = rsaKey
+	case "authorized_user":
+		if creds.ClientID == "" || creds.ClientSecret == "" || creds.RefreshToken == "" {
+			return nil, fmt.Errorf("google calendar: authorized_user credentials need client_id, client_secret and refresh_token")
+		}
+	default:
+		return nil, fmt.Errorf("google calendar: credentials type %q (want service_account or authorized_user)", creds.Type)
+	}
+	return c, nil
+}
+
+// parseGoogleCalendarCredentials parses a credentials file's content.
+func parseGoogleCalendarCredentials(s, calendar string) (*googleCalendar, error) {
+	var creds googleCalendarCredentials
+	if err := json.Unmarshal([]byte(s), &creds); err != nil {
+		return nil, fmt.Errorf("google calendar credentials: %w", err)
+	}
+	creds.Calendar = cmp.Or(creds.Calendar, calendar)
+	return newGoogleCalendar(creds)
+}
+
+func (c *googleCalendar) tokenURI() string {
+	if c.creds.TokenURI != "" {
+		return c.creds.TokenURI
+	}
+	return googleTokenURL
+}
+
+// accessToken returns a token acting as subject, the user whose calendar
+// is read; empty for the service account itself.
+func (c *googleCalendar) accessToken(ctx context.Context, subject string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := clock.Now()
+	if t, ok := c.tokens[subject]; ok && now.Before(t.expiry) {
+		return t.token, nil
+	}
+
+	form := url.Values{}
+	if c.key != nil {
+		assertion, err := c.assertion(subject, now)
+		if err != nil {
+			return "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	} else {
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.creds.ClientID)
+		form.Set("client_secret", c.creds.ClientSecret)
+		form.Set("refresh_token", c.creds.RefreshToken)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURI(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doCalendarRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("google oauth: %w", err)
+	}
+	if len(c.tokens) > 1000 {
+		clear(c.tokens) // hosts come and go; start over rather than grow
+	}
+	c.tokens[subject] = googleToken{resp.AccessToken, now.Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)}
+	return resp.AccessToken, nil
+}
+
+// assertion is the signed JWT a service account trades for a token.
+func (c *googleCalendar) assertion(subject string, now time.Time) (string, error) {
+	claims := map[string]any{
+		"iss":   c.creds.ClientEmail,
+		"scope": googleCalendarScope,
+		"aud":   c.tokenURI(),
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if subject != "" {
+		claims["sub"] = subject
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// Events reads the configured calendar, or host's own.
+func (c *googleCalendar) Events(ctx context.Context, host string, from, to time.Time) ([]calendarEvent, error) {
+	calendar, subject := c.creds.Calendar, ""
+	if calendar == "" {
+		calendar = host
+		if c.key != nil {
+			subject = host
+		}
+	}
+	token, err := c.accessToken(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{
+		"timeMin":      {from.Format(time.RFC3339)},
+		"timeMax":      {to.Format(time.RFC3339)},
+		"singleEvents": {"true"},
+		"orderBy":      {"startTime"},
+		"maxResults":   {"250"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleCalendarBaseURL+"/calendars/"+url.PathEscape(calendar)+"/events?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	type eventTime struct {
+		DateTime string `json:"dateTime"` // empty for all-day events
+	}
+	var resp struct {
+		Items []struct {
+			Status         string    `json:"status"`
+			Start          eventTime `json:"start"`
+			End            eventTime `json:"end"`
+			Location       string    `json:"location"`
+			Description    string    `json:"description"`
+			ConferenceData struct {
+				EntryPoints []struct {
+					URI string `json:"uri"`
+				} `json:"entryPoints"`
+			} `json:"conferenceData"`
+		} `json:"items"`
+	}
+	if err := doCalendarRequest(req, &resp); err != nil {
+		return nil, fmt.Errorf("google calendar: %w", err)
+	}
+	var events []calendarEvent
+	for _, it := range resp.Items {
+		start, err1 := time.Parse(time.RFC3339, it.Start.DateTime)
+		end, err2 := time.Parse(time.RFC3339, it.End.DateTime)
+		if it.Status == "cancelled" || err1 != nil || err2 != nil {
+			continue
+		}
+		text := []string{it.Location, it.Description}
+		for _, ep := range it.ConferenceData.EntryPoints {
+			text = append(text, ep.URI)
+		}
+		events = append(events, calendarEvent{Start: start, End: end, Text: strings.Join(text, "\n")})
+	}
+	return events, nil
+}
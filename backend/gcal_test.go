@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// googleFake is the OAuth token endpoint and the events list of the
+// Calendar API, with one calendar per ID.
+type googleFake struct {
+	*httptest.Server
+	key *rsa.PrivateKey // the service account's
+
+	mu        sync.Mutex
+	calendars map[string][]map[string]any
+	tokens    map[string]string // token -> the user it acts as
+	grants    []string
+}
+
+func newGoogleFake(t *testing.T) *googleFake {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &googleFake{key: key, calendars: map[string][]map[string]any{}, tokens: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", g.handleToken)
+	mux.HandleFunc("GET /calendar/v3/calendars/{id}/events", g.handleEvents)
+	g.Server = httptest.NewServer(mux)
+	t.Cleanup(g.Close)
+
+	oldBase, oldToken := googleCalendarBaseURL, googleTokenURL
+	googleCalendarBaseURL, googleTokenURL = g.URL+"/calendar/v3", g.URL+"/token"
+	t.Cleanup(func() { googleCalendarBaseURL, googleTokenURL = oldBase, oldToken })
+	return g
+}
+
+// serviceAccount is the fake's service account key file.
+func (g *googleFake) serviceAccount() string {
+	der, _ := x509.MarshalPKCS8PrivateKey(g.key)
+	b, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "hotaru@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    g.URL + "/token",
+	})
+	return string(b)
+}
+
+// addEvent puts a timed event on calendar.
+func (g *googleFake) addEvent(calendar string, start time.Time, length time.Duration, fields map[string]any) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := map[string]any{
+		"status": "confirmed",
+		"start":  map[string]string{"dateTime": start.Format(time.RFC3339)},
+		"end":    map[string]string{"dateTime": start.Add(length).Format(time.RFC3339)},
+	}
+	for k, v := range fields {
+		e[k] = v
+	}
+	g.calendars[calendar] = append(g.calendars[calendar], e)
+}
+
+func (g *googleFake) handleToken(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	subject := ""
+	switch r.PostFormValue("grant_type") {
+	case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+		parts := strings.Split(r.PostFormValue("assertion"), ".")
+		if len(parts) != 3 {
+			http.Error(w, "bad assertion", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&g.key.PublicKey, crypto.SHA256, sum[:], sig) != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims struct {
+			Sub   string `json:"sub"`
+			Scope string `json:"scope"`
+			Aud   string `json:"aud"`
+		}
+		json.Unmarshal(payload, &claims)
+		if claims.Scope != googleCalendarScope || claims.Aud != g.URL+"/token" {
+			http.Error(w, "bad claims", http.StatusBadRequest)
+			return
+		}
+		subject = claims.Sub
+	case "refresh_token":
+		if r.PostFormValue("refresh_token") != "refresh" || r.PostFormValue("client_secret") != "gsecret" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		subject = "oauth-user"
+	default:
+		http.Error(w, "unsupported_grant_type", http.StatusBadRequest)
+		return
+	}
+	g.grants = append(g.grants, subject)
+	token := "gtok-" + subject
+	g.tokens[token] = subject
+	json.NewEncoder(w).Encode(map[string]any{"access_token": token, "expires_in": 3600, "token_type": "Bearer"})
+}
+
+func (g *googleFake) handleEvents(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id := r.PathValue("id")
+	subject, ok := g.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	// Without delegation only shared calendars can be read
+	if !ok || subject != "" && subject != "oauth-user" && subject != id {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	from, _ := time.Parse(time.RFC3339, q.Get("timeMin"))
+	to, _ := time.Parse(time.RFC3339, q.Get("timeMax"))
+	var items []map[string]any
+	for _, e := range g.calendars[id] {
+		start, _ := time.Parse(time.RFC3339, e["start"].(map[string]string)["dateTime"])
+		end, _ := time.Parse(time.RFC3339, e["end"].(map[string]string)["dateTime"])
+		if end.After(from) && start.Before(to) {
+			items = append(items, e)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func TestGoogleCalendarSchedule(t *testing.T) {
+	z := newZoomFake(t)
+	g := newGoogleFake(t)
+	fc := useFakeClock(t)
+	fc.now = time.Date(2026, 10, 16, 10, 48, 0, 0, time.UTC)
+	cal, err := parseGoogleCalendarCredentials(g.serviceAccount(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src calendarSource = cal
+	defaultCalendar.Store(&src)
+	t.Cleanup(func() { defaultCalendar.Store(nil) })
+
+	// Zoom says an hour from 10:00; the calendar was moved to 10:15-10:45,
+	// and the early run of the series at 0:15 is not this one
+	z.addMeeting("mid-cal", fakeMeeting{Topic: "定例MTG", Number: 81234567890, HostEmail: "host@example.com", Start: fc.now.Add(-48 * time.Minute), Duration: time.Hour})
+	host := "host@example.com"
+	g.addEvent(host, fc.now.Add(-10*time.Hour-33*time.Minute), 30*time.Minute, map[string]any{"location": "https://zoom.us/j/81234567890"})
+	g.addEvent(host, fc.now.Add(-33*time.Minute), 30*time.Minute, map[string]any{
+		"summary":     "定例MTG",
+		"description": "Zoom ミーティングに参加する\nミーティング ID: 812 3456 7890",
+	})
+	g.addEvent(host, fc.now.Add(-24*time.Hour), 8*time.Hour, map[string]any{"start": map[string]string{"date": "2026-10-16"}}) // all day
+	g.addEvent(host, fc.now.Add(12*time.Minute), time.Hour, map[string]any{"summary": "1on1"})
+	g.addEvent(host, fc.now.Add(2*time.Hour), time.Hour, nil)
+	g.addEvent(host, fc.now.Add(5*time.Minute), time.Hour, map[string]any{"status": "cancelled"})
+
+	zCtx := &ZoomAuthContext{UID: "u1", Mid: "mid-cal"}
+	info := meetingInfo(context.Background(), zCtx)
+	if info == nil || !info.StartTime.Equal(fc.now.Add(-33*time.Minute)) || info.Duration != 30*time.Minute {
+		t.Fatalf("meeting info = %+v", info)
+	}
+	got := generateMeetingInfoHTML(defaultLocale, info, clock.Now())
+	if !strings.Contains(got, "予定 30分 / 経過 33分（3分超過） / 次の予定まで 12分") {
+		t.Errorf("meeting line: %s", got)
+	}
+	fc.Advance(15 * time.Minute)
+	if got := generateMeetingInfoHTML(defaultLocale, info, clock.Now()); !strings.Contains(got, "次の予定が始まっています") {
+		t.Errorf("meeting line after the next start: %s", got)
+	}
+	if len(g.grants) != 1 || g.grants[0] != host {
+		t.Errorf("tokens granted for %q, want the host", g.grants)
+	}
+
+	// A meeting that is not on the calendar keeps Zoom's schedule
+	z.addMeeting("mid-adhoc", fakeMeeting{Topic: "立ち話", Number: 89999999999, HostEmail: host, Start: fc.now, Duration: 40 * time.Minute})
+	info = meetingInfo(context.Background(), &ZoomAuthContext{UID: "u1", Mid: "mid-adhoc"})
+	if info == nil || info.Duration != 40*time.Minute || !info.NextMeeting.IsZero() {
+		t.Errorf("meeting info = %+v", info)
+	}
+}
+
+func TestGoogleCalendarOAuth(t *testing.T) {
+	g := newGoogleFake(t)
+	useFakeClock(t)
+	now := clock.Now()
+	g.addEvent("room-7f@example.com", now, time.Hour, map[string]any{
+		"conferenceData": map[string]any{"entryPoints": []map[string]string{{"uri": "https://example.zoom.us/j/81234567890?pwd=x"}}},
+	})
+	creds := `{"type": "authorized_user", "client_id": "gid", "client_secret": "gsecret", "refresh_token": "refresh"}`
+	cal, err := parseGoogleCalendarCredentials(creds, "room-7f@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &MeetingInfo{Number: "81234567890", HostEmail: "host@example.com"}
+	for range 2 {
+		if err := applyCalendar(context.Background(), cal, info, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info.Duration != time.Hour || len(g.grants) != 1 {
+		t.Errorf("info = %+v after %d grants", info, len(g.grants))
+	}
+
+	for _, bad := range []string{
+		`{"type": "external_account"}`,
+		`{"type": "authorized_user", "client_id": "gid"}`,
+		`{"type": "service_account", "client_email": "a@b", "private_key": "not pem"}`,
+		`not json`,
+	} {
+		if _, err := parseGoogleCalendarCredentials(bad, ""); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenCase renders one variant of a fragment.
+type goldenCase struct {
+	name string
+	// id is the element the panel's script or an htmx swap looks up
+	id     string
+	render func(lang string) string
+}
+
+// goldenCases cover every fragment the panels are sent, in the variants
+// that change their markup.
+func goldenCases() []goldenCase {
+	s := builtinSettings
+	start := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	samples := []VoteSample{
+		{At: start, Participants: 4, Votes: 0},
+		{At: start.Add(10 * time.Minute), Participants: 4, Votes: 1},
+		{At: start.Add(20 * time.Minute), Participants: 5, Votes: 3},
+	}
+	report := &MeetingReport{
+		StartedAt:    start,
+		FirstVoteAt:  start.Add(10 * time.Minute),
+		TriggeredAt:  start.Add(70 * time.Minute),
+		Scheduled:    time.Hour,
+		Participants: 5,
+		Votes:        3,
+		Samples:      samples,
+	}
+	ctx := context.WithValue(context.Background(), requestIDKey, "0123456789abcdef")
+
+	cases := []goldenCase{
+		{"gauge-waiting", "gauge-container", func(lang string) string { return generateGaugeHTML(lang, 0, false, &s) }},
+		{"gauge-rising", "gauge-container", func(lang string) string { return generateGaugeHTML(lang, 37.5, false, &s) }},
+		{"gauge-passed", "gauge-container", func(lang string) string { return generateGaugeHTML(lang, 100, false, &s) }},
+		{"gauge-triggered", "gauge-container", func(lang string) string { return generateGaugeHTML(lang, 100, true, &s) }},
+		{"ambience-fireflies", "ambience", func(string) string { return generateAmbienceHTML(s.AmbienceStages, 30, false) }},
+		{"ambience-dim", "ambience", func(string) string { return generateAmbienceHTML(s.AmbienceStages, 60, false) }},
+		{"ambience-reduced-motion", "ambience", func(string) string { return generateAmbienceHTML(s.AmbienceStages, 60, true) }},
+		{"sparkline", "sparkline", func(lang string) string { return generateSparklineHTML(lang, samples) }},
+		{"report", "report", func(lang string) string { return generateReportHTML(lang, report) }},
+		{"snooze-countdown", "snooze-banner", func(lang string) string { return generateSnoozeBannerHTML(lang, 90*time.Second) }},
+		{"snooze-button", "snooze", func(lang string) string { return generateSnoozeButtonHTML(lang, 5, 2) }},
+		{"side-poll-running", "side-poll", func(lang string) string { return generateSidePollHTML(lang, polls["break"], 4, 1, false) }},
+		{"side-poll-passed", "side-poll", func(lang string) string { return generateSidePollHTML(lang, polls["extend"], 4, 3, true) }},
+		{"meeting-info", "meeting-info", func(lang string) string {
+			return generateMeetingInfoHTML(lang, &MeetingInfo{Topic: "定例MTG", StartTime: start, Duration: time.Hour}, start.Add(75*time.Minute))
+		}},
+		{"voter-list-empty", "voter-list", func(lang string) string { return generateVoterListHTML(lang, nil, 0) }},
+		{"voter-list-named", "voter-list", func(lang string) string { return generateVoterListHTML(lang, []string{"佐藤", "<b>鈴木</b>"}, 4) }},
+		{"host-panel", "host-panel", func(lang string) string {
+			return generateHostPanelHTML(lang, 5, 2, false, true, nil, false)
+		}},
+		{"host-panel-named-snoozable", "host-panel", func(lang string) string {
+			return generateHostPanelHTML(lang, 5, 2, true, true, nil, true)
+		}},
+		{"host-panel-poll-running", "host-panel", func(lang string) string {
+			return generateHostPanelHTML(lang, 5, 2, false, false, polls["break"], false)
+		}},
+		{"room-events", "room-events", func(lang string) string {
+			return renderFragment(lang, "room_events", map[string]any{"Latest": "1700000000000-0", "Triggered": []string{"10:42"}})
+		}},
+		{"error-frame", "", func(lang string) string { return frameRateLimited.render(ctx, lang, false) }},
+		{"error-frame-oob", "error-frame", func(lang string) string { return frameRateLimited.render(ctx, lang, true) }},
+		{"idle-closed", "gauge-container", func(lang string) string { return renderFragment(lang, "idle_closed", nil) }},
+	}
+	for _, th := range themes {
+		cases = append(cases, goldenCase{"theme-" + th.ID, "theme", func(lang string) string { return wrapTheme(lang, th, "<p>body</p>") }})
+	}
+	muted := *themes[0]
+	muted.Audio = ""
+	cases = append(cases, goldenCase{"theme-muted", "theme", func(lang string) string { return wrapTheme(lang, &muted, "<p>body</p>") }})
+	return cases
+}
+
+// TestGoldenFragments compares every fragment variant in every locale with
+// testdata/golden. After an intended markup change, run
+// go test -run TestGoldenFragments -update and review the diff.
+func TestGoldenFragments(t *testing.T) {
+	// Fingerprints change with the frontend files; keep the bare names
+	assets := frontendAssets.Swap(nil)
+	t.Cleanup(func() { frontendAssets.Store(assets) })
+
+	dir := filepath.Join("testdata", "golden")
+	if *updateGolden {
+		os.MkdirAll(dir, 0o755)
+	}
+	langs := []string{defaultLocale}
+	for lang := range messages {
+		langs = append(langs, lang)
+	}
+	for _, c := range goldenCases() {
+		for _, lang := range langs {
+			t.Run(c.name+"/"+lang, func(t *testing.T) {
+				got := c.render(lang)
+				if got == "" {
+					t.Fatal("rendered nothing")
+				}
+				if c.id != "" && strings.Count(got, fmt.Sprintf(`id="%s"`, c.id)) != 1 {
+					t.Errorf("want one element with id %q:\n%s", c.id, got)
+				}
+				path := filepath.Join(dir, c.name+"."+lang+".html")
+				if *updateGolden {
+					if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("%v (run with -update to create it)", err)
+				}
+				if got != string(want) {
+					t.Errorf("%s differs from the golden file:\n--- got\n%s\n--- want\n%s", c.name, got, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// graphCredentials are a Microsoft Entra app registration with the
+// Calendars.Read application permission, for organizations on Microsoft
+// 365.
+type graphCredentials struct {
+	TenantID     string `json:"tenant_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+
+	// Calendar is the mailbox to read, e.g. a meeting room's; empty for
+	// the host's own
+	Calendar string `json:"calendar,omitempty"`
+}
+
+var (
+	graphBaseURL  = "https://graph.microsoft.com/v1.0"
+	graphLoginURL = "https://login.microsoftonline.com"
+)
+
+// graphMaxPages bounds the calendarView pages read per lookup.
+const graphMaxPages = 4
+
+// graphCalendar reads events with Microsoft Graph, with an app-only token
+// cached until shortly before it expires.
+type graphCalendar struct {
+	creds graphCredentials
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newGraphCalendar(creds graphCredentials) (*graphCalendar, error) {
+	if creds.TenantID == "" || creds.ClientID == "" || creds.ClientSecret == "" {
+		return nil, fmt.Errorf("microsoft graph: tenant_id, client_id and client_secret are required")
+	}
+	return &graphCalendar{creds: creds}, nil
+}
+
+func (c *graphCalendar) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && clock.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.creds.ClientID},
+		"client_secret": {c.creds.ClientSecret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+	}
+	u := graphLoginURL + "/" + url.PathEscape(c.creds.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doCalendarRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("microsoft oauth: %w", err)
+	}
+	c.token = resp.AccessToken
+	c.tokenExpiry = clock.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// graphTime parses Graph's dateTime, which has no offset; Prefer asks for
+// UTC.
+func graphTime(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02T15:04:05.9999999", s, time.UTC)
+}
+
+// Events reads the calendar view of the configured mailbox, or host's.
+func (c *graphCalendar) Events(ctx context.Context, host string, from, to time.Time) ([]calendarEvent, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{
+		"startDateTime": {from.UTC().Format(time.RFC3339)},
+		"endDateTime":   {to.UTC().Format(time.RFC3339)},
+		"$select":       {"start,end,isCancelled,isAllDay,location,body,onlineMeeting"},
+		"$top":          {"100"},
+	}
+	next := graphBaseURL + "/users/" + url.PathEscape(cmp.Or(c.creds.Calendar, host)) + "/calendarView?" + q.Encode()
+
+	type graphTimeZone struct {
+		DateTime string `json:"dateTime"`
+	}
+	var events []calendarEvent
+	for page := 0; next != "" && page < graphMaxPages; page++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Prefer", `outlook.timezone="UTC", outlook.body-content-type="text"`)
+		var resp struct {
+			Value []struct {
+				IsCancelled bool          `json:"isCancelled"`
+				IsAllDay    bool          `json:"isAllDay"`
+				Start       graphTimeZone `json:"start"`
+				End         graphTimeZone `json:"end"`
+				Location    struct {
+					DisplayName string `json:"displayName"`
+				} `json:"location"`
+				Body struct {
+					Content string `json:"content"`
+				} `json:"body"`
+				OnlineMeeting *struct {
+					JoinURL string `json:"joinUrl"`
+				} `json:"onlineMeeting"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := doCalendarRequest(req, &resp); err != nil {
+			return nil, fmt.Errorf("microsoft graph: %w", err)
+		}
+		for _, it := range resp.Value {
+			start, err1 := graphTime(it.Start.DateTime)
+			end, err2 := graphTime(it.End.DateTime)
+			if it.IsCancelled || it.IsAllDay || err1 != nil || err2 != nil {
+				continue
+			}
+			text := []string{it.Location.DisplayName, it.Body.Content}
+			if it.OnlineMeeting != nil {
+				text = append(text, it.OnlineMeeting.JoinURL)
+			}
+			events = append(events, calendarEvent{Start: start, End: end, Text: strings.Join(text, "\n")})
+		}
+		next = resp.NextLink
+	}
+	return events, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// graphFake is the Microsoft identity platform's token endpoint and the
+// calendarView of Microsoft Graph, two events per page.
+type graphFake struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	mailboxes map[string][]map[string]any
+	tokens    int
+}
+
+func newGraphFake(t *testing.T) *graphFake {
+	t.Helper()
+	g := &graphFake{mailboxes: map[string][]map[string]any{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /contoso/oauth2/v2.0/token", g.handleToken)
+	mux.HandleFunc("GET /v1.0/users/{user}/calendarView", g.handleCalendarView)
+	g.Server = httptest.NewServer(mux)
+	t.Cleanup(g.Close)
+
+	oldBase, oldLogin := graphBaseURL, graphLoginURL
+	graphBaseURL, graphLoginURL = g.URL+"/v1.0", g.URL
+	t.Cleanup(func() { graphBaseURL, graphLoginURL = oldBase, oldLogin })
+	return g
+}
+
+// addEvent puts an event on mailbox's calendar.
+func (g *graphFake) addEvent(mailbox string, start time.Time, length time.Duration, fields map[string]any) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	const layout = "2006-01-02T15:04:05.0000000"
+	e := map[string]any{
+		"start": map[string]string{"dateTime": start.UTC().Format(layout), "timeZone": "UTC"},
+		"end":   map[string]string{"dateTime": start.Add(length).UTC().Format(layout), "timeZone": "UTC"},
+	}
+	for k, v := range fields {
+		e[k] = v
+	}
+	g.mailboxes[mailbox] = append(g.mailboxes[mailbox], e)
+}
+
+func (g *graphFake) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("client_secret") != "msecret" || r.PostFormValue("scope") != "https://graph.microsoft.com/.default" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	g.mu.Lock()
+	g.tokens++
+	g.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "mtok", "expires_in": 3599, "token_type": "Bearer"})
+}
+
+func (g *graphFake) handleCalendarView(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer mtok" || !strings.Contains(r.Header.Get("Prefer"), `outlook.timezone="UTC"`) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	events := g.mailboxes[r.PathValue("user")]
+	skip, _ := strconv.Atoi(r.URL.Query().Get("$skip"))
+	page := events[min(skip, len(events)):min(skip+2, len(events))]
+	resp := map[string]any{"value": page}
+	if skip+2 < len(events) {
+		q := r.URL.Query()
+		q.Set("$skip", strconv.Itoa(skip+2))
+		resp["@odata.nextLink"] = g.URL + r.URL.Path + "?" + q.Encode()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestGraphCalendarBackToBack(t *testing.T) {
+	z := newZoomFake(t)
+	g := newGraphFake(t)
+	fc := useFakeClock(t)
+	fc.now = time.Date(2026, 10, 16, 10, 40, 0, 0, time.UTC)
+	cal, err := newGraphCalendar(graphCredentials{TenantID: "contoso", ClientID: "mid", ClientSecret: "msecret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src calendarSource = cal
+	defaultCalendar.Store(&src)
+	t.Cleanup(func() { defaultCalendar.Store(nil) })
+
+	host := "host@contoso.example"
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	z.addMeeting("mid-graph", fakeMeeting{Topic: "週次定例", Number: 87654321098, HostEmail: host, Start: start, Duration: 90 * time.Minute})
+	g.addEvent(host, start.Add(-time.Hour), time.Hour, map[string]any{"isAllDay": true})
+	g.addEvent(host, start, 45*time.Minute, map[string]any{
+		"location": map[string]string{"displayName": "Zoom"},
+		"body":     map[string]string{"content": "Join Zoom Meeting https://contoso.zoom.us/j/87654321098"},
+	})
+	g.addEvent(host, start.Add(45*time.Minute), 15*time.Minute, map[string]any{"isCancelled": true})
+	g.addEvent(host, start.Add(48*time.Minute), 30*time.Minute, map[string]any{
+		"onlineMeeting": map[string]string{"joinUrl": "https://teams.microsoft.com/l/meetup-join/x"},
+	})
+
+	info := meetingInfo(context.Background(), &ZoomAuthContext{UID: "u1", Mid: "mid-graph"})
+	if info == nil || info.Duration != 45*time.Minute || !info.NextMeeting.Equal(start.Add(48*time.Minute)) || !info.BackToBack {
+		t.Fatalf("meeting info = %+v", info)
+	}
+	got := generateMeetingInfoHTML(defaultLocale, info, clock.Now())
+	if !strings.Contains(got, "予定 45分 / 経過 40分（残り 5分） / 次の予定まで 8分（休憩なし）") || !strings.Contains(got, "meeting-info-rush") {
+		t.Errorf("meeting line: %s", got)
+	}
+	if got := generateMeetingInfoHTML("en", info, start.Add(20*time.Minute)); strings.Contains(got, "meeting-info-rush") || !strings.Contains(got, "back to back") {
+		t.Errorf("meeting line early on: %s", got)
+	}
+
+	// The next occurrence, with nothing after it: no rush
+	g.addEvent(host, start.Add(2*time.Hour), time.Hour, map[string]any{"body": map[string]string{"content": "ID: 876 5432 1098"}})
+	fc.now = start.Add(2*time.Hour + 55*time.Minute)
+	info = &MeetingInfo{Number: "87654321098", HostEmail: host}
+	if err := applyCalendar(context.Background(), cal, info, fc.now); err != nil {
+		t.Fatal(err)
+	}
+	if !info.StartTime.Equal(start.Add(2*time.Hour)) || info.BackToBack || !info.NextMeeting.IsZero() {
+		t.Errorf("later meeting info = %+v", info)
+	}
+	// One token for the pages of the first lookup; it expired before the
+	// second
+	if g.tokens != 2 {
+		t.Errorf("%d tokens issued, want 2", g.tokens)
+	}
+
+	if _, err := newGraphCalendar(graphCredentials{TenantID: "contoso"}); err == nil {
+		t.Error("credentials without a client accepted")
+	}
+}
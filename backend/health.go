@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// redisHealthy is maintained by monitorRedis; readiness fails while
+	// Redis is unreachable so load balancers route panels elsewhere.
+	redisHealthy atomic.Bool
+
+	// shuttingDown fails readiness as soon as a stop signal arrives, before
+	// the listener closes.
+	shuttingDown atomic.Bool
+
+	redisUp = new(expvar.Int)
+)
+
+func init() {
+	redisHealthy.Store(true)
+	metrics.Set("redis_up", redisUp)
+}
+
+func setRedisHealthy(ok bool) {
+	if redisHealthy.Swap(ok) != ok {
+		if ok {
+			log.Println("Redis reachable again")
+		} else {
+			log.Println("WARNING: Redis unreachable, reporting not ready")
+		}
+	}
+	if ok {
+		redisUp.Set(1)
+	} else {
+		redisUp.Set(0)
+	}
+}
+
+// monitorRedis pings Redis every interval. After a failure it checks again
+// with exponential backoff (capped at interval) so recovery is noticed quickly.
+func monitorRedis(interval time.Duration) {
+	setRedisHealthy(true)
+	goSafe("redis-monitor", func() {
+		delay := interval
+		for {
+			time.Sleep(delay)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			err := rdb.Ping(ctx).Err()
+			cancel()
+
+			setRedisHealthy(err == nil)
+			switch {
+			case err == nil:
+				delay = interval
+			case delay == interval:
+				delay = 250 * time.Millisecond
+			default:
+				delay = min(delay*2, interval)
+			}
+		}
+	})
+}
+
+// handleHealthz is the liveness probe: the process is up and serving.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz is the readiness probe: the store is reachable and the
+// server is not shutting down.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case shuttingDown.Load():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case useRedis && !redisHealthy.Load():
+		http.Error(w, "redis unreachable", http.StatusServiceUnavailable)
+	default:
+		w.Write([]byte("ok\n"))
+	}
+}
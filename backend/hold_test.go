@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTriggerHold(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			fc := useFakeClock(t)
+			ctx := context.Background()
+			room := "hold-" + store
+			p := (&RoomSettings{ThresholdPercent: 50, HoldSeconds: 10}).triggerPoll()
+			passed := func() bool {
+				_, _, passed, _, err := evaluatePoll(ctx, room, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return passed
+			}
+
+			// 1 of 3 is no majority, until someone drops out
+			for _, uid := range []string{"a", "b", "c"} {
+				AddParticipant(ctx, room, uid)
+			}
+			PollVote(ctx, room, p, "a")
+			RemoveParticipant(ctx, room, "c")
+			if passed() {
+				t.Fatal("passed the moment the majority appeared")
+			}
+			fc.Advance(5 * time.Second)
+			AddParticipant(ctx, room, "c")
+			if passed() {
+				t.Fatal("passed without a majority")
+			}
+
+			// The majority has to hold for the whole 10s again
+			RemoveParticipant(ctx, room, "c")
+			passed()
+			fc.Advance(9 * time.Second)
+			if passed() {
+				t.Error("passed before the hold was over")
+			}
+			fc.Advance(time.Second)
+			_, _, ok, fired, _ := evaluatePoll(ctx, room, p)
+			if !ok || !fired {
+				t.Errorf("after the hold: passed=%t fired=%t", ok, fired)
+			}
+		})
+	}
+}
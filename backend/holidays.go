@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Holiday calendars a room's holiday_calendar can name. Weekends count as
+// days off with either.
+const (
+	holidayCalendarJapan = "jp"   // Japanese national holidays
+	holidayCalendarNone  = "none" // weekends and extra_holidays only
+)
+
+// parseHoliday parses an extra holiday: YYYY-MM-DD for one day, MM-DD for
+// the same day every year.
+func parseHoliday(s string) (year, md int, err error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Year(), int(t.Month())*100 + t.Day(), nil
+	}
+	if md, err := parseMonthDay(s); err == nil {
+		return 0, md, nil
+	}
+	return 0, 0, fmt.Errorf("holiday %q: want YYYY-MM-DD or MM-DD", s)
+}
+
+// validateHolidays checks the holiday fields that are set.
+func (s *RoomSettings) validateHolidays() error {
+	switch s.HolidayCalendar {
+	case "", holidayCalendarJapan, holidayCalendarNone:
+	default:
+		return fmt.Errorf("holiday_calendar must be %q or %q", holidayCalendarJapan, holidayCalendarNone)
+	}
+	for _, h := range s.ExtraHolidays {
+		if _, _, err := parseHoliday(h); err != nil {
+			return err
+		}
+	}
+	if s.HolidayThresholdPercent < 0 || s.HolidayThresholdPercent > 100 {
+		return fmt.Errorf("holiday_threshold_percent must be in (0, 100]")
+	}
+	if s.HolidayText != "" {
+		if err := validateSettingText("holiday text", s.HolidayText); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dayOff reports whether now is a weekend, a holiday of the room's
+// calendar or one of its extra holidays, in the room's time zone.
+func (s *RoomSettings) dayOff(now time.Time) bool {
+	local := now.In(s.location())
+	if wd := local.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return true
+	}
+	md := int(local.Month())*100 + local.Day()
+	for _, h := range s.ExtraHolidays {
+		if year, hmd, err := parseHoliday(h); err == nil && hmd == md && (year == 0 || year == local.Year()) {
+			return true
+		}
+	}
+	return s.HolidayCalendar == holidayCalendarJapan && japaneseHolidays(local.Year())[md]
+}
+
+// applyDayOff lowers the threshold to HolidayThresholdPercent on days off
+// and shows HolidayText while nobody has voted yet. Rooms without a
+// holiday threshold are left alone.
+func (s *RoomSettings) applyDayOff(now time.Time) {
+	if s.HolidayThresholdPercent <= 0 || !s.dayOff(now) {
+		return
+	}
+	s.ThresholdPercent = min(s.ThresholdPercent, s.HolidayThresholdPercent)
+	if s.HolidayText != "" && len(s.GaugeStages) > 0 {
+		stages := append([]GaugeStage(nil), s.GaugeStages...)
+		stages[0].Text = s.HolidayText
+		s.GaugeStages = stages
+	}
+}
+
+var (
+	jpHolidaysMu sync.Mutex
+	jpHolidays   = map[int]map[int]bool{} // year -> month*100 + day
+)
+
+// japaneseHolidays returns the national holidays of year as month*100 +
+// day, computed by the rules of the Act on National Holidays as they stand
+// since 2020, with the moved holidays of the 2020 and 2021 Olympics. The
+// equinox formula holds until 2099.
+func japaneseHolidays(year int) map[int]bool {
+	jpHolidaysMu.Lock()
+	defer jpHolidaysMu.Unlock()
+	if h, ok := jpHolidays[year]; ok {
+		return h
+	}
+	// nthMonday is the day of the nth Monday of month
+	nthMonday := func(month time.Month, n int) int {
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+		return 1 + (8-int(first))%7 + (n-1)*7
+	}
+	// equinox is the day of the vernal (base 20.8431) or autumnal (23.2488)
+	// equinox in Japan
+	equinox := func(base float64) int {
+		y := year - 1980
+		return int(base+0.242194*float64(y)) - y/4
+	}
+
+	h := map[int]bool{
+		101:                                true, // 元日
+		100 + nthMonday(time.January, 2):   true, // 成人の日
+		211:                                true, // 建国記念の日
+		223:                                true, // 天皇誕生日
+		300 + equinox(20.8431):             true, // 春分の日
+		429:                                true, // 昭和の日
+		503:                                true, // 憲法記念日
+		504:                                true, // みどりの日
+		505:                                true, // こどもの日
+		900 + nthMonday(time.September, 3): true, // 敬老の日
+		900 + equinox(23.2488):             true, // 秋分の日
+		1103:                               true, // 文化の日
+		1123:                               true, // 勤労感謝の日
+	}
+	switch year {
+	case 2020:
+		h[723], h[724], h[810] = true, true, true // 海の日, スポーツの日, 山の日
+	case 2021:
+		h[722], h[723], h[808] = true, true, true
+	default:
+		h[700+nthMonday(time.July, 3)] = true     // 海の日
+		h[811] = true                             // 山の日
+		h[1000+nthMonday(time.October, 2)] = true // スポーツの日
+	}
+
+	// 振替休日: a holiday on a Sunday moves to the next day that is not a
+	// holiday. 国民の休日: a day between two holidays is one too.
+	date := func(md int) time.Time {
+		return time.Date(year, time.Month(md/100), md%100, 0, 0, 0, 0, time.UTC)
+	}
+	key := func(t time.Time) int { return int(t.Month())*100 + t.Day() }
+	var extra []int
+	for md := range h {
+		d := date(md)
+		if d.Weekday() == time.Sunday {
+			next := d.AddDate(0, 0, 1)
+			for h[key(next)] {
+				next = next.AddDate(0, 0, 1)
+			}
+			if next.Year() == year {
+				extra = append(extra, key(next))
+			}
+		}
+		if between := d.AddDate(0, 0, 1); !h[key(between)] && h[key(between.AddDate(0, 0, 1))] && between.Weekday() != time.Sunday && between.Year() == year {
+			extra = append(extra, key(between))
+		}
+	}
+	for _, md := range extra {
+		h[md] = true
+	}
+	jpHolidays[year] = h
+	return h
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The panel is written in Japanese; other locales translate its fixed texts.
+// Messages are keyed by the Japanese source text, gettext style, so texts
+// that are not in the catalog (custom ending texts, topics) pass through.
+const defaultLocale = "ja"
+
+var messages = map[string]map[string]string{
+	"en": {
+		"待機中":   "Waiting",
+		"そろそろ…": "Getting there…",
+		"休日の会議、おつかれさまです":       "Thanks for meeting on a day off",
+		"%d%% が帰りたい":           "%d%% want to leave",
+		"(匿名)":                 "(anonymous)",
+		"投票が成立しました":            "The vote passed",
+		"本日の営業は終了しました":         "That's all for today",
+		"速やかにご退出ください":          "Please leave promptly",
+		"帰りたい人: ":              "Want to leave: ",
+		"まだいません":               "nobody yet",
+		"、":                    ", ",
+		" ほか":                  " and",
+		"匿名 %d 人":              "%d anonymous",
+		"参加者 %d 人 / 帰りたい %d 人": "%d participants / %d want to leave",
+		"リセット":                 "Reset",
+		"名前表示をオンにする":           "Show names",
+		"名前表示をオフにする":           "Hide names",
+		"投票を終了":                "End poll",
+		"「%s」を聞く":              "Ask \"%s\"",
+		"あと%d分":                "%d more minutes",
+		"あと":                   "Ending in",
+		"で終了します":               "",
+		"蛍の光ボタン":               "Hotaru End Button",
+		"長引く会議を「空気」で終わらせよう": "End long meetings by reading the room",
+		"Zoom連携待機中...":      "Connecting to Zoom...",
+		"帰る":                "Leave",
+		"名前表示がオンのとき、自分の名前を公開する": "Show my name when names are on",
+		"休憩する？":     "Take a break?",
+		"休憩したい":     "I need a break",
+		"休憩しましょう！":  "Let's take a break!",
+		"延長する？":     "Run over?",
+		"延長したい":     "Keep going",
+		"延長が決まりました": "The meeting runs over",
+		"会議時間":      "Meeting length",
+		"%d分":       "%d min",
+		"%s分":       "%s min",
+		"最初の投票":     "First vote",
+		"開始から %s分":  "%s min in",
+		"成立まで":      "Until majority",
+		"投票率":       "Turnout",
+		"予定 %d分":    "scheduled %d min",
+		"経過 %d分":    "%d min in",
+		"（%d分超過）":   " (%d min over)",
+		"（残り %d分）":  " (%d min left)",
+		"ホストの次の予定が始まっています":        "The host's next meeting has started",
+		"%d分後にホストの次の予定が始まります":     "The host's next meeting starts in %d min",
+		"次の予定まで %d分":              "next meeting in %d min",
+		"次の予定まで %d分（休憩なし）":        "next meeting in %d min, back to back",
+		"次の予定が始まっています":            "next meeting has started",
+		"成立した会議":                  "Meetings ended by vote",
+		"平均の会議時間":                 "Average length",
+		"予定を超過した会議":               "Meetings over schedule",
+		"平均 %d分":                  "%d min on average",
+		"%s に終了が決まっていました":         "The meeting was voted to end at %s",
+		"平均の投票率":                  "Average turnout",
+		"サーバーに接続できません。再試行しています":   "Cannot reach the server. Retrying",
+		"この操作はホストだけが行えます":         "Only the host can do this",
+		"この機能はこの会議では使えません":        "This feature is not available in this meeting",
+		"投票が多すぎます。少し待ってから押してください": "Too many votes. Wait a moment and try again",
+		"投票できません: 会議は既に終了扱いです":    "Cannot vote: the meeting has already been voted to end",
+		"延長できるのは終了が決まったあとだけです":    "The ending can only be postponed once it has been decided",
+		"投票できません: この投票は締め切られました":  "Cannot vote: this poll has closed",
+		"この投票は使えません":              "This poll is not available",
+		"ご契約のプランの利用上限に達しています":     "Your organization has reached its plan's limit",
+		"問い合わせ番号":                 "Reference",
+		"操作がないため、まもなく参加者から外れます。続けるには画面に触れてください": "No one has touched this panel for a while. Touch it to stay a participant",
+		"操作がなかったため、参加者から外れました":                  "You were taken off the participants after a while without input",
+		"もう一度参加する":            "Join again",
+		"ホストによってこの会議から外されました": "The host removed you from this meeting",
+		"外す参加者の名前を入力してください":   "Enter the name of the participant to remove",
+		"その名前を共有した参加者はいません":   "No participant shared that name",
+	},
+}
+
+// translate returns msg in lang, or msg itself when there is no translation.
+func translate(lang, msg string) string {
+	if t, ok := messages[lang][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// matchLocale maps a language tag such as en-US to a supported locale.
+func matchLocale(tag string) (string, bool) {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	primary, _, _ = strings.Cut(primary, "_")
+	if primary == defaultLocale {
+		return primary, true
+	}
+	_, ok := messages[primary]
+	return primary, ok
+}
+
+// negotiateLocale picks the panel language for a request: the lang query
+// param zoom-init.js sends from the Zoom client's language wins, then
+// Accept-Language by preference.
+func negotiateLocale(r *http.Request) string {
+	if lang, ok := matchLocale(r.URL.Query().Get("lang")); ok {
+		return lang
+	}
+
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for part := range strings.SplitSeq(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{tag, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if lang, ok := matchLocale(p.tag); ok {
+			return lang
+		}
+	}
+	return defaultLocale
+}
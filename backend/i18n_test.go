@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+	for _, c := range []struct {
+		query, acceptLanguage, want string
+	}{
+		{"", "", "ja"},
+		{"", "en-US,en;q=0.9", "en"},
+		{"", "fr-FR, en;q=0.8, ja;q=0.9", "ja"},
+		{"", "fr, de;q=0.5", "ja"},
+		{"", "ja;q=0, en;q=0.1", "en"},
+		{"en_GB", "ja", "en"},
+		{"ko", "en", "en"}, // unsupported hint falls back to the header
+	} {
+		r := httptest.NewRequest("GET", "/?lang="+c.query, nil)
+		r.Header.Set("Accept-Language", c.acceptLanguage)
+		if got := negotiateLocale(r); got != c.want {
+			t.Errorf("lang=%q Accept-Language=%q: got %q, want %q", c.query, c.acceptLanguage, got, c.want)
+		}
+	}
+
+	if got := translate("en", "本日の営業は終了しました"); got != "That's all for today" {
+		t.Errorf("translate = %q", got)
+	}
+	if got := translate("en", "定例MTG"); got != "定例MTG" {
+		t.Errorf("untranslated text changed to %q", got)
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// icsCalendar is an iCalendar feed, for organizations that cannot grant
+// calendar API access: a published calendar or a secret address. A
+// background job fetches it every icsRefresh; lookups read the last copy
+// and never wait on the feed.
+type icsCalendar struct {
+	url string
+
+	events atomic.Pointer[[]icsEvent]
+
+	mu   sync.Mutex // one fetch at a time
+	etag string
+}
+
+var (
+	icsRefresh = 15 * time.Minute
+
+	icsFeeds      sync.Map // URL -> *icsCalendar
+	icsPollerOnce sync.Once
+)
+
+// icsMaxBytes bounds a feed; a year of a busy calendar is a few MB.
+const icsMaxBytes = 16 << 20
+
+// icsMaxOccurrences bounds the expansion of one recurring event.
+const icsMaxOccurrences = 20000
+
+// icsFeed returns the calendar of url, shared by all tenants using it, and
+// makes sure the poller runs.
+func icsFeed(url string) *icsCalendar {
+	if strings.HasPrefix(url, "webcal://") {
+		url = "https://" + strings.TrimPrefix(url, "webcal://")
+	}
+	val, _ := icsFeeds.LoadOrStore(url, &icsCalendar{url: url})
+	icsPollerOnce.Do(func() { goSafe("ics-poller", pollICSFeeds) })
+	return val.(*icsCalendar)
+}
+
+// pollICSFeeds refreshes every feed now and then every icsRefresh.
+func pollICSFeeds() {
+	for {
+		icsFeeds.Range(func(_, val any) bool {
+			c := val.(*icsCalendar)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := c.refresh(ctx); err != nil {
+				log.Printf("ICS feed %s: %v", redactURL(c.url, false), err)
+			}
+			return true
+		})
+		time.Sleep(icsRefresh)
+	}
+}
+
+// refresh fetches the feed unless it is unchanged since the last fetch.
+func (c *icsCalendar) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	resp, err := calendarHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("GET: %s", resp.Status)
+	}
+	events, err := parseICS(io.LimitReader(resp.Body, icsMaxBytes))
+	if err != nil {
+		return err
+	}
+	c.events.Store(&events)
+	c.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// Events expands the feed's events overlapping from..to. A feed is often
+// a whole team's calendar, so events naming an organizer or attendees
+// count only when host is one of them.
+func (c *icsCalendar) Events(_ context.Context, host string, from, to time.Time) ([]calendarEvent, error) {
+	p := c.events.Load()
+	if p == nil {
+		return nil, fmt.Errorf("ICS feed not fetched yet")
+	}
+	host = strings.ToLower(host)
+	var out []calendarEvent
+	for _, e := range *p {
+		if len(e.people) > 0 && !slices.Contains(e.people, host) {
+			continue
+		}
+		out = append(out, e.occurrences(from, to)...)
+	}
+	return out, nil
+}
+
+// icsEvent is a VEVENT: one timed event, a recurring one, or a changed
+// occurrence of one (RECURRENCE-ID).
+type icsEvent struct {
+	calendarEvent // the first occurrence
+
+	rule    *icsRule
+	exclude map[int64]bool // EXDATE and moved occurrences, by start
+	people  []string       // organizer and attendee addresses, lower case
+}
+
+// icsRule is the part of RRULE that calendars write for meetings: daily,
+// weekly on given days, or monthly on the same day.
+type icsRule struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+// occurrences returns those starting before to and ending after from.
+func (e *icsEvent) occurrences(from, to time.Time) []calendarEvent {
+	var out []calendarEvent
+	length := e.End.Sub(e.Start)
+	add := func(start time.Time) {
+		if !e.exclude[start.Unix()] && start.Before(to) && start.Add(length).After(from) {
+			out = append(out, calendarEvent{Start: start, End: start.Add(length), Text: e.Text})
+		}
+	}
+	r := e.rule
+	if r == nil {
+		add(e.Start)
+		return out
+	}
+	n := 0
+	// next reports whether to go on after the occurrence at start
+	next := func(start time.Time) bool {
+		if start.Before(e.Start) {
+			return true
+		}
+		if !r.until.IsZero() && start.After(r.until) || !start.Before(to) || r.count > 0 && n >= r.count {
+			return false
+		}
+		n++
+		add(start)
+		return true
+	}
+	for i := range icsMaxOccurrences {
+		switch r.freq {
+		case "DAILY":
+			if !next(e.Start.AddDate(0, 0, i*r.interval)) {
+				return out
+			}
+		case "WEEKLY":
+			// Weeks start on Monday
+			week := e.Start.AddDate(0, 0, -((int(e.Start.Weekday())+6)%7)+7*i*r.interval)
+			for _, wd := range r.byDay {
+				if !next(week.AddDate(0, 0, (int(wd)+6)%7)) {
+					return out
+				}
+			}
+		case "MONTHLY":
+			start := e.Start.AddDate(0, i*r.interval, 0)
+			if start.Day() != e.Start.Day() {
+				continue // no such day this month
+			}
+			if !next(start) {
+				return out
+			}
+		}
+	}
+	return out
+}
+
+// parseICS reads the VEVENTs of a feed. VTIMEZONE blocks are not read:
+// TZID must be an IANA name, as Google and most exports write, or one of
+// windowsZones.
+func parseICS(r io.Reader) ([]icsEvent, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+	type vevent struct {
+		uid          string
+		recurrenceID time.Time
+		cancelled    bool
+		event        icsEvent
+		allDay       bool
+		duration     time.Duration
+		bad          bool
+	}
+	var all []*vevent
+	var cur *vevent
+	nested := 0 // depth of VALARMs and the like inside the VEVENT
+	for _, line := range lines {
+		name, params, value := splitICSLine(line)
+		if cur == nil {
+			if name == "BEGIN" && value == "VEVENT" {
+				cur = &vevent{event: icsEvent{exclude: map[int64]bool{}}}
+			}
+			continue
+		}
+		if name == "BEGIN" {
+			nested++
+		}
+		if nested > 0 {
+			if name == "END" {
+				nested--
+			}
+			continue
+		}
+		var err error
+		switch name {
+		case "END":
+			if value == "VEVENT" {
+				all = append(all, cur)
+				cur = nil
+			}
+		case "UID":
+			cur.uid = value
+		case "STATUS":
+			cur.cancelled = strings.EqualFold(value, "CANCELLED")
+		case "DTSTART":
+			cur.event.Start, cur.allDay, err = parseICSTime(params, value)
+		case "DTEND":
+			cur.event.End, _, err = parseICSTime(params, value)
+		case "DURATION":
+			cur.duration, err = parseICSDuration(value)
+		case "RECURRENCE-ID":
+			cur.recurrenceID, _, err = parseICSTime(params, value)
+		case "EXDATE":
+			for v := range strings.SplitSeq(value, ",") {
+				t, _, err := parseICSTime(params, v)
+				if err == nil {
+					cur.event.exclude[t.Unix()] = true
+				}
+			}
+		case "RRULE":
+			cur.event.rule, err = parseICSRule(value)
+		case "LOCATION", "DESCRIPTION", "URL", "X-GOOGLE-CONFERENCE":
+			cur.event.Text += unescapeICS(value) + "\n"
+		case "ORGANIZER", "ATTENDEE":
+			if addr, ok := strings.CutPrefix(strings.ToLower(value), "mailto:"); ok {
+				cur.event.people = append(cur.event.people, addr)
+			}
+		}
+		if err != nil {
+			cur.bad = true // skip what we cannot read rather than the feed
+		}
+	}
+
+	// Moved occurrences replace the one of the series they came from
+	for _, v := range all {
+		if v.recurrenceID.IsZero() {
+			continue
+		}
+		for _, s := range all {
+			if s.uid == v.uid && s.recurrenceID.IsZero() {
+				s.event.exclude[v.recurrenceID.Unix()] = true
+			}
+		}
+	}
+	var events []icsEvent
+	for _, v := range all {
+		e := v.event
+		if e.End.IsZero() {
+			e.End = e.Start.Add(v.duration)
+		}
+		if v.bad || v.cancelled || v.allDay || e.Start.IsZero() || !e.End.After(e.Start) {
+			continue
+		}
+		if !v.recurrenceID.IsZero() {
+			e.rule = nil
+		}
+		if r := e.rule; r != nil && r.freq == "WEEKLY" {
+			if r.byDay == nil {
+				r.byDay = []time.Weekday{e.Start.Weekday()}
+			}
+			// In week order, from Monday
+			slices.SortFunc(r.byDay, func(a, b time.Weekday) int { return (int(a)+6)%7 - (int(b)+6)%7 })
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// unfoldICS splits a feed into logical lines: a line starting with a space
+// or tab continues the previous one.
+func unfoldICS(r io.Reader) ([]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var lines []string
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// splitICSLine splits NAME;PARAM=V;PARAM="a:b":value.
+func splitICSLine(line string) (name string, params map[string]string, value string) {
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, ""
+	}
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+	params = map[string]string{}
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// unescapeICS undoes the escaping of TEXT values.
+func unescapeICS(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// windowsZones are the Windows time zone names Outlook writes as TZID.
+var windowsZones = map[string]string{
+	"Tokyo Standard Time":     "Asia/Tokyo",
+	"GMT Standard Time":       "Europe/London",
+	"Pacific Standard Time":   "America/Los_Angeles",
+	"Eastern Standard Time":   "America/New_York",
+	"China Standard Time":     "Asia/Shanghai",
+	"Korea Standard Time":     "Asia/Seoul",
+	"Singapore Standard Time": "Asia/Singapore",
+}
+
+// parseICSTime parses a DATE-TIME, in UTC, TZID or the server's zone, or
+// a DATE, which marks an all-day event.
+func parseICSTime(params map[string]string, value string) (t time.Time, allDay bool, err error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err = time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+	if v, ok := strings.CutSuffix(value, "Z"); ok {
+		t, err = time.ParseInLocation("20060102T150405", v, time.UTC)
+		return t, false, err
+	}
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		if name, ok := windowsZones[tzid]; ok {
+			tzid = name
+		}
+		if l, err := loadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// parseICSDuration parses the durations events use, e.g. PT1H30M or P1D.
+func parseICSDuration(s string) (time.Duration, error) {
+	rest, ok := strings.CutPrefix(s, "P")
+	if !ok {
+		return 0, fmt.Errorf("duration %q", s)
+	}
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, r := range rest {
+		switch {
+		case r == 'T':
+			inTime = true
+		case r >= '0' && r <= '9':
+			num += string(r)
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("duration %q", s)
+			}
+			num = ""
+			switch {
+			case r == 'W':
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case r == 'D':
+				d += time.Duration(n) * 24 * time.Hour
+			case r == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case r == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case r == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, fmt.Errorf("duration %q", s)
+			}
+		}
+	}
+	return d, nil
+}
+
+var icsWeekdays = map[string]time.Weekday{"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday}
+
+// parseICSRule parses the RRULEs icsRule covers and rejects the rest.
+func parseICSRule(s string) (*icsRule, error) {
+	r := &icsRule{interval: 1}
+	for part := range strings.SplitSeq(s, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(v)
+		case "COUNT":
+			r.count, err = strconv.Atoi(v)
+		case "UNTIL":
+			r.until, _, err = parseICSTime(nil, v)
+		case "BYDAY":
+			for d := range strings.SplitSeq(v, ",") {
+				wd, ok := icsWeekdays[strings.ToUpper(d)]
+				if !ok {
+					return nil, fmt.Errorf("rule %q: BYDAY %s", s, d) // e.g. 2TU
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("rule %q: %s not supported", s, k)
+		}
+		if err != nil || r.interval < 1 {
+			return nil, fmt.Errorf("rule %q", s)
+		}
+	}
+	switch r.freq {
+	case "DAILY", "MONTHLY":
+		if r.byDay != nil {
+			return nil, fmt.Errorf("rule %q: BYDAY only with WEEKLY", s)
+		}
+	case "WEEKLY":
+	default:
+		return nil, fmt.Errorf("rule %q: FREQ %s not supported", s, r.freq)
+	}
+	return r, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testICS is a team calendar: the host's weekly meeting on Mondays and
+// Thursdays with one Monday off and one Thursday moved, the host's 1on1 in
+// a Windows time zone, someone else's meeting, and events to ignore.
+const testICS = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Example//Calendar//EN
+BEGIN:VTIMEZONE
+TZID:Asia/Tokyo
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:weekly@example.com
+DTSTART;TZID=Asia/Tokyo:20261001T100000
+DTEND;TZID=Asia/Tokyo:20261001T110000
+RRULE:FREQ=WEEKLY;BYDAY=TH,MO
+EXDATE;TZID=Asia/Tokyo:20261019T100000
+SUMMARY:週次定例
+DESCRIPTION:Zoom ミーティングに参加する\nhttps://example.zoom.us/j/8123
+ 4567890?pwd=abc\n\nミーティング ID: 812 3456 7890
+ORGANIZER;CN="Host: Team Lead":mailto:Host@Example.com
+ATTENDEE;CN=Guest:mailto:guest@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly@example.com
+RECURRENCE-ID;TZID=Asia/Tokyo:20261022T100000
+DTSTART;TZID=Asia/Tokyo:20261022T150000
+DTEND;TZID=Asia/Tokyo:20261022T160000
+LOCATION:https://example.zoom.us/j/81234567890
+ORGANIZER:mailto:host@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:1on1@example.com
+DTSTART;TZID=Tokyo Standard Time:20261015T110000
+DURATION:PT30M
+SUMMARY:1on1
+ORGANIZER:mailto:host@example.com
+BEGIN:VALARM
+ACTION:DISPLAY
+DESCRIPTION:Reminder
+TRIGGER:-PT10M
+DURATION:PT5M
+REPEAT:1
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:other@example.com
+DTSTART:20261015T013000Z
+DTEND:20261015T020000Z
+ORGANIZER:mailto:someone@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:cancelled@example.com
+DTSTART:20261015T012000Z
+DTEND:20261015T014000Z
+STATUS:CANCELLED
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday@example.com
+DTSTART;VALUE=DATE:20261015
+DTEND;VALUE=DATE:20261016
+END:VEVENT
+BEGIN:VEVENT
+UID:yearly@example.com
+DTSTART:20261015T003000Z
+DTEND:20261015T004500Z
+RRULE:FREQ=YEARLY
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestICSFeed(t *testing.T) {
+	bodies := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		bodies++
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte(strings.ReplaceAll(testICS, "\n", "\r\n")))
+	}))
+	defer srv.Close()
+
+	cal := &icsCalendar{url: srv.URL}
+	if _, err := cal.Events(context.Background(), "host@example.com", time.Now(), time.Now()); err == nil {
+		t.Error("events before the first fetch")
+	}
+	for range 2 {
+		if err := cal.refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bodies != 1 {
+		t.Errorf("feed sent %d times, want once", bodies)
+	}
+
+	at := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, c := range []struct {
+		now        string
+		start      string // of the meeting, empty if not on the calendar
+		next       string
+		backToBack bool
+	}{
+		// Thursday 10:10 in Tokyo; the 1on1 follows, someone else's 10:30
+		// meeting does not count
+		{"2026-10-15T01:10:00Z", "2026-10-15T01:00:00Z", "2026-10-15T02:00:00Z", true},
+		{"2026-10-12T01:10:00Z", "2026-10-12T01:00:00Z", "", false},
+		{"2026-10-19T01:10:00Z", "", "", false}, // the Monday off
+		{"2026-10-22T06:10:00Z", "2026-10-22T06:00:00Z", "", false},
+	} {
+		info := &MeetingInfo{Number: "81234567890", HostEmail: "host@example.com"}
+		if err := applyCalendar(context.Background(), cal, info, at(c.now)); err != nil {
+			t.Fatal(err)
+		}
+		if c.start == "" {
+			if !info.StartTime.IsZero() {
+				t.Errorf("%s: found a meeting at %v", c.now, info.StartTime)
+			}
+			continue
+		}
+		if !info.StartTime.Equal(at(c.start)) || info.Duration != time.Hour {
+			t.Errorf("%s: meeting %v for %v, want %s", c.now, info.StartTime, info.Duration, c.start)
+		}
+		if c.next != "" && !info.NextMeeting.Equal(at(c.next)) || c.next == "" && !info.NextMeeting.IsZero() || info.BackToBack != c.backToBack {
+			t.Errorf("%s: next %v back to back %t, want %s", c.now, info.NextMeeting, info.BackToBack, c.next)
+		}
+	}
+
+	// What the host sees that Thursday: the meeting and the 1on1 with its
+	// own length, not the alarm's
+	events, _ := cal.Events(context.Background(), "HOST@example.com", at("2026-10-15T00:00:00Z"), at("2026-10-15T03:00:00Z"))
+	if len(events) != 2 || events[1].End.Sub(events[1].Start) != 30*time.Minute {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestICSRules(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	start := time.Date(2026, 1, 31, 9, 0, 0, 0, tokyo)
+	count := func(rule string, from, to time.Time) int {
+		t.Helper()
+		r, err := parseICSRule(rule)
+		if err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		e := icsEvent{calendarEvent: calendarEvent{Start: start, End: start.Add(time.Hour)}, rule: r}
+		return len(e.occurrences(from, to))
+	}
+	year := start.AddDate(1, 0, 0)
+	for rule, want := range map[string]int{
+		"FREQ=DAILY;COUNT=10":                          10,
+		"FREQ=DAILY;INTERVAL=7;UNTIL=20260307T000000Z": 6, // UNTIL included
+		"FREQ=MONTHLY":                                 7, // only months with a 31st
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=5":   5,
+	} {
+		if got := count(rule, start, year); got != want {
+			t.Errorf("%s: %d occurrences, want %d", rule, got, want)
+		}
+	}
+	// Only those in the window, counted from the start of the series
+	if got := count("FREQ=DAILY;COUNT=10", start.AddDate(0, 0, 8), year); got != 2 {
+		t.Errorf("window: %d occurrences, want 2", got)
+	}
+	for _, bad := range []string{"FREQ=YEARLY", "FREQ=MONTHLY;BYDAY=2TU", "FREQ=WEEKLY;BYSETPOS=1", "FREQ=DAILY;INTERVAL=0"} {
+		if _, err := parseICSRule(bad); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+	for s, want := range map[string]time.Duration{"PT1H30M": 90 * time.Minute, "P1D": 24 * time.Hour, "PT45S": 45 * time.Second, "P1W": 7 * 24 * time.Hour} {
+		if got, err := parseICSDuration(s); err != nil || got != want {
+			t.Errorf("%s = %v, %v", s, got, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// idleHeader carries how many seconds ago the panel's user last touched
+// it. Polling keeps a forgotten panel counted as a participant, so only
+// input says whether someone is still there.
+const idleHeader = "X-Idle-Seconds"
+
+// idleTimeout is how long a panel may go without input before it is
+// warned; idleGrace later it is dropped from the room. 0 disables both.
+var idleTimeout = 30 * time.Minute
+
+const idleGrace = time.Minute
+
+// htmxStopPolling is the status that makes htmx stop polling.
+const htmxStopPolling = 286
+
+var frameIdle = ErrorFrame{"idle", "操作がないため、まもなく参加者から外れます。続けるには画面に触れてください", true}
+
+func idleFor(r *http.Request) time.Duration {
+	secs, err := strconv.Atoi(r.Header.Get(idleHeader))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// idleWarning returns the frame warning an idle panel before it is
+// dropped, or nil.
+func idleWarning(zCtx *ZoomAuthContext) *ErrorFrame {
+	if idleTimeout <= 0 || zCtx.Idle < idleTimeout {
+		return nil
+	}
+	return &frameIdle
+}
+
+// closeIdlePanel drops a panel idle past its warning from the room's
+// participants and tells it to stop polling, reporting whether it did.
+// The panel joins again when reloaded.
+func closeIdlePanel(w http.ResponseWriter, ctx context.Context, zCtx *ZoomAuthContext) bool {
+	if idleTimeout <= 0 || zCtx.Idle < idleTimeout+idleGrace {
+		return false
+	}
+	if err := RemoveParticipant(ctx, zCtx.RoomID(), zCtx.UID); err != nil {
+		logf(ctx, "RemoveParticipant error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return true
+	}
+	logf(ctx, "Idle panel dropped after %s", zCtx.Idle)
+	exportRoomEvent(zCtx, exportLeave, pseudonymize(zCtx.RoomID(), zCtx.UID), "", 0, 0)
+	departed(ctx, zCtx)
+	metrics.Add("idle_panels_closed", 1)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(htmxStopPolling)
+	w.Write([]byte(renderFragment(zCtx.Locale, "idle_closed", nil)))
+	return true
+}
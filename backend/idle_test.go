@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdlePanels(t *testing.T) {
+	ts := newTestServer(t)
+	idleTimeout = 10 * time.Minute
+	t.Cleanup(func() { idleTimeout = 30 * time.Minute })
+
+	poll := func(pid string, idle time.Duration) (int, string) {
+		q := url.Values{"roomId": {"idle-room"}, "pid": {pid}}
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/state?"+q.Encode(), nil)
+		req.Header.Set(idleHeader, strconv.Itoa(int(idle/time.Second)))
+		req.Header.Set("HX-Request", "true")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	poll("here", 0)
+	if code, body := poll("away", 5*time.Minute); code != http.StatusOK || strings.Contains(body, "error-frame") {
+		t.Errorf("active panel: %d\n%s", code, body)
+	}
+	if code, body := poll("away", 10*time.Minute); code != http.StatusOK || !strings.Contains(body, `data-code="idle"`) {
+		t.Errorf("idle panel not warned: %d\n%s", code, body)
+	}
+	if n, _, _, _ := CheckTriggerStatus(t.Context(), "idle-room"); n != 2 {
+		t.Fatalf("participants before closing = %d", n)
+	}
+
+	code, body := poll("away", 11*time.Minute)
+	if code != htmxStopPolling || !strings.Contains(body, "idle-closed") {
+		t.Errorf("idle panel not closed: %d\n%s", code, body)
+	}
+	if n, _, _, _ := CheckTriggerStatus(t.Context(), "idle-room"); n != 1 {
+		t.Errorf("participants after closing = %d", n)
+	}
+
+	// The one remaining participant decides alone
+	assertGauge(t, "here", ts.queryClient("idle-room", "here").vote(), "100.0%", true)
+
+	// Disabled, nobody is dropped
+	idleTimeout = 0
+	if code, _ := poll("away", time.Hour); code != http.StatusOK {
+		t.Errorf("with the policy off: %d", code)
+	}
+}
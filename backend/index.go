@@ -0,0 +1,84 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// indexPage renders frontend/index.html as an html/template so the panel
+// shows the room's current state on first paint instead of a placeholder
+// until the first poll. The parsed template is cached until the file changes.
+type indexPage struct {
+	assets *assetServer
+
+	mu   sync.Mutex
+	hash string
+	tmpl *template.Template
+}
+
+// indexData is what index.html can reference.
+type indexData struct {
+	Lang        string
+	ZoomContext string        // echoed back for zoom-init.js
+	State       template.HTML // empty when the caller is not authenticated
+}
+
+func (p *indexPage) template() (*template.Template, error) {
+	a, err := p.assets.load("/index.html")
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tmpl != nil && p.hash == a.hash {
+		return p.tmpl, nil
+	}
+	t, err := template.New("index.html").Funcs(templateFuncs).Parse(p.assets.fingerprintRefs(string(a.raw)))
+	if err != nil {
+		return nil, err
+	}
+	p.hash, p.tmpl = a.hash, t
+	return t, nil
+}
+
+func (p *indexPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	t, err := p.template()
+	if err != nil {
+		logf(ctx, "index.html: %v", err)
+		httpError(w, ctx, "Failed to load index.html", http.StatusInternalServerError)
+		return
+	}
+
+	data := indexData{Lang: negotiateLocale(r), ZoomContext: r.Header.Get("x-zoom-app-context")}
+	// Execute a clone so the cached template can be cloned again
+	page, err := t.Clone()
+	if err != nil {
+		httpError(w, ctx, "Failed to render index.html", http.StatusInternalServerError)
+		return
+	}
+	page.Funcs(template.FuncMap{"t": func(msg string) string { return translate(data.Lang, msg) }})
+
+	if zCtx := authenticate(r); zCtx != nil {
+		setAccessUID(ctx, zCtx.UID)
+		if state, err := renderState(ctx, zCtx); err != nil {
+			// The first poll will fill the panel in
+			logf(ctx, "Initial state failed: %v", err)
+		} else {
+			data.State = template.HTML(state)
+		}
+	}
+
+	var b strings.Builder
+	if err := page.Execute(&b, data); err != nil {
+		logf(ctx, "index.html: %v", err)
+		httpError(w, ctx, "Failed to render index.html", http.StatusInternalServerError)
+		return
+	}
+	// The page embeds per-request context, so it must never be cached
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ReportRollup aggregates a tenant's meeting reports over a period.
+type ReportRollup struct {
+	Date string `json:"date"` // first day, YYYY-MM-DD
+
+	// Held counts meetings whose panel was opened, Meetings those that
+	// triggered and so have a report
+	Held     int `json:"held"`
+	Meetings int `json:"meetings"`
+	Overruns int `json:"overruns"`
+
+	// LengthBuckets counts triggered meetings by length, split at
+	// lengthBucketMinutes
+	LengthBuckets []int `json:"length_buckets"`
+
+	AvgLengthMinutes         float64 `json:"avg_length_minutes"`
+	AvgOverrunMinutes        float64 `json:"avg_overrun_minutes"`
+	AvgTimeToMajorityMinutes float64 `json:"avg_time_to_majority_minutes"`
+	AvgParticipation         float64 `json:"avg_participation"`
+}
+
+// rollupRetention is how long daily rollups are kept, much longer than the
+// reports they are made of.
+const rollupRetention = 2 * 365 * 24 * time.Hour
+
+// rollupDays is how many past days each rollup run recomputes, so days
+// missed while the scheduler was down are filled in.
+const rollupDays = 7
+
+// lengthBucketMinutes are the upper bounds of the length buckets; the last
+// bucket holds longer meetings.
+var lengthBucketMinutes = []int{15, 30, 45, 60, 90, 120}
+
+func lengthBucket(d time.Duration) int {
+	for i, m := range lengthBucketMinutes {
+		if d < time.Duration(m)*time.Minute {
+			return i
+		}
+	}
+	return len(lengthBucketMinutes)
+}
+
+func rollupReports(date string, reports []*MeetingReport) ReportRollup {
+	ru := ReportRollup{Date: date, Meetings: len(reports), LengthBuckets: make([]int, len(lengthBucketMinutes)+1)}
+	if len(reports) == 0 {
+		return ru
+	}
+	majorities := 0
+	for _, r := range reports {
+		ru.LengthBuckets[lengthBucket(r.Length())]++
+		ru.AvgLengthMinutes += r.Length().Minutes()
+		ru.AvgOverrunMinutes += r.Overrun().Minutes()
+		ru.AvgParticipation += r.ParticipationRate()
+		if r.Overrun() > 0 {
+			ru.Overruns++
+		}
+		if !r.FirstVoteAt.IsZero() {
+			ru.AvgTimeToMajorityMinutes += r.TimeToMajority().Minutes()
+			majorities++
+		}
+	}
+	n := float64(len(reports))
+	ru.AvgLengthMinutes /= n
+	ru.AvgOverrunMinutes /= n
+	ru.AvgParticipation /= n
+	if majorities > 0 {
+		ru.AvgTimeToMajorityMinutes /= float64(majorities)
+	}
+	return ru
+}
+
+// scheduledTenants are the tenants jobs run for; "" is the single tenant of
+// a deployment without TENANTS_FILE.
+func scheduledTenants() []string {
+	p := tenants.Load()
+	if p == nil {
+		return []string{""}
+	}
+	ids := make([]string, len(*p))
+	for i, t := range *p {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+// startOfDay is midnight of t's day in the server's time zone.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// countHeldMeeting counts a meeting held on date for the tenant's rollups.
+func countHeldMeeting(ctx context.Context, tenant, date string) {
+	if err := IncrHeldMeetings(ctx, tenant, date); err != nil {
+		logf(ctx, "IncrHeldMeetings error: %v", err)
+	}
+}
+
+// runRollup stores each tenant's daily rollups of the last rollupDays days.
+// Days without meetings are skipped.
+func runRollup(ctx context.Context, _ *ScheduledJob) error {
+	today := startOfDay(clock.Now())
+	var errs []error
+	for _, tenant := range scheduledTenants() {
+		for i := rollupDays; i >= 1; i-- {
+			from := today.AddDate(0, 0, -i)
+			date := from.Format("2006-01-02")
+			reports, err := ReportsBetween(ctx, tenant, from, from.AddDate(0, 0, 1))
+			held := 0
+			if err == nil {
+				held, err = HeldMeetings(ctx, tenant, date)
+			}
+			if err == nil && (held > 0 || len(reports) > 0) {
+				ru := rollupReports(date, reports)
+				ru.Held = max(held, ru.Meetings)
+				err = SaveRollup(ctx, tenant, ru)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tenant %q: %w", tenant, err))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runRetentionPurge drops logged reports older than the job's retention,
+// and rollups and benchmark days older than rollupRetention.
+func runRetentionPurge(ctx context.Context, j *ScheduledJob) error {
+	now := clock.Now()
+	var errs []error
+	if _, err := PurgeBenchmarkDays(ctx, startOfDay(now.Add(-rollupRetention)).Format("2006-01-02")); err != nil {
+		errs = append(errs, fmt.Errorf("benchmark: %w", err))
+	}
+	for _, tenant := range scheduledTenants() {
+		reports, err := PurgeReports(ctx, tenant, now.Add(-j.retention))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q reports: %w", tenant, err))
+			continue
+		}
+		rollups, err := PurgeRollups(ctx, tenant, startOfDay(now.Add(-rollupRetention)).Format("2006-01-02"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q rollups: %w", tenant, err))
+			continue
+		}
+		if reports > 0 || rollups > 0 {
+			log.Printf("Purged %d report(s) and %d rollup(s) of tenant %q", reports, rollups, tenant)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// digestText is the plain-text weekly digest used by email and chat.
+func digestText(lang string, from, to time.Time, ru ReportRollup) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s\n", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	fmt.Fprintf(&b, "%s: %d\n", translate(lang, "成立した会議"), ru.Meetings)
+	fmt.Fprintf(&b, "%s: %s\n", translate(lang, "平均の会議時間"), fmt.Sprintf(translate(lang, "%d分"), int(ru.AvgLengthMinutes+0.5)))
+	fmt.Fprintf(&b, "%s: %d (%s)\n", translate(lang, "予定を超過した会議"), ru.Overruns, fmt.Sprintf(translate(lang, "平均 %d分"), int(ru.AvgOverrunMinutes+0.5)))
+	fmt.Fprintf(&b, "%s: %.0f%%\n", translate(lang, "平均の投票率"), ru.AvgParticipation*100)
+	return b.String()
+}
+
+// runWeeklyDigest sends each tenant a summary of the last seven days to its
+// digest addresses and chat webhook. Tenants without meetings get nothing.
+func runWeeklyDigest(ctx context.Context, _ *ScheduledJob) error {
+	to := startOfDay(clock.Now())
+	from := to.AddDate(0, 0, -7)
+	var errs []error
+	for _, tenant := range scheduledTenants() {
+		d := reportDeliveryFor(tenant)
+		if d.Disabled || (d.ChatWebhookURL == "" && (d.SMTPURL == "" || len(d.DigestTo) == 0)) {
+			continue
+		}
+		reports, err := ReportsBetween(ctx, tenant, from, to)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", tenant, err))
+			continue
+		}
+		if len(reports) == 0 {
+			continue
+		}
+		text := digestText(defaultLocale, from, to, rollupReports(from.Format("2006-01-02"), reports))
+		if d.SMTPURL != "" {
+			for _, addr := range d.DigestTo {
+				if err := sendReportEmail(ctx, d, "今週のまとめ（蛍の光ボタン）", addr, text, ""); err != nil {
+					errs = append(errs, fmt.Errorf("tenant %q digest to %s: %w", tenant, addr, err))
+				}
+			}
+		}
+		if d.ChatWebhookURL != "" {
+			if err := postJSON(ctx, d.ChatWebhookURL, map[string]string{"text": "蛍の光ボタン 今週のまとめ\n" + text}, nil, ""); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %q digest chat post: %w", tenant, err))
+			}
+		}
+		metrics.Add("digests_sent", 1)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Documents: a localStore keeps its state as documents, Go values found by
+// kind and ID, each with an optional expiry. memKV holds the values in
+// this instance's memory. tableKV holds their JSON in a table, a SQLite or
+// bbolt file or a DynamoDB table, so they outlive the process; every write
+// is conditional on the version that was read, so instances sharing a
+// DynamoDB table cannot overwrite each other's changes.
+
+// kvEntry is a document handed to the fn of kv.update.
+type kvEntry struct {
+	val     any       // the document, a new one when it was not found
+	found   bool      // whether it was stored and not expired
+	expires time.Time // when it expires, zero for never
+	drop    bool      // set by fn to delete the document
+}
+
+// kv is where a localStore keeps its documents.
+type kv interface {
+	// update calls fn with the document, or one from newDoc when it is
+	// missing or expired, and keeps what fn leaves. Nothing is written
+	// when fn fails. A kv shared by instances calls fn again when another
+	// instance wrote the document in between, so fn must set everything
+	// it reports on each call, and must not update other documents.
+	update(ctx context.Context, kind, id string, newDoc func() any, fn func(e *kvEntry) error) error
+	// view calls fn with the document, or nil when it is missing or
+	// expired. fn must not change it.
+	view(ctx context.Context, kind, id string, newDoc func() any, fn func(val any)) error
+	// scan calls fn with each live document of kind whose ID starts with
+	// prefix, in no particular order, until fn returns false. With a nil
+	// newDoc, fn is only given the IDs. fn must not change documents.
+	scan(ctx context.Context, kind, prefix string, newDoc func() any, fn func(id string, val any) bool) error
+	// sweep deletes the expired documents, which are otherwise only
+	// dropped when written again.
+	sweep(ctx context.Context) error
+	close() error
+}
+
+// updateDoc is kv.update with documents of type T.
+func updateDoc[T any](ctx context.Context, s kv, kind, id string, newDoc func() *T, fn func(d *T, e *kvEntry) error) error {
+	return s.update(ctx, kind, id, func() any { return newDoc() }, func(e *kvEntry) error {
+		return fn(e.val.(*T), e)
+	})
+}
+
+// viewDoc is kv.view with documents of type T.
+func viewDoc[T any](ctx context.Context, s kv, kind, id string, fn func(d *T)) error {
+	return s.view(ctx, kind, id, func() any { return new(T) }, func(val any) {
+		d, _ := val.(*T)
+		fn(d)
+	})
+}
+
+// scanDocs is kv.scan with documents of type T.
+func scanDocs[T any](ctx context.Context, s kv, kind, prefix string, fn func(id string, d *T) bool) error {
+	return s.scan(ctx, kind, prefix, func() any { return new(T) }, func(id string, val any) bool {
+		return fn(id, val.(*T))
+	})
+}
+
+// dropDoc deletes a document.
+func dropDoc(ctx context.Context, s kv, kind, id string) error {
+	return s.update(ctx, kind, id, func() any { return nil }, func(e *kvEntry) error {
+		e.drop = true
+		return nil
+	})
+}
+
+// dropDocs deletes the documents of kind whose ID starts with prefix.
+func dropDocs(ctx context.Context, s kv, kind, prefix string) error {
+	var ids []string
+	err := s.scan(ctx, kind, prefix, nil, func(id string, _ any) bool {
+		ids = append(ids, id)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range ids {
+		errs = append(errs, dropDoc(ctx, s, kind, id))
+	}
+	return errors.Join(errs...)
+}
+
+// countDocs counts the documents of kind whose ID starts with prefix.
+func countDocs(ctx context.Context, s kv, kind, prefix string) (int, error) {
+	n := 0
+	err := s.scan(ctx, kind, prefix, nil, func(string, any) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// expired reports whether a document expiring at expires is gone at now.
+func expired(expires, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// memKV keeps documents in memory, one lock per document. A kind's
+// documents are grouped by docGroup, so the ones listed by a prefix with
+// a space, such as a room's participants, are found without going through
+// every document of the kind.
+type memKV struct {
+	kinds sync.Map // kind -> *sync.Map of group -> *memGroup
+}
+
+// memGroup holds the documents of a group. Its lock is never held while
+// taking a document's, only the other way round.
+type memGroup struct {
+	mu   sync.Mutex
+	docs map[string]*memDoc
+	dead bool // deleted from its map, look it up again
+}
+
+type memDoc struct {
+	mu      sync.RWMutex
+	val     any // nil until written
+	expires time.Time
+	dead    bool // deleted from its group, look it up again
+}
+
+func newMemKV() *memKV {
+	return &memKV{}
+}
+
+// docGroup is the part of a document ID before its first space. The
+// IDs of documents listed together, like a room's participants, start
+// with the same group and a space.
+func docGroup(id string) string {
+	group, _, _ := strings.Cut(id, " ")
+	return group
+}
+
+func (m *memKV) groups(kind string) *sync.Map {
+	groups, ok := m.kinds.Load(kind)
+	if !ok {
+		groups, _ = m.kinds.LoadOrStore(kind, new(sync.Map))
+	}
+	return groups.(*sync.Map)
+}
+
+// doc returns the document at id and its group, adding an empty one.
+func (m *memKV) doc(kind, id string) (*memGroup, *memDoc) {
+	groups := m.groups(kind)
+	for {
+		// Load first: nearly every call finds the group
+		val, ok := groups.Load(docGroup(id))
+		if !ok {
+			val, _ = groups.LoadOrStore(docGroup(id), &memGroup{docs: make(map[string]*memDoc)})
+		}
+		g := val.(*memGroup)
+		g.mu.Lock()
+		if g.dead {
+			// Swept since the load
+			g.mu.Unlock()
+			continue
+		}
+		d, ok := g.docs[id]
+		if !ok {
+			d = &memDoc{}
+			g.docs[id] = d
+		}
+		g.mu.Unlock()
+		return g, d
+	}
+}
+
+// lookup returns the document at id, or nil.
+func (m *memKV) lookup(kind, id string) *memDoc {
+	val, ok := m.groups(kind).Load(docGroup(id))
+	if !ok {
+		return nil
+	}
+	g := val.(*memGroup)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.docs[id]
+}
+
+// remove deletes d, which its caller has locked and marked dead, from g.
+func (g *memGroup) remove(id string, d *memDoc) {
+	g.mu.Lock()
+	if g.docs[id] == d {
+		delete(g.docs, id)
+	}
+	g.mu.Unlock()
+}
+
+// list returns the IDs and documents of g starting with prefix.
+func (g *memGroup) list(prefix string) ([]string, []*memDoc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ids []string
+	var docs []*memDoc
+	for id, d := range g.docs {
+		if strings.HasPrefix(id, prefix) {
+			ids, docs = append(ids, id), append(docs, d)
+		}
+	}
+	return ids, docs
+}
+
+func (d *memDoc) live(now time.Time) bool {
+	return d.val != nil && !expired(d.expires, now)
+}
+
+func (m *memKV) update(_ context.Context, kind, id string, newDoc func() any, fn func(e *kvEntry) error) error {
+	for {
+		g, d := m.doc(kind, id)
+		d.mu.Lock()
+		if d.dead {
+			// Swept or deleted since the lookup
+			d.mu.Unlock()
+			continue
+		}
+		now := clock.Now()
+		e := kvEntry{val: d.val, found: d.live(now), expires: d.expires}
+		if !e.found {
+			e.val, e.expires = newDoc(), time.Time{}
+		}
+		err := fn(&e)
+		switch {
+		case err != nil:
+		case e.drop || expired(e.expires, now):
+			d.val, d.dead = nil, true
+			g.remove(id, d)
+		default:
+			d.val, d.expires = e.val, e.expires
+		}
+		d.mu.Unlock()
+		return err
+	}
+}
+
+func (m *memKV) view(_ context.Context, kind, id string, _ func() any, fn func(val any)) error {
+	d := m.lookup(kind, id)
+	if d == nil {
+		fn(nil)
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if !d.live(clock.Now()) {
+		fn(nil)
+		return nil
+	}
+	fn(d.val)
+	return nil
+}
+
+func (m *memKV) scan(_ context.Context, kind, prefix string, newDoc func() any, fn func(id string, val any) bool) error {
+	now := clock.Now()
+	var groups []*memGroup
+	if group, _, ok := strings.Cut(prefix, " "); ok {
+		if val, ok := m.groups(kind).Load(group); ok {
+			groups = append(groups, val.(*memGroup))
+		}
+	} else {
+		m.groups(kind).Range(func(_, val any) bool {
+			groups = append(groups, val.(*memGroup))
+			return true
+		})
+	}
+	for _, g := range groups {
+		ids, docs := g.list(prefix)
+		for i, d := range docs {
+			d.mu.RLock()
+			more := true
+			switch {
+			case !d.live(now):
+			case newDoc == nil:
+				more = fn(ids[i], nil)
+			default:
+				more = fn(ids[i], d.val)
+			}
+			d.mu.RUnlock()
+			if !more {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memKV) sweep(context.Context) error {
+	now := clock.Now()
+	m.kinds.Range(func(_, groups any) bool {
+		groups.(*sync.Map).Range(func(key, val any) bool {
+			g := val.(*memGroup)
+			ids, docs := g.list("")
+			for i, d := range docs {
+				d.mu.Lock()
+				if !d.live(now) {
+					d.val, d.dead = nil, true
+					g.remove(ids[i], d)
+				}
+				d.mu.Unlock()
+			}
+			g.mu.Lock()
+			if len(g.docs) == 0 {
+				g.dead = true
+				groups.(*sync.Map).CompareAndDelete(key, g)
+			}
+			g.mu.Unlock()
+			return true
+		})
+		return true
+	})
+	return nil
+}
+
+func (m *memKV) close() error {
+	return nil
+}
+
+// table is what a tableKV keeps documents in: items by key holding the
+// document's JSON, its expiry and a version bumped by every write.
+type table interface {
+	// get returns the item at key, with version 0 when there is none.
+	// Expired items are returned too.
+	get(ctx context.Context, key string) (tableItem, error)
+	// put writes the item at key if it is still at version, 0 for none,
+	// and returns errVersionChanged otherwise.
+	put(ctx context.Context, key string, data []byte, expires time.Time, version int64) error
+	// del deletes the item at key if it is still at version.
+	del(ctx context.Context, key string, version int64) error
+	// scan calls fn with each item whose key starts with prefix, expired
+	// ones included, until fn returns false. fn must not use the table.
+	scan(ctx context.Context, prefix string, fn func(key string, it tableItem) bool) error
+	// sweep deletes the items expired at now.
+	sweep(ctx context.Context, now time.Time) error
+	close() error
+}
+
+type tableItem struct {
+	data    []byte
+	expires time.Time // zero for never
+	version int64
+}
+
+// errVersionChanged is returned by table writes that lost to another.
+var errVersionChanged = errors.New("store: item was written concurrently")
+
+// tableRetries is how often a write that lost to another instance is
+// redone before giving up.
+const tableRetries = 8
+
+// tableKV keeps documents as JSON in a table, under keyPrefix, the kind
+// and the ID.
+type tableKV struct {
+	table table
+	// locks serialize this instance's writes of a key, so only writes
+	// from other instances conflict
+	locks [64]sync.Mutex
+}
+
+func newTableKV(t table) *tableKV {
+	return &tableKV{table: t}
+}
+
+func (t *tableKV) key(kind, id string) string {
+	return keyPrefix + kind + ":" + id
+}
+
+// decode reads an item into a document from newDoc, returning nil when
+// the item is missing or expired.
+func (t *tableKV) decode(key string, it tableItem, newDoc func() any, now time.Time) (any, error) {
+	if it.version == 0 || len(it.data) == 0 || expired(it.expires, now) {
+		return nil, nil
+	}
+	val := newDoc()
+	if val == nil {
+		return nil, nil
+	}
+	if err := json.Unmarshal(it.data, val); err != nil {
+		return nil, fmt.Errorf("store: %s: %w", key, err)
+	}
+	return val, nil
+}
+
+func (t *tableKV) update(ctx context.Context, kind, id string, newDoc func() any, fn func(e *kvEntry) error) error {
+	key := t.key(kind, id)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &t.locks[h.Sum32()%uint32(len(t.locks))]
+	mu.Lock()
+	defer mu.Unlock()
+	for try := 0; ; try++ {
+		it, err := t.table.get(ctx, key)
+		if err != nil {
+			return err
+		}
+		now := clock.Now()
+		val, err := t.decode(key, it, newDoc, now)
+		if err != nil {
+			return err
+		}
+		e := kvEntry{val: val, found: val != nil, expires: it.expires}
+		if !e.found {
+			e.val, e.expires = newDoc(), time.Time{}
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+		err = t.write(ctx, key, it, &e, now)
+		if !errors.Is(err, errVersionChanged) {
+			return err
+		}
+		metrics.Add("store_write_conflicts", 1)
+		if try == tableRetries {
+			return fmt.Errorf("store: %s: too many concurrent writes", key)
+		}
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(10*(try+1)))) * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// write stores what update's fn left of the item it read. A document fn
+// did not change is not written again, nor is one whose expiry only
+// moved by less than a 24th of the time it has left, so that panels
+// polling a room do not write it on every poll.
+func (t *tableKV) write(ctx context.Context, key string, it tableItem, e *kvEntry, now time.Time) error {
+	if e.drop || expired(e.expires, now) {
+		if it.version == 0 {
+			return nil
+		}
+		return t.table.del(ctx, key, it.version)
+	}
+	data, err := json.Marshal(e.val)
+	if err != nil {
+		return err
+	}
+	if e.found && bytes.Equal(data, it.data) && !expiryMoved(it.expires, e.expires, now) {
+		return nil
+	}
+	return t.table.put(ctx, key, data, e.expires, it.version)
+}
+
+// expiryMoved reports whether an expiry changed from old to next by enough
+// to be written.
+func expiryMoved(old, next, now time.Time) bool {
+	switch {
+	case old.Equal(next):
+		return false
+	case old.IsZero() || next.IsZero() || next.Before(old):
+		return true
+	}
+	return next.Sub(old) >= next.Sub(now)/24
+}
+
+func (t *tableKV) view(ctx context.Context, kind, id string, newDoc func() any, fn func(val any)) error {
+	key := t.key(kind, id)
+	it, err := t.table.get(ctx, key)
+	if err != nil {
+		return err
+	}
+	val, err := t.decode(key, it, newDoc, clock.Now())
+	if err != nil {
+		return err
+	}
+	fn(val)
+	return nil
+}
+
+func (t *tableKV) scan(ctx context.Context, kind, prefix string, newDoc func() any, fn func(id string, val any) bool) error {
+	kindPrefix := t.key(kind, "")
+	now := clock.Now()
+	var err error
+	scanErr := t.table.scan(ctx, kindPrefix+prefix, func(key string, it tableItem) bool {
+		if it.version == 0 || len(it.data) == 0 || expired(it.expires, now) {
+			return true
+		}
+		id := strings.TrimPrefix(key, kindPrefix)
+		if newDoc == nil {
+			return fn(id, nil)
+		}
+		var val any
+		if val, err = t.decode(key, it, newDoc, now); err != nil {
+			return false
+		}
+		return fn(id, val)
+	})
+	return cmp.Or(scanErr, err)
+}
+
+func (t *tableKV) sweep(ctx context.Context) error {
+	return t.table.sweep(ctx, clock.Now())
+}
+
+func (t *tableKV) close() error {
+	return t.table.close()
+}
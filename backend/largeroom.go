@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// Large rooms: with hundreds of panels polling, what each poll costs adds
+// up while most polls show nothing new. Rooms with largeRoomParticipants
+// or more fall back to cheaper behavior:
+//
+//   - panels poll every largeRoomPollInterval, told by X-Poll-Interval
+//   - statuses are cached for largeRoomStatusTTL between writes
+//   - a poll whose panel already shows the state gets 204 without a body;
+//     the panel sends back the ETag of what it shows
+//   - the vote timeline, the voter names and the ambience are left out
+//
+// Rooms going back under the size return to the normal behavior on the
+// next poll.
+
+// renderPolicy is how a room's panels are kept up to date.
+type renderPolicy struct {
+	PollInterval time.Duration
+	// StatusTTL is how long the room's status is cached, at least
+	// statusCacheTTL
+	StatusTTL time.Duration
+	// Lean leaves the decorations out of the panel
+	Lean bool
+	// ChangesOnly answers polls with 204 while the state is unchanged
+	ChangesOnly bool
+}
+
+var (
+	// largeRoomParticipants is where a room becomes large; 0 never
+	largeRoomParticipants = 500
+	largeRoomPollInterval = 5 * time.Second
+	largeRoomStatusTTL    = 5 * time.Second
+
+	pollInterval = 2 * time.Second
+)
+
+// pollIntervalHeader tells the panel how often to poll, in seconds.
+const pollIntervalHeader = "X-Poll-Interval"
+
+// policyFor returns the render policy of a room with participants panels.
+func policyFor(participants int) renderPolicy {
+	if largeRoomParticipants > 0 && participants >= largeRoomParticipants {
+		return renderPolicy{
+			PollInterval: largeRoomPollInterval,
+			StatusTTL:    max(statusCacheTTL, largeRoomStatusTTL),
+			Lean:         true,
+			ChangesOnly:  true,
+		}
+	}
+	return renderPolicy{PollInterval: pollInterval, StatusTTL: statusCacheTTL}
+}
+
+// pollIntervalValue is the X-Poll-Interval of the policy.
+func (p renderPolicy) pollIntervalValue() string {
+	return strconv.Itoa(int(p.PollInterval / time.Second))
+}
+
+// stateETag identifies a rendered state, for ChangesOnly.
+func stateETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+}
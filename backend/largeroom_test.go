@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLargeRoomPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	old := largeRoomParticipants
+	largeRoomParticipants = 3
+	t.Cleanup(func() { largeRoomParticipants = old })
+
+	poll := func(pid, etag string) (*http.Response, string) {
+		t.Helper()
+		q := url.Values{"roomId": {"big-room"}, "pid": {pid}}
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/state?"+q.Encode(), nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, _ := poll("p1", "")
+	if resp.Header.Get(pollIntervalHeader) != "2" || resp.Header.Get("ETag") != "" {
+		t.Errorf("small room: interval %q, etag %q", resp.Header.Get(pollIntervalHeader), resp.Header.Get("ETag"))
+	}
+
+	poll("p2", "")
+	resp, body := poll("p3", "")
+	etag := resp.Header.Get("ETag")
+	if resp.Header.Get(pollIntervalHeader) != "5" || etag == "" {
+		t.Fatalf("large room: interval %q, etag %q", resp.Header.Get(pollIntervalHeader), etag)
+	}
+	if strings.Contains(body, "sparkline") {
+		t.Errorf("large room panel is not lean:\n%s", body)
+	}
+	if resp, body := poll("p3", etag); resp.StatusCode != http.StatusNoContent || body != "" {
+		t.Errorf("unchanged poll: %d %q", resp.StatusCode, body)
+	}
+
+	// A vote changes the state
+	ts.queryClient("big-room", "p1").vote()
+	if resp, _ := poll("p3", etag); resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("poll after a vote: %d, etag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
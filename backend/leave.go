@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// leaveGrace is how long a participant who closed the panel still counts,
+// so a reload or a Zoom client reconnecting does not move the gauge.
+const leaveGrace = 15 * time.Second
+
+// A participant leaving more than flapThreshold times in flapWindow is
+// flapping, usually on a bad network; its leaves wait flapGrace instead,
+// so the gauge stays put while it comes and goes.
+const (
+	flapThreshold = 3
+	flapWindow    = 5 * time.Minute
+	flapGrace     = 2 * time.Minute
+)
+
+// handleLeave is sent by the panel as it closes. The participant stops
+// counting once the grace is over, unless it polls again before then.
+func handleLeave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	grace := leaveGrace
+	n, _, err := CountAction(ctx, zCtx.RoomID(), "leave", zCtx.UID, flapWindow)
+	if err != nil {
+		logf(ctx, "CountAction error: %v", err)
+	} else if n > flapThreshold {
+		grace = flapGrace
+		metrics.Add("flapping_leaves", 1)
+		if n == flapThreshold+1 {
+			logf(ctx, "Participant flapping: %d leaves in %s", n, flapWindow)
+		}
+	}
+
+	if err := LeaveParticipant(ctx, zCtx.RoomID(), zCtx.UID, clock.Now().Add(grace)); err != nil {
+		logf(ctx, "LeaveParticipant error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// departed re-evaluates the room after participants were removed, as the
+// smaller count can pass the threshold without a new vote. Per the room's
+// departure rule it triggers right away or waits for one more vote.
+func departed(ctx context.Context, zCtx *ZoomAuthContext) {
+	settings := roomSettings(ctx, zCtx)
+	if settings.DepartureRule == departureVote {
+		if err := AwaitVote(ctx, zCtx.RoomID(), defaultPollID); err != nil {
+			logf(ctx, "AwaitVote error: %v", err)
+		}
+		return
+	}
+	if _, _, _, err := checkTrigger(ctx, zCtx, &settings); err != nil {
+		logf(ctx, "CheckTriggerStatus error: %v", err)
+	}
+}
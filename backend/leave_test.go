@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLeaveGrace(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			ts := newTestServer(t)
+			if store == "memory" {
+				useRedis = false
+			}
+			fc := useFakeClock(t)
+			room := "leave-room-" + store
+			participants := func() int {
+				n, _, _, _ := CheckTriggerStatus(context.Background(), room)
+				return n
+			}
+			a := ts.queryClient(room, "a")
+			b := ts.queryClient(room, "b")
+			a.poll()
+			b.poll()
+
+			// A reload within the grace does not count as leaving
+			if status, _ := b.post("/api/leave", nil); status != http.StatusNoContent {
+				t.Fatalf("leave: %d", status)
+			}
+			fc.Advance(leaveGrace / 2)
+			b.poll()
+			fc.Advance(leaveGrace)
+			a.poll()
+			if n := participants(); n != 2 {
+				t.Errorf("after a reload: %d participants", n)
+			}
+
+			// Closing the panel does, once the grace is over
+			b.post("/api/leave", nil)
+			a.poll()
+			if n := participants(); n != 2 {
+				t.Errorf("within the grace: %d participants", n)
+			}
+			fc.Advance(leaveGrace)
+			a.poll()
+			if n := participants(); n != 1 {
+				t.Errorf("after the grace: %d participants", n)
+			}
+		})
+	}
+}
+
+func TestFlappingParticipant(t *testing.T) {
+	ts := newTestServer(t)
+	useRedis = false
+	fc := useFakeClock(t)
+	a := ts.queryClient("flap-room", "a")
+	flappy := ts.queryClient("flap-room", "flappy")
+	a.poll()
+
+	for range flapThreshold {
+		flappy.poll()
+		flappy.post("/api/leave", nil)
+		fc.Advance(time.Second)
+	}
+	flappy.poll()
+	flappy.post("/api/leave", nil)
+
+	// Past the threshold, a leave waits flapGrace
+	fc.Advance(leaveGrace)
+	a.poll()
+	if n, _, _, _ := CheckTriggerStatus(context.Background(), "flap-room"); n != 2 {
+		t.Errorf("flapping participant dropped after %s: %d participants", leaveGrace, n)
+	}
+	fc.Advance(flapGrace)
+	a.poll()
+	if n, _, _, _ := CheckTriggerStatus(context.Background(), "flap-room"); n != 1 {
+		t.Errorf("after flapGrace: %d participants", n)
+	}
+}
+
+func TestDepartureRule(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		for _, rule := range []string{departureFire, departureVote} {
+			t.Run(store+"/"+rule, func(t *testing.T) {
+				useRedis = false
+				if store == "redis" {
+					mr, client := setupTestRedis()
+					rdb = client
+					t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+				}
+				saved := defaultSettings.Load()
+				s := *saved
+				s.DepartureRule = rule
+				defaultSettings.Store(&s)
+				t.Cleanup(func() { defaultSettings.Store(saved) })
+				ctx := context.Background()
+				room := "departure-" + store + "-" + rule
+				zCtx := &ZoomAuthContext{UID: "a", Mid: room}
+
+				for _, uid := range []string{"a", "b", "c"} {
+					AddParticipant(ctx, room, uid)
+				}
+				Vote(ctx, room, "a")
+				RemoveParticipant(ctx, room, "c")
+				departed(ctx, zCtx)
+
+				if rule == departureFire {
+					// Read the flag without evaluating the poll again
+					var passed bool
+					local.viewRoom(ctx, room, func(rm *MemRoom) { passed = rm.poll(defaultPollID).Passed })
+					if useRedis {
+						passed = rdb.Get(ctx, pollKey(room, defaultPollID, "passed")).Val() == "1"
+					}
+					if !passed {
+						t.Error("not triggered by the departure")
+					}
+					return
+				}
+				if _, _, passed, _ := CheckTriggerStatus(ctx, room); passed {
+					t.Fatal("triggered without another vote")
+				}
+				// Pressing again is the explicit vote the room waits for
+				if added, _ := Vote(ctx, room, "a"); added {
+					t.Error("second press counted as a new vote")
+				}
+				if _, _, passed, _ := CheckTriggerStatus(ctx, room); !passed {
+					t.Error("not triggered by the next vote")
+				}
+			})
+		}
+	}
+}
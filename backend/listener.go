@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// inheritedListener returns the socket passed in by a supervisor using the
+// systemd socket activation protocol (LISTEN_PID and LISTEN_FDS, sockets
+// from fd 3), or nil when there is none. The supervisor keeps the socket
+// open across restarts, so connections that arrive while the new process
+// starts wait in its backlog instead of being refused.
+func inheritedListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	if n := os.Getenv("LISTEN_FDS"); n != "1" {
+		return nil, fmt.Errorf("LISTEN_FDS=%s: want exactly one socket", n)
+	}
+	f := os.NewFile(3, "LISTEN_FDS")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// listen opens the server's listening socket: the inherited one if there
+// is one, else a new one on port. With reusePort the socket is opened with
+// SO_REUSEPORT, so a new process can start on the same port while the old
+// one is still draining.
+func listen(port string, reusePort bool) (net.Listener, error) {
+	ln, err := inheritedListener()
+	if ln != nil || err != nil {
+		return ln, err
+	}
+	lc := net.ListenConfig{}
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", ":"+port)
+}
@@ -0,0 +1,12 @@
+//go:build !((linux && !(mips || mipsle || mips64 || mips64le || sparc64)) || darwin || freebsd)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("REUSE_PORT is not supported on this platform")
+}
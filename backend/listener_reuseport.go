@@ -0,0 +1,16 @@
+//go:build (linux && !(mips || mipsle || mips64 || mips64le || sparc64)) || darwin || freebsd
+
+package main
+
+import "syscall"
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
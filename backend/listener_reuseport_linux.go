@@ -0,0 +1,7 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le || sparc64)
+
+package main
+
+// soReusePort is SO_REUSEPORT, which package syscall does not define on
+// Linux. 15 on every architecture but mips, sparc and parisc.
+const soReusePort = 0xf
@@ -0,0 +1,38 @@
+//go:build (linux && !(mips || mipsle || mips64 || mips64le || sparc64)) || darwin || freebsd
+
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	first, err := listen("0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	port := strconv.Itoa(first.Addr().(*net.TCPAddr).Port)
+
+	// The next process of a deploy binds the same port while the old one
+	// still serves
+	second, err := listen(port, true)
+	if err != nil {
+		t.Fatalf("second listener: %v", err)
+	}
+	second.Close()
+
+	if ln, err := listen(port, false); err == nil {
+		ln.Close()
+		t.Error("port taken without SO_REUSEPORT")
+	}
+
+	// Not ours: a socket handed to another process is ignored
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if ln, err := inheritedListener(); ln != nil || err != nil {
+		t.Errorf("inherited = %v, %v", ln, err)
+	}
+}
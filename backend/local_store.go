@@ -0,0 +1,1298 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localStore keeps the shared state as documents in a kv: in memory for a
+// single instance, in a SQLite or bbolt file for a single instance that
+// keeps its state across restarts, or in a DynamoDB table shared by many.
+// A room is one document, with its participants, votes and leaves kept
+// apart in documents of their own.
+type localStore struct {
+	name string
+	kv   kv
+}
+
+func newLocalStore(name string, kv kv) *localStore {
+	return &localStore{name: name, kv: kv}
+}
+
+func (s *localStore) Name() string { return s.name }
+
+// Document kinds of a localStore
+const (
+	docRoom           = "room"
+	docReport         = "report"
+	docReportLog      = "report_log"
+	docReportOptOut   = "report_optout"
+	docSeries         = "series"
+	docRollup         = "rollup"
+	docBenchmark      = "benchmark"
+	docScheduler      = "scheduler"
+	docHeld           = "held"
+	docUsage          = "usage"
+	docUsageMonth     = "usage_month"
+	docResume         = "resume"
+	docAction         = "action"
+	docVoteKey        = "votekey"
+	docArchive        = "archive"
+	docClosing        = "closing"
+	docInstance       = "instance"
+	docRecentTriggers = "recent_triggers"
+	docUIDHashKey     = "uid_hash_key"
+	docParticipant    = "participant"
+	docVote           = "vote"
+	docLeave          = "leave"
+	docVoterName      = "voter_name"
+)
+
+type MemRoom struct {
+	Polls      map[string]*MemPoll // by poll ID, created on first use
+	Rounds     int                 // polls created so far, see MemPoll.Round
+	ActivePoll string              // side poll started by the host, if any
+	OpenedAt   time.Time           // first time anyone opened the panel
+	// ExpiresAt is the document's expiry
+	ExpiresAt time.Time `json:"-"`
+
+	// SnoozedUntil postpones the ending screen while in the future
+	SnoozedUntil time.Time
+
+	// Settings are the room's overrides of defaultSettings, bumping
+	// SettingsVersion on every change
+	Settings        RoomSettings
+	SettingsVersion int64
+
+	// Actions overrides the default trigger actions when non-nil
+	Actions []string
+
+	// Samples is the vote timeline ring buffer, oldest first
+	Samples []VoteSample
+
+	// Events are the newest maxRoomEvents room events, oldest first
+	Events []RoomEvent
+
+	// Named mode: voters who consented are listed by display name.
+	// NamesRound is bumped to forget the names given so far.
+	Named      bool
+	NamesRound int
+
+	// Denied are participants the host removed from the room
+	Denied map[string]bool // pseudonymized uid
+}
+
+// MemPoll is the state of one poll in a room.
+type MemPoll struct {
+	// Round tells the poll's votes from those of the polls of the same ID
+	// the room had before, which a reset or a new side poll replaced
+	Round     int
+	Passed    bool
+	FirstVote time.Time
+	MetSince  time.Time // when the threshold was last met, for Poll.Hold
+	AwaitVote bool      // passes only after another vote, see AwaitVote
+}
+
+// A room's participants, votes, leaves and display names are documents of
+// their own rather than part of the room's, so that people joining and
+// voting at once each write their own document. They are kept for
+// roomItemTTL after they were last written, longer than a room kept alive
+// by activity needs them, and only read under the room they belong to: an
+// expired room opened again starts without the ones of before.
+const roomItemTTL = 7 * 24 * time.Hour
+
+// roomParticipant is a participant in a room, since it joined.
+type roomParticipant struct {
+	Joined time.Time
+}
+
+// pollVote is a participant's vote in a poll.
+type pollVote struct {
+	At time.Time
+}
+
+// roomLeave is a participant who closed the panel, still counted until
+// the leave takes effect.
+type roomLeave struct {
+	At time.Time
+}
+
+// voterName is the display name a voter shared in named mode.
+type voterName struct {
+	Name string
+}
+
+func newMemRoom(now time.Time) *MemRoom {
+	return &MemRoom{
+		Polls:     make(map[string]*MemPoll),
+		Denied:    make(map[string]bool),
+		OpenedAt:  now,
+		ExpiresAt: now.Add(roomTTL),
+	}
+}
+
+// poll returns the state of poll id, creating it in a new round.
+func (rm *MemRoom) poll(id string) *MemPoll {
+	p, ok := rm.Polls[id]
+	if !ok {
+		p = &MemPoll{Round: rm.Rounds}
+		rm.Rounds++
+		rm.Polls[id] = p
+	}
+	return p
+}
+
+// items is the prefix of the IDs of the room's participants and leaves:
+// the room ID, which keeps them together, and when the room was opened.
+func (rm *MemRoom) items(mid string) string {
+	return mid + " " + strconv.FormatInt(rm.OpenedAt.UnixNano(), 36) + " "
+}
+
+// pollVotes is the prefix of the IDs of the votes in a round of a poll of
+// the room with items.
+func pollVotes(items, pollID string, round int) string {
+	return items + pollID + " " + strconv.Itoa(round) + " "
+}
+
+// voterNames is the prefix of the IDs of the display names given in the
+// room with items since it last forgot them.
+func (rm *MemRoom) voterNames(items string) string {
+	return items + "names " + strconv.Itoa(rm.NamesRound) + " "
+}
+
+// touch extends the room lifetime, mirroring the Redis EXPIRE refresh.
+func (rm *MemRoom) touch() {
+	rm.ExpiresAt = clock.Now().Add(roomTTL)
+}
+
+// updateRoom runs fn on the room, a new one if it is missing or expired,
+// and keeps the result. See kv.update for what fn may do.
+func (s *localStore) updateRoom(ctx context.Context, mid string, fn func(rm *MemRoom) error) error {
+	newRoom := func() *MemRoom { return newMemRoom(clock.Now()) }
+	return updateDoc(ctx, s.kv, docRoom, mid, newRoom, func(rm *MemRoom, e *kvEntry) error {
+		if e.found {
+			rm.ExpiresAt = e.expires
+		}
+		if err := fn(rm); err != nil {
+			return err
+		}
+		e.expires = rm.ExpiresAt
+		return nil
+	})
+}
+
+// viewRoom runs fn on the room, a new one if it is missing or expired.
+func (s *localStore) viewRoom(ctx context.Context, mid string, fn func(rm *MemRoom)) error {
+	return viewDoc(ctx, s.kv, docRoom, mid, func(rm *MemRoom) {
+		if rm == nil {
+			rm = newMemRoom(clock.Now())
+		}
+		fn(rm)
+	})
+}
+
+// roomItems returns the prefix of the IDs of the room's participants and
+// leaves, see MemRoom.items.
+func (s *localStore) roomItems(ctx context.Context, mid string) (string, error) {
+	var items string
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { items = rm.items(mid) })
+	return items, err
+}
+
+// sweep drops the expired documents.
+func (s *localStore) sweep(ctx context.Context) error {
+	return s.kv.sweep(ctx)
+}
+
+func (s *localStore) close() error {
+	return s.kv.close()
+}
+
+func (s *localStore) JoinRoom(ctx context.Context, mid, uid string, _ *Poll) (bool, error) {
+	var items string
+	err := s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		items = rm.items(mid)
+		rm.touch()
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	var joined bool
+	err = updateDoc(ctx, s.kv, docParticipant, items+uid, newDoc[roomParticipant], func(rp *roomParticipant, e *kvEntry) error {
+		joined = !e.found
+		if joined {
+			rp.Joined = clock.Now()
+		}
+		e.expires = clock.Now().Add(roomItemTTL)
+		return nil
+	})
+	if err == nil {
+		err = dropDoc(ctx, s.kv, docLeave, items+uid)
+	}
+	if err != nil {
+		return false, err
+	}
+	// A room waiting for the archive job is not idle
+	if archiver != nil {
+		err = updateDoc(ctx, s.kv, docArchive, mid, newDoc[archiveMark], func(a *archiveMark, e *kvEntry) error {
+			a.LastUse = clock.Now().UnixMilli()
+			e.drop = !e.found
+			return nil
+		})
+	}
+	return joined && err == nil, err
+}
+
+func (s *localStore) RemoveParticipant(ctx context.Context, mid, uid string) error {
+	items, err := s.roomItems(ctx, mid)
+	if err == nil {
+		err = dropDoc(ctx, s.kv, docParticipant, items+uid)
+	}
+	if err != nil {
+		return err
+	}
+	return s.markClosing(ctx, mid, clock.Now())
+}
+
+func (s *localStore) LeaveParticipant(ctx context.Context, mid, uid string, at time.Time) error {
+	items, err := s.roomItems(ctx, mid)
+	if err == nil {
+		err = updateDoc(ctx, s.kv, docLeave, items+uid, newDoc[roomLeave], func(l *roomLeave, e *kvEntry) error {
+			l.At, e.expires = at, clock.Now().Add(roomItemTTL)
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return s.markClosing(ctx, mid, at)
+}
+
+func (s *localStore) SettleLeaves(ctx context.Context, mid string, now time.Time) ([]string, error) {
+	items, err := s.roomItems(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+	var due []string
+	err = scanDocs(ctx, s.kv, docLeave, items, func(id string, l *roomLeave) bool {
+		if !l.At.After(now) {
+			due = append(due, strings.TrimPrefix(id, items))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	var gone []string
+	for _, uid := range due {
+		// Only the call that drops the leave removes the participant
+		var settled bool
+		err := updateDoc(ctx, s.kv, docLeave, items+uid, newDoc[roomLeave], func(l *roomLeave, e *kvEntry) error {
+			settled = e.found && !l.At.After(now)
+			e.drop = settled || !e.found
+			return nil
+		})
+		if err == nil && settled {
+			err = dropDoc(ctx, s.kv, docParticipant, items+uid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if settled {
+			gone = append(gone, uid)
+		}
+	}
+	return gone, nil
+}
+
+func (s *localStore) PollVote(ctx context.Context, mid string, p *Poll, uid string) (bool, error) {
+	var votes string
+	err := s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		mp := rm.poll(p.ID)
+		if mp.Passed {
+			return errPollPassed
+		}
+		mp.AwaitVote = false
+		if mp.FirstVote.IsZero() {
+			mp.FirstVote = clock.Now()
+		}
+		votes = pollVotes(rm.items(mid), p.ID, mp.Round)
+		rm.touch()
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	var added bool
+	err = updateDoc(ctx, s.kv, docVote, votes+uid, newDoc[pollVote], func(v *pollVote, e *kvEntry) error {
+		added = !e.found
+		if added {
+			v.At = clock.Now()
+		}
+		e.expires = clock.Now().Add(roomItemTTL)
+		return nil
+	})
+	return added && err == nil, err
+}
+
+// EvaluatePoll counts the participants and votes first and then decides
+// in the room's document. A reset in between replaces the poll with one
+// of a new round, whose votes were not counted, so it waits for the next
+// evaluation rather than fire on the old votes.
+func (s *localStore) EvaluatePoll(ctx context.Context, mid string, p *Poll) (total, votes int, passed, fired bool, err error) {
+	var items string
+	var round int
+	var started bool
+	err = s.viewRoom(ctx, mid, func(rm *MemRoom) {
+		items = rm.items(mid)
+		if mp, ok := rm.Polls[p.ID]; ok {
+			round, started = mp.Round, true
+		}
+	})
+	if err != nil {
+		return 0, 0, false, false, err
+	}
+	total, votes, err = s.countPoll(ctx, items, p, round, started)
+	if err != nil {
+		return 0, 0, false, false, err
+	}
+	err = s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		_, had := rm.Polls[p.ID]
+		mp := rm.poll(p.ID)
+		fired, passed = false, mp.Passed
+		if rm.items(mid) != items || had != started || had && mp.Round != round {
+			// Counted in a room or round replaced since
+			return nil
+		}
+
+		met := !mp.Passed && !mp.AwaitVote && p.Threshold(total, votes)
+		if p.Hold > 0 {
+			if !met {
+				mp.MetSince = time.Time{}
+			} else if mp.MetSince.IsZero() {
+				mp.MetSince = clock.Now()
+			}
+			met = met && clock.Now().Sub(mp.MetSince) >= p.Hold
+		}
+		fired = met
+		if met {
+			mp.Passed = true
+			rm.touch()
+		}
+		passed = mp.Passed
+		return nil
+	})
+	if err != nil {
+		return 0, 0, false, false, err
+	}
+	return total, votes, passed, fired, nil
+}
+
+// countPoll counts the participants of the room with items and the votes
+// in a round of poll p, none if it has not started. With p.MinPresence,
+// only the votes of participants present that long count.
+func (s *localStore) countPoll(ctx context.Context, items string, p *Poll, round int, started bool) (total, votes int, err error) {
+	if p.MinPresence == 0 {
+		total, err = countDocs(ctx, s.kv, docParticipant, items)
+		if err == nil && started {
+			votes, err = countDocs(ctx, s.kv, docVote, pollVotes(items, p.ID, round))
+		}
+		return total, votes, err
+	}
+	present := make(map[string]bool)
+	err = scanDocs(ctx, s.kv, docParticipant, items, func(id string, rp *roomParticipant) bool {
+		total++
+		present[strings.TrimPrefix(id, items)] = clock.Now().Sub(rp.Joined) >= p.MinPresence
+		return true
+	})
+	if err != nil || !started {
+		return total, 0, err
+	}
+	prefix := pollVotes(items, p.ID, round)
+	err = s.kv.scan(ctx, docVote, prefix, nil, func(id string, _ any) bool {
+		if present[strings.TrimPrefix(id, prefix)] {
+			votes++
+		}
+		return true
+	})
+	return total, votes, err
+}
+
+func (s *localStore) AwaitVote(ctx context.Context, mid, pollID string) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		rm.poll(pollID).AwaitVote = true
+		return nil
+	})
+}
+
+func (s *localStore) ActivePoll(ctx context.Context, mid string) (string, error) {
+	var id string
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { id = rm.ActivePoll })
+	return id, err
+}
+
+func (s *localStore) StartPoll(ctx context.Context, mid string, p *Poll) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		if prev := rm.ActivePoll; prev != "" {
+			delete(rm.Polls, prev)
+		}
+		rm.ActivePoll = ""
+		if p != nil {
+			rm.ActivePoll = p.ID
+		}
+		rm.touch()
+		return nil
+	})
+}
+
+func (s *localStore) ResetRoom(ctx context.Context, mid string) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		delete(rm.Polls, defaultPollID)
+		delete(rm.Polls, snoozePoll.ID)
+		rm.SnoozedUntil = time.Time{}
+		rm.NamesRound++
+		rm.touch()
+		return nil
+	})
+}
+
+func (s *localStore) SetNamedMode(ctx context.Context, mid string, on bool) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		if rm.Named && !on {
+			rm.NamesRound++
+		}
+		rm.Named = on
+		rm.touch()
+		return nil
+	})
+}
+
+func (s *localStore) VoterNames(ctx context.Context, mid string) (named bool, byUID map[string]string, votes map[string]bool, err error) {
+	var names, voted string
+	err = s.viewRoom(ctx, mid, func(rm *MemRoom) {
+		named = rm.Named
+		items := rm.items(mid)
+		names = rm.voterNames(items)
+		if mp := rm.Polls[defaultPollID]; mp != nil {
+			voted = pollVotes(items, defaultPollID, mp.Round)
+		}
+	})
+	if err != nil || !named {
+		return named, nil, nil, err
+	}
+	byUID = make(map[string]string)
+	err = scanDocs(ctx, s.kv, docVoterName, names, func(id string, vn *voterName) bool {
+		byUID[strings.TrimPrefix(id, names)] = vn.Name
+		return true
+	})
+	if err != nil || voted == "" {
+		return named, byUID, nil, err
+	}
+	votes = make(map[string]bool)
+	err = s.kv.scan(ctx, docVote, voted, nil, func(id string, _ any) bool {
+		votes[strings.TrimPrefix(id, voted)] = true
+		return true
+	})
+	return named, byUID, votes, err
+}
+
+func (s *localStore) DenyParticipant(ctx context.Context, mid, uid string) error {
+	var drop [][2]string
+	err := s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		rm.Denied[uid] = true
+		items := rm.items(mid)
+		drop = [][2]string{{docParticipant, items + uid}, {docLeave, items + uid}, {docVoterName, rm.voterNames(items) + uid}}
+		for id, mp := range rm.Polls {
+			drop = append(drop, [2]string{docVote, pollVotes(items, id, mp.Round) + uid})
+		}
+		rm.touch()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range drop {
+		if err := dropDoc(ctx, s.kv, doc[0], doc[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *localStore) IsDenied(ctx context.Context, mid, uid string) (bool, error) {
+	var denied bool
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { denied = rm.Denied[uid] })
+	return denied, err
+}
+
+func (s *localStore) ClearDenied(ctx context.Context, mid string) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		rm.Denied = make(map[string]bool)
+		return nil
+	})
+}
+
+func (s *localStore) SetVoterName(ctx context.Context, mid, uid, name string) error {
+	var named bool
+	var names string
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { named, names = rm.Named, rm.voterNames(rm.items(mid)) })
+	if err != nil || !named {
+		return err
+	}
+	return updateDoc(ctx, s.kv, docVoterName, names+uid, newDoc[voterName], func(vn *voterName, e *kvEntry) error {
+		vn.Name, e.expires = name, clock.Now().Add(roomItemTTL)
+		return nil
+	})
+}
+
+func (s *localStore) RoomActions(ctx context.Context, mid string) ([]string, error) {
+	var actions []string
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { actions = slices.Clone(rm.Actions) })
+	return actions, err
+}
+
+func (s *localStore) SetRoomActions(ctx context.Context, mid string, actions []string) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		rm.Actions = actions
+		rm.touch()
+		return nil
+	})
+}
+
+func (s *localStore) SnoozedUntil(ctx context.Context, mid string) (time.Time, error) {
+	var until time.Time
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { until = rm.SnoozedUntil })
+	return until, err
+}
+
+func (s *localStore) Snooze(ctx context.Context, mid string, until time.Time) error {
+	return s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		rm.SnoozedUntil = until
+		delete(rm.Polls, snoozePoll.ID)
+		rm.touch()
+		return nil
+	})
+}
+
+func (s *localStore) RoomSettingsOverride(ctx context.Context, mid string) (RoomSettings, int64, error) {
+	var rs RoomSettings
+	var version int64
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { rs, version = rm.Settings, rm.SettingsVersion })
+	return rs, version, err
+}
+
+func (s *localStore) SetRoomSettings(ctx context.Context, mid string, rs RoomSettings, expect int64) (int64, error) {
+	var version int64
+	err := s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		if expect >= 0 && rm.SettingsVersion != expect {
+			return errSettingsConflict
+		}
+		rm.Settings = rs
+		rm.SettingsVersion++
+		rm.touch()
+		version = rm.SettingsVersion
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (s *localStore) RoomTimeline(ctx context.Context, mid string) (opened, firstVote time.Time, err error) {
+	err = s.viewRoom(ctx, mid, func(rm *MemRoom) {
+		if mp, ok := rm.Polls[defaultPollID]; ok {
+			firstVote = mp.FirstVote
+		}
+		opened = rm.OpenedAt
+	})
+	return opened, firstVote, err
+}
+
+func (s *localStore) AddVoteSample(ctx context.Context, mid string, vs VoteSample, interval time.Duration) (bool, error) {
+	var added bool
+	err := s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		n := len(rm.Samples)
+		added = n == 0 || vs.At.Sub(rm.Samples[n-1].At) >= interval
+		if !added {
+			return nil
+		}
+		rm.Samples = append(rm.Samples, vs)
+		if len(rm.Samples) > maxSamples {
+			rm.Samples = rm.Samples[len(rm.Samples)-maxSamples:]
+		}
+		return nil
+	})
+	if err != nil || !added {
+		return false, err
+	}
+	return true, s.markArchivable(ctx, mid)
+}
+
+func (s *localStore) VoteSamples(ctx context.Context, mid string) ([]VoteSample, error) {
+	var samples []VoteSample
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) { samples = slices.Clone(rm.Samples) })
+	return samples, err
+}
+
+func (s *localStore) AppendRoomEvent(ctx context.Context, mid, typ string, at time.Time) (string, error) {
+	var id string
+	err := s.updateRoom(ctx, mid, func(rm *MemRoom) error {
+		ms, seq := uint64(at.UnixMilli()), uint64(0)
+		if n := len(rm.Events); n > 0 {
+			if prevMs, prevSeq := parseStreamID(rm.Events[n-1].ID); ms <= prevMs {
+				ms, seq = prevMs, prevSeq+1
+			}
+		}
+		id = strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq, 10)
+		rm.Events = append(rm.Events, RoomEvent{ID: id, Type: typ, At: at})
+		if len(rm.Events) > maxRoomEvents {
+			rm.Events = rm.Events[len(rm.Events)-maxRoomEvents:]
+		}
+		rm.touch()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, s.markArchivable(ctx, mid)
+}
+
+func (s *localStore) RoomEventsSince(ctx context.Context, mid, since string) ([]RoomEvent, error) {
+	var events []RoomEvent
+	err := s.viewRoom(ctx, mid, func(rm *MemRoom) {
+		if since == "" {
+			events = slices.Clone(rm.Events[max(len(rm.Events)-1, 0):])
+			return
+		}
+		if i := slices.IndexFunc(rm.Events, func(e RoomEvent) bool { return streamIDLess(since, e.ID) }); i >= 0 {
+			events = slices.Clone(rm.Events[i:])
+		}
+	})
+	return events, err
+}
+
+// newDoc returns a new document for kv.update.
+func newDoc[T any]() *T {
+	return new(T)
+}
+
+// actionWindow is a fixed window of one participant's actions, a document
+// expiring with the window.
+type actionWindow struct {
+	Start time.Time
+	N     int
+}
+
+func (s *localStore) CountAction(ctx context.Context, mid, kind, uid string, window time.Duration) (int, time.Duration, error) {
+	var n int
+	var left time.Duration
+	err := updateDoc(ctx, s.kv, docAction, mid+" "+kind+" "+uid, newDoc[actionWindow], func(w *actionWindow, e *kvEntry) error {
+		now := clock.Now()
+		if !e.found {
+			w.Start, w.N = now, 0
+		}
+		w.N++
+		e.expires = w.Start.Add(window)
+		n, left = w.N, e.expires.Sub(now)
+		return nil
+	})
+	return n, left, err
+}
+
+// voteKey is a vote's idempotency key, a document expiring with the key.
+type voteKey struct {
+	Ack string // empty while the vote is being taken
+}
+
+func (s *localStore) ClaimVoteKey(ctx context.Context, mid, uid, key string, ttl time.Duration) (string, bool, error) {
+	var ack string
+	var claimed bool
+	err := updateDoc(ctx, s.kv, docVoteKey, mid+" "+uid+" "+key, newDoc[voteKey], func(vk *voteKey, e *kvEntry) error {
+		ack, claimed = vk.Ack, !e.found
+		if claimed {
+			e.expires = clock.Now().Add(ttl)
+		}
+		return nil
+	})
+	return ack, claimed && err == nil, err
+}
+
+func (s *localStore) SettleVoteKey(ctx context.Context, mid, uid, key, ack string) error {
+	return updateDoc(ctx, s.kv, docVoteKey, mid+" "+uid+" "+key, newDoc[voteKey], func(vk *voteKey, e *kvEntry) error {
+		vk.Ack = ack
+		e.drop = !e.found || ack == ""
+		return nil
+	})
+}
+
+func (s *localStore) SaveReport(ctx context.Context, mid string, r, logged *MeetingReport) error {
+	err := updateDoc(ctx, s.kv, docReport, mid, newDoc[MeetingReport], func(d *MeetingReport, e *kvEntry) error {
+		*d = *r
+		e.expires = clock.Now().Add(reportTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.markArchivable(ctx, mid); err != nil {
+		return err
+	}
+	return updateDoc(ctx, s.kv, docReportLog, reportLogID(r.Tenant, r.TriggeredAt, mid), newDoc[MeetingReport], func(d *MeetingReport, e *kvEntry) error {
+		*d = *logged
+		return nil
+	})
+}
+
+// reportLogID names a logged report so the tenant's are found by prefix.
+func reportLogID(tenant string, at time.Time, mid string) string {
+	return fmt.Sprintf("%s %020d %s", tenant, at.UnixNano(), mid)
+}
+
+func (s *localStore) RoomReport(ctx context.Context, mid string) (*MeetingReport, error) {
+	var r *MeetingReport
+	err := viewDoc(ctx, s.kv, docReport, mid, func(d *MeetingReport) {
+		if d != nil {
+			cp := *d
+			r = &cp
+		}
+	})
+	return r, err
+}
+
+func (s *localStore) ReportsBetween(ctx context.Context, tenant string, from, to time.Time) ([]*MeetingReport, error) {
+	var reports []*MeetingReport
+	err := scanDocs(ctx, s.kv, docReportLog, tenant+" ", func(_ string, r *MeetingReport) bool {
+		if !r.TriggeredAt.Before(from) && r.TriggeredAt.Before(to) {
+			cp := *r
+			reports = append(reports, &cp)
+		}
+		return true
+	})
+	slices.SortStableFunc(reports, func(a, b *MeetingReport) int { return a.TriggeredAt.Compare(b.TriggeredAt) })
+	return reports, err
+}
+
+func (s *localStore) PurgeReports(ctx context.Context, tenant string, cutoff time.Time) (int64, error) {
+	var old []string
+	err := scanDocs(ctx, s.kv, docReportLog, tenant+" ", func(id string, r *MeetingReport) bool {
+		if r.TriggeredAt.Before(cutoff) {
+			old = append(old, id)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, id := range old {
+		if err := dropDoc(ctx, s.kv, docReportLog, id); err != nil {
+			return int64(i), err
+		}
+	}
+	return int64(len(old)), nil
+}
+
+// reportOptOut marks a host that unsubscribed from report emails. It
+// never expires.
+type reportOptOut struct {
+	Out bool
+}
+
+func (s *localStore) ReportOptOut(ctx context.Context, id string) (bool, error) {
+	var out bool
+	err := viewDoc(ctx, s.kv, docReportOptOut, id, func(o *reportOptOut) { out = o != nil && o.Out })
+	return out, err
+}
+
+func (s *localStore) SetReportOptOut(ctx context.Context, id string, out bool) error {
+	return updateDoc(ctx, s.kv, docReportOptOut, id, newDoc[reportOptOut], func(o *reportOptOut, e *kvEntry) error {
+		o.Out, e.drop = true, !out
+		return nil
+	})
+}
+
+// seriesEntries are the occurrences of a series by date.
+type seriesEntries struct {
+	Entries map[string]SeriesEntry
+}
+
+func (s *localStore) RecordSeriesEntry(ctx context.Context, series string, se SeriesEntry, overwrite bool) (bool, error) {
+	var added bool
+	err := updateDoc(ctx, s.kv, docSeries, series, newDoc[seriesEntries], func(d *seriesEntries, e *kvEntry) error {
+		if d.Entries == nil {
+			d.Entries = make(map[string]SeriesEntry)
+		}
+		_, exists := d.Entries[se.Date]
+		added = !exists
+		if !exists || overwrite {
+			d.Entries[se.Date] = se
+		}
+		e.expires = clock.Now().Add(seriesTTL)
+		return nil
+	})
+	return added && err == nil, err
+}
+
+func (s *localStore) SeriesEntries(ctx context.Context, series string) ([]SeriesEntry, error) {
+	var entries []SeriesEntry
+	err := viewDoc(ctx, s.kv, docSeries, series, func(d *seriesEntries) {
+		if d != nil {
+			entries = slices.Collect(maps.Values(d.Entries))
+		}
+	})
+	return entries, err
+}
+
+// tenantRollups are a tenant's daily rollups by date.
+type tenantRollups struct {
+	Days map[string]ReportRollup
+}
+
+func (s *localStore) SaveRollup(ctx context.Context, tenant string, ru ReportRollup) error {
+	return updateDoc(ctx, s.kv, docRollup, tenant, newDoc[tenantRollups], func(d *tenantRollups, e *kvEntry) error {
+		if d.Days == nil {
+			d.Days = make(map[string]ReportRollup)
+		}
+		d.Days[ru.Date] = ru
+		return nil
+	})
+}
+
+func (s *localStore) Rollups(ctx context.Context, tenant string) ([]ReportRollup, error) {
+	var rollups []ReportRollup
+	err := viewDoc(ctx, s.kv, docRollup, tenant, func(d *tenantRollups) {
+		if d != nil {
+			rollups = slices.Collect(maps.Values(d.Days))
+		}
+	})
+	return rollups, err
+}
+
+func (s *localStore) PurgeRollups(ctx context.Context, tenant, date string) (int64, error) {
+	var n int
+	err := updateDoc(ctx, s.kv, docRollup, tenant, newDoc[tenantRollups], func(d *tenantRollups, e *kvEntry) error {
+		n = len(d.Days)
+		maps.DeleteFunc(d.Days, func(day string, _ ReportRollup) bool { return day < date })
+		n -= len(d.Days)
+		e.drop = len(d.Days) == 0
+		return nil
+	})
+	return int64(n), err
+}
+
+// benchmarkPool is the cross-tenant benchmark pool by date, one document.
+type benchmarkPool struct {
+	Days map[string]BenchmarkDay
+}
+
+func (s *localStore) PutBenchmarkDay(ctx context.Context, date string, day *BenchmarkDay) error {
+	return updateDoc(ctx, s.kv, docBenchmark, "pool", newDoc[benchmarkPool], func(d *benchmarkPool, e *kvEntry) error {
+		if d.Days == nil {
+			d.Days = make(map[string]BenchmarkDay)
+		}
+		if day == nil {
+			delete(d.Days, date)
+		} else {
+			d.Days[date] = *day
+		}
+		return nil
+	})
+}
+
+func (s *localStore) BenchmarkDays(ctx context.Context) ([]BenchmarkDay, error) {
+	var days []BenchmarkDay
+	err := viewDoc(ctx, s.kv, docBenchmark, "pool", func(d *benchmarkPool) {
+		if d != nil {
+			days = slices.Collect(maps.Values(d.Days))
+		}
+	})
+	return days, err
+}
+
+func (s *localStore) PurgeBenchmarkDays(ctx context.Context, date string) (int64, error) {
+	var n int
+	err := updateDoc(ctx, s.kv, docBenchmark, "pool", newDoc[benchmarkPool], func(d *benchmarkPool, e *kvEntry) error {
+		n = len(d.Days)
+		maps.DeleteFunc(d.Days, func(day string, _ BenchmarkDay) bool { return day < date })
+		n -= len(d.Days)
+		e.drop = len(d.Days) == 0
+		return nil
+	})
+	return int64(n), err
+}
+
+// leaderLock is the scheduler's leader lock, expiring unless renewed.
+type leaderLock struct {
+	Holder string
+}
+
+func (s *localStore) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	var lead bool
+	err := updateDoc(ctx, s.kv, docScheduler, "leader", newDoc[leaderLock], func(l *leaderLock, e *kvEntry) error {
+		lead = !e.found || l.Holder == id
+		if lead {
+			l.Holder, e.expires = id, clock.Now().Add(ttl)
+		}
+		return nil
+	})
+	return lead && err == nil, err
+}
+
+// jobRun is the claim on one run of a scheduled job.
+type jobRun struct {
+	Due time.Time
+}
+
+func (s *localStore) ClaimJobRun(ctx context.Context, job string, due time.Time) (bool, error) {
+	var claimed bool
+	err := updateDoc(ctx, s.kv, docScheduler, "run:"+job+":"+strconv.FormatInt(due.Unix(), 10), newDoc[jobRun], func(r *jobRun, e *kvEntry) error {
+		claimed = !e.found
+		if claimed {
+			r.Due, e.expires = due, clock.Now().Add(jobRunTTL)
+		}
+		return nil
+	})
+	return claimed && err == nil, err
+}
+
+// counter is a document counting something.
+type counter struct {
+	N int
+}
+
+func (s *localStore) IncrHeldMeetings(ctx context.Context, tenant, date string) error {
+	return updateDoc(ctx, s.kv, docHeld, tenant+" "+date, newDoc[counter], func(c *counter, e *kvEntry) error {
+		c.N++
+		e.expires = clock.Now().Add(heldTTL)
+		return nil
+	})
+}
+
+func (s *localStore) HeldMeetings(ctx context.Context, tenant, date string) (int, error) {
+	return s.count(ctx, docHeld, tenant+" "+date)
+}
+
+func (s *localStore) count(ctx context.Context, kind, id string) (int, error) {
+	var n int
+	err := viewDoc(ctx, s.kv, kind, id, func(c *counter) {
+		if c != nil {
+			n = c.N
+		}
+	})
+	return n, err
+}
+
+// usageDoc is a tenant's metering: when each room and each panel last
+// connected.
+type usageDoc struct {
+	Rooms  map[string]time.Time // mid -> last connection
+	Panels map[string]time.Time // mid + " " + pseudonymized uid -> last connection
+}
+
+func (s *localStore) MarkConnected(ctx context.Context, tenant, mid, uid string, at, idleBefore time.Time) error {
+	return updateDoc(ctx, s.kv, docUsage, tenant, newDoc[usageDoc], func(u *usageDoc, e *kvEntry) error {
+		if u.Rooms == nil {
+			u.Rooms, u.Panels = map[string]time.Time{}, map[string]time.Time{}
+		}
+		u.Rooms[mid], u.Panels[mid+" "+uid] = at, at
+		maps.DeleteFunc(u.Rooms, func(_ string, t time.Time) bool { return t.Before(idleBefore) })
+		maps.DeleteFunc(u.Panels, func(_ string, t time.Time) bool { return t.Before(idleBefore) })
+		e.expires = clock.Now().Add(roomTTL)
+		return nil
+	})
+}
+
+func (s *localStore) TenantConnections(ctx context.Context, tenant, mid, uid string, since time.Time) (ConnectedUsage, error) {
+	var cu ConnectedUsage
+	err := viewDoc(ctx, s.kv, docUsage, tenant, func(u *usageDoc) {
+		if u == nil {
+			return
+		}
+		for room, t := range u.Rooms {
+			if !t.Before(since) {
+				cu.Meetings++
+				cu.RoomActive = cu.RoomActive || room == mid
+			}
+		}
+		for panel, t := range u.Panels {
+			if !t.Before(since) {
+				cu.Participants++
+				cu.Connected = cu.Connected || panel == mid+" "+uid
+			}
+		}
+	})
+	return cu, err
+}
+
+func (s *localStore) IncrMonthlyTriggers(ctx context.Context, tenant, month string) error {
+	return updateDoc(ctx, s.kv, docUsageMonth, tenant+" "+month, newDoc[counter], func(c *counter, e *kvEntry) error {
+		c.N++
+		e.expires = clock.Now().Add(usageTTL)
+		return nil
+	})
+}
+
+func (s *localStore) MonthlyTriggers(ctx context.Context, tenant, month string) (int, error) {
+	return s.count(ctx, docUsageMonth, tenant+" "+month)
+}
+
+func (s *localStore) SaveResumeSession(ctx context.Context, tokenHash string, rs *resumeSession, ttl time.Duration) error {
+	return updateDoc(ctx, s.kv, docResume, tokenHash, newDoc[resumeSession], func(d *resumeSession, e *kvEntry) error {
+		*d = *rs
+		e.expires = clock.Now().Add(ttl)
+		return nil
+	})
+}
+
+func (s *localStore) ResumeSession(ctx context.Context, tokenHash string, ttl time.Duration) (*resumeSession, error) {
+	var rs *resumeSession
+	err := updateDoc(ctx, s.kv, docResume, tokenHash, newDoc[resumeSession], func(d *resumeSession, e *kvEntry) error {
+		rs = nil
+		if !e.found {
+			e.drop = true
+			return nil
+		}
+		cp := *d
+		rs, e.expires = &cp, clock.Now().Add(ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// archiveMark records that a room has a history for the archive job.
+type archiveMark struct {
+	LastUse int64 // Unix milliseconds
+}
+
+// markArchivable records that the room has a history for the archive job.
+// It does nothing when archiving is off.
+func (s *localStore) markArchivable(ctx context.Context, mid string) error {
+	if archiver == nil {
+		return nil
+	}
+	return updateDoc(ctx, s.kv, docArchive, mid, newDoc[archiveMark], func(a *archiveMark, e *kvEntry) error {
+		a.LastUse = clock.Now().UnixMilli()
+		return nil
+	})
+}
+
+func (s *localStore) ArchiveCandidates(ctx context.Context, idleBefore time.Time, limit int) ([]string, error) {
+	cutoff := idleBefore.UnixMilli()
+	var mids []string
+	err := scanDocs(ctx, s.kv, docArchive, "", func(mid string, a *archiveMark) bool {
+		if a.LastUse < cutoff {
+			mids = append(mids, mid)
+		}
+		return len(mids) < limit
+	})
+	return mids, err
+}
+
+func (s *localStore) PurgeRoom(ctx context.Context, mid string) error {
+	for _, kind := range []string{docRoom, docArchive, docClosing} {
+		if err := dropDoc(ctx, s.kv, kind, mid); err != nil {
+			return err
+		}
+	}
+	for _, kind := range []string{docParticipant, docVote, docLeave, docVoterName, docAction, docVoteKey} {
+		if err := dropDocs(ctx, s.kv, kind, mid+" "); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closingMark records when the last leave of a room whose participants
+// may all have left takes effect.
+type closingMark struct {
+	At time.Time
+}
+
+// markClosing records that a participant leaves the room at the given
+// time, so the room is looked at once it may be empty.
+func (s *localStore) markClosing(ctx context.Context, mid string, at time.Time) error {
+	return updateDoc(ctx, s.kv, docClosing, mid, newDoc[closingMark], func(c *closingMark, e *kvEntry) error {
+		if at.After(c.At) {
+			c.At = at
+		}
+		return nil
+	})
+}
+
+func (s *localStore) ClosingRooms(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	var mids []string
+	err := scanDocs(ctx, s.kv, docClosing, "", func(mid string, c *closingMark) bool {
+		if !c.At.After(now) {
+			mids = append(mids, mid)
+		}
+		return len(mids) < limit
+	})
+	return mids, err
+}
+
+// ClaimEmptyRoom looks at the room while updating its closing mark, so a
+// leave marked in between makes it look again.
+func (s *localStore) ClaimEmptyRoom(ctx context.Context, mid string, now time.Time) (bool, []string, error) {
+	var empty bool
+	var uids []string
+	err := updateDoc(ctx, s.kv, docClosing, mid, newDoc[closingMark], func(c *closingMark, e *kvEntry) error {
+		empty, uids = false, nil
+		if !e.found {
+			e.drop = true
+			return nil
+		}
+		var stored, leaving bool
+		var items string
+		var last time.Time
+		err := viewDoc(ctx, s.kv, docRoom, mid, func(rm *MemRoom) {
+			if rm != nil {
+				stored, items = true, rm.items(mid)
+			}
+		})
+		leaves := make(map[string]time.Time)
+		if err == nil && stored {
+			err = scanDocs(ctx, s.kv, docLeave, items, func(id string, l *roomLeave) bool {
+				leaves[strings.TrimPrefix(id, items)] = l.At
+				return true
+			})
+		}
+		if err == nil && stored {
+			leaving = true
+			err = s.kv.scan(ctx, docParticipant, items, nil, func(id string, _ any) bool {
+				uid := strings.TrimPrefix(id, items)
+				at, ok := leaves[uid]
+				if !ok {
+					leaving = false
+					return false
+				}
+				if at.After(last) {
+					last = at
+				}
+				uids = append(uids, uid)
+				return true
+			})
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case !stored || !leaving:
+			uids, e.drop = nil, true
+		case last.After(now):
+			uids, c.At = nil, last
+		default:
+			empty, e.drop = true, true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return empty, uids, nil
+}
+
+func (s *localStore) LiveRooms(ctx context.Context, limit int) ([]liveRoom, bool, error) {
+	var rooms []liveRoom
+	var items, votes []string
+	more := false
+	err := scanDocs(ctx, s.kv, docRoom, "", func(mid string, rm *MemRoom) bool {
+		if len(rooms) == limit {
+			more = true
+			return false
+		}
+		r := liveRoom{Room: mid, OpenedAt: rm.OpenedAt}
+		items = append(items, rm.items(mid))
+		votes = append(votes, "")
+		if mp, ok := rm.Polls[defaultPollID]; ok {
+			r.Triggered = mp.Passed
+			votes[len(votes)-1] = pollVotes(rm.items(mid), defaultPollID, mp.Round)
+		}
+		rooms = append(rooms, r)
+		return true
+	})
+	// Counted once the scan is over, which must not read the store
+	for i := range rooms {
+		if err != nil {
+			break
+		}
+		rooms[i].Participants, err = countDocs(ctx, s.kv, docParticipant, items[i])
+		if err == nil && votes[i] != "" {
+			rooms[i].Votes, err = countDocs(ctx, s.kv, docVote, votes[i])
+		}
+	}
+	return rooms, more, err
+}
+
+// PutInstanceStatus keeps each heartbeat as a document expiring when the
+// instance would be stale.
+func (s *localStore) PutInstanceStatus(ctx context.Context, st *instanceStatus, staleBefore time.Time) error {
+	return updateDoc(ctx, s.kv, docInstance, st.ID, newDoc[instanceStatus], func(d *instanceStatus, e *kvEntry) error {
+		*d = *st
+		e.expires = st.Seen.Add(clock.Now().Sub(staleBefore))
+		return nil
+	})
+}
+
+func (s *localStore) InstanceStatuses(ctx context.Context) ([]instanceStatus, error) {
+	var list []instanceStatus
+	err := scanDocs(ctx, s.kv, docInstance, "", func(_ string, st *instanceStatus) bool {
+		list = append(list, *st)
+		return true
+	})
+	return list, err
+}
+
+// recentTriggers are the newest trigger events, newest first.
+type recentTriggers struct {
+	Events []TriggerEvent
+}
+
+func (s *localStore) RecordTrigger(ctx context.Context, ev TriggerEvent) error {
+	return updateDoc(ctx, s.kv, docRecentTriggers, "events", newDoc[recentTriggers], func(d *recentTriggers, e *kvEntry) error {
+		d.Events = slices.Insert(d.Events, 0, ev)
+		if len(d.Events) > maxRecentTriggers {
+			d.Events = d.Events[:maxRecentTriggers]
+		}
+		return nil
+	})
+}
+
+func (s *localStore) RecentTriggers(ctx context.Context) ([]TriggerEvent, error) {
+	var events []TriggerEvent
+	err := viewDoc(ctx, s.kv, docRecentTriggers, "events", func(d *recentTriggers) {
+		if d != nil {
+			events = slices.Clone(d.Events)
+		}
+	})
+	return events, err
+}
+
+// storedUIDHashKey is the uidHashKey generated for this store, kept in it
+// so the pseudonyms in a file stay the same across restarts.
+type storedUIDHashKey struct {
+	Key []byte
+}
+
+// UIDHashKey returns the store's uidHashKey, generating it on first use.
+func (s *localStore) UIDHashKey(ctx context.Context) ([]byte, error) {
+	var key []byte
+	err := updateDoc(ctx, s.kv, docUIDHashKey, "key", newDoc[storedUIDHashKey], func(d *storedUIDHashKey, e *kvEntry) error {
+		if len(d.Key) == 0 {
+			d.Key = make([]byte, 32)
+			rand.Read(d.Key)
+		}
+		key = d.Key
+		return nil
+	})
+	return key, err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+)
+
+// debugLogging turns on debugf. It can be flipped while serving, so an
+// incident can be looked into without restarting the instance.
+var debugLogging atomic.Bool
+
+// debugf is logf for detail too noisy to log all the time: how each
+// request was authenticated and what each trigger check saw.
+func debugf(ctx context.Context, format string, args ...any) {
+	if !debugLogging.Load() {
+		return
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		format = "[req=" + id + "] " + format
+	}
+	log.Output(2, "debug: "+fmt.Sprintf(format, args...))
+}
+
+func logLevel() string {
+	if debugLogging.Load() {
+		return logLevelDebug
+	}
+	return logLevelInfo
+}
+
+// parseLogLevel reports whether level is debug.
+func parseLogLevel(level string) (bool, error) {
+	switch level {
+	case logLevelInfo:
+		return false, nil
+	case logLevelDebug:
+		return true, nil
+	}
+	return false, fmt.Errorf("log level must be %q or %q, got %q", logLevelInfo, logLevelDebug, level)
+}
+
+// setLogLevel switches between info and debug logging.
+func setLogLevel(level string) error {
+	debug, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	debugLogging.Store(debug)
+	return nil
+}
+
+// handleAdminLogLevel serves /admin/log-level: GET returns the current
+// level, PUT or POST with level=info|debug changes it.
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		if err := setLogLevel(r.FormValue("level")); err != nil {
+			httpError(w, ctx, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logf(ctx, "Log level set to %s", logLevel())
+	default:
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"level": logLevel()})
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// watchLogLevelSignal does nothing where there is no SIGUSR1; use
+// /admin/log-level instead.
+func watchLogLevelSignal() {}
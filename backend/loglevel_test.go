@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	t.Cleanup(func() { setLogLevel(logLevelInfo) })
+	setLogLevel(logLevelInfo)
+	handler := adminOnly("admin-secret", handleAdminLogLevel)
+
+	call := func(method, body string) (int, string) {
+		req := httptest.NewRequest(method, "/admin/log-level", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		var got map[string]string
+		json.NewDecoder(rec.Body).Decode(&got)
+		return rec.Code, got["level"]
+	}
+
+	if code, level := call(http.MethodGet, ""); code != http.StatusOK || level != logLevelInfo {
+		t.Errorf("GET = %d %q", code, level)
+	}
+	if code, level := call(http.MethodPut, "level=debug"); code != http.StatusOK || level != logLevelDebug || !debugLogging.Load() {
+		t.Errorf("PUT debug = %d %q", code, level)
+	}
+	if code, _ := call(http.MethodPut, "level=trace"); code != http.StatusBadRequest || !debugLogging.Load() {
+		t.Errorf("PUT trace = %d", code)
+	}
+	if code, _ := call(http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d", code)
+	}
+	if code, level := call(http.MethodPost, "level=info"); code != http.StatusOK || level != logLevelInfo {
+		t.Errorf("POST info = %d %q", code, level)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevelSignal toggles between info and debug logging each time
+// the process receives SIGUSR1.
+func watchLogLevelSignal() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	goSafe("log-level-signal", func() {
+		for range usr1 {
+			debugLogging.Store(!debugLogging.Load())
+			log.Printf("Log level set to %s (SIGUSR1)", logLevel())
+		}
+	})
+}
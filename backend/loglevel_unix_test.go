@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLogLevelSignal(t *testing.T) {
+	t.Cleanup(func() { setLogLevel(logLevelInfo) })
+	setLogLevel(logLevelInfo)
+	watchLogLevelSignal()
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	deadline := time.Now().Add(2 * time.Second)
+	for logLevel() != logLevelDebug && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if logLevel() != logLevelDebug {
+		t.Errorf("level after SIGUSR1 = %s", logLevel())
+	}
+}
@@ -1,52 +1,270 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 )
 
-// HTML rendering helper for HTMX
-func generateGaugeHTML(fill float64, triggered bool) string {
-	statusHtml := ""
-	if fill >= 100 {
-		statusHtml = `本日の営業は終了しました<br><span style="font-size: 0.6em">速やかにご退出ください</span>`
-	} else if fill > 0 {
-		statusHtml = `そろそろ… <span class='anonym-info'>(匿名)</span>`
-	} else {
-		statusHtml = `待機中 <span class='anonym-info'>(匿名)</span>`
+// HTML rendering helper for HTMX. Texts come from the room's settings.
+func generateGaugeHTML(lang string, fill float64, triggered bool, s *RoomSettings) string {
+	return renderFragment(lang, "gauge", map[string]any{
+		"Fill":          fmt.Sprintf("%.1f", fill),
+		"Percent":       int(math.Round(fill)), // for screen readers
+		"Triggered":     triggered,
+		"Passed":        fill >= 100, // but the room's actions do not include the ending screen
+		"Status":        s.gaugeText(fill),
+		"EndingText":    s.EndingText,
+		"EndingSubtext": s.EndingSubtext,
+	})
+}
+
+// generateHostPanelHTML is appended to the gauge for hosts: exact counts, a
+// reset button and the named-mode toggle. Participants only ever see the
+// anonymous gauge.
+func generateHostPanelHTML(lang string, participants, votes int, named, namedAllowed bool, active *Poll, snoozable bool) string {
+	side := make([]*Poll, len(sidePollIDs))
+	for i, id := range sidePollIDs {
+		side[i] = polls[id]
 	}
+	return renderFragment(lang, "host_panel", map[string]any{
+		"Participants": participants,
+		"Votes":        votes,
+		"Named":        named,
+		"NamedAllowed": namedAllowed,
+		// Hosts can snooze the ending on their own, without a vote
+		"Snoozable":   snoozable,
+		"SnoozeLabel": snoozeLabel(lang),
+		"PollRunning": active != nil,
+		"SidePolls":   side,
+	})
+}
 
-	triggerScript := ""
-	if triggered {
-		triggerScript = `<script>if(window.hotaruAudio && window.hotaruAudio.paused) window.hotaruAudio.play();</script>`
+// generateSnoozeBannerHTML counts down to the postponed ending. The panel
+// polls often enough that the server-rendered time stays current.
+func generateSnoozeBannerHTML(lang string, remaining time.Duration) string {
+	secs := int(remaining.Round(time.Second).Seconds())
+	return renderFragment(lang, "snooze_banner", map[string]any{
+		"Seconds":   secs,
+		"Countdown": fmt.Sprintf("%d:%02d", secs/60, secs%60),
+	})
+}
+
+// generateSnoozeButtonHTML lets the room vote to postpone the ending.
+func generateSnoozeButtonHTML(lang string, participants, votes int) string {
+	return renderFragment(lang, "snooze_button", map[string]any{
+		"PollID":       snoozePoll.ID,
+		"Label":        snoozeLabel(lang),
+		"Votes":        votes,
+		"Participants": participants,
+	})
+}
+
+// generateSidePollHTML renders the host-started poll below the main gauge.
+func generateSidePollHTML(lang string, p *Poll, participants, votes int, passed bool) string {
+	fill := 0.0
+	if participants > 0 {
+		fill = min(float64(votes)/float64(participants)*100, 100)
 	}
+	return renderFragment(lang, "side_poll", map[string]any{
+		"Poll":    p,
+		"Passed":  passed,
+		"Fill":    fmt.Sprintf("%.1f", fill),
+		"Percent": int(math.Round(fill)),
+	})
+}
 
-	return fmt.Sprintf(`
-<div id="gauge-container">
-	<div class="gauge">
-		<div class="gauge-fill" style="width: %.1f%%;"></div>
-	</div>
-	<p class="status-text">%s</p>
-	%s
-</div>`, fill, statusHtml, triggerScript)
+// generateMeetingInfoHTML renders e.g. "定例MTG — 予定 60分 / 経過 48分（残り 12分）".
+func generateMeetingInfoHTML(lang string, info *MeetingInfo, now time.Time) string {
+	parts := []string{}
+	if info.Duration > 0 {
+		parts = append(parts, fmt.Sprintf(translate(lang, "予定 %d分"), int(info.Duration.Minutes())))
+	}
+	if !info.StartTime.IsZero() && now.After(info.StartTime) {
+		elapsed := now.Sub(info.StartTime)
+		text := fmt.Sprintf(translate(lang, "経過 %d分"), int(elapsed.Minutes()))
+		switch {
+		case info.Duration > 0 && elapsed > info.Duration:
+			text += fmt.Sprintf(translate(lang, "（%d分超過）"), int((elapsed - info.Duration).Minutes()))
+		case info.Duration > 0:
+			text += fmt.Sprintf(translate(lang, "（残り %d分）"), int((info.Duration - elapsed).Minutes()))
+		}
+		parts = append(parts, text)
+	}
+	if !info.NextMeeting.IsZero() {
+		next := info.NextMeeting.Sub(now)
+		switch {
+		case next <= 0:
+			parts = append(parts, translate(lang, "次の予定が始まっています"))
+		case info.BackToBack:
+			parts = append(parts, fmt.Sprintf(translate(lang, "次の予定まで %d分（休憩なし）"), int(next.Minutes())))
+		default:
+			parts = append(parts, fmt.Sprintf(translate(lang, "次の予定まで %d分"), int(next.Minutes())))
+		}
+	}
+
+	if info.Topic == "" && len(parts) == 0 {
+		return ""
+	}
+	return renderFragment(lang, "meeting_info", map[string]any{
+		"Topic":   info.Topic,
+		"Details": strings.Join(parts, " / "),
+		// Back to back and about to end: the host has somewhere to be
+		"Rush": info.BackToBack && info.Duration > 0 && !now.Before(info.StartTime.Add(info.Duration-backToBackGap)),
+	})
+}
+
+// generateNextMeetingWarningHTML warns the room that the host's next
+// meeting starts in next, or started when next is not positive.
+func generateNextMeetingWarningHTML(lang string, next time.Duration) string {
+	text := translate(lang, "ホストの次の予定が始まっています")
+	if next > 0 {
+		text = fmt.Sprintf(translate(lang, "%d分後にホストの次の予定が始まります"), int(next.Minutes()))
+	}
+	return renderFragment(lang, "next_meeting_warning", map[string]any{"Text": text})
+}
+
+// generateVoterListHTML lists voters who chose to share their name; the rest
+// are only counted.
+func generateVoterListHTML(lang string, names []string, votes int) string {
+	return renderFragment(lang, "voter_list", map[string]any{
+		"Names":     names,
+		"Anonymous": max(votes-len(names), 0),
+	})
+}
+
+// cleanDisplayName trims a client-supplied display name to something safe to
+// store and show; an empty result means no name.
+func cleanDisplayName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if r := []rune(name); len(r) > 64 {
+		name = string(r[:64])
+	}
+	return name
+}
+
+// checkTrigger evaluates the room's default poll and starts the trigger
+// actions if this call is the one that marked it passed.
+func checkTrigger(ctx context.Context, zCtx *ZoomAuthContext, s *RoomSettings) (participants, votes int, triggered bool, err error) {
+	participants, votes, triggered, fired, err := evaluatePoll(ctx, zCtx.RoomID(), s.triggerPoll())
+	debugf(ctx, "Trigger check: %d/%d votes, triggered=%t fired=%t err=%v", votes, participants, triggered, fired, err)
+	if fired {
+		recordRoomEvent(ctx, zCtx.RoomID(), eventTriggered)
+		exportRoomEvent(zCtx, exportTrigger, "", s.triggerPoll().ID, participants, votes)
+		saveMeetingReport(ctx, zCtx, participants, votes)
+		runTriggerActions(ctx, zCtx, s, participants, votes)
+	}
+	return participants, votes, triggered, err
+}
+
+// snoozeState returns how long the room's ending is still snoozed, applying
+// a snooze the room has just voted for, and the current snooze votes.
+func snoozeState(ctx context.Context, zCtx *ZoomAuthContext) (time.Duration, int) {
+	now := clock.Now()
+	until, err := SnoozedUntil(ctx, zCtx.RoomID())
+	if err != nil {
+		logf(ctx, "SnoozedUntil error: %v", err)
+	}
+	if now.Before(until) {
+		return until.Sub(now), 0
+	}
+
+	_, votes, _, fired, err := evaluatePoll(ctx, zCtx.RoomID(), snoozePoll)
+	if err != nil {
+		logf(ctx, "Snooze poll error: %v", err)
+		return 0, 0
+	}
+	if !fired {
+		return 0, votes
+	}
+	if err := Snooze(ctx, zCtx.RoomID(), now.Add(snoozeDuration)); err != nil {
+		logf(ctx, "Snooze error: %v", err)
+		return 0, votes
+	}
+	logf(ctx, "Room voted to snooze the ending")
+	metrics.Add("snoozes", 1)
+	recordRoomEvent(ctx, zCtx.RoomID(), eventSnoozed)
+	return snoozeDuration, 0
 }
 
 func sendState(w http.ResponseWriter, ctx context.Context, zCtx *ZoomAuthContext) {
-	// Calculate and return current state
-	AddParticipant(ctx, zCtx.Mid, zCtx.UID) // ensure active
-	participants, votes, triggered, err := CheckTriggerStatus(ctx, zCtx.Mid)
+	sendStateNotice(w, ctx, zCtx, nil)
+}
+
+// sendStateNotice is sendState with an error frame for a request that
+// was turned away without failing, such as a vote after the ending.
+func sendStateNotice(w http.ResponseWriter, ctx context.Context, zCtx *ZoomAuthContext, notice *ErrorFrame) {
+	body, policy, err := renderPanel(ctx, zCtx)
 	if err != nil {
-		log.Printf("CheckTriggerStatus error: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		logf(ctx, "CheckTriggerStatus error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set(pollIntervalHeader, policy.pollIntervalValue())
+	if policy.ChangesOnly && notice == nil {
+		etag := stateETag(body)
+		w.Header().Set("ETag", etag)
+		if zCtx.ShownETag == etag {
+			metrics.Add("unchanged_polls", 1)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if notice != nil {
+		metrics.Add("error_frames", 1)
+		notice.setHeaders(w)
+	}
+	io.WriteString(w, body)
+	if notice != nil {
+		io.WriteString(w, notice.render(ctx, zCtx.Locale, true))
+	}
+}
+
+// renderState renders the caller's view of the room: the gauge or ending
+// screen plus whatever controls apply to them.
+func renderState(ctx context.Context, zCtx *ZoomAuthContext) (string, error) {
+	body, _, err := renderPanel(ctx, zCtx)
+	return body, err
+}
+
+// renderPanel is renderState with the policy the room's size calls for.
+func renderPanel(ctx context.Context, zCtx *ZoomAuthContext) (string, renderPolicy, error) {
+	settings := roomSettings(ctx, zCtx)
+	if joined, err := JoinRoom(ctx, zCtx.RoomID(), zCtx.UID, settings.triggerPoll()); err != nil { // ensure active
+		logf(ctx, "JoinRoom error: %v", err)
+	} else if joined {
+		exportRoomEvent(zCtx, exportJoin, pseudonymize(zCtx.RoomID(), zCtx.UID), "", 0, 0)
+	}
+	if gone, err := SettleLeaves(ctx, zCtx.RoomID()); err != nil {
+		logf(ctx, "SettleLeaves error: %v", err)
+	} else if len(gone) > 0 {
+		for _, uid := range gone {
+			exportRoomEvent(zCtx, exportLeave, uid, "", 0, 0)
+		}
+		departed(ctx, zCtx)
+	}
+	participants, votes, triggered, err := checkTrigger(ctx, zCtx, &settings)
+	if err != nil {
+		return "", renderPolicy{}, err
+	}
+	policy := policyFor(participants)
 
 	fill := 0.0
 	if participants > 0 {
@@ -60,116 +278,732 @@ func sendState(w http.ResponseWriter, ctx context.Context, zCtx *ZoomAuthContext
 		fill = 100.0
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(generateGaugeHTML(fill, triggered)))
+	names, named, err := VoterNames(ctx, zCtx.RoomID())
+	if err != nil {
+		// The voter list is optional; still show the gauge
+		logf(ctx, "VoterNames error: %v", err)
+	}
+	namedAllowed := settings.feature(zCtx.RoomID(), "named_mode")
+	named = named && namedAllowed
+	showNames := named && !policy.Lean
+
+	active, err := ActivePoll(ctx, zCtx.RoomID())
+	if err != nil {
+		logf(ctx, "ActivePoll error: %v", err)
+	}
+
+	var body strings.Builder
+	info := meetingInfo(ctx, zCtx)
+	recordOccurrence(ctx, zCtx, info)
+	body.WriteString(roomClockHTML(ctx, zCtx, info))
+	ending := triggered && showsEnding(roomActions(ctx, zCtx.RoomID()))
+	var remaining time.Duration
+	snoozeVotes := 0
+	snoozable := ending && settings.feature(zCtx.RoomID(), "snooze")
+	if snoozable {
+		remaining, snoozeVotes = snoozeState(ctx, zCtx)
+	}
+	body.WriteString(replayEvents(ctx, zCtx, ending && remaining <= 0))
+	body.WriteString(generateGaugeHTML(zCtx.Locale, fill, ending && remaining <= 0, &settings))
+	if !triggered {
+		samples := sampleVotes(ctx, zCtx.RoomID(), participants, votes)
+		if !policy.Lean {
+			body.WriteString(generateSparklineHTML(zCtx.Locale, samples))
+		}
+	}
+	if ending && remaining <= 0 {
+		if report, err := RoomReport(ctx, zCtx.RoomID()); err != nil {
+			logf(ctx, "RoomReport error: %v", err)
+		} else if report != nil {
+			body.WriteString(generateReportHTML(zCtx.Locale, report))
+		}
+	}
+	if !triggered && !policy.Lean {
+		body.WriteString(generateAmbienceHTML(settings.AmbienceStages, fill, zCtx.ReducedMotion))
+	}
+	if remaining > 0 {
+		body.WriteString(generateSnoozeBannerHTML(zCtx.Locale, remaining))
+	} else if snoozable {
+		body.WriteString(generateSnoozeButtonHTML(zCtx.Locale, participants, snoozeVotes))
+	}
+	if showNames {
+		body.WriteString(generateVoterListHTML(zCtx.Locale, names, votes))
+	}
+	if active != nil && !triggered {
+		if _, pollVotes, passed, err := PollStatus(ctx, zCtx.RoomID(), active); err != nil {
+			logf(ctx, "PollStatus error: %v", err)
+		} else {
+			body.WriteString(generateSidePollHTML(zCtx.Locale, active, participants, pollVotes, passed))
+		}
+	}
+	if zCtx.IsHost() {
+		body.WriteString(generateHostPanelHTML(zCtx.Locale, participants, votes, named, namedAllowed, active, snoozable))
+	}
+
+	if th := themeByID(settings.Theme); th != nil {
+		if audio := roomAudio(zCtx.Tenant, &settings); audio != "" || zCtx.Muted {
+			custom := *th
+			custom.Audio = audio
+			if zCtx.Muted {
+				custom.Audio = ""
+			}
+			th = &custom
+		}
+		return wrapTheme(zCtx.Locale, th, body.String()), policy, nil
+	}
+	return body.String(), policy, nil
 }
 
 func handleGetState(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	ctx := r.Context()
 	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	sendState(w, ctx, zCtx)
+	if closeIdlePanel(w, ctx, zCtx) {
+		return
+	}
+	if !admitConnection(ctx, zCtx) {
+		panelError(w, ctx, zCtx, frameQuotaExceeded, http.StatusTooManyRequests)
+		return
+	}
+	sendStateNotice(w, withReplicaReads(ctx), zCtx, idleWarning(zCtx))
 }
 
 func handleVote(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	ctx := r.Context()
 	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	Vote(ctx, zCtx.Mid, zCtx.UID)
+	// The default poll unless a side poll is named; side polls only take
+	// votes while they are the room's active poll
+	p := defaultPoll
+	if id := r.FormValue("poll"); id == snoozePoll.ID {
+		// Snooze votes only count while the ending is showing
+		settings := roomSettings(ctx, zCtx)
+		_, _, triggered, err := checkTrigger(ctx, zCtx, &settings)
+		if err != nil {
+			logf(ctx, "CheckTriggerStatus error: %v", err)
+			panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+			return
+		}
+		if !triggered {
+			panelError(w, ctx, zCtx, frameNotTriggered, http.StatusConflict)
+			return
+		}
+		if !settings.feature(zCtx.RoomID(), "snooze") {
+			panelError(w, ctx, zCtx, frameDisabled, http.StatusForbidden)
+			return
+		}
+		p = snoozePoll
+	} else if id != "" && id != defaultPollID {
+		active, err := ActivePoll(ctx, zCtx.RoomID())
+		if err != nil {
+			logf(ctx, "ActivePoll error: %v", err)
+			panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+			return
+		}
+		if active == nil || active.ID != id {
+			panelError(w, ctx, zCtx, framePollNotRunning, http.StatusConflict)
+			return
+		}
+		p = active
+	}
+
+	// A replayed vote is answered as the first one was, without counting
+	// it again
+	key := idempotencyKey(r, p)
+	if key != "" {
+		ack, first, err := ClaimVoteKey(ctx, zCtx.RoomID(), zCtx.UID, key, voteKeyTTL)
+		if err != nil {
+			logf(ctx, "ClaimVoteKey error: %v", err)
+			key = ""
+		} else if !first {
+			metrics.Add("votes_replayed", 1)
+			w.Header().Set(voteAckHeader, cmp.Or(ack, ackDuplicate))
+			sendState(w, ctx, zCtx)
+			return
+		}
+	}
+	settleKey := func(ack string) {
+		if key == "" {
+			return
+		}
+		if err := SettleVoteKey(ctx, zCtx.RoomID(), zCtx.UID, key, ack); err != nil {
+			logf(ctx, "SettleVoteKey error: %v", err)
+		}
+	}
+
+	if ok, wait := allowVote(ctx, zCtx.RoomID(), zCtx.UID); !ok {
+		settleKey("")
+		metrics.Add("votes_rate_limited", 1)
+		w.Header().Set(voteAckHeader, ackRateLimited)
+		w.Header().Set("Retry-After", retryAfter(wait))
+		panelError(w, ctx, zCtx, frameRateLimited, http.StatusTooManyRequests)
+		return
+	}
+
+	var notice *ErrorFrame
+	var ack string
+	added, err := PollVote(ctx, zCtx.RoomID(), p, zCtx.UID)
+	switch {
+	case errors.Is(err, errPollPassed):
+		ack = ackRoomTriggered
+		notice = &frameRoomTriggered
+	case err != nil:
+		settleKey("")
+		logf(ctx, "Vote error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return
+	case added:
+		ack = ackAccepted
+		exportRoomEvent(zCtx, exportVote, pseudonymize(zCtx.RoomID(), zCtx.UID), p.ID, 0, 0)
+	default:
+		ack = ackDuplicate
+	}
+	settleKey(ack)
+	w.Header().Set(voteAckHeader, ack)
+
+	// Sent only when the participant ticked the consent box
+	if name := cleanDisplayName(r.FormValue("display_name")); name != "" && p == defaultPoll {
+		if err := SetVoterName(ctx, zCtx.RoomID(), zCtx.UID, name); err != nil {
+			logf(ctx, "SetVoterName error: %v", err)
+		}
+	}
 
 	// Just fetch and return updated state immediately
+	sendStateNotice(w, ctx, zCtx, notice)
+}
+
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		panelError(w, ctx, zCtx, frameForbidden, http.StatusForbidden)
+		return
+	}
+
+	if err := ResetRoom(ctx, zCtx.RoomID()); err != nil {
+		logf(ctx, "ResetRoom error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+	logf(ctx, "Room reset by host")
+	recordRoomEvent(ctx, zCtx.RoomID(), eventReset)
+
 	sendState(w, ctx, zCtx)
 }
 
-func main() {
-	// Initialize Redis Connection
-	initRedis()
-	defer func() {
-		if rdb != nil {
-			rdb.Close()
-			log.Println("Redis connection closed")
+// handleNamedMode lets the host turn the voter list on or off.
+func handleNamedMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		panelError(w, ctx, zCtx, frameForbidden, http.StatusForbidden)
+		return
+	}
+
+	enable := r.FormValue("enabled") == "true"
+	if settings := roomSettings(ctx, zCtx); enable && !settings.feature(zCtx.RoomID(), "named_mode") {
+		panelError(w, ctx, zCtx, frameDisabled, http.StatusForbidden)
+		return
+	}
+
+	if err := SetNamedMode(ctx, zCtx.RoomID(), enable); err != nil {
+		logf(ctx, "SetNamedMode error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	sendState(w, ctx, zCtx)
+}
+
+// handleStartPoll lets the host start a side poll, or close it with an
+// empty poll value.
+func handleStartPoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		panelError(w, ctx, zCtx, frameForbidden, http.StatusForbidden)
+		return
+	}
+
+	var p *Poll
+	if id := r.FormValue("poll"); id != "" {
+		if p = polls[id]; p == nil || p == defaultPoll {
+			panelError(w, ctx, zCtx, frameUnknownPoll, http.StatusBadRequest)
+			return
 		}
-	}()
+	}
 
-	fs := http.FileServer(http.Dir("../frontend"))
-	mux := http.NewServeMux()
+	if err := StartPoll(ctx, zCtx.RoomID(), p); err != nil {
+		logf(ctx, "StartPoll error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+	if p != nil {
+		recordRoomEvent(ctx, zCtx.RoomID(), eventPollStarted)
+	} else {
+		recordRoomEvent(ctx, zCtx.RoomID(), eventPollClosed)
+	}
 
-	// Intercept requests to inject the Zoom App Context header into index.html
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" || r.URL.Path == "/index.html" {
-			htmlBytes, err := os.ReadFile("../frontend/index.html")
-			if err != nil {
-				http.Error(w, "Failed to load index.html", http.StatusInternalServerError)
-				return
-			}
+	sendState(w, ctx, zCtx)
+}
 
-			htmlStr := string(htmlBytes)
-			ctxHeader := r.Header.Get("x-zoom-app-context")
+// handleSnooze lets the host postpone the ending by snoozeDuration from now.
+func handleSnooze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		panelError(w, ctx, zCtx, frameForbidden, http.StatusForbidden)
+		return
+	}
 
-			// Inject the context directly into a meta tag
-			metaTag := fmt.Sprintf(`<meta name="zoom-app-context" content="%s">`, ctxHeader)
-			htmlStr = strings.Replace(htmlStr, "</head>", metaTag+"\n</head>", 1)
+	if settings := roomSettings(ctx, zCtx); !settings.feature(zCtx.RoomID(), "snooze") {
+		panelError(w, ctx, zCtx, frameDisabled, http.StatusForbidden)
+		return
+	}
 
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.Write([]byte(htmlStr))
+	if err := Snooze(ctx, zCtx.RoomID(), clock.Now().Add(snoozeDuration)); err != nil {
+		logf(ctx, "Snooze error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+	logf(ctx, "Ending snoozed by host")
+	metrics.Add("snoozes", 1)
+	recordRoomEvent(ctx, zCtx.RoomID(), eventSnoozed)
+
+	sendState(w, ctx, zCtx)
+}
+
+// handleSetActions lets the host choose the room's trigger actions. An empty
+// list runs nothing; reset=true restores the deployment default.
+func handleSetActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		panelError(w, ctx, zCtx, frameForbidden, http.StatusForbidden)
+		return
+	}
+
+	var actions []string
+	if r.FormValue("reset") != "true" {
+		var err error
+		if actions, err = parseActionList(r.FormValue("actions")); err != nil {
+			httpError(w, ctx, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if actions == nil {
+			actions = []string{}
+		}
+	}
+
+	if err := SetRoomActions(ctx, zCtx.RoomID(), actions); err != nil {
+		logf(ctx, "SetRoomActions error: %v", err)
+		panelError(w, ctx, zCtx, frameUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	sendState(w, ctx, zCtx)
+}
+
+// newMux registers the frontend and API routes.
+func newMux(cfg *Config) (*http.ServeMux, error) {
+	assets, err := newAssetServer(cfg.FrontendDir)
+	if err != nil {
+		return nil, err
+	}
+	if assets.baseURL, _, err = parseAssetBaseURL(cfg.AssetBaseURL); err != nil {
+		return nil, err
+	}
+	if _, err := assets.preload(); err != nil {
+		return nil, fmt.Errorf("frontend assets: %w", err)
+	}
+	frontendAssets.Store(assets)
+	mux := http.NewServeMux()
+
+	// index.html is rendered per request with the caller's context and state
+	index := &indexPage{assets: assets}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || r.URL.Path == "/index.html" {
+			index.ServeHTTP(w, r)
 			return
 		}
 
-		fs.ServeHTTP(w, r)
+		assets.ServeHTTP(w, r)
 	})
 
 	// Start HTTP Endpoints (No WebSockets)
 	mux.HandleFunc("/api/state", AuthMiddleware(handleGetState))
 	mux.HandleFunc("/api/vote", AuthMiddleware(handleVote))
-	port := strings.TrimSpace(os.Getenv("PORT"))
-	if port == "" {
-		port = "8080"
+	mux.HandleFunc("/api/reset", AuthMiddleware(handleReset))
+	mux.HandleFunc("/api/named", AuthMiddleware(handleNamedMode))
+	mux.HandleFunc("/api/poll", AuthMiddleware(handleStartPoll))
+	mux.HandleFunc("/api/actions", AuthMiddleware(handleSetActions))
+	mux.HandleFunc("/api/snooze", AuthMiddleware(handleSnooze))
+	mux.HandleFunc("/api/leave", AuthMiddleware(handleLeave))
+	mux.HandleFunc("/api/rooms/{id}/settings", AuthMiddleware(handleRoomSettings))
+	mux.HandleFunc("/api/rooms/{id}/deny", AuthMiddleware(handleRoomDeny))
+	mux.HandleFunc("/api/rooms/{id}/report", AuthMiddleware(handleRoomReport))
+	mux.HandleFunc("/api/rooms/{id}/series", AuthMiddleware(handleRoomSeries))
+	mux.HandleFunc("/api/stats/benchmark", AuthMiddleware(handleBenchmark))
+	mux.HandleFunc("/api/usage", AuthMiddleware(handleUsage))
+	mux.HandleFunc("/api/reports/subscription", AuthMiddleware(handleReportSubscription))
+	mux.HandleFunc("/api/reports/unsubscribe", handleReportUnsubscribe)
+	mux.HandleFunc("/api/themes", handleThemes)
+	mux.HandleFunc("/api/protocol.schema.json", handleProtocolSchema)
+	mux.HandleFunc("/api/audio", AuthMiddleware(handleAudio))
+	mux.HandleFunc("/api/audio/{tenant}/{id}", handleAudioFile)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	// With a secret provider, its ADMIN_TOKEN may come or change later
+	if cfg.AdminToken != "" || cfg.SecretProvider != "" {
+		mux.HandleFunc("/admin/config", adminOnly(cfg.AdminToken, handleAdminConfig(cfg)))
+		mux.HandleFunc("/admin/log-level", adminOnly(cfg.AdminToken, handleAdminLogLevel))
+		mux.HandleFunc("/admin/reload", adminOnly(cfg.AdminToken, handleAdminReload))
+		mux.HandleFunc("/admin/status", adminOnly(cfg.AdminToken, handleAdminStatus))
+		mux.HandleFunc("/admin/usage", adminOnly(cfg.AdminToken, handleAdminUsage))
+		mux.HandleFunc("/admin/dashboard", handleAdminDashboard(cfg.AdminToken))
+	}
+	if cfg.DevTools {
+		log.Println("WARNING: dev tools enabled, /dev/zoom-context can mint valid Zoom contexts")
+		mux.HandleFunc("/dev/zoom-context", handleDevZoomContext)
+	}
+	return mux, nil
+}
+
+// newHandler wraps the routes with the server-wide middleware.
+func newHandler(cfg *Config) (http.Handler, error) {
+	mux, err := newMux(cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, assetOrigin, _ := parseAssetBaseURL(cfg.AssetBaseURL)
+	return RequestIDMiddleware(AccessLogMiddleware(cfg.LogRedactIDs, SecurityHeadersMiddleware(contentSecurityPolicy(assetOrigin), RecoverMiddleware(mux)))), nil
+}
+
+// serve runs the HTTP server until SIGINT/SIGTERM.
+func serve(cfg *Config) error {
+	if err := cfg.validateEnvironment(); err != nil {
+		return err
+	}
+	devBypass = cfg.DevBypass
+	if devBypass {
+		log.Println("**************************************************************")
+		log.Println("WARNING: DEV_BYPASS is enabled (ENVIRONMENT=" + cfg.Environment + ").")
+		log.Println("Loopback clients can act as any user via roomId/pid params.")
+		log.Println("**************************************************************")
 	}
 
+	if cfg.ZoomSecretsFile != "" {
+		if err := watchSecretsFile(cfg.ZoomSecretsFile); err != nil {
+			return err
+		}
+	}
+
+	if cfg.TenantsFile != "" {
+		if err := loadTenantsFile(cfg.TenantsFile); err != nil {
+			return err
+		}
+	}
+
+	provider, err := newSecretProvider(cfg.SecretProvider)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		if err := startSecretRefresher(provider, cfg.SecretRefresh); err != nil {
+			return fmt.Errorf("secret provider %s: %w", cfg.SecretProvider, err)
+		}
+	}
+
+	if err := configureTriggerActions(cfg); err != nil {
+		return err
+	}
+	configureReportDelivery(cfg)
+	if cfg.SnoozeDuration < time.Minute {
+		return fmt.Errorf("SNOOZE_DURATION must be at least 1m, got %s", cfg.SnoozeDuration)
+	}
+	snoozeDuration = cfg.SnoozeDuration
+	resumeTTL = cfg.ResumeTokenTTL
+	idleTimeout = cfg.IdleTimeout
+	statusCacheTTL = cfg.StatusCacheTTL
+	if cfg.LargeRoomParticipants < 0 {
+		return fmt.Errorf("LARGE_ROOM_PARTICIPANTS must not be negative")
+	}
+	largeRoomParticipants = cfg.LargeRoomParticipants
+	if err := setLogLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	watchLogLevelSignal()
+	if err := configureDefaultSettings(cfg); err != nil {
+		return err
+	}
+	if cfg.SeasonsFile != "" {
+		if err := startSeasonScheduler(cfg.SeasonsFile); err != nil {
+			return err
+		}
+	}
+	if cfg.TemplateDir != "" {
+		if err := loadTemplateDir(cfg.TemplateDir); err != nil {
+			return err
+		}
+		if cfg.Environment == "development" {
+			watchTemplateDir(cfg.TemplateDir, time.Second)
+		}
+	}
+
+	if api := newZoomAPIClient(zoomAPICredentials{
+		AccountID:    cfg.ZoomAccountID,
+		ClientID:     cfg.ZoomAPIClientID,
+		ClientSecret: secretValue("ZOOM_API_CLIENT_SECRET"),
+	}); api != nil {
+		defaultZoomAPI.Store(api)
+		log.Println("Zoom API enabled, meeting topics and schedules will be shown")
+	}
+	if cal, err := configuredCalendar(cfg); err != nil {
+		return err
+	} else if cal != nil {
+		defaultCalendar.Store(&cal)
+		log.Println("Calendar connected, meeting schedules will be read from it")
+	}
+	goSafe("meeting-info-sweeper", func() {
+		for range time.Tick(meetingInfoTTL) {
+			sweepMeetingInfos()
+			sweepOccurrences()
+			sweepRoomSamplers()
+			sweepRoomStatuses()
+			sweepRoomClocks()
+			sweepMeteredPanels()
+			roomHashKeys.Clear()
+		}
+	})
+
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies = proxies
+
+	backend, storePath, err := cfg.storeBackend()
+	if err != nil {
+		return err
+	}
+
+	// Initialize Redis Connection
+	keyPrefix = cfg.RedisKeyPrefix
+	if backend == storeRedis {
+		initRedis(cfg)
+	} else if cfg.RedisURL != "" {
+		log.Printf("WARNING: REDIS_URL is ignored with STORE_BACKEND=%s.", backend)
+	}
+	initRedisReplicas(cfg)
+	if err := initRegions(cfg); err != nil {
+		return err
+	}
+	enableRedisRetries(cfg.RedisOpTimeout, cfg.RedisRetries, cfg.RedisRetryBackoff)
+	enableRedisChaos(cfg.ChaosErrorRate, cfg.ChaosLatency)
+	defer func() {
+		for _, client := range rdbReplicas {
+			client.Close()
+		}
+		rdbReplicas = nil
+		closeRegions()
+		if rdb != nil {
+			rdb.Close()
+			log.Println("Redis connection closed")
+		}
+	}()
+
+	if backend == storeDynamo {
+		if err := initDynamo(cfg); err != nil {
+			return err
+		}
+	}
+	if backend == storeSQLite || backend == storeBolt {
+		if err := openStoreFile(backend, storePath); err != nil {
+			return err
+		}
+	}
+	defer closeStore()
+	if key := secretValue("UID_HASH_KEY"); key != "" {
+		setUIDHashKey(key)
+	} else if backend == storeSQLite || backend == storeBolt {
+		// The participants and votes in the file must keep their pseudonyms
+		if err := useStoredUIDHashKey(); err != nil {
+			return err
+		}
+	} else if useRedis || backend == storeDynamo {
+		if cfg.Environment == "production" {
+			return fmt.Errorf("UID_HASH_KEY must be set when using %s in production", backend)
+		}
+		log.Println("WARNING: UID_HASH_KEY is not set. Votes are only deduplicated within this instance.")
+	}
+
+	if useRedis {
+		monitorRedis(5 * time.Second)
+		subscribeRoomStatus()
+		subscribeRegionBus()
+	}
+	startInstanceHeartbeat()
+	if err := startAudioLibrary(cfg); err != nil {
+		return err
+	}
+	if err := startArchive(cfg); err != nil {
+		return err
+	}
+	startRoomCloser()
+	if err := startBilling(cfg); err != nil {
+		return err
+	}
+	if err := startScheduler(cfg.ScheduleFile); err != nil {
+		return err
+	}
+	if err := startEventExport(cfg); err != nil {
+		return err
+	}
+	if err := startSimulator(cfg); err != nil {
+		return err
+	}
+	liveConfig.Store(cfg)
+	watchConfigReload()
+
+	if !useRedis {
+		// Expired documents are otherwise only dropped when written again
+		goSafe("store-sweeper", func() {
+			for range time.Tick(10 * time.Minute) {
+				if err := local.sweep(context.Background()); err != nil {
+					log.Printf("store: sweep: %v", err)
+				}
+			}
+		})
+	}
+
+	handler, err := newHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	port := cfg.Port
+
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:      ":" + port,
+		Handler:   handler,
+		ConnState: trackConn,
+	}
+
+	serveOn, challenge, err := configureTLS(cfg, server)
+	if err != nil {
+		return err
+	}
+	ln, err := listen(port, cfg.ReusePort)
+	if err != nil {
+		return err
 	}
 
 	// Graceful Shutdown Channel
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	if challenge != nil {
+		go func() {
+			log.Println("ACME HTTP-01 challenge server started on port " + cfg.HTTPChallengePort)
+			if err := challenge.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Challenge server error: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		log.Println("Robust Go Server started on port " + port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := serveOn(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe error: %v", err)
 		}
 	}()
 
 	<-stop // Block until signal
 	log.Println("Shutting down gracefully...")
+	shuttingDown.Store(true)
+	if cfg.DrainDelay > 0 {
+		// Readiness is failing now; keep serving while traffic moves to
+		// the new process
+		log.Printf("Draining for %s", cfg.DrainDelay)
+		time.Sleep(cfg.DrainDelay)
+	}
 
 	// Create a deadline for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server force shutdown: %v", err)
 	}
+	if challenge != nil {
+		challenge.Shutdown(ctx)
+	}
+	closeEventExport(ctx)
 
 	log.Println("Server stopping successfully")
+	return nil
 }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// metrics holds the server counters, published as JSON on /metrics.
+// Only this map is exposed; the default expvar set (cmdline, memstats) is not.
+var metrics = expvar.NewMap("hotaru")
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(metrics.String()))
+}
+
+// metricInt returns the current value of an integer counter, 0 if unset.
+func metricInt(name string) int64 {
+	if v, ok := metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// mqttPublish sends one QoS 0 message over a fresh MQTT 3.1.1 connection.
+// Triggers are rare, so a connection per message keeps this dependency-free.
+// brokerURL is tcp://, mqtt://, ssl:// or mqtts://host[:port] with optional
+// user:password.
+func mqttPublish(ctx context.Context, brokerURL, topic string, payload []byte) error {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return fmt.Errorf("mqtt url: %w", err)
+	}
+	useTLS := u.Scheme == "ssl" || u.Scheme == "mqtts"
+	addr := u.Host
+	if u.Port() == "" {
+		if useTLS {
+			addr = net.JoinHostPort(u.Hostname(), "8883")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if useTLS {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn = tc
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+	}
+
+	// CONNECT: protocol "MQTT" level 4, clean session, 30s keepalive
+	var vh []byte
+	vh = mqttString(vh, "MQTT")
+	flags := byte(0x02)
+	var pl []byte
+	pl = mqttString(pl, fmt.Sprintf("hotaru-%d", time.Now().UnixNano()))
+	if u.User != nil {
+		flags |= 0x80
+		pl = mqttString(pl, u.User.Username())
+		if pw, ok := u.User.Password(); ok {
+			flags |= 0x40
+			pl = mqttString(pl, pw)
+		}
+	}
+	vh = append(vh, 4, flags, 0, 30)
+	if _, err := conn.Write(mqttPacket(0x10, append(vh, pl...))); err != nil {
+		return err
+	}
+
+	// CONNACK: 0x20 0x02 <session present> <return code>
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(bufio.NewReader(conn), ack); err != nil {
+		return fmt.Errorf("mqtt connack: %w", err)
+	}
+	if ack[0] != 0x20 || ack[3] != 0 {
+		return fmt.Errorf("mqtt connect refused (code %d)", ack[3])
+	}
+
+	publish := append(mqttString(nil, topic), payload...)
+	if _, err := conn.Write(mqttPacket(0x30, publish)); err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte{0xE0, 0x00}) // DISCONNECT
+	return err
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttPacket prefixes body with the fixed header and variable-length size.
+func mqttPacket(header byte, body []byte) []byte {
+	out := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectStore is a bucket of S3 or Google Cloud Storage, reached over the
+// plain HTTP APIs like the secret providers, or a local directory.
+type objectStore interface {
+	put(ctx context.Context, key string, body []byte, contentType string) error
+	// get returns the object, or an error wrapping os.ErrNotExist
+	get(ctx context.Context, key string) ([]byte, error)
+	// list returns the keys starting with prefix
+	list(ctx context.Context, prefix string) ([]string, error)
+	delete(ctx context.Context, key string) error
+}
+
+var objectHTTP = &http.Client{Timeout: 30 * time.Second}
+
+// openObjectStore opens the bucket of an s3://bucket/prefix or
+// gs://bucket/prefix URL and returns it with the prefix. endpoint overrides
+// the service's, e.g. for MinIO or a GCS emulator.
+func openObjectStore(rawURL, endpoint string) (objectStore, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, "", fmt.Errorf("want s3://bucket/prefix or gs://bucket/prefix, got %q", rawURL)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+	switch u.Scheme {
+	case "s3":
+		s := &s3Store{
+			bucket:       u.Host,
+			region:       envOr("AWS_REGION", envOr("AWS_DEFAULT_REGION", "")),
+			accessKey:    envOr("AWS_ACCESS_KEY_ID", ""),
+			secretKey:    envOr("AWS_SECRET_ACCESS_KEY", ""),
+			sessionToken: envOr("AWS_SESSION_TOKEN", ""),
+		}
+		if s.region == "" || s.accessKey == "" || s.secretKey == "" {
+			return nil, "", fmt.Errorf("s3: AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+		}
+		if endpoint != "" {
+			// Path-style, as S3-compatible servers expect
+			s.base = endpoint + "/" + s.bucket
+		} else {
+			s.base = "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com"
+		}
+		return s, prefix, nil
+	case "gs":
+		return &gcsStore{
+			bucket:   u.Host,
+			endpoint: cmp.Or(endpoint, "https://storage.googleapis.com"),
+			gcpToken: gcpToken{staticToken: envOr("GCP_ACCESS_TOKEN", "")},
+		}, prefix, nil
+	}
+	return nil, "", fmt.Errorf("want s3://bucket/prefix or gs://bucket/prefix, got %q", rawURL)
+}
+
+// doObjectRequest sends req and decodes a JSON or XML response into out
+// when it is not nil. A *[]byte gets the body as is.
+func doObjectRequest(req *http.Request, out any) error {
+	resp, err := objectHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Host, os.ErrNotExist)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, resp.Status)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out = body
+		return nil
+	case *s3ListResult:
+		return xml.Unmarshal(body, out)
+	default:
+		return json.Unmarshal(body, out)
+	}
+}
+
+// s3Store is an S3 bucket, signed with the standard AWS_* credentials.
+type s3Store struct {
+	base, bucket, region               string
+	accessKey, secretKey, sessionToken string
+}
+
+// s3Escape encodes a key for an S3 request path: every byte but the
+// unreserved characters and slashes.
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-_.~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (s *s3Store) request(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Request, error) {
+	u, err := url.Parse(s.base + "/" + s3Escape(key))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	return req, nil
+}
+
+func (s *s3Store) sign(req *http.Request, body []byte) {
+	signAWSRequest(req, body, s.accessKey, s.secretKey, s.region, "s3", clock.Now())
+}
+
+func (s *s3Store) put(ctx context.Context, key string, body []byte, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, body)
+	return doObjectRequest(req, nil)
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Store) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := s.request(ctx, http.MethodGet, "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, nil)
+		var res s3ListResult
+		if err := doObjectRequest(req, &res); err != nil {
+			return nil, err
+		}
+		for _, c := range res.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (s *s3Store) get(ctx context.Context, key string) ([]byte, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil)
+	var body []byte
+	return body, doObjectRequest(req, &body)
+}
+
+func (s *s3Store) delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil)
+	return doObjectRequest(req, nil)
+}
+
+// gcsStore is a Cloud Storage bucket, with the token of GCP_ACCESS_TOKEN or
+// the metadata server.
+type gcsStore struct {
+	endpoint, bucket string
+	gcpToken
+}
+
+func (g *gcsStore) do(ctx context.Context, method, u string, body []byte, contentType string, out any) error {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return doObjectRequest(req, out)
+}
+
+func (g *gcsStore) put(ctx context.Context, key string, body []byte, contentType string) error {
+	u := g.endpoint + "/upload/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?uploadType=media&name=" + url.QueryEscape(key)
+	return g.do(ctx, http.MethodPost, u, body, contentType, nil)
+}
+
+func (g *gcsStore) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		q := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		var res struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		u := g.endpoint + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?" + q.Encode()
+		if err := g.do(ctx, http.MethodGet, u, nil, "", &res); err != nil {
+			return nil, err
+		}
+		for _, it := range res.Items {
+			keys = append(keys, it.Name)
+		}
+		if res.NextPageToken == "" {
+			return keys, nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+func (g *gcsStore) get(ctx context.Context, key string) ([]byte, error) {
+	var body []byte
+	u := g.endpoint + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(key) + "?alt=media"
+	return body, g.do(ctx, http.MethodGet, u, nil, "", &body)
+}
+
+func (g *gcsStore) delete(ctx context.Context, key string) error {
+	u := g.endpoint + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(key)
+	return g.do(ctx, http.MethodDelete, u, nil, "", nil)
+}
+
+// dirStore keeps objects as files under a directory, through os.Root so
+// no key can escape it.
+type dirStore struct {
+	root *os.Root
+}
+
+// openDirStore opens dir, creating it.
+func openDirStore(dir string) (*dirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &dirStore{root: root}, nil
+}
+
+// put writes the file under a temporary name first, so readers never see
+// half of it.
+func (d *dirStore) put(_ context.Context, key string, body []byte, _ string) error {
+	if dir := filepath.Dir(filepath.FromSlash(key)); dir != "." {
+		if err := d.root.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.FromSlash(key) + ".tmp-" + newRequestID()
+	if err := d.root.WriteFile(tmp, body, 0o644); err != nil {
+		return err
+	}
+	if err := d.root.Rename(tmp, filepath.FromSlash(key)); err != nil {
+		d.root.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (d *dirStore) get(_ context.Context, key string) ([]byte, error) {
+	return d.root.ReadFile(filepath.FromSlash(key))
+}
+
+// list only looks in the directory of prefix, which is all its callers
+// need.
+func (d *dirStore) list(_ context.Context, prefix string) ([]string, error) {
+	dir, _ := path.Split(prefix)
+	f, err := d.root.Open(filepath.FromSlash(cmp.Or(dir, ".")))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		if key := dir + e.Name(); !e.IsDir() && strings.HasPrefix(key, prefix) && !strings.Contains(e.Name(), ".tmp-") {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (d *dirStore) delete(_ context.Context, key string) error {
+	return d.root.Remove(filepath.FromSlash(key))
+}
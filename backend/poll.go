@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Poll is a quick anonymous yes-vote in a room. The 帰る button is the
+// default poll and always runs; hosts can start one side poll at a time
+// (休憩する？, 延長する？) that shares the same participants.
+type Poll struct {
+	ID        string // store key segment and form value
+	Question  string // shown above a side poll's gauge
+	Label     string // vote button text
+	Threshold ThresholdRule
+
+	// MinPresence is how long a voter must have been in the room before
+	// their vote counts toward the threshold
+	MinPresence time.Duration
+
+	// Hold is how long the threshold must stay met before the poll passes,
+	// so a momentary majority, say from someone dropping out, does not end
+	// the meeting
+	Hold time.Duration
+
+	// Ending polls show the ending screen when they pass; others show
+	// PassedText as a banner.
+	Ending     bool
+	PassedText string
+}
+
+// ThresholdRule decides whether a poll passes with votes out of participants.
+type ThresholdRule func(participants, votes int) bool
+
+// majority passes once at least half of the participants (rounded up) voted.
+func majority(participants, votes int) bool {
+	if participants <= 0 || votes <= 0 {
+		return false
+	}
+	return votes >= int(math.Ceil(float64(participants)/2.0))
+}
+
+// percentThreshold passes once pct percent of the participants (rounded
+// up) voted; majority is percentThreshold(50).
+func percentThreshold(pct float64) ThresholdRule {
+	return func(participants, votes int) bool {
+		if participants <= 0 || votes <= 0 {
+			return false
+		}
+		return votes >= int(math.Ceil(float64(participants)*pct/100))
+	}
+}
+
+const defaultPollID = "home"
+
+var defaultPoll = &Poll{
+	ID:        defaultPollID,
+	Label:     "帰る",
+	Threshold: majority,
+	Ending:    true,
+}
+
+// polls are the polls a room can run, by ID.
+var polls = map[string]*Poll{
+	defaultPollID: defaultPoll,
+	"break": {
+		ID:         "break",
+		Question:   "休憩する？",
+		Label:      "休憩したい",
+		Threshold:  majority,
+		PassedText: "休憩しましょう！",
+	},
+	"extend": {
+		ID:         "extend",
+		Question:   "延長する？",
+		Label:      "延長したい",
+		Threshold:  majority,
+		PassedText: "延長が決まりました",
+	},
+}
+
+// snoozePoll is offered on the ending screen. When it passes the ending is
+// postponed by snoozeDuration and the poll starts over.
+var snoozePoll = &Poll{
+	ID:        "snooze",
+	Threshold: majority,
+}
+
+// snoozeDuration is how long a snooze postpones the ending.
+var snoozeDuration = 5 * time.Minute
+
+// snoozeLabel is the snooze button text, e.g. あと5分.
+func snoozeLabel(lang string) string {
+	return fmt.Sprintf(translate(lang, "あと%d分"), int(snoozeDuration.Minutes()))
+}
+
+// sidePollIDs lists the polls a host can start, in display order.
+var sidePollIDs = []string{"break", "extend"}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMinPresence(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			fc := useFakeClock(t)
+			ctx := context.Background()
+			room := "presence-" + store
+			s := RoomSettings{ThresholdPercent: 50, MinPresenceSeconds: 60}
+			p := s.triggerPoll()
+
+			AddParticipant(ctx, room, "early")
+			fc.Advance(45 * time.Second)
+			AddParticipant(ctx, room, "late")
+			PollVote(ctx, room, p, "late")
+			if _, votes, passed, _, _ := evaluatePoll(ctx, room, p); votes != 0 || passed {
+				t.Errorf("drive-by vote counted: votes=%d passed=%t", votes, passed)
+			}
+
+			// The early participant's vote counts at once, the late one's
+			// once they have been there a minute
+			PollVote(ctx, room, p, "early")
+			fc.Advance(15 * time.Second)
+			if _, votes, passed, _, _ := evaluatePoll(ctx, room, p); votes != 1 || !passed {
+				t.Errorf("after a minute: votes=%d passed=%t", votes, passed)
+			}
+			fc.Advance(45 * time.Second)
+			if _, votes, _, _, _ := evaluatePoll(ctx, room, p); votes != 2 {
+				t.Errorf("both present long enough: votes=%d", votes)
+			}
+		})
+	}
+
+	if p := (&RoomSettings{ThresholdPercent: 50}).triggerPoll(); p != defaultPoll {
+		t.Error("default settings changed the trigger poll")
+	}
+	if err := (&RoomSettings{MinPresenceSeconds: maxMinPresence + 1}).validate(); err == nil {
+		t.Error("too long a minimum presence accepted")
+	}
+}
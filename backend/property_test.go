@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// TestThresholdRuleProperties checks the threshold rules on arbitrary
+// counts: they never pass an empty room or one without votes, pass once
+// pct percent of the participants voted, and more votes never unpass.
+func TestThresholdRuleProperties(t *testing.T) {
+	rule := func(pct uint8, participants, votes int16) bool {
+		pctf := float64(pct%100 + 1)
+		r := percentThreshold(pctf)
+		p, v := int(participants), int(votes)
+		got := r(p, v)
+		switch {
+		case p <= 0 || v <= 0:
+			return !got
+		case got != (v*100 >= int(pctf)*p):
+			return false
+		}
+		return !got || r(p, v+1) && (p == 1 || r(p-1, v))
+	}
+	if err := quick.Check(rule, nil); err != nil {
+		t.Error(err)
+	}
+	sameAsHalf := func(participants, votes int16) bool {
+		return majority(int(participants), int(votes)) == percentThreshold(50)(int(participants), int(votes))
+	}
+	if err := quick.Check(sameAsHalf, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// pollOp is one step of a pollScript.
+type pollOp struct {
+	kind string // join, leave, vote or wait
+	uid  string
+	wait time.Duration
+}
+
+// pollScript is a random room: the poll's settings and what its
+// participants do.
+type pollScript struct {
+	settings RoomSettings
+	ops      []pollOp
+}
+
+func (pollScript) Generate(r *rand.Rand, size int) reflect.Value {
+	s := pollScript{settings: RoomSettings{ThresholdPercent: float64(1 + r.Intn(100))}}
+	if r.Intn(2) == 0 {
+		s.settings.MinPresenceSeconds = 1 + r.Intn(30)
+	}
+	if r.Intn(2) == 0 {
+		s.settings.HoldSeconds = 1 + r.Intn(30)
+	}
+	kinds := []string{"join", "join", "leave", "vote", "wait"}
+	for range 1 + r.Intn(size+1) {
+		s.ops = append(s.ops, pollOp{
+			kind: kinds[r.Intn(len(kinds))],
+			uid:  "u" + strconv.Itoa(r.Intn(6)),
+			wait: time.Duration(r.Intn(20)) * time.Second,
+		})
+	}
+	return reflect.ValueOf(s)
+}
+
+// pollModel is what the poll's rules say, kept apart from the store.
+type pollModel struct {
+	p        *Poll
+	pct      float64
+	joined   map[string]time.Time
+	votes    map[string]bool
+	metSince time.Time
+	passed   bool
+}
+
+// counts are the participants and the votes that count now: with
+// MinPresence only those of voters present long enough.
+func (m *pollModel) counts(now time.Time) (total, votes int) {
+	for uid := range m.votes {
+		at, ok := m.joined[uid]
+		if m.p.MinPresence <= 0 || ok && now.Sub(at) >= m.p.MinPresence {
+			votes++
+		}
+	}
+	return len(m.joined), votes
+}
+
+// fires reports whether the poll should pass now, updating how long the
+// threshold has been met.
+func (m *pollModel) fires(now time.Time) bool {
+	if m.passed {
+		return false
+	}
+	total, votes := m.counts(now)
+	met := total > 0 && votes > 0 && float64(votes)*100 >= m.pct*float64(total)
+	if !met {
+		m.metSince = time.Time{}
+		return false
+	}
+	if m.metSince.IsZero() {
+		m.metSince = now
+	}
+	return now.Sub(m.metSince) >= m.p.Hold
+}
+
+// TestPollProperties runs random rooms through the store and evaluates the
+// poll after every step. The poll must fire exactly when the model says
+// the threshold is met and has held, never in an empty room, and once:
+// a passed poll stays passed and refuses votes.
+func TestPollProperties(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			// The status cache only delays what the rules decide
+			old := statusCacheTTL
+			statusCacheTTL = 0
+			t.Cleanup(func() { statusCacheTTL = old })
+			fc := useFakeClock(t)
+			ctx := context.Background()
+			rooms := 0
+
+			check := func(s pollScript) bool {
+				rooms++
+				room := "property-" + store + "-" + strconv.Itoa(rooms)
+				PurgeRoom(ctx, room) // left over from an earlier -count run
+				p := s.settings.triggerPoll()
+				m := &pollModel{p: p, pct: s.settings.ThresholdPercent, joined: map[string]time.Time{}, votes: map[string]bool{}}
+				for i, op := range s.ops {
+					var err error
+					switch op.kind {
+					case "join":
+						err = AddParticipant(ctx, room, op.uid)
+						if _, ok := m.joined[op.uid]; !ok {
+							m.joined[op.uid] = fc.now
+						}
+					case "leave":
+						err = RemoveParticipant(ctx, room, op.uid)
+						delete(m.joined, op.uid)
+					case "vote":
+						_, err = PollVote(ctx, room, p, op.uid)
+						if m.passed && errors.Is(err, errPollPassed) {
+							err = nil
+						} else if !m.passed {
+							m.votes[op.uid] = true
+						}
+					case "wait":
+						fc.Advance(op.wait)
+					}
+					if err != nil {
+						t.Logf("step %d %+v: %v", i, op, err)
+						return false
+					}
+
+					total, votes, passed, fired, err := evaluatePoll(ctx, room, p)
+					wantTotal, wantVotes := m.counts(fc.now)
+					want := m.fires(fc.now)
+					m.passed = m.passed || want
+					switch {
+					case err != nil:
+						t.Logf("step %d: %v", i, err)
+					case total != wantTotal || votes != wantVotes:
+						t.Logf("step %d %+v: %d/%d, want %d/%d", i, op, votes, total, wantVotes, wantTotal)
+					case fired != want:
+						t.Logf("step %d %+v: fired %t at %d/%d", i, op, fired, votes, total)
+					case fired && total == 0:
+						t.Logf("step %d: fired in an empty room", i)
+					case passed != m.passed:
+						t.Logf("step %d %+v: passed %t", i, op, passed)
+					default:
+						continue
+					}
+					return false
+				}
+				return true
+			}
+			if err := quick.Check(check, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// protocolSchema is the JSON Schema of everything the server sends as
+// JSON, for alternative frontends and webhook receivers. The contract
+// tests hold the server to it.
+//
+//go:embed protocol.schema.json
+var protocolSchema []byte
+
+// handleProtocolSchema serves GET /api/protocol.schema.json.
+func handleProtocolSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(protocolSchema)
+}
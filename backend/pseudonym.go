@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// uidHashKey keys the pseudonyms stored in place of raw Zoom uids, so the
+// participants and votes sets don't reveal who voted even to someone with
+// Redis access. Without UID_HASH_KEY the sqlite and bolt stores generate one
+// and keep it in their file; otherwise a random per-process key is used,
+// which is fine for the in-memory store but not for instances sharing a
+// store.
+var uidHashKey atomic.Pointer[[]byte]
+
+func init() {
+	key := make([]byte, 32)
+	rand.Read(key)
+	uidHashKey.Store(&key)
+}
+
+func setUIDHashKey(secret string) {
+	key := []byte(secret)
+	uidHashKey.Store(&key)
+}
+
+// useStoredUIDHashKey switches to the key kept in the store's file.
+func useStoredUIDHashKey() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key, err := local.UIDHashKey(ctx)
+	if err != nil {
+		return fmt.Errorf("UID_HASH_KEY: %w", err)
+	}
+	uidHashKey.Store(&key)
+	return nil
+}
+
+// roomHashKey is the key derived for a room from base.
+type roomHashKey struct {
+	base *[]byte
+	key  []byte
+}
+
+// roomHashKeys caches the derived keys, as a single poll pseudonymizes a
+// handful of times. A changed uidHashKey makes them stale; the sweeper
+// drops them all now and then.
+var roomHashKeys sync.Map // mid -> roomHashKey
+
+// pseudonymize returns uid hashed with a key derived for mid, so the same
+// user gets unrelated pseudonyms in different rooms.
+func pseudonymize(mid, uid string) string {
+	base := uidHashKey.Load()
+	cached, ok := roomHashKeys.Load(mid)
+	if !ok || cached.(roomHashKey).base != base {
+		cached = roomHashKey{base: base, key: hmacSHA256(*base, "room:"+mid)}
+		roomHashKeys.Store(mid, cached)
+	}
+	return hex.EncodeToString(hmacSHA256(cached.(roomHashKey).key, uid)[:16])
+}
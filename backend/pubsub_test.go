@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventually polls cond until it holds, failing t after two seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// TestRoomStatusPublish checks that the writes that change a room's counts
+// tell the other instances on the status channel.
+func TestRoomStatusPublish(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	useFakeClock(t)
+	ctx := context.Background()
+	const room = "publish-room"
+
+	sub := client.Subscribe(ctx, statusChannel(room))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ch := sub.Channel()
+	// published returns the rooms announced until the channel goes quiet
+	published := func() []string {
+		var rooms []string
+		for {
+			select {
+			case msg := <-ch:
+				rooms = append(rooms, msg.Payload)
+			case <-time.After(100 * time.Millisecond):
+				return rooms
+			}
+		}
+	}
+	AddParticipant(ctx, room, "a")
+	AddParticipant(ctx, room, "b")
+	AddParticipant(ctx, room, "c") // so one vote does not pass the poll
+	published()
+
+	for _, write := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"vote", func() error { _, err := PollVote(ctx, room, defaultPoll, "a"); return err }},
+		{"reset", func() error { return ResetRoom(ctx, room) }},
+		{"settings", func() error { _, err := SetRoomSettings(ctx, room, RoomSettings{Theme: "dark"}, -1); return err }},
+		{"start poll", func() error { return StartPoll(ctx, room, polls["break"]) }},
+		{"leave", func() error { return RemoveParticipant(ctx, room, "b") }},
+	} {
+		if err := write.fn(); err != nil {
+			t.Fatalf("%s: %v", write.name, err)
+		}
+		if rooms := published(); len(rooms) != 1 || rooms[0] != room {
+			t.Errorf("%s: published %q", write.name, rooms)
+		}
+	}
+
+	// With the cache off nobody caches, so nothing is published
+	old := statusCacheTTL
+	statusCacheTTL = 0
+	t.Cleanup(func() { statusCacheTTL = old })
+	PollVote(ctx, room, defaultPoll, "a")
+	if rooms := published(); len(rooms) != 0 {
+		t.Errorf("published %q with the cache off", rooms)
+	}
+}
+
+// TestRegionBusSubscriber runs the region bus subscriber and checks that
+// what another region sends reaches the panels polling this one.
+func TestRegionBusSubscriber(t *testing.T) {
+	t.Setenv("ZOOM_CLIENT_SECRET", "test_secret")
+	enableDevBypass(t)
+	fc := useFakeClock(t)
+	mr, _ := useRegions(t, "tokyo", "osaka")
+	handler, err := newHandler(&Config{FrontendDir: "../frontend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	ts := &testServer{Server: srv, t: t, mr: mr}
+	const room = "bus-panel-room"
+
+	subscribeRegionBus()
+	// The subscriber is up once a publish reaches someone
+	local := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer local.Close()
+	eventually(t, "the subscriber", func() bool {
+		n, _ := local.Publish(context.Background(), regionBusChannel(), "{}").Result()
+		return n > 0
+	})
+	publish := func(origin, typ string, data any) {
+		t.Helper()
+		b, _ := json.Marshal(data)
+		msg, _ := json.Marshal(regionEvent{ID: newRequestID(), Origin: origin, Type: typ, Room: room, Data: b})
+		if err := local.Publish(context.Background(), regionBusChannel(), msg).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	panel := ts.queryClient(room, "a")
+	ts.queryClient(room, "b").poll()
+	if body := panel.poll(); !strings.Contains(body, `data-triggered="false"`) {
+		t.Fatalf("fresh room:\n%s", body)
+	}
+	shows := func(want string) func() bool {
+		return func() bool { return strings.Contains(panel.poll(), want) }
+	}
+
+	// A broken message does not stop the subscriber, and our own events
+	// are not applied again
+	local.Publish(context.Background(), regionBusChannel(), "not json")
+	publish("tokyo", busSettings, RoomSettings{Theme: "minimal"})
+
+	// Settings changed in the other region
+	publish("osaka", busSettings, RoomSettings{Theme: "dark", EndingText: "またあした"})
+	eventually(t, "the settings", shows(`data-theme="dark"`))
+
+	// The room passed in the other region
+	publish("osaka", busPassed, defaultPollID)
+	eventually(t, "the ending", shows("またあした"))
+	if body := panel.poll(); !strings.Contains(body, `data-triggered="true"`) {
+		t.Errorf("ending screen:\n%s", body)
+	}
+
+	// Snoozed there: the countdown shows here
+	publish("osaka", busSnooze, fc.now.Add(5*time.Minute).Unix())
+	eventually(t, "the snooze", shows(`id="snooze-banner"`))
+
+	// Reset there: the gauge starts over. The reset is done once it drops
+	// the cached counts
+	done := local.Subscribe(context.Background(), statusChannel(room))
+	defer done.Close()
+	if _, err := done.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	publish("osaka", busReset, nil)
+	if _, err := done.ReceiveMessage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the reset", shows(`data-triggered="false"`))
+	if strings.Contains(panel.poll(), `data-theme="minimal"`) {
+		t.Error("this region's own event was applied")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// RecoverMiddleware turns a handler panic into a 500 instead of a dropped
+// connection, logging the stack trace.
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec) // deliberate abort, let net/http handle it
+			}
+			metrics.Add("panics_recovered", 1)
+			logf(r.Context(), "panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			httpError(w, r.Context(), "Internal Server Error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// goSafe runs fn in a background goroutine. If fn panics, the panic is logged
+// and fn is restarted after a short delay; if fn returns normally it is done.
+func goSafe(name string, fn func()) {
+	go func() {
+		for !runRecovered(name, fn) {
+			time.Sleep(time.Second)
+		}
+	}()
+}
+
+// runRecovered calls fn and reports whether it returned without panicking.
+func runRecovered(name string, fn func()) (ok bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			metrics.Add("panics_recovered", 1)
+			log.Printf("panic in %s goroutine, restarting: %v\n%s", name, rec, debug.Stack())
+		}
+	}()
+	fn()
+	return true
+}
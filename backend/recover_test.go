@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	before := metricInt("panics_recovered")
+
+	h := RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/state", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+	if got := metricInt("panics_recovered"); got != before+1 {
+		t.Errorf("expected panic counter %d, got %d", before+1, got)
+	}
+}
+
+func TestGoSafeRestartsAfterPanic(t *testing.T) {
+	runs := make(chan int, 3)
+	n := 0
+	goSafe("test", func() {
+		n++
+		runs <- n
+		if n == 1 {
+			panic("first run fails")
+		}
+	})
+
+	for want := 1; want <= 2; want++ {
+		select {
+		case got := <-runs:
+			if got != want {
+				t.Fatalf("expected run %d, got %d", want, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("run %d did not happen", want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisOptions parses REDIS_URL and applies the pool settings.
+func (c *Config) redisOptions() (*redis.Options, error) {
+	return c.redisOptionsFor(c.RedisURL)
+}
+
+// redisOptionsFor parses url and applies the pool settings. Settings left
+// at zero keep what the URL says, or go-redis' default.
+func (c *Config) redisOptionsFor(url string) (*redis.Options, error) {
+	if c.RedisPoolSize < 0 || c.RedisMinIdleConns < 0 || c.RedisPoolTimeout < 0 ||
+		c.RedisDialTimeout < 0 || c.RedisReadTimeout < 0 || c.RedisWriteTimeout < 0 {
+		return nil, fmt.Errorf("redis: pool sizes and timeouts must not be negative")
+	}
+	if c.RedisPoolSize > 0 && c.RedisMinIdleConns > c.RedisPoolSize {
+		return nil, fmt.Errorf("redis: REDIS_MIN_IDLE_CONNS (%d) is larger than REDIS_POOL_SIZE (%d)", c.RedisMinIdleConns, c.RedisPoolSize)
+	}
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	setNonZero(&opt.PoolSize, c.RedisPoolSize)
+	setNonZero(&opt.MinIdleConns, c.RedisMinIdleConns)
+	setNonZero(&opt.PoolTimeout, c.RedisPoolTimeout)
+	setNonZero(&opt.DialTimeout, c.RedisDialTimeout)
+	setNonZero(&opt.ReadTimeout, c.RedisReadTimeout)
+	setNonZero(&opt.WriteTimeout, c.RedisWriteTimeout)
+	return opt, nil
+}
+
+func setNonZero[T int | time.Duration](dst *T, v T) {
+	if v != 0 {
+		*dst = v
+	}
+}
+
+// redisPoolStat publishes one pool statistic of the global client, 0
+// while there is none.
+func redisPoolStat(stat func(*redis.PoolStats, *redis.Options) float64) expvar.Func {
+	return func() any {
+		if rdb == nil {
+			return 0
+		}
+		return stat(rdb.PoolStats(), rdb.Options())
+	}
+}
+
+func init() {
+	metrics.Set("redis_pool_conns", redisPoolStat(func(s *redis.PoolStats, _ *redis.Options) float64 {
+		return float64(s.TotalConns)
+	}))
+	metrics.Set("redis_pool_idle_conns", redisPoolStat(func(s *redis.PoolStats, _ *redis.Options) float64 {
+		return float64(s.IdleConns)
+	}))
+	// Share of the pool in use; at 1 commands start queueing for a connection
+	metrics.Set("redis_pool_saturation", redisPoolStat(func(s *redis.PoolStats, opt *redis.Options) float64 {
+		if opt.PoolSize <= 0 || s.TotalConns < s.IdleConns {
+			return 0
+		}
+		return float64(s.TotalConns-s.IdleConns) / float64(opt.PoolSize)
+	}))
+	// Commands that had to wait for a connection, and those that gave up
+	// after REDIS_POOL_TIMEOUT
+	metrics.Set("redis_pool_waits", redisPoolStat(func(s *redis.PoolStats, _ *redis.Options) float64 {
+		return float64(s.WaitCount)
+	}))
+	metrics.Set("redis_pool_wait_seconds", redisPoolStat(func(s *redis.PoolStats, _ *redis.Options) float64 {
+		return time.Duration(s.WaitDurationNs).Seconds()
+	}))
+	metrics.Set("redis_pool_timeouts", redisPoolStat(func(s *redis.PoolStats, _ *redis.Options) float64 {
+		return float64(s.Timeouts)
+	}))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rdbReplicas are clients for the read replicas in REDIS_REPLICA_URLS.
+// Without any, every read goes to rdb.
+var rdbReplicas []*redis.Client
+
+// replicaReadsKey marks a request whose poll status reads may be served
+// by a replica.
+const replicaReadsKey ctxKey = "replicaReads"
+
+// withReplicaReads lets the poll status reads made with ctx go to a
+// replica. Only panels polling for the gauge get it: a replica may lag a
+// little behind the primary, and a panel that just voted must see its vote.
+func withReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey, true)
+}
+
+// initRedisReplicas connects to the replicas listed in cfg. Replicas that
+// cannot be reached at startup are left out.
+func initRedisReplicas(cfg *Config) {
+	if rdb == nil {
+		return
+	}
+	for _, url := range splitList(cfg.RedisReplicaURLs) {
+		opt, err := cfg.redisOptionsFor(url)
+		if err != nil {
+			log.Printf("Invalid Redis replica settings: %v. Ignoring the replica.", err)
+			continue
+		}
+		opt.MaxRetries = -1
+		client := redis.NewClient(opt)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = client.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			log.Printf("Warning: Failed to connect to Redis replica at %s, reading from the primary instead. Error: %v", opt.Addr, err)
+			client.Close()
+			continue
+		}
+		rdbReplicas = append(rdbReplicas, client)
+	}
+	if len(rdbReplicas) > 0 {
+		log.Printf("Reading poll status from %d Redis replica(s).", len(rdbReplicas))
+	}
+}
+
+// redisClients returns the primary client followed by the replicas and
+// the other regions' clients.
+func redisClients() []*redis.Client {
+	if rdb == nil {
+		return nil
+	}
+	clients := append([]*redis.Client{rdb}, rdbReplicas...)
+	for _, peer := range regionPeers {
+		clients = append(clients, peer.client)
+	}
+	return clients
+}
+
+// readRedis runs read against a random replica when ctx allows it, and
+// against the primary when it does not, there are no replicas, or the
+// replica fails.
+func readRedis(ctx context.Context, read func(*redis.Client) error) error {
+	if len(rdbReplicas) > 0 && ctx.Value(replicaReadsKey) != nil {
+		err := read(rdbReplicas[rand.Intn(len(rdbReplicas))])
+		if err == nil || errors.Is(err, redis.Nil) {
+			metrics.Add("redis_replica_reads", 1)
+			return err
+		}
+		metrics.Add("redis_replica_errors", 1)
+		debugf(ctx, "Replica read failed, retrying on the primary: %v", err)
+	}
+	return read(rdb)
+}
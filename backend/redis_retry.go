@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// retryHook bounds every Redis command with a per-attempt timeout and retries
+// transient failures with exponential backoff. All store commands (SADD,
+// SCARD, GET, SET, EXPIRE) are idempotent, so replaying them is safe.
+type retryHook struct {
+	timeout time.Duration // per attempt, 0 for none
+	retries int           // extra attempts after the first
+	backoff time.Duration // delay before the first retry, doubled each time
+}
+
+// isTransientRedisErr reports whether err is worth retrying: network errors,
+// timeouts, dropped connections, and servers that are loading or failing over.
+func isTransientRedisErr(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	for _, prefix := range []string{"LOADING", "TRYAGAIN", "MASTERDOWN", "CLUSTERDOWN", "READONLY"} {
+		if strings.HasPrefix(err.Error(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// do runs attempt until it succeeds, fails permanently, runs out of retries,
+// or ctx is done.
+func (h retryHook) do(ctx context.Context, attempt func(context.Context) error) error {
+	backoff := h.backoff
+	for i := 0; ; i++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if h.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, h.timeout)
+		}
+		err := attempt(attemptCtx)
+		timedOut := attemptCtx.Err() == context.DeadlineExceeded
+		cancel()
+
+		if err == nil || errors.Is(err, redis.Nil) {
+			return err
+		}
+		if timedOut {
+			metrics.Add("redis_timeouts", 1)
+		}
+		if i >= h.retries || ctx.Err() != nil || !isTransientRedisErr(err) {
+			metrics.Add("redis_errors", 1)
+			return err
+		}
+
+		metrics.Add("redis_retries", 1)
+		// Full jitter keeps instances from retrying in lockstep after a blip
+		delay := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			metrics.Add("redis_errors", 1)
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (h retryHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h retryHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return h.do(ctx, func(ctx context.Context) error {
+			return next(ctx, cmd)
+		})
+	}
+}
+
+func (h retryHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		return h.do(ctx, func(ctx context.Context) error {
+			return next(ctx, cmds)
+		})
+	}
+}
+
+// enableRedisRetries installs the timeout/retry hook on the global clients.
+// It must be added before enableRedisChaos so injected faults are retried
+// like real ones.
+func enableRedisRetries(timeout time.Duration, retries int, backoff time.Duration) {
+	for _, client := range redisClients() {
+		client.AddHook(retryHook{timeout: timeout, retries: retries, backoff: backoff})
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math"
-	"os"
-	"sync"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -15,47 +17,39 @@ import (
 var (
 	rdb      *redis.Client
 	useRedis bool
-	memRooms = sync.Map{} // map[string]*MemRoom
 )
 
-type MemRoom struct {
-	mu           sync.RWMutex
-	Participants map[string]bool
-	Votes        map[string]bool
-	Triggered    bool
+// redisStore keeps the shared state in Redis, one key per part of a room
+// so that instances change them with single commands and scripts.
+type redisStore struct {
+	rdb *redis.Client
 }
 
-func getMemRoom(mid string) *MemRoom {
-	val, _ := memRooms.LoadOrStore(mid, &MemRoom{
-		Participants: make(map[string]bool),
-		Votes:        make(map[string]bool),
-		Triggered:    false,
-	})
-	return val.(*MemRoom)
-}
+func (s redisStore) Name() string { return storeRedis }
 
-func initRedis() {
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
+func initRedis(cfg *Config) {
+	if cfg.RedisURL == "" {
 		log.Println("REDIS_URL not set. Falling back to in-memory store.")
 		useRedis = false
 		return
 	}
 
-	opt, err := redis.ParseURL(redisURL)
+	opt, err := cfg.redisOptions()
 	if err != nil {
-		log.Printf("Failed to parse REDIS_URL: %v. Falling back to in-memory store.", err)
+		log.Printf("Invalid Redis settings: %v. Falling back to in-memory store.", err)
 		useRedis = false
 		return
 	}
 
+	// Retries are done by retryHook, which counts them in metrics
+	opt.MaxRetries = -1
 	rdb = redis.NewClient(opt)
 
 	// Ping to ensure connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := rdb.Ping(ctx).Err(); err != nil {
-		log.Printf("Warning: Failed to connect to Redis at %s. Falling back to in-memory store. Error: %v", redisURL, err)
+		log.Printf("Warning: Failed to connect to Redis at %s. Falling back to in-memory store. Error: %v", opt.Addr, err)
 		useRedis = false
 		rdb.Close()
 		rdb = nil
@@ -67,120 +61,1256 @@ func initRedis() {
 
 const roomTTL = 24 * time.Hour
 
-func AddParticipant(ctx context.Context, mid, uid string) error {
-	if !useRedis {
-		rm := getMemRoom(mid)
-		rm.mu.Lock()
-		rm.Participants[uid] = true
-		rm.mu.Unlock()
-		return nil
+// keyPrefix namespaces every Redis key (e.g. "staging:") so several
+// environments can share one Redis.
+var keyPrefix string
+
+// roomKey returns the Redis key holding one part of a room's state.
+func roomKey(mid, part string) string {
+	return keyPrefix + "room:" + mid + ":" + part
+}
+
+// joinScript adds a participant the way AddParticipant did with a
+// pipeline, announces a new one on the room status channel, and returns
+// what evaluatePoll would otherwise read in another round trip: whether
+// the participant is new, the participant and vote counts, the passed
+// flag and whether the poll awaits a vote. It also keeps a room that is
+// waiting for the archive job from counting as idle.
+var joinScript = redis.NewScript(`
+local added = redis.call("SADD", KEYS[1], ARGV[1])
+redis.call("HSETNX", KEYS[2], ARGV[1], ARGV[2])
+redis.call("ZREM", KEYS[3], ARGV[1])
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+redis.call("EXPIRE", KEYS[2], ARGV[4])
+redis.call("SET", KEYS[4], ARGV[3], "NX", "EX", ARGV[4])
+if added == 1 and ARGV[5] ~= "" then
+	redis.call("PUBLISH", ARGV[5], ARGV[6])
+end
+local passed = 0
+if redis.call("GET", KEYS[6]) == "1" then
+	passed = 1
+end
+if ARGV[7] ~= "" then
+	redis.call("ZADD", KEYS[8], "XX", ARGV[2], ARGV[6])
+end
+return {added, redis.call("SCARD", KEYS[1]), redis.call("SCARD", KEYS[5]), passed, redis.call("EXISTS", KEYS[7])}
+`)
+
+func (s redisStore) JoinRoom(ctx context.Context, mid, uid string, p *Poll) (bool, error) {
+	pollID := defaultPollID
+	if p != nil {
+		pollID = p.ID
+	}
+	channel := ""
+	if statusCacheTTL > 0 {
+		channel = statusChannel(mid)
+	}
+	statuses := roomStatusesFor(mid)
+	_, gen, _ := statuses.get(pollID)
+	keys := []string{
+		roomKey(mid, "participants"), roomKey(mid, "joined"), roomKey(mid, "leaving"), roomKey(mid, "opened"),
+		pollKey(mid, pollID, "votes"), pollKey(mid, pollID, "passed"), pollKey(mid, pollID, "await_vote"), archiveKey(),
+	}
+	archiving := ""
+	if archiver != nil {
+		archiving = "1"
+	}
+	res, err := joinScript.Run(ctx, s.rdb, keys, uid, clock.Now().UnixMilli(), clock.Now().Unix(), int64(roomTTL/time.Second), channel, mid, archiving).Int64Slice()
+	if err != nil {
+		return false, err
 	}
+	joined := res[0] == 1
+	if joined {
+		// The script told the other instances
+		forgetRoomStatus(mid)
+		gen++
+	}
+	total, votes, passed := int(res[1]), int(res[2]), res[3] == 1
+	// The other regions' counts are not in here
+	if p == nil || p.MinPresence > 0 || statusCacheTTL <= 0 || len(regionPeers) > 0 {
+		return joined, nil
+	}
+	// Only cache counts evaluatePoll would do nothing with; a met
+	// threshold or a running hold still need it
+	if passed || (p.Hold <= 0 && !p.Threshold(total, votes)) {
+		statuses.put(pollID, gen, pollStatus{total: total, votes: votes, passed: passed})
+	}
+	return joined, nil
+}
 
-	pipe := rdb.Pipeline()
-	partKey := fmt.Sprintf("room:%s:participants", mid)
+// pollKey returns the Redis key of one part of a poll's state. The default
+// poll keeps the original room:{mid}:votes|triggered keys.
+func pollKey(mid, pollID, part string) string {
+	if pollID == defaultPollID {
+		if part == "passed" {
+			part = "triggered"
+		}
+		return roomKey(mid, part)
+	}
+	return roomKey(mid, "poll:"+pollID+":"+part)
+}
 
-	pipe.SAdd(ctx, partKey, uid)
-	pipe.Expire(ctx, partKey, roomTTL)
+func (s redisStore) RemoveParticipant(ctx context.Context, mid, uid string) error {
+	pipe := s.rdb.Pipeline()
+	pipe.SRem(ctx, roomKey(mid, "participants"), uid)
+	pipe.HDel(ctx, roomKey(mid, "joined"), uid)
+	markClosing(ctx, pipe, mid, clock.Now())
+	_, err := pipe.Exec(ctx)
+	invalidateRoomStatus(ctx, mid)
+	return err
+}
 
+func (s redisStore) LeaveParticipant(ctx context.Context, mid, uid string, at time.Time) error {
+	leaveKey := roomKey(mid, "leaving")
+	pipe := s.rdb.Pipeline()
+	pipe.ZAdd(ctx, leaveKey, redis.Z{Score: float64(at.UnixMilli()), Member: uid})
+	pipe.Expire(ctx, leaveKey, roomTTL)
+	markClosing(ctx, pipe, mid, at)
 	_, err := pipe.Exec(ctx)
 	return err
 }
 
-func RemoveParticipant(ctx context.Context, mid, uid string) error {
-	if !useRedis {
-		rm := getMemRoom(mid)
-		rm.mu.Lock()
-		delete(rm.Participants, uid)
-		rm.mu.Unlock()
-		return nil
+func (s redisStore) SettleLeaves(ctx context.Context, mid string, now time.Time) ([]string, error) {
+	leaveKey := roomKey(mid, "leaving")
+	due, err := s.rdb.ZRangeByScore(ctx, leaveKey, &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now.UnixMilli(), 10)}).Result()
+	if err != nil || len(due) == 0 {
+		return nil, err
+	}
+	members := make([]any, len(due))
+	for i, uid := range due {
+		members[i] = uid
+	}
+	pipe := s.rdb.Pipeline()
+	pipe.SRem(ctx, roomKey(mid, "participants"), members...)
+	pipe.HDel(ctx, roomKey(mid, "joined"), due...)
+	// Removed one by one, so a leave settled by two instances at once
+	// is only returned by the one whose ZREM took it
+	removed := make([]*redis.IntCmd, len(due))
+	for i, uid := range due {
+		removed[i] = pipe.ZRem(ctx, leaveKey, uid)
 	}
+	_, err = pipe.Exec(ctx)
+	invalidateRoomStatus(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+	var gone []string
+	for i, uid := range due {
+		if removed[i].Val() == 1 {
+			gone = append(gone, uid)
+		}
+	}
+	return gone, nil
+}
+
+// errPollPassed is returned by PollVote for votes arriving after the poll
+// passed.
+var errPollPassed = errors.New("poll has already passed")
 
-	partKey := fmt.Sprintf("room:%s:participants", mid)
-	return rdb.SRem(ctx, partKey, uid).Err()
+func (s redisStore) PollVote(ctx context.Context, mid string, p *Poll, uid string) (bool, error) {
+	passedKey := pollKey(mid, p.ID, "passed")
+	isPassed, err := s.rdb.Get(ctx, passedKey).Result()
+	if err == nil && isPassed == "1" {
+		return false, errPollPassed
+	}
+
+	voteKey := pollKey(mid, p.ID, "votes")
+	added, err := s.rdb.SAdd(ctx, voteKey, uid).Result()
+	if err != nil {
+		return false, err
+	}
+	awaited := s.rdb.Del(ctx, pollKey(mid, p.ID, "await_vote")).Val()
+	s.rdb.Expire(ctx, voteKey, roomTTL)
+	if added > 0 {
+		s.rdb.SetNX(ctx, pollKey(mid, p.ID, "first_vote"), clock.Now().Unix(), roomTTL)
+	}
+	if added > 0 || awaited > 0 {
+		invalidateRoomStatus(ctx, mid)
+	}
+
+	return added > 0, nil // True if it was a new vote
 }
 
-func Vote(ctx context.Context, mid, uid string) (bool, error) {
-	if !useRedis {
-		rm := getMemRoom(mid)
-		rm.mu.Lock()
-		defer rm.mu.Unlock()
+func (s redisStore) EvaluatePoll(ctx context.Context, mid string, p *Poll) (total, votes int, passed, fired bool, err error) {
+	statuses := roomStatusesFor(mid)
+	cached, gen, fresh := statuses.get(p.ID)
+	if fresh {
+		return cached.total, cached.votes, cached.passed, false, nil
+	}
 
-		if rm.Triggered {
-			return false, nil
+	// Read the counts from a replica if ctx allows it. They only decide
+	// what the panel shows: a lagging replica may still show the votes
+	// from before a reset, so claimIfMet checks the threshold again on
+	// the primary before anything fires.
+	var st redisPollState
+	err = readRedis(ctx, func(client *redis.Client) error {
+		var err error
+		st, err = readPollState(ctx, client.TxPipeline(), mid, p)
+		return err
+	})
+	if err != nil {
+		return 0, 0, false, false, err
+	}
+	var peerTotal, peerVotes int
+	if len(regionPeers) > 0 {
+		peerTotal, peerVotes = peerPollCounts(ctx, mid, p)
+	}
+
+	met := !st.passed && !st.awaiting && p.Threshold(st.total+peerTotal, st.votes+peerVotes)
+	if p.Hold > 0 && !st.passed {
+		if met, err = s.holdThreshold(ctx, pollKey(mid, p.ID, "met_since"), met, p.Hold); err != nil {
+			return 0, 0, false, false, err
 		}
-		if rm.Votes[uid] {
-			return false, nil
+	}
+	if met {
+		if st, fired, err = s.claimIfMet(ctx, mid, p, peerTotal, peerVotes); err != nil {
+			return 0, 0, false, false, err
 		}
-		rm.Votes[uid] = true
-		return true, nil
 	}
+	total, votes, passed = st.total+peerTotal, st.votes+peerVotes, st.passed
 
-	trigKey := fmt.Sprintf("room:%s:triggered", mid)
-	isTriggered, err := rdb.Get(ctx, trigKey).Result()
-	if err == nil && isTriggered == "1" {
-		return false, nil // Already triggered, vote ignored
+	if fired {
+		invalidateRoomStatus(ctx, mid)
+	} else if statusCacheTTL > 0 {
+		statuses.put(p.ID, gen, pollStatus{total: total, votes: votes, passed: passed})
 	}
+	return total, votes, passed, fired, nil
+}
 
-	voteKey := fmt.Sprintf("room:%s:votes", mid)
-	added, err := rdb.SAdd(ctx, voteKey, uid).Result()
+// claimIfMet reads poll p again on the primary and marks it passed if its
+// threshold is still met there, reporting whether this call did it. The
+// poll's keys are watched from the read to the claim, so a vote, reset or
+// AwaitVote in between leaves the poll to the next evaluation. Across
+// regions the claim region's Redis takes the claim, see claimPoll.
+func (s redisStore) claimIfMet(ctx context.Context, mid string, p *Poll, peerTotal, peerVotes int) (st redisPollState, fired bool, err error) {
+	passedKey := pollKey(mid, p.ID, "passed")
+	keys := []string{roomKey(mid, "participants"), pollKey(mid, p.ID, "votes"), passedKey, pollKey(mid, p.ID, "await_vote")}
+	err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		if st, err = readPollState(ctx, tx.Pipeline(), mid, p); err != nil {
+			return err
+		}
+		if st.passed || st.awaiting || !p.Threshold(st.total+peerTotal, st.votes+peerVotes) {
+			return nil
+		}
+		if len(regionPeers) > 0 {
+			fired, err = claimPoll(ctx, mid, p.ID)
+		} else {
+			var claimed *redis.BoolCmd
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				claimed = pipe.SetNX(ctx, passedKey, "1", roomTTL)
+				return nil
+			})
+			fired = err == nil && claimed.Val()
+		}
+		st.passed = err == nil
+		return err
+	}, keys...)
+	if errors.Is(err, redis.TxFailedErr) {
+		return st, false, nil
+	}
+	return st, fired, err
+}
+
+// redisPollState is what evaluatePoll reads about a poll from one Redis.
+type redisPollState struct {
+	total, votes     int
+	passed, awaiting bool
+}
+
+// readPollState reads poll p through pipe, a transaction for a consistent
+// read or a plain pipeline inside a WATCH.
+func readPollState(ctx context.Context, pipe redis.Pipeliner, mid string, p *Poll) (redisPollState, error) {
+	voteKey := pollKey(mid, p.ID, "votes")
+	totalCmd := pipe.SCard(ctx, roomKey(mid, "participants"))
+	votesCmd := pipe.SCard(ctx, voteKey)
+	passedCmd := pipe.Get(ctx, pollKey(mid, p.ID, "passed"))
+	awaitCmd := pipe.Exists(ctx, pollKey(mid, p.ID, "await_vote"))
+	var votersCmd *redis.StringSliceCmd
+	var joinedCmd *redis.MapStringStringCmd
+	if p.MinPresence > 0 {
+		votersCmd = pipe.SMembers(ctx, voteKey)
+		joinedCmd = pipe.HGetAll(ctx, roomKey(mid, "joined"))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return redisPollState{}, err // missing keys return 0/redis.Nil, anything else is a real failure
+	}
+
+	st := redisPollState{
+		total:    int(totalCmd.Val()),
+		votes:    int(votesCmd.Val()),
+		passed:   passedCmd.Val() == "1",
+		awaiting: awaitCmd.Val() != 0,
+	}
+	if p.MinPresence > 0 {
+		st.votes = 0
+		before := clock.Now().Add(-p.MinPresence).UnixMilli()
+		joined := joinedCmd.Val()
+		for _, uid := range votersCmd.Val() {
+			if at, err := strconv.ParseInt(joined[uid], 10, 64); err == nil && at <= before {
+				st.votes++
+			}
+		}
+	}
+	return st, nil
+}
+
+func (s redisStore) AwaitVote(ctx context.Context, mid, pollID string) error {
+	defer invalidateRoomStatus(ctx, mid)
+	return s.rdb.Set(ctx, pollKey(mid, pollID, "await_vote"), "1", roomTTL).Err()
+}
+
+// holdThreshold reports whether a threshold met now has been met since at
+// least hold ago, keeping when it was first met in key and forgetting that
+// as soon as it is not.
+func (s redisStore) holdThreshold(ctx context.Context, key string, met bool, hold time.Duration) (bool, error) {
+	if !met {
+		return false, s.rdb.Del(ctx, key).Err()
+	}
+	now := clock.Now()
+	pipe := s.rdb.TxPipeline()
+	pipe.SetNX(ctx, key, now.UnixMilli(), roomTTL)
+	sinceCmd := pipe.Get(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	since, err := sinceCmd.Int64()
 	if err != nil {
 		return false, err
 	}
-	rdb.Expire(ctx, voteKey, roomTTL)
+	return now.Sub(time.UnixMilli(since)) >= hold, nil
+}
 
-	return added > 0, nil // True if it was a new vote
+func (s redisStore) ActivePoll(ctx context.Context, mid string) (string, error) {
+	id, err := s.rdb.Get(ctx, roomKey(mid, "activepoll")).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s redisStore) StartPoll(ctx context.Context, mid string, p *Poll) error {
+	prev, err := s.rdb.Get(ctx, roomKey(mid, "activepoll")).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	if prev != "" {
+		pipe.Del(ctx, pollKey(mid, prev, "votes"), pollKey(mid, prev, "passed"))
+	}
+	if p != nil {
+		pipe.Del(ctx, pollKey(mid, p.ID, "votes"), pollKey(mid, p.ID, "passed"))
+		pipe.Set(ctx, roomKey(mid, "activepoll"), p.ID, roomTTL)
+	} else {
+		pipe.Del(ctx, roomKey(mid, "activepoll"))
+	}
+	_, err = pipe.Exec(ctx)
+	invalidateRoomStatus(ctx, mid)
+	return err
+}
+
+func (s redisStore) ResetRoom(ctx context.Context, mid string) error {
+	defer invalidateRoomStatus(ctx, mid)
+	sendRegionEvent(ctx, busReset, mid, nil)
+	return s.rdb.Del(ctx, roomKey(mid, "votes"), roomKey(mid, "triggered"), roomKey(mid, "first_vote"), roomKey(mid, "names"), roomKey(mid, "met_since"), roomKey(mid, "await_vote"),
+		roomKey(mid, "snooze"), pollKey(mid, snoozePoll.ID, "votes"), pollKey(mid, snoozePoll.ID, "passed")).Err()
+}
+
+func (s redisStore) SetNamedMode(ctx context.Context, mid string, on bool) error {
+	if on {
+		return s.rdb.Set(ctx, roomKey(mid, "named"), "1", roomTTL).Err()
+	}
+	return s.rdb.Del(ctx, roomKey(mid, "named"), roomKey(mid, "names")).Err()
+}
+
+func (s redisStore) VoterNames(ctx context.Context, mid string) (bool, map[string]string, map[string]bool, error) {
+	pipe := s.rdb.Pipeline()
+	namedCmd := pipe.Get(ctx, roomKey(mid, "named"))
+	namesCmd := pipe.HGetAll(ctx, roomKey(mid, "names"))
+	votesCmd := pipe.SMembers(ctx, roomKey(mid, "votes"))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, nil, nil, err
+	}
+	votes := make(map[string]bool)
+	for _, uid := range votesCmd.Val() {
+		votes[uid] = true
+	}
+	return namedCmd.Val() == "1", namesCmd.Val(), votes, nil
+}
+
+func (s redisStore) DenyParticipant(ctx context.Context, mid, uid string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, roomKey(mid, "denied"), uid)
+	pipe.Expire(ctx, roomKey(mid, "denied"), roomTTL)
+	pipe.SRem(ctx, roomKey(mid, "participants"), uid)
+	pipe.HDel(ctx, roomKey(mid, "joined"), uid)
+	pipe.ZRem(ctx, roomKey(mid, "leaving"), uid)
+	pipe.HDel(ctx, roomKey(mid, "names"), uid)
+	for id := range polls {
+		pipe.SRem(ctx, pollKey(mid, id, "votes"), uid)
+	}
+	_, err := pipe.Exec(ctx)
+	invalidateRoomStatus(ctx, mid)
+	return err
 }
 
-func CheckTriggerStatus(ctx context.Context, mid string) (int, int, bool, error) {
-	if !useRedis {
-		rm := getMemRoom(mid)
-		rm.mu.Lock()
-		defer rm.mu.Unlock()
+func (s redisStore) IsDenied(ctx context.Context, mid, uid string) (bool, error) {
+	return s.rdb.SIsMember(ctx, roomKey(mid, "denied"), uid).Result()
+}
 
-		total := len(rm.Participants)
-		votes := len(rm.Votes)
+func (s redisStore) ClearDenied(ctx context.Context, mid string) error {
+	return s.rdb.Del(ctx, roomKey(mid, "denied")).Err()
+}
 
-		if rm.Triggered {
-			return total, votes, true, nil
+func (s redisStore) SetVoterName(ctx context.Context, mid, uid, name string) error {
+	if named, err := s.rdb.Get(ctx, roomKey(mid, "named")).Result(); err != nil || named != "1" {
+		if errors.Is(err, redis.Nil) {
+			return nil
 		}
+		return err
+	}
+	pipe := s.rdb.Pipeline()
+	pipe.HSet(ctx, roomKey(mid, "names"), uid, name)
+	pipe.Expire(ctx, roomKey(mid, "names"), roomTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s redisStore) RoomActions(ctx context.Context, mid string) ([]string, error) {
+	v, err := s.rdb.Get(ctx, roomKey(mid, "actions")).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if actions := splitList(v); actions != nil {
+		return actions, nil
+	}
+	return []string{}, nil
+}
+
+func (s redisStore) SetRoomActions(ctx context.Context, mid string, actions []string) error {
+	if actions == nil {
+		return s.rdb.Del(ctx, roomKey(mid, "actions")).Err()
+	}
+	// A lone comma keeps an empty list distinct from a missing key
+	return s.rdb.Set(ctx, roomKey(mid, "actions"), ","+strings.Join(actions, ","), roomTTL).Err()
+}
+
+func (s redisStore) SnoozedUntil(ctx context.Context, mid string) (time.Time, error) {
+	v, err := s.rdb.Get(ctx, roomKey(mid, "snooze")).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(v, 0), nil
+}
 
-		if total > 0 {
-			threshold := int(math.Ceil(float64(total) / 2.0))
-			if votes >= threshold && votes > 0 {
-				rm.Triggered = true
+func (s redisStore) Snooze(ctx context.Context, mid string, until time.Time) error {
+	sendRegionEvent(ctx, busSnooze, mid, until.Unix())
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, roomKey(mid, "snooze"), until.Unix(), roomTTL)
+	pipe.Del(ctx, pollKey(mid, snoozePoll.ID, "votes"), pollKey(mid, snoozePoll.ID, "passed"))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// errSettingsConflict is returned by SetRoomSettings when the room's
+// settings changed since the version the caller read.
+var errSettingsConflict = errors.New("room settings were changed concurrently")
+
+// RoomSettingsOverride reads the settings from a hash with the JSON under
+// "data" and a counter under "version".
+func (s redisStore) RoomSettingsOverride(ctx context.Context, mid string) (RoomSettings, int64, error) {
+	var rs RoomSettings
+	vals, err := s.rdb.HMGet(ctx, roomKey(mid, "settings"), "data", "version").Result()
+	if err != nil {
+		return rs, 0, err
+	}
+	data, _ := vals[0].(string)
+	v, _ := vals[1].(string)
+	version, _ := strconv.ParseInt(v, 10, 64)
+	if data != "" {
+		err = json.Unmarshal([]byte(data), &rs)
+	}
+	return rs, version, err
+}
+
+func (s redisStore) SetRoomSettings(ctx context.Context, mid string, rs RoomSettings, expect int64) (int64, error) {
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return 0, err
+	}
+	key := roomKey(mid, "settings")
+	var version int64
+	err = s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		if expect >= 0 {
+			cur, err := tx.HGet(ctx, key, "version").Int64()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			if cur != expect {
+				return errSettingsConflict
 			}
 		}
+		var incr *redis.IntCmd
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "data", b)
+			incr = pipe.HIncrBy(ctx, key, "version", 1)
+			pipe.Expire(ctx, key, roomTTL)
+			return nil
+		})
+		version = incr.Val()
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return 0, errSettingsConflict
+	}
+	// The poll's threshold and presence rule come from the settings
+	invalidateRoomStatus(ctx, mid)
+	if err == nil {
+		sendRegionEvent(ctx, busSettings, mid, rs)
+	}
+	return version, err
+}
 
-		return total, votes, rm.Triggered, nil
+func (s redisStore) RoomTimeline(ctx context.Context, mid string) (opened, firstVote time.Time, err error) {
+	vals, err := s.rdb.MGet(ctx, roomKey(mid, "opened"), pollKey(mid, defaultPollID, "first_vote")).Result()
+	if err != nil {
+		return opened, firstVote, err
+	}
+	unix := func(v any) time.Time {
+		str, _ := v.(string)
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return time.Unix(n, 0)
+		}
+		return time.Time{}
 	}
+	return unix(vals[0]), unix(vals[1]), nil
+}
 
-	partKey := fmt.Sprintf("room:%s:participants", mid)
-	voteKey := fmt.Sprintf("room:%s:votes", mid)
-	trigKey := fmt.Sprintf("room:%s:triggered", mid)
+// reportTTL is how long meeting reports outlive their room, for the stats
+// API.
+const reportTTL = 30 * 24 * time.Hour
 
-	// Fetch all state
-	pipe := rdb.TxPipeline()
-	totalCmd := pipe.SCard(ctx, partKey)
-	votesCmd := pipe.SCard(ctx, voteKey)
-	trigCmd := pipe.Get(ctx, trigKey)
-	_, _ = pipe.Exec(ctx) // Ignoring exec error as missing keys return 0/redis.Nil
+// reportKey is outside the room's keys so the report survives the room.
+func reportKey(mid string) string {
+	return keyPrefix + "report:" + mid
+}
 
-	total := int(totalCmd.Val())
-	votes := int(votesCmd.Val())
-	triggered := trigCmd.Val() == "1"
+// reportLogKey is the sorted set of a tenant's reports by trigger time,
+// for digests and rollups. The purge job trims it.
+func reportLogKey(tenant string) string {
+	return keyPrefix + "report_log:" + tenant
+}
 
-	if triggered {
-		return total, votes, true, nil
+func (s redisStore) SaveReport(ctx context.Context, mid string, r, logged *MeetingReport) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	lb, err := json.Marshal(logged)
+	if err != nil {
+		return err
 	}
+	pipe := s.rdb.Pipeline()
+	pipe.Set(ctx, reportKey(mid), b, reportTTL)
+	markArchivable(ctx, pipe, mid)
+	pipe.ZAdd(ctx, reportLogKey(r.Tenant), redis.Z{Score: float64(r.TriggeredAt.Unix()), Member: lb})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s redisStore) ReportsBetween(ctx context.Context, tenant string, from, to time.Time) ([]*MeetingReport, error) {
+	vals, err := s.rdb.ZRangeByScore(ctx, reportLogKey(tenant), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.Unix(), 10),
+		Max: "(" + strconv.FormatInt(to.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var reports []*MeetingReport
+	for _, v := range vals {
+		var r MeetingReport
+		if err := json.Unmarshal([]byte(v), &r); err != nil {
+			return nil, err
+		}
+		reports = append(reports, &r)
+	}
+	return reports, nil
+}
+
+func (s redisStore) PurgeReports(ctx context.Context, tenant string, cutoff time.Time) (int64, error) {
+	return s.rdb.ZRemRangeByScore(ctx, reportLogKey(tenant), "-inf", "("+strconv.FormatInt(cutoff.Unix(), 10)).Result()
+}
+
+func (s redisStore) RoomReport(ctx context.Context, mid string) (*MeetingReport, error) {
+	b, err := s.rdb.Get(ctx, reportKey(mid)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r MeetingReport
+	return &r, json.Unmarshal(b, &r)
+}
+
+// reportOptOutKey is the Redis set of unsubscribed hosts. Opt-outs never
+// expire.
+func reportOptOutKey() string {
+	return keyPrefix + "report_optout"
+}
+
+func (s redisStore) ReportOptOut(ctx context.Context, id string) (bool, error) {
+	return s.rdb.SIsMember(ctx, reportOptOutKey(), id).Result()
+}
+
+func (s redisStore) SetReportOptOut(ctx context.Context, id string, out bool) error {
+	if out {
+		return s.rdb.SAdd(ctx, reportOptOutKey(), id).Err()
+	}
+	return s.rdb.SRem(ctx, reportOptOutKey(), id).Err()
+}
+
+// seriesTTL is how long a meeting series is kept after its last occurrence.
+const seriesTTL = 180 * 24 * time.Hour
 
-	if total > 0 {
-		threshold := int(math.Ceil(float64(total) / 2.0))
-		if votes >= threshold && votes > 0 {
-			// Threshold met, mark as triggered
-			rdb.Set(ctx, trigKey, "1", roomTTL)
-			triggered = true
+func seriesKey(series string) string {
+	return keyPrefix + "series:" + series
+}
+
+func (s redisStore) RecordSeriesEntry(ctx context.Context, series string, e SeriesEntry, overwrite bool) (bool, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	pipe := s.rdb.Pipeline()
+	var added *redis.BoolCmd
+	var set *redis.IntCmd
+	if overwrite {
+		set = pipe.HSet(ctx, seriesKey(series), e.Date, b)
+	} else {
+		added = pipe.HSetNX(ctx, seriesKey(series), e.Date, b)
+	}
+	pipe.Expire(ctx, seriesKey(series), seriesTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if overwrite {
+		return set.Val() == 1, nil
+	}
+	return added.Val(), nil
+}
+
+func (s redisStore) SeriesEntries(ctx context.Context, series string) ([]SeriesEntry, error) {
+	vals, err := s.rdb.HVals(ctx, seriesKey(series)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var entries []SeriesEntry
+	for _, v := range vals {
+		var e SeriesEntry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			return nil, err
 		}
+		entries = append(entries, e)
 	}
+	return entries, nil
+}
 
-	return total, votes, triggered, nil
+func (s redisStore) AddVoteSample(ctx context.Context, mid string, vs VoteSample, interval time.Duration) (bool, error) {
+	ok, err := s.rdb.SetNX(ctx, roomKey(mid, "sample_lock"), 1, interval).Result()
+	if err != nil || !ok {
+		return false, err
+	}
+	b, err := json.Marshal(vs)
+	if err != nil {
+		return false, err
+	}
+	key := roomKey(mid, "samples")
+	pipe := s.rdb.Pipeline()
+	pipe.LPush(ctx, key, b)
+	pipe.LTrim(ctx, key, 0, maxSamples-1)
+	pipe.Expire(ctx, key, roomTTL)
+	markArchivable(ctx, pipe, mid)
+	_, err = pipe.Exec(ctx)
+	return err == nil, err
+}
+
+func (s redisStore) VoteSamples(ctx context.Context, mid string) ([]VoteSample, error) {
+	vals, err := s.rdb.LRange(ctx, roomKey(mid, "samples"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]VoteSample, len(vals))
+	for i, v := range vals {
+		// LPUSH keeps the newest first
+		if err := json.Unmarshal([]byte(v), &samples[len(vals)-1-i]); err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
+
+// rollupKey is the hash of a tenant's daily rollups by date.
+func rollupKey(tenant string) string {
+	return keyPrefix + "rollup:" + tenant
+}
+
+func (s redisStore) SaveRollup(ctx context.Context, tenant string, ru ReportRollup) error {
+	b, err := json.Marshal(ru)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, rollupKey(tenant), ru.Date, b).Err()
+}
+
+func (s redisStore) Rollups(ctx context.Context, tenant string) ([]ReportRollup, error) {
+	vals, err := s.rdb.HVals(ctx, rollupKey(tenant)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var rollups []ReportRollup
+	for _, v := range vals {
+		var ru ReportRollup
+		if err := json.Unmarshal([]byte(v), &ru); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, ru)
+	}
+	return rollups, nil
+}
+
+func (s redisStore) PurgeRollups(ctx context.Context, tenant, date string) (int64, error) {
+	days, err := s.rdb.HKeys(ctx, rollupKey(tenant)).Result()
+	if err != nil {
+		return 0, err
+	}
+	old := slices.DeleteFunc(days, func(d string) bool { return d >= date })
+	if len(old) == 0 {
+		return 0, nil
+	}
+	return s.rdb.HDel(ctx, rollupKey(tenant), old...).Result()
+}
+
+func schedulerLeaderKey() string {
+	return keyPrefix + "scheduler:leader"
+}
+
+// acquireLeaderScript takes the leader lock when it is free and renews it
+// when id already holds it.
+var acquireLeaderScript = redis.NewScript(`
+local cur = redis.call("GET", KEYS[1])
+if cur == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if not cur then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func (s redisStore) AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	n, err := acquireLeaderScript.Run(ctx, s.rdb, []string{schedulerLeaderKey()}, id, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s redisStore) ClaimJobRun(ctx context.Context, job string, due time.Time) (bool, error) {
+	return s.rdb.SetNX(ctx, jobRunKey(job, due), 1, jobRunTTL).Result()
+}
+
+// jobRunTTL keeps job run claims past the longest schedule interval.
+const jobRunTTL = 8 * 24 * time.Hour
+
+func jobRunKey(job string, due time.Time) string {
+	return keyPrefix + "scheduler:run:" + job + ":" + strconv.FormatInt(due.Unix(), 10)
+}
+
+// heldTTL keeps the per-day held meeting counters long enough for the
+// rollup job to recompute past days.
+const heldTTL = 30 * 24 * time.Hour
+
+func heldKey(tenant, date string) string {
+	return keyPrefix + "held:" + tenant + ":" + date
+}
+
+func (s redisStore) IncrHeldMeetings(ctx context.Context, tenant, date string) error {
+	pipe := s.rdb.Pipeline()
+	pipe.Incr(ctx, heldKey(tenant, date))
+	pipe.Expire(ctx, heldKey(tenant, date), heldTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s redisStore) HeldMeetings(ctx context.Context, tenant, date string) (int, error) {
+	n, err := s.rdb.Get(ctx, heldKey(tenant, date)).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// usageTTL keeps a tenant's monthly counters for a year of invoices.
+const usageTTL = 400 * 24 * time.Hour
+
+// usageKey holds a tenant's metering: sorted sets "rooms" and "panels" by
+// last connection, and a hash per month of counters.
+func usageKey(tenant, part string) string {
+	return keyPrefix + "usage:" + tenant + ":" + part
+}
+
+// ConnectedUsage is what a tenant has connected since some time.
+type ConnectedUsage struct {
+	Meetings, Participants int
+	// RoomActive and Connected tell whether the room and panel asked about
+	// are among them
+	RoomActive, Connected bool
+}
+
+func (s redisStore) MarkConnected(ctx context.Context, tenant, mid, uid string, at, idleBefore time.Time) error {
+	cutoff := "(" + strconv.FormatInt(idleBefore.UnixMilli(), 10)
+	score := float64(at.UnixMilli())
+	pipe := s.rdb.Pipeline()
+	for key, member := range map[string]string{usageKey(tenant, "rooms"): mid, usageKey(tenant, "panels"): mid + " " + uid} {
+		pipe.ZAdd(ctx, key, redis.Z{Score: score, Member: member})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", cutoff)
+		pipe.Expire(ctx, key, roomTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s redisStore) TenantConnections(ctx context.Context, tenant, mid, uid string, since time.Time) (ConnectedUsage, error) {
+	var cu ConnectedUsage
+	from := strconv.FormatInt(since.UnixMilli(), 10)
+	pipe := s.rdb.Pipeline()
+	meetings := pipe.ZCount(ctx, usageKey(tenant, "rooms"), from, "+inf")
+	participants := pipe.ZCount(ctx, usageKey(tenant, "panels"), from, "+inf")
+	room := pipe.ZScore(ctx, usageKey(tenant, "rooms"), mid)
+	panel := pipe.ZScore(ctx, usageKey(tenant, "panels"), mid+" "+uid)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return cu, err
+	}
+	cu.Meetings, cu.Participants = int(meetings.Val()), int(participants.Val())
+	cu.RoomActive = room.Err() == nil && room.Val() >= float64(since.UnixMilli())
+	cu.Connected = panel.Err() == nil && panel.Val() >= float64(since.UnixMilli())
+	return cu, nil
+}
+
+func (s redisStore) IncrMonthlyTriggers(ctx context.Context, tenant, month string) error {
+	pipe := s.rdb.Pipeline()
+	pipe.HIncrBy(ctx, usageKey(tenant, month), "triggers", 1)
+	pipe.Expire(ctx, usageKey(tenant, month), usageTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s redisStore) MonthlyTriggers(ctx context.Context, tenant, month string) (int, error) {
+	n, err := s.rdb.HGet(ctx, usageKey(tenant, month), "triggers").Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// benchmarkKey is the hash of the cross-tenant benchmark pool by date. It
+// holds no tenant IDs.
+func benchmarkKey() string {
+	return keyPrefix + "benchmark"
+}
+
+func (s redisStore) PutBenchmarkDay(ctx context.Context, date string, day *BenchmarkDay) error {
+	if day == nil {
+		return s.rdb.HDel(ctx, benchmarkKey(), date).Err()
+	}
+	b, err := json.Marshal(day)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, benchmarkKey(), date, b).Err()
+}
+
+func (s redisStore) BenchmarkDays(ctx context.Context) ([]BenchmarkDay, error) {
+	vals, err := s.rdb.HVals(ctx, benchmarkKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	days := make([]BenchmarkDay, len(vals))
+	for i, v := range vals {
+		if err := json.Unmarshal([]byte(v), &days[i]); err != nil {
+			return nil, err
+		}
+	}
+	return days, nil
+}
+
+func (s redisStore) PurgeBenchmarkDays(ctx context.Context, date string) (int64, error) {
+	days, err := s.rdb.HKeys(ctx, benchmarkKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+	old := slices.DeleteFunc(days, func(d string) bool { return d >= date })
+	if len(old) == 0 {
+		return 0, nil
+	}
+	return s.rdb.HDel(ctx, benchmarkKey(), old...).Result()
+}
+
+func resumeKey(tokenHash string) string {
+	return keyPrefix + "resume:" + tokenHash
+}
+
+func (s redisStore) SaveResumeSession(ctx context.Context, tokenHash string, rs *resumeSession, ttl time.Duration) error {
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, resumeKey(tokenHash), b, ttl).Err()
+}
+
+func (s redisStore) ResumeSession(ctx context.Context, tokenHash string, ttl time.Duration) (*resumeSession, error) {
+	b, err := s.rdb.GetEx(ctx, resumeKey(tokenHash), ttl).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rs resumeSession
+	return &rs, json.Unmarshal(b, &rs)
+}
+
+func (s redisStore) AppendRoomEvent(ctx context.Context, mid, typ string, at time.Time) (string, error) {
+	key := roomKey(mid, "events")
+	pipe := s.rdb.Pipeline()
+	add := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: maxRoomEvents,
+		Approx: true,
+		Values: map[string]any{"type": typ, "at": at.UnixMilli()},
+	})
+	pipe.Expire(ctx, key, roomTTL)
+	markArchivable(ctx, pipe, mid)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return add.Val(), nil
+}
+
+func (s redisStore) RoomEventsSince(ctx context.Context, mid, since string) ([]RoomEvent, error) {
+	key := roomKey(mid, "events")
+	var msgs []redis.XMessage
+	var err error
+	if since == "" {
+		msgs, err = s.rdb.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	} else {
+		msgs, err = s.rdb.XRange(ctx, key, since, "+").Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := make([]RoomEvent, 0, len(msgs))
+	for _, m := range msgs {
+		if m.ID == since {
+			continue // XRANGE is inclusive
+		}
+		typ, _ := m.Values["type"].(string)
+		at, _ := strconv.ParseInt(fmt.Sprint(m.Values["at"]), 10, 64)
+		events = append(events, RoomEvent{ID: m.ID, Type: typ, At: time.UnixMilli(at)})
+	}
+	return events, nil
+}
+
+// actionScript counts one action in a fixed window, starting the window
+// with the first, and returns the count and the window's time left.
+var actionScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {n, redis.call("PTTL", KEYS[1])}
+`)
+
+func (s redisStore) CountAction(ctx context.Context, mid, kind, uid string, window time.Duration) (int, time.Duration, error) {
+	res, err := actionScript.Run(ctx, s.rdb, []string{roomKey(mid, kind+"actions:"+uid)}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(res[0]), time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func (s redisStore) ClaimVoteKey(ctx context.Context, mid, uid, key string, ttl time.Duration) (string, bool, error) {
+	k := roomKey(mid, "votekey:"+uid+":"+key)
+	taken, err := s.rdb.SetNX(ctx, k, "", ttl).Result()
+	if err != nil || taken {
+		return "", taken, err
+	}
+	ack, err := s.rdb.Get(ctx, k).Result()
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	return ack, false, err
+}
+
+func (s redisStore) SettleVoteKey(ctx context.Context, mid, uid, key, ack string) error {
+	k := roomKey(mid, "votekey:"+uid+":"+key)
+	if ack == "" {
+		return s.rdb.Del(ctx, k).Err()
+	}
+	return s.rdb.SetArgs(ctx, k, ack, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+}
+
+// archiveKey is the sorted set of rooms with a history to archive, scored
+// by their last use in Unix milliseconds.
+func archiveKey() string {
+	return keyPrefix + "archive"
+}
+
+// markArchivable records in pipe that the room has a history for the
+// archive job. It does nothing when archiving is off.
+func markArchivable(ctx context.Context, pipe redis.Pipeliner, mid string) {
+	if archiver == nil {
+		return
+	}
+	pipe.ZAdd(ctx, archiveKey(), redis.Z{Score: float64(clock.Now().UnixMilli()), Member: mid})
+}
+
+func (s redisStore) ArchiveCandidates(ctx context.Context, idleBefore time.Time, limit int) ([]string, error) {
+	return s.rdb.ZRangeByScore(ctx, archiveKey(), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(idleBefore.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+// globEscaper escapes the characters Redis MATCH patterns treat specially.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (s redisStore) PurgeRoom(ctx context.Context, mid string) error {
+	// Poll and action keys have IDs in their names, so they are found by
+	// pattern
+	iter := s.rdb.Scan(ctx, 0, globEscaper.Replace(roomKey(mid, ""))+"*", 1000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	pipe := s.rdb.Pipeline()
+	for chunk := range slices.Chunk(keys, 500) {
+		pipe.Del(ctx, chunk...)
+	}
+	pipe.ZRem(ctx, archiveKey(), mid)
+	pipe.ZRem(ctx, closingKey(), mid)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	invalidateRoomStatus(ctx, mid)
+	return nil
+}
+
+// closingKey is the sorted set of rooms whose participants may all have
+// left, scored by when the last of their leaves takes effect in Unix
+// milliseconds.
+func closingKey() string {
+	return keyPrefix + "closing"
+}
+
+// markClosing records in pipe that a participant leaves the room at the
+// given time, so the room is looked at once it may be empty.
+func markClosing(ctx context.Context, pipe redis.Pipeliner, mid string, at time.Time) {
+	pipe.ZAddGT(ctx, closingKey(), redis.Z{Score: float64(at.UnixMilli()), Member: mid})
+}
+
+func (s redisStore) ClosingRooms(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return s.rdb.ZRangeByScore(ctx, closingKey(), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+// claimEmptyScript takes the room off the closing set and returns 1 and
+// the room's participants if every one of them has left by ARGV[2]. A
+// room with a participant who has not left is dropped from the set; one
+// whose leaves are still in their grace is put back for the last of them.
+var claimEmptyScript = redis.NewScript(`
+local uids = redis.call("SMEMBERS", KEYS[1])
+local last = 0
+for _, uid in ipairs(uids) do
+	local at = redis.call("ZSCORE", KEYS[2], uid)
+	if not at then
+		redis.call("ZREM", KEYS[3], ARGV[1])
+		return {0}
+	end
+	last = math.max(last, tonumber(at))
+end
+if last > tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[3], last, ARGV[1])
+	return {0}
+end
+if redis.call("ZREM", KEYS[3], ARGV[1]) == 0 then
+	return {0}
+end
+table.insert(uids, 1, 1)
+return uids
+`)
+
+func (s redisStore) ClaimEmptyRoom(ctx context.Context, mid string, now time.Time) (bool, []string, error) {
+	keys := []string{roomKey(mid, "participants"), roomKey(mid, "leaving"), closingKey()}
+	res, err := claimEmptyScript.Run(ctx, s.rdb, keys, mid, now.UnixMilli()).Slice()
+	if err != nil || len(res) == 0 || res[0] != int64(1) {
+		return false, nil, err
+	}
+	uids := make([]string, 0, len(res)-1)
+	for _, uid := range res[1:] {
+		id, _ := uid.(string)
+		uids = append(uids, id)
+	}
+	return true, uids, nil
+}
+
+// liveRoom is what the status dashboard shows of one room.
+type liveRoom struct {
+	Room         string    `json:"room"`
+	OpenedAt     time.Time `json:"opened_at,omitzero"`
+	Participants int       `json:"participants"`
+	Votes        int       `json:"votes"`
+	Triggered    bool      `json:"triggered"`
+}
+
+// LiveRooms scans Redis for the rooms' opened keys.
+func (s redisStore) LiveRooms(ctx context.Context, limit int) ([]liveRoom, bool, error) {
+	prefix := keyPrefix + "room:"
+	iter := s.rdb.Scan(ctx, 0, globEscaper.Replace(prefix)+"*:opened", 1000).Iterator()
+	var mids []string
+	more := false
+	for iter.Next(ctx) {
+		if len(mids) == limit {
+			more = true
+			break
+		}
+		mids = append(mids, strings.TrimSuffix(strings.TrimPrefix(iter.Val(), prefix), ":opened"))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, false, err
+	}
+	pipe := s.rdb.Pipeline()
+	type roomCmds struct {
+		opened, triggered *redis.StringCmd
+		total, votes      *redis.IntCmd
+	}
+	cmds := make([]roomCmds, len(mids))
+	for i, mid := range mids {
+		cmds[i] = roomCmds{
+			opened:    pipe.Get(ctx, roomKey(mid, "opened")),
+			triggered: pipe.Get(ctx, pollKey(mid, defaultPollID, "passed")),
+			total:     pipe.SCard(ctx, roomKey(mid, "participants")),
+			votes:     pipe.SCard(ctx, pollKey(mid, defaultPollID, "votes")),
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
+	rooms := make([]liveRoom, 0, len(mids))
+	for i, mid := range mids {
+		r := liveRoom{
+			Room:         mid,
+			Participants: int(cmds[i].total.Val()),
+			Votes:        int(cmds[i].votes.Val()),
+			Triggered:    cmds[i].triggered.Val() == "1",
+		}
+		if n, err := strconv.ParseInt(cmds[i].opened.Val(), 10, 64); err == nil {
+			r.OpenedAt = time.Unix(n, 0)
+		}
+		rooms = append(rooms, r)
+	}
+	return rooms, more, nil
+}
+
+// instancesKey is the hash of the instances' last heartbeats, by ID.
+func instancesKey() string {
+	return keyPrefix + "instances"
+}
+
+func (s redisStore) PutInstanceStatus(ctx context.Context, st *instanceStatus, staleBefore time.Time) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := s.rdb.HSet(ctx, instancesKey(), st.ID, b).Err(); err != nil {
+		return err
+	}
+	all, err := s.InstanceStatuses(ctx)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, other := range all {
+		if other.Seen.Before(staleBefore) {
+			stale = append(stale, other.ID)
+		}
+	}
+	if len(stale) > 0 {
+		return s.rdb.HDel(ctx, instancesKey(), stale...).Err()
+	}
+	return nil
+}
+
+func (s redisStore) InstanceStatuses(ctx context.Context) ([]instanceStatus, error) {
+	vals, err := s.rdb.HGetAll(ctx, instancesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]instanceStatus, 0, len(vals))
+	for id, val := range vals {
+		var st instanceStatus
+		if err := json.Unmarshal([]byte(val), &st); err != nil {
+			log.Printf("Skipping unreadable heartbeat of instance %s: %v", id, err)
+			continue
+		}
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// maxRecentTriggers is how many trigger events the status dashboard keeps.
+const maxRecentTriggers = 50
+
+func recentTriggersKey() string {
+	return keyPrefix + "recent-triggers"
+}
+
+func (s redisStore) RecordTrigger(ctx context.Context, ev TriggerEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.LPush(ctx, recentTriggersKey(), b)
+	pipe.LTrim(ctx, recentTriggersKey(), 0, maxRecentTriggers-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s redisStore) RecentTriggers(ctx context.Context) ([]TriggerEvent, error) {
+	vals, err := s.rdb.LRange(ctx, recentTriggersKey(), 0, maxRecentTriggers-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]TriggerEvent, 0, len(vals))
+	for _, val := range vals {
+		var ev TriggerEvent
+		if err := json.Unmarshal([]byte(val), &ev); err == nil {
+			events = append(events, ev)
+		}
+	}
+	return events, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"testing"
 	"time"
 
@@ -98,3 +99,250 @@ func TestTTLSet(t *testing.T) {
 		t.Errorf("Data did not expire after 24h: got total %d, votes %d", total, votes)
 	}
 }
+
+func TestChaosHookSurfacesErrors(t *testing.T) {
+	mr, client := setupTestRedis()
+	defer mr.Close()
+
+	rdb = client
+	ctx := context.Background()
+	client.AddHook(chaosHook{errorRate: 1})
+
+	if err := AddParticipant(ctx, "chaosRoom", "u1"); err == nil {
+		t.Errorf("expected injected error from AddParticipant")
+	}
+	if _, err := Vote(ctx, "chaosRoom", "u1"); err == nil {
+		t.Errorf("expected injected error from Vote")
+	}
+	if _, _, _, err := CheckTriggerStatus(ctx, "chaosRoom"); err == nil {
+		t.Errorf("expected injected error from CheckTriggerStatus")
+	}
+}
+
+func TestKeyPrefixSeparatesEnvironments(t *testing.T) {
+	mr, client := setupTestRedis()
+	defer mr.Close()
+	rdb = client
+	ctx := context.Background()
+	t.Cleanup(func() { keyPrefix = "" })
+
+	keyPrefix = "staging:"
+	AddParticipant(ctx, "shared", "u1")
+	Vote(ctx, "shared", "u1")
+	if !mr.Exists("staging:room:shared:votes") {
+		t.Fatalf("expected prefixed key, have %v", mr.Keys())
+	}
+
+	keyPrefix = "prod:"
+	total, votes, triggered, err := CheckTriggerStatus(ctx, "shared")
+	if err != nil || total != 0 || votes != 0 || triggered {
+		t.Errorf("prod saw staging state: total %d, votes %d, triggered %t, err %v", total, votes, triggered, err)
+	}
+}
+
+func TestUIDsArePseudonymizedAtRest(t *testing.T) {
+	mr, client := setupTestRedis()
+	defer mr.Close()
+	rdb = client
+	ctx := context.Background()
+
+	AddParticipant(ctx, "roomA", "zoom-uid-1")
+	Vote(ctx, "roomA", "zoom-uid-1")
+	for _, key := range []string{roomKey("roomA", "participants"), roomKey("roomA", "votes")} {
+		members, err := mr.Members(key)
+		if err != nil || len(members) != 1 {
+			t.Fatalf("%s: members %v, err %v", key, members, err)
+		}
+		if members[0] == "zoom-uid-1" || members[0] != pseudonymize("roomA", "zoom-uid-1") {
+			t.Errorf("%s holds %q, want the room pseudonym", key, members[0])
+		}
+	}
+
+	if pseudonymize("roomA", "zoom-uid-1") == pseudonymize("roomB", "zoom-uid-1") {
+		t.Error("pseudonyms must differ between rooms")
+	}
+}
+
+// flakyHook fails the first n commands or pipelines with a timeout.
+type flakyHook struct{ n *int }
+
+func (h flakyHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+func (h flakyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if *h.n > 0 {
+			*h.n--
+			cmd.SetErr(errChaosTimeout)
+			return errChaosTimeout
+		}
+		return next(ctx, cmd)
+	}
+}
+func (h flakyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if *h.n > 0 {
+			*h.n--
+			return errChaosTimeout
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestRetryHookRecoversTransientErrors(t *testing.T) {
+	mr, client := setupTestRedis()
+	defer mr.Close()
+	rdb = client
+	ctx := context.Background()
+
+	failures := 2
+	client.AddHook(retryHook{timeout: time.Second, retries: 2, backoff: time.Millisecond})
+	client.AddHook(flakyHook{&failures})
+
+	retries := metricInt("redis_retries")
+	if err := AddParticipant(ctx, "retryRoom", "u1"); err != nil {
+		t.Fatalf("AddParticipant after 2 transient failures: %v", err)
+	}
+	if got := metricInt("redis_retries") - retries; got != 2 {
+		t.Errorf("redis_retries grew by %d, want 2", got)
+	}
+
+	failures = 3
+	errs := metricInt("redis_errors")
+	if _, _, _, err := CheckTriggerStatus(ctx, "retryRoom"); err == nil {
+		t.Error("expected failure once retries are exhausted")
+	}
+	if metricInt("redis_errors") != errs+1 {
+		t.Error("exhausted retries should count one redis_errors")
+	}
+}
+
+func TestRetryHookTimesOutHungRedis(t *testing.T) {
+	mr, client := setupTestRedis()
+	defer mr.Close()
+	rdb = client
+
+	client.AddHook(retryHook{timeout: 20 * time.Millisecond, retries: 1, backoff: time.Millisecond})
+	client.AddHook(chaosHook{maxLatency: time.Hour})
+
+	start := time.Now()
+	timeouts := metricInt("redis_timeouts")
+	if _, err := Vote(context.Background(), "hungRoom", "u1"); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Vote took %v against a hung Redis", elapsed)
+	}
+	if metricInt("redis_timeouts") < timeouts+2 {
+		t.Error("each timed out attempt should count in redis_timeouts")
+	}
+}
+
+func TestRedisPoolOptions(t *testing.T) {
+	cfg := &Config{RedisURL: "redis://localhost:6379/0?pool_size=7&dial_timeout=2s"}
+	opt, err := cfg.redisOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.PoolSize != 7 || opt.DialTimeout != 2*time.Second {
+		t.Errorf("URL settings not kept: pool %d, dial %s", opt.PoolSize, opt.DialTimeout)
+	}
+
+	cfg.RedisPoolSize, cfg.RedisMinIdleConns, cfg.RedisReadTimeout = 50, 5, 300*time.Millisecond
+	if opt, _ = cfg.redisOptions(); opt.PoolSize != 50 || opt.MinIdleConns != 5 || opt.ReadTimeout != 300*time.Millisecond || opt.DialTimeout != 2*time.Second {
+		t.Errorf("overrides: %+v", opt)
+	}
+
+	cfg.RedisMinIdleConns = 51
+	if _, err := cfg.redisOptions(); err == nil {
+		t.Error("more idle connections than the pool accepted")
+	}
+	cfg.RedisMinIdleConns, cfg.RedisWriteTimeout = 0, -time.Second
+	if _, err := cfg.redisOptions(); err == nil {
+		t.Error("negative timeout accepted")
+	}
+}
+
+func TestRedisPoolSaturationMetric(t *testing.T) {
+	mr, client := setupTestRedis()
+	client.Close()
+	client = redis.NewClient(&redis.Options{Addr: mr.Addr(), PoolSize: 4})
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	saturation := func() float64 { return metrics.Get("redis_pool_saturation").(expvar.Func)().(float64) }
+
+	if got := saturation(); got != 0 {
+		t.Errorf("idle pool saturation = %v", got)
+	}
+	// Hold two connections from the pool
+	ctx := context.Background()
+	for range 2 {
+		conn := client.Conn()
+		if err := conn.Ping(ctx).Err(); err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+	if got := saturation(); got != 0.5 {
+		t.Errorf("saturation with 2 of 4 in use = %v", got)
+	}
+}
+
+func TestReplicaReads(t *testing.T) {
+	mr, client := setupTestRedis()
+	replica, replicaClient := setupTestRedis()
+	rdb = client
+	rdbReplicas = []*redis.Client{replicaClient}
+	t.Cleanup(func() {
+		mr.Close()
+		client.Close()
+		replica.Close()
+		replicaClient.Close()
+		rdb, rdbReplicas, useRedis = nil, nil, false
+	})
+	useFakeClock(t)
+	ctx := context.Background()
+	room := "replica-room"
+	AddParticipant(ctx, room, "u1")
+	AddParticipant(ctx, room, "u2")
+
+	// The replica has not caught up yet; only panel polls read from it
+	if total, _, _, _ := CheckTriggerStatus(ctx, room); total != 2 {
+		t.Errorf("primary read: %d participants", total)
+	}
+	forgetRoomStatus(room)
+	if total, _, _, _ := CheckTriggerStatus(withReplicaReads(ctx), room); total != 0 {
+		t.Errorf("replica read: %d participants", total)
+	}
+
+	// A replica still showing the votes from before a reset does not fire
+	forgetRoomStatus(room)
+	replica.SAdd(roomKey(room, "participants"), "u1", "u2")
+	replica.SAdd(pollKey(room, defaultPollID, "votes"), "u1", "u2")
+	if _, _, triggered, err := CheckTriggerStatus(withReplicaReads(ctx), room); err != nil || triggered {
+		t.Fatalf("stale replica: triggered %t, %v", triggered, err)
+	}
+	if mr.Exists(pollKey(room, defaultPollID, "passed")) {
+		t.Error("stale replica marked the poll passed on the primary")
+	}
+
+	// Once the primary has the votes too, the poll is marked passed there
+	Vote(ctx, room, "u1")
+	Vote(ctx, room, "u2")
+	forgetRoomStatus(room)
+	if _, _, triggered, err := CheckTriggerStatus(withReplicaReads(ctx), room); err != nil || !triggered {
+		t.Fatalf("triggered %t, %v", triggered, err)
+	}
+	if v, _ := mr.Get(pollKey(room, defaultPollID, "passed")); v != "1" {
+		t.Errorf("passed flag on the primary = %q", v)
+	}
+
+	// A failing replica falls back to the primary
+	replicaClient.Close()
+	forgetRoomStatus(room)
+	errs := metricInt("redis_replica_errors")
+	if total, _, _, err := CheckTriggerStatus(withReplicaReads(ctx), room); err != nil || total != 2 {
+		t.Errorf("after replica failure: %d participants, %v", total, err)
+	}
+	if metricInt("redis_replica_errors") != errs+1 {
+		t.Error("replica failure not counted")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Multi-region mode: every region runs its own Redis holding the presence
+// and votes of the panels it serves, so polling stays in the region. The
+// gauge adds up the counts of all regions, reading the other regions'
+// Redis at most once per statusCacheTTL. What has to agree everywhere
+// (polls passing, resets, snoozes and room settings) is sent to the other
+// regions over the region bus, a pub/sub channel on each region's Redis.
+
+// regionPeer is another region and its Redis.
+type regionPeer struct {
+	name   string
+	client *redis.Client
+}
+
+var (
+	// region names this instance's region, empty outside multi-region mode
+	region      string
+	regionPeers []regionPeer
+
+	regionLabel = new(expvar.String)
+)
+
+func init() {
+	metrics.Set("region", regionLabel)
+}
+
+// Region bus event types
+const (
+	busStatus   = "status"   // counts changed, drop cached ones
+	busPassed   = "passed"   // Data is the poll ID
+	busReset    = "reset"    // the room was reset
+	busSnooze   = "snooze"   // Data is the Unix time the snooze ends
+	busSettings = "settings" // Data is the room's RoomSettings
+)
+
+type regionEvent struct {
+	ID     string          `json:"id"`
+	Origin string          `json:"origin"`
+	Type   string          `json:"type"`
+	Room   string          `json:"room"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// regionBusKey marks the context of writes applied from the region bus,
+// so they are not sent back out.
+const regionBusKey ctxKey = "regionBus"
+
+func regionBusChannel() string {
+	return keyPrefix + "region-bus"
+}
+
+// parseRegionPeers parses REGION_PEERS, name=redis-url pairs separated by
+// commas.
+func parseRegionPeers(s string) (map[string]string, error) {
+	peers := map[string]string{}
+	for _, pair := range splitList(s) {
+		name, url, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("REGION_PEERS: want name=redis-url, got %q", pair)
+		}
+		if _, dup := peers[name]; dup {
+			return nil, fmt.Errorf("REGION_PEERS: region %q listed twice", name)
+		}
+		peers[name] = strings.TrimSpace(url)
+	}
+	return peers, nil
+}
+
+// initRegions connects to the other regions' Redis. Without Redis there is
+// nothing to share and the peers are ignored.
+func initRegions(cfg *Config) error {
+	region = cfg.Region
+	regionLabel.Set(region)
+	if cfg.RegionPeers == "" {
+		return nil
+	}
+	peers, err := parseRegionPeers(cfg.RegionPeers)
+	if err != nil {
+		return err
+	}
+	if region == "" {
+		return fmt.Errorf("REGION must be set when REGION_PEERS is")
+	}
+	if _, ok := peers[region]; ok {
+		return fmt.Errorf("REGION_PEERS lists this instance's own region %q", region)
+	}
+	if rdb == nil {
+		log.Println("WARNING: REGION_PEERS is set but Redis is not; running as a single region.")
+		return nil
+	}
+	for name, url := range peers {
+		opt, err := cfg.redisOptionsFor(url)
+		if err != nil {
+			return fmt.Errorf("REGION_PEERS: region %s: %w", name, err)
+		}
+		opt.MaxRetries = -1
+		regionPeers = append(regionPeers, regionPeer{name: name, client: redis.NewClient(opt)})
+	}
+	log.Printf("Region %s, sharing rooms with %d other region(s).", region, len(regionPeers))
+	return nil
+}
+
+// claimRegion is the region whose Redis decides which instance fires a
+// poll: the first region name in order, the same for everyone.
+func claimRegion() *regionPeer {
+	var first *regionPeer
+	for i, p := range regionPeers {
+		if p.name < region && (first == nil || p.name < first.name) {
+			first = &regionPeers[i]
+		}
+	}
+	return first
+}
+
+// claimPoll marks poll pollID of the room passed and reports whether this
+// call did it. Across regions the claim is taken in the claim region's
+// Redis, then recorded here and sent to the others.
+func claimPoll(ctx context.Context, mid, pollID string) (bool, error) {
+	passedKey := pollKey(mid, pollID, "passed")
+	peer := claimRegion()
+	if peer == nil {
+		fired, err := rdb.SetNX(ctx, passedKey, "1", roomTTL).Result()
+		if fired {
+			sendRegionEvent(ctx, busPassed, mid, pollID)
+		}
+		return fired, err
+	}
+	fired, err := peer.client.SetNX(ctx, passedKey, "1", roomTTL).Result()
+	if err != nil {
+		metrics.Add("region_claim_errors", 1)
+		return false, fmt.Errorf("claim in region %s: %w", peer.name, err)
+	}
+	if err := rdb.Set(ctx, passedKey, "1", roomTTL).Err(); err != nil {
+		return false, err
+	}
+	if fired {
+		sendRegionEvent(ctx, busPassed, mid, pollID)
+	}
+	return fired, nil
+}
+
+// peerPollCounts adds up the participants and votes of poll p in the other
+// regions. A region that cannot be read counts as empty until it can.
+func peerPollCounts(ctx context.Context, mid string, p *Poll) (total, votes int) {
+	for _, peer := range regionPeers {
+		statuses := peerStatusesFor(peer.name + "\x00" + mid)
+		if cached, _, fresh := statuses.get(p.ID); fresh {
+			total += cached.total
+			votes += cached.votes
+			continue
+		}
+		_, gen, _ := statuses.get(p.ID)
+		st, err := readPollState(ctx, peer.client.TxPipeline(), mid, p)
+		if err != nil {
+			metrics.Add("region_peer_errors", 1)
+			logf(ctx, "Reading region %s: %v", peer.name, err)
+			continue
+		}
+		total += st.total
+		votes += st.votes
+		if statusCacheTTL > 0 {
+			statuses.put(p.ID, gen, pollStatus{total: st.total, votes: st.votes})
+		}
+	}
+	return total, votes
+}
+
+// sendRegionEvent publishes an event about the room to the other regions,
+// unless ctx is applying one that came from there.
+func sendRegionEvent(ctx context.Context, typ, mid string, data any) {
+	if ctx.Value(regionBusKey) != nil || len(regionPeers) == 0 {
+		return
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		logf(ctx, "Region event error: %v", err)
+		return
+	}
+	msg, _ := json.Marshal(regionEvent{ID: newRequestID(), Origin: region, Type: typ, Room: mid, Data: b})
+	for _, peer := range regionPeers {
+		if err := peer.client.Publish(ctx, regionBusChannel(), msg).Err(); err != nil {
+			metrics.Add("region_peer_errors", 1)
+			logf(ctx, "Region event to %s error: %v", peer.name, err)
+			continue
+		}
+		metrics.Add("region_events_sent", 1)
+	}
+}
+
+// applyRegionEvent applies an event from another region. Every instance
+// in the region gets it and drops its cached counts; the write it carries
+// is made once, by the instance that claims the event ID.
+func applyRegionEvent(payload string) error {
+	var ev regionEvent
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		return err
+	}
+	if ev.Origin == region || ev.Room == "" {
+		return nil
+	}
+	metrics.Add("region_events_received", 1)
+	forgetRoomStatus(ev.Origin + "\x00" + ev.Room)
+	forgetRoomStatus(ev.Room)
+	if ev.Type == busStatus {
+		return nil
+	}
+	ctx := context.WithValue(context.Background(), regionBusKey, ev.Origin)
+	if first, err := rdb.SetNX(ctx, keyPrefix+"region-event:"+ev.ID, "1", time.Hour).Result(); err != nil || !first {
+		return err
+	}
+
+	switch ev.Type {
+	case busPassed:
+		var pollID string
+		if err := json.Unmarshal(ev.Data, &pollID); err != nil {
+			return err
+		}
+		defer invalidateRoomStatus(ctx, ev.Room)
+		return rdb.Set(ctx, pollKey(ev.Room, pollID, "passed"), "1", roomTTL).Err()
+	case busReset:
+		return ResetRoom(ctx, ev.Room)
+	case busSnooze:
+		var until int64
+		if err := json.Unmarshal(ev.Data, &until); err != nil {
+			return err
+		}
+		defer invalidateRoomStatus(ctx, ev.Room)
+		return Snooze(ctx, ev.Room, time.Unix(until, 0))
+	case busSettings:
+		var s RoomSettings
+		if err := json.Unmarshal(ev.Data, &s); err != nil {
+			return err
+		}
+		_, err := SetRoomSettings(ctx, ev.Room, s, -1)
+		return err
+	}
+	return fmt.Errorf("unknown region event %q", ev.Type)
+}
+
+// subscribeRegionBus applies the events the other regions send. Events
+// missed while the subscription reconnects are not replayed; counts catch
+// up within statusCacheTTL, and a missed pass is claimed again here.
+func subscribeRegionBus() {
+	if len(regionPeers) == 0 {
+		return
+	}
+	goSafe("region-bus", func() {
+		sub := rdb.Subscribe(context.Background(), regionBusChannel())
+		defer sub.Close()
+		for msg := range sub.Channel() {
+			if err := applyRegionEvent(msg.Payload); err != nil {
+				log.Printf("Region event error: %v", err)
+			}
+		}
+	})
+}
+
+// closeRegions closes the clients of the other regions.
+func closeRegions() {
+	for _, peer := range regionPeers {
+		peer.client.Close()
+	}
+	regionPeers = nil
+}
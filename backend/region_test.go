@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// useRegions makes this instance region name, with its own Redis and the
+// given other regions. It returns this region's Redis.
+func useRegions(t *testing.T, name string, peers ...string) (*miniredis.Miniredis, map[string]*miniredis.Miniredis) {
+	mr, client := setupTestRedis()
+	rdb, region = client, name
+	others := map[string]*miniredis.Miniredis{}
+	for _, peer := range peers {
+		peerMR, peerClient := setupTestRedis()
+		others[peer] = peerMR
+		regionPeers = append(regionPeers, regionPeer{name: peer, client: peerClient})
+	}
+	t.Cleanup(func() {
+		closeRegions()
+		for _, peerMR := range others {
+			peerMR.Close()
+		}
+		mr.Close()
+		client.Close()
+		rdb, region, useRedis = nil, "", false
+	})
+	return mr, others
+}
+
+// inRegion runs fn as an instance of another region would, against that
+// region's Redis.
+func inRegion(peer *miniredis.Miniredis, fn func()) {
+	client := redis.NewClient(&redis.Options{Addr: peer.Addr()})
+	defer client.Close()
+	saved, savedPeers := rdb, regionPeers
+	rdb, regionPeers = client, nil
+	defer func() { rdb, regionPeers = saved, savedPeers }()
+	fn()
+}
+
+func TestRegionsShareCounts(t *testing.T) {
+	useFakeClock(t)
+	mr, peers := useRegions(t, "tokyo", "osaka")
+	ctx := context.Background()
+	room := "region-room"
+
+	AddParticipant(ctx, room, "u1")
+	AddParticipant(ctx, room, "u2")
+	inRegion(peers["osaka"], func() {
+		AddParticipant(ctx, room, "u3")
+		AddParticipant(ctx, room, "u4")
+		PollVote(ctx, room, defaultPoll, "u3")
+	})
+	if total, votes, triggered, err := CheckTriggerStatus(ctx, room); err != nil || total != 4 || votes != 1 || triggered {
+		t.Fatalf("status = %d/%d %t, %v", votes, total, triggered, err)
+	}
+
+	// Votes in both regions pass the poll. Osaka sorts first, so the claim
+	// is made there
+	inRegion(peers["osaka"], func() { PollVote(ctx, room, defaultPoll, "u4") })
+	PollVote(ctx, room, defaultPoll, "u1")
+	forgetRoomStatus("osaka\x00" + room)
+	_, _, _, fired, err := evaluatePoll(ctx, room, defaultPoll)
+	if err != nil || !fired {
+		t.Fatalf("fired %t, %v", fired, err)
+	}
+	passedKey := pollKey(room, defaultPollID, "passed")
+	if v, _ := peers["osaka"].Get(passedKey); v != "1" {
+		t.Errorf("claim region passed = %q", v)
+	}
+	if v, _ := mr.Get(passedKey); v != "1" {
+		t.Errorf("local passed = %q", v)
+	}
+	if _, _, _, fired, _ := evaluatePoll(ctx, room, defaultPoll); fired {
+		t.Error("fired twice")
+	}
+}
+
+func TestRegionBus(t *testing.T) {
+	useFakeClock(t)
+	mr, peers := useRegions(t, "tokyo", "osaka")
+	ctx := context.Background()
+	room := "region-bus-room"
+
+	// A reset here is sent to the other region
+	osaka := redis.NewClient(&redis.Options{Addr: peers["osaka"].Addr()})
+	defer osaka.Close()
+	sub := osaka.Subscribe(ctx, regionBusChannel())
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ResetRoom(ctx, room)
+	var ev regionEvent
+	for ev.Type != busReset {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal([]byte(msg.Payload), &ev)
+	}
+	if ev.Origin != "tokyo" || ev.Room != room {
+		t.Errorf("event = %+v", ev)
+	}
+
+	// A pass from the other region is recorded here, once, and not sent back
+	event := func(typ string, data any) string {
+		b, _ := json.Marshal(data)
+		msg, _ := json.Marshal(regionEvent{ID: newRequestID(), Origin: "osaka", Type: typ, Room: room, Data: b})
+		return string(msg)
+	}
+	passed := event(busPassed, defaultPollID)
+	sent := metricInt("region_events_sent")
+	if err := applyRegionEvent(passed); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mr.Get(pollKey(room, defaultPollID, "passed")); v != "1" {
+		t.Errorf("passed = %q", v)
+	}
+	if metricInt("region_events_sent") != sent {
+		t.Error("applied event sent back out")
+	}
+	mr.Del(pollKey(room, defaultPollID, "passed"))
+	if err := applyRegionEvent(passed); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(pollKey(room, defaultPollID, "passed")) {
+		t.Error("event applied twice")
+	}
+
+	// Settings changed there are stored here
+	s := *defaultSettings.Load()
+	s.Theme = "night"
+	if err := applyRegionEvent(event(busSettings, s)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := RoomSettingsOverride(ctx, room); got.Theme != "night" {
+		t.Errorf("theme = %q", got.Theme)
+	}
+}
+
+func TestParseRegionPeers(t *testing.T) {
+	peers, err := parseRegionPeers("osaka=redis://osaka:6379, us = redis://us:6379/1")
+	if err != nil || len(peers) != 2 || peers["us"] != "redis://us:6379/1" {
+		t.Errorf("peers = %v, %v", peers, err)
+	}
+	for _, bad := range []string{"osaka", "=redis://x", "a=redis://x,a=redis://y"} {
+		if _, err := parseRegionPeers(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// reloadableFields are the Config fields a reload applies while serving.
+// Changes to any other field are reported and wait for a restart.
+var reloadableFields = map[string]bool{
+	"TriggerThreshold": true,
+	"MinPresence":      true,
+	"TriggerHold":      true,
+	"DepartureRule":    true,
+	"GaugeStages":      true,
+	"AmbienceStages":   true,
+	"EndingText":       true,
+	"EndingSubtext":    true,
+	"Theme":            true,
+	"FeatureFlags":     true,
+	"SeasonsFile":      true,
+	"LogLevel":         true,
+}
+
+var (
+	// reloadArgs are the serve command line, parsed again on each reload
+	// so flags keep overriding CONFIG_FILE
+	reloadArgs []string
+
+	// liveConfig is the configuration being served, reloaded fields
+	// included
+	liveConfig atomic.Pointer[Config]
+
+	reloadMu sync.Mutex
+)
+
+// readConfigFile parses KEY=VALUE lines. Blank lines and lines starting
+// with # are skipped; values may be double-quoted.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+// loadConfigArgs builds the configuration for a subcommand from the
+// environment, CONFIG_FILE and args.
+func loadConfigArgs(name string, args []string) (*Config, error) {
+	parse := func() (*Config, error) {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		cfg := loadConfig(fs)
+		return cfg, fs.Parse(args)
+	}
+	configFileValues.Store(nil)
+	cfg, err := parse()
+	if err != nil || cfg.ConfigFile == "" {
+		return cfg, err
+	}
+	values, err := readConfigFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+	configFileValues.Store(&values)
+	return parse()
+}
+
+// applyReloadable puts the settings defaults and seasons of cfg into
+// effect, both or, if either is invalid, neither.
+func applyReloadable(cfg *Config) error {
+	var list []*Season
+	if cfg.SeasonsFile != "" {
+		var err error
+		if list, err = readSeasonsFile(cfg.SeasonsFile); err != nil {
+			return err
+		}
+	}
+	if err := configureDefaultSettings(cfg); err != nil {
+		return err
+	}
+	useSeasons(list)
+	return nil
+}
+
+// ReloadResult lists the Config fields a reload found changed.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// reloadConfig reads the configuration again and applies what can change
+// while serving. Seasons are re-read even if SEASONS_FILE is unchanged.
+func reloadConfig() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	cur := liveConfig.Load()
+	if cur == nil {
+		return nil, errors.New("server not started")
+	}
+	next, err := loadConfigArgs("serve", reloadArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	applied := *cur
+	curV, nextV, appliedV := reflect.ValueOf(cur).Elem(), reflect.ValueOf(next).Elem(), reflect.ValueOf(&applied).Elem()
+	for i := range curV.NumField() {
+		name := curV.Type().Field(i).Name
+		if reflect.DeepEqual(curV.Field(i).Interface(), nextV.Field(i).Interface()) {
+			continue
+		}
+		if reloadableFields[name] {
+			appliedV.Field(i).Set(nextV.Field(i))
+			res.Applied = append(res.Applied, name)
+		} else {
+			res.RestartRequired = append(res.RestartRequired, name)
+		}
+	}
+	debug, err := parseLogLevel(applied.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	if err := applyReloadable(&applied); err != nil {
+		return nil, err
+	}
+	// Only a changed LOG_LEVEL overrides a level set at runtime
+	if applied.LogLevel != cur.LogLevel {
+		debugLogging.Store(debug)
+	}
+	liveConfig.Store(&applied)
+	metrics.Add("config_reloads", 1)
+	log.Printf("Configuration reloaded: changed %v, restart required for %v", res.Applied, res.RestartRequired)
+	return res, nil
+}
+
+// watchConfigReload reloads the configuration on SIGHUP.
+func watchConfigReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	goSafe("config-reloader", func() {
+		for range hup {
+			if _, err := reloadConfig(); err != nil {
+				metrics.Add("config_reload_errors", 1)
+				log.Printf("Configuration reload failed, keeping the previous one: %v", err)
+			}
+		}
+	})
+}
+
+// handleAdminReload serves POST /admin/reload, the same as SIGHUP but
+// answering with what changed or why nothing did.
+func handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := reloadConfig()
+	if err != nil {
+		metrics.Add("config_reload_errors", 1)
+		logf(ctx, "Configuration reload failed: %v", err)
+		httpError(w, ctx, "Reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestConfigReload(t *testing.T) {
+	settings, rollout, level := defaultSettings.Load(), featureRollout.Load(), logLevel()
+	t.Cleanup(func() {
+		defaultSettings.Store(settings)
+		featureRollout.Store(rollout)
+		setLogLevel(level)
+		liveConfig.Store(nil)
+		configFileValues.Store(nil)
+		reloadArgs = nil
+	})
+
+	path := filepath.Join(t.TempDir(), "hotaru.env")
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("ENDING_TEXT", "from the environment")
+	os.WriteFile(path, []byte("# thresholds\nTRIGGER_THRESHOLD=60\n"), 0o600)
+	reloadArgs = []string{"-port", "7000"}
+	cfg, err := loadConfigArgs("serve", reloadArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TriggerThreshold != 60 || cfg.EndingText != "from the environment" || cfg.Port != "7000" {
+		t.Fatalf("config = %v %q %q", cfg.TriggerThreshold, cfg.EndingText, cfg.Port)
+	}
+	configureDefaultSettings(cfg)
+	liveConfig.Store(cfg)
+
+	reload := func() (int, ReloadResult) {
+		rec := httptest.NewRecorder()
+		handleAdminReload(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+		var res ReloadResult
+		json.NewDecoder(rec.Body).Decode(&res)
+		return rec.Code, res
+	}
+
+	// The file wins over the environment, flags over the file
+	os.WriteFile(path, []byte("TRIGGER_THRESHOLD=75\nENDING_TEXT=\"閉店です\"\nLOG_LEVEL=debug\nPORT=9999\nFEATURE_FLAGS=snooze=off\n"), 0o600)
+	code, res := reload()
+	if code != http.StatusOK {
+		t.Fatalf("reload = %d", code)
+	}
+	slices.Sort(res.Applied)
+	if !slices.Equal(res.Applied, []string{"EndingText", "FeatureFlags", "LogLevel", "TriggerThreshold"}) || len(res.RestartRequired) != 0 {
+		t.Errorf("result = %+v", res)
+	}
+	s := defaultSettings.Load()
+	if s.ThresholdPercent != 75 || s.EndingText != "閉店です" || logLevel() != logLevelDebug || (*featureRollout.Load())["snooze"] != 0 {
+		t.Errorf("applied = %+v, log level %s", s, logLevel())
+	}
+	if live := liveConfig.Load(); live.TriggerThreshold != 75 || live.Port != "7000" {
+		t.Errorf("live config = %v %q", live.TriggerThreshold, live.Port)
+	}
+
+	// Settings that need a restart are reported, not applied
+	reloadArgs = nil
+	if _, res := reload(); !slices.Equal(res.RestartRequired, []string{"Port"}) || liveConfig.Load().Port != "7000" {
+		t.Errorf("result = %+v", res)
+	}
+
+	// An invalid file changes nothing
+	os.WriteFile(path, []byte("TRIGGER_THRESHOLD=0\nENDING_TEXT=broken\n"), 0o600)
+	if code, _ := reload(); code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid reload = %d", code)
+	}
+	if s := defaultSettings.Load(); s.ThresholdPercent != 75 || s.EndingText != "閉店です" {
+		t.Errorf("after invalid reload = %+v", s)
+	}
+	os.WriteFile(path, []byte("TRIGGER_THRESHOLD\n"), 0o600)
+	if code, _ := reload(); code != http.StatusUnprocessableEntity {
+		t.Errorf("malformed file = %d", code)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// MeetingReport summarizes how a meeting went up to its trigger. It is
+// shown on the ending screen and kept for the stats API.
+type MeetingReport struct {
+	Tenant    string `json:"tenant,omitempty"`
+	MeetingID string `json:"meeting_id"`
+	RoomID    string `json:"room_id"`
+	SeriesID  string `json:"series_id"`
+	Topic     string `json:"topic,omitempty"`
+
+	// StartedAt is the meeting's start from the Zoom API, or when the
+	// first panel opened
+	StartedAt   time.Time `json:"started_at"`
+	FirstVoteAt time.Time `json:"first_vote_at,omitzero"`
+	TriggeredAt time.Time `json:"triggered_at"`
+
+	// Scheduled is the planned length, 0 if unscheduled
+	Scheduled time.Duration `json:"scheduled_ns,omitempty"`
+
+	Participants int `json:"participants"`
+	Votes        int `json:"votes"`
+
+	// Samples are the votes over time up to the trigger, oldest first
+	Samples []VoteSample `json:"samples,omitempty"`
+}
+
+// Length is how long the meeting ran until the trigger.
+func (r *MeetingReport) Length() time.Duration {
+	return r.TriggeredAt.Sub(r.StartedAt)
+}
+
+// Overrun is how far the meeting ran past its schedule, if it did.
+func (r *MeetingReport) Overrun() time.Duration {
+	if r.Scheduled <= 0 {
+		return 0
+	}
+	return max(r.Length()-r.Scheduled, 0)
+}
+
+// TimeToMajority is how long the room took from the first vote to the
+// trigger.
+func (r *MeetingReport) TimeToMajority() time.Duration {
+	if r.FirstVoteAt.IsZero() {
+		return 0
+	}
+	return r.TriggeredAt.Sub(r.FirstVoteAt)
+}
+
+// ParticipationRate is the share of participants who voted, 0..1.
+func (r *MeetingReport) ParticipationRate() float64 {
+	if r.Participants == 0 {
+		return 0
+	}
+	return float64(r.Votes) / float64(r.Participants)
+}
+
+// MarshalJSON adds the derived figures for API clients.
+func (r *MeetingReport) MarshalJSON() ([]byte, error) {
+	type plain MeetingReport
+	return json.Marshal(struct {
+		*plain
+		LengthMinutes         float64 `json:"length_minutes"`
+		OverrunMinutes        float64 `json:"overrun_minutes"`
+		TimeToMajorityMinutes float64 `json:"time_to_majority_minutes"`
+		ParticipationRate     float64 `json:"participation_rate"`
+	}{
+		(*plain)(r),
+		r.Length().Minutes(),
+		r.Overrun().Minutes(),
+		r.TimeToMajority().Minutes(),
+		r.ParticipationRate(),
+	})
+}
+
+// saveMeetingReport builds and stores the report of a room that has just
+// triggered.
+func saveMeetingReport(ctx context.Context, zCtx *ZoomAuthContext, participants, votes int) {
+	r := &MeetingReport{
+		Tenant:       zCtx.Tenant,
+		MeetingID:    zCtx.Mid,
+		RoomID:       zCtx.RoomID(),
+		TriggeredAt:  clock.Now(),
+		Participants: participants,
+		Votes:        votes,
+	}
+	opened, firstVote, err := RoomTimeline(ctx, r.RoomID)
+	if err != nil {
+		logf(ctx, "RoomTimeline error: %v", err)
+	}
+	r.StartedAt, r.FirstVoteAt = opened, firstVote
+	if r.Samples, err = VoteSamples(ctx, r.RoomID); err != nil {
+		logf(ctx, "VoteSamples error: %v", err)
+	}
+	r.Samples = append(r.Samples, VoteSample{At: r.TriggeredAt, Participants: participants, Votes: votes})
+	info := meetingInfo(ctx, zCtx)
+	if info != nil {
+		r.Topic, r.Scheduled = info.Topic, info.Duration
+		if !info.StartTime.IsZero() && info.StartTime.Before(r.TriggeredAt) {
+			r.StartedAt = info.StartTime
+		}
+	}
+	if r.StartedAt.IsZero() {
+		r.StartedAt = r.TriggeredAt
+	}
+	r.SeriesID = seriesID(zCtx, info)
+	recordTriggeredOccurrence(ctx, r)
+
+	if err := SaveReport(ctx, r.RoomID, r); err != nil {
+		logf(ctx, "SaveReport error: %v", err)
+	}
+
+	reqID := requestIDFrom(ctx)
+	go runRecovered("report-delivery", func() {
+		bg, cancel := context.WithTimeout(context.WithValue(context.Background(), requestIDKey, reqID), 30*time.Second)
+		defer cancel()
+		deliverReport(bg, r, info)
+	})
+}
+
+// generateReportHTML renders the summary shown under the ending screen.
+func generateReportHTML(lang string, r *MeetingReport) string {
+	minutes := func(d time.Duration) int { return int(d.Round(time.Minute).Minutes()) }
+	data := map[string]any{
+		"Length":        minutes(r.Length()),
+		"Overrun":       minutes(r.Overrun()),
+		"Participation": int(math.Round(r.ParticipationRate() * 100)),
+	}
+	if !r.FirstVoteAt.IsZero() {
+		data["FirstVote"] = fmt.Sprint(minutes(r.FirstVoteAt.Sub(r.StartedAt)))
+		data["ToMajority"] = fmt.Sprint(minutes(r.TimeToMajority()))
+	}
+	return renderFragment(lang, "report", data) + generateSparklineHTML(lang, r.Samples)
+}
+
+// handleRoomReport serves GET /api/rooms/{id}/report, the room's latest
+// meeting report, to its hosts.
+func handleRoomReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() || r.PathValue("id") != zCtx.Mid {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	report, err := RoomReport(ctx, zCtx.RoomID())
+	if err != nil {
+		logf(ctx, "RoomReport error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if report == nil {
+		httpError(w, ctx, "Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
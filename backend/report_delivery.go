@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ReportDelivery says where post-meeting reports go. Emails go to the
+// meeting's host as reported by the Zoom API, so they need API credentials;
+// the chat webhook posts to a channel.
+type ReportDelivery struct {
+	SMTPURL        string `json:"smtp_url,omitempty"`
+	EmailFrom      string `json:"email_from,omitempty"`
+	ChatWebhookURL string `json:"chat_webhook_url,omitempty"`
+
+	// DigestTo are the addresses the weekly digest is mailed to
+	DigestTo []string `json:"digest_to,omitempty"`
+
+	// Disabled turns report delivery off for a tenant
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// reportDelivery is the deployment default from Config.
+var (
+	reportDelivery ReportDelivery
+	publicURL      string
+)
+
+func configureReportDelivery(cfg *Config) {
+	reportDelivery = ReportDelivery{
+		SMTPURL:        cfg.ReportSMTPURL,
+		EmailFrom:      cfg.ReportEmailFrom,
+		ChatWebhookURL: cfg.ReportChatWebhookURL,
+		DigestTo:       splitList(cfg.ReportDigestTo),
+	}
+	publicURL = strings.TrimRight(cfg.PublicURL, "/")
+}
+
+// reportDeliveryFor returns the tenant's delivery settings, with fields it
+// leaves empty taken from the deployment.
+func reportDeliveryFor(tenant string) ReportDelivery {
+	d := reportDelivery
+	t := tenantByID(tenant)
+	if t == nil || t.Reports == nil {
+		return d
+	}
+	if t.Reports.Disabled {
+		return ReportDelivery{Disabled: true}
+	}
+	if t.Reports.SMTPURL != "" {
+		d.SMTPURL = t.Reports.SMTPURL
+	}
+	if t.Reports.EmailFrom != "" {
+		d.EmailFrom = t.Reports.EmailFrom
+	}
+	if t.Reports.ChatWebhookURL != "" {
+		d.ChatWebhookURL = t.Reports.ChatWebhookURL
+	}
+	if len(t.Reports.DigestTo) > 0 {
+		d.DigestTo = t.Reports.DigestTo
+	}
+	return d
+}
+
+// reportBaseURL is where links in the tenant's emails point: its first
+// Home URL host, or PUBLIC_URL.
+func reportBaseURL(tenant string) string {
+	if t := tenantByID(tenant); t != nil && len(t.Hosts) > 0 {
+		return "https://" + t.Hosts[0]
+	}
+	return publicURL
+}
+
+// reportText is the plain-text report used by email and chat.
+func reportText(lang string, r *MeetingReport) string {
+	minutes := func(d time.Duration) int { return int(d.Round(time.Minute).Minutes()) }
+	var b strings.Builder
+	if r.Topic != "" {
+		b.WriteString(r.Topic + "\n")
+	}
+	fmt.Fprintf(&b, "%s: %s", translate(lang, "会議時間"), fmt.Sprintf(translate(lang, "%d分"), minutes(r.Length())))
+	if r.Overrun() > 0 {
+		fmt.Fprintf(&b, translate(lang, "（%d分超過）"), minutes(r.Overrun()))
+	}
+	b.WriteString("\n")
+	if !r.FirstVoteAt.IsZero() {
+		fmt.Fprintf(&b, "%s: %s\n", translate(lang, "最初の投票"), fmt.Sprintf(translate(lang, "開始から %s分"), fmt.Sprint(minutes(r.FirstVoteAt.Sub(r.StartedAt)))))
+		fmt.Fprintf(&b, "%s: %s\n", translate(lang, "成立まで"), fmt.Sprintf(translate(lang, "%s分"), fmt.Sprint(minutes(r.TimeToMajority()))))
+	}
+	fmt.Fprintf(&b, "%s: %.0f%% (%d / %d)\n", translate(lang, "投票率"), r.ParticipationRate()*100, r.Votes, r.Participants)
+	return b.String()
+}
+
+// deliverReport sends r to the meeting's host by email and to the chat
+// webhook, as configured for its tenant. Hosts who unsubscribed get
+// neither.
+func deliverReport(ctx context.Context, r *MeetingReport, info *MeetingInfo) {
+	d := reportDeliveryFor(r.Tenant)
+	if d.Disabled || (d.SMTPURL == "" && d.ChatWebhookURL == "") {
+		return
+	}
+	hostID := ""
+	if info != nil {
+		hostID = info.HostID
+	}
+	if hostID != "" {
+		out, err := ReportOptOut(ctx, hostID)
+		if err != nil {
+			logf(ctx, "ReportOptOut error: %v", err)
+			return
+		}
+		if out {
+			return
+		}
+	}
+
+	text := reportText(defaultLocale, r)
+	if d.SMTPURL != "" && info != nil && info.HostEmail != "" {
+		unsubscribe := ""
+		if base := reportBaseURL(r.Tenant); base != "" && hostID != "" {
+			unsubscribe = base + "/api/reports/unsubscribe?" + url.Values{"host": {hostID}, "sig": {unsubscribeSignature(hostID)}}.Encode()
+		}
+		if err := sendReportEmail(ctx, d, "会議のまとめ（蛍の光ボタン）", info.HostEmail, text, unsubscribe); err != nil {
+			metrics.Add("report_delivery_errors", 1)
+			logf(ctx, "Report email failed: %v", err)
+		} else {
+			metrics.Add("reports_emailed", 1)
+		}
+	}
+	if d.ChatWebhookURL != "" {
+		if err := postJSON(ctx, d.ChatWebhookURL, map[string]string{"text": "蛍の光ボタン 会議のまとめ\n" + text}, nil, ""); err != nil {
+			metrics.Add("report_delivery_errors", 1)
+			logf(ctx, "Report chat post failed: %v", err)
+		}
+	}
+}
+
+// sendReportEmail mails text over smtp:// (STARTTLS when offered) or
+// smtps:// (implicit TLS).
+func sendReportEmail(ctx context.Context, d ReportDelivery, subject, to, text, unsubscribe string) error {
+	u, err := url.Parse(d.SMTPURL)
+	if err != nil {
+		return fmt.Errorf("smtp url: %w", err)
+	}
+	implicitTLS := u.Scheme == "smtps"
+	addr := u.Host
+	if u.Port() == "" {
+		if implicitTLS {
+			addr = net.JoinHostPort(u.Hostname(), "465")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "587")
+		}
+	}
+	from := d.EmailFrom
+	if from == "" {
+		return fmt.Errorf("REPORT_EMAIL_FROM is not set")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	if implicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, u.Hostname())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !implicitTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if u.User != nil {
+		pw, ok := u.User.Password()
+		if !ok {
+			pw = secretValue("REPORT_SMTP_PASSWORD")
+		}
+		if err := c.Auth(smtp.PlainAuth("", u.User.Username(), pw, u.Hostname())); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\n", from, to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", clock.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n")
+	body := text
+	if unsubscribe != "" {
+		fmt.Fprintf(&msg, "List-Unsubscribe: <%s>\r\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n", unsubscribe)
+		body += "\n配信停止: " + unsubscribe + "\n"
+	}
+	msg.WriteString("\r\n" + strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// unsubscribeSignature authenticates unsubscribe links, keyed like the
+// pseudonyms so links stay valid across instances sharing UID_HASH_KEY.
+func unsubscribeSignature(hostID string) string {
+	return hex.EncodeToString(hmacSHA256(*uidHashKey.Load(), "unsubscribe:"+hostID)[:16])
+}
+
+// handleReportUnsubscribe serves the signed unsubscribe link of report
+// emails. POST is the one-click unsubscribe of RFC 8058.
+func handleReportUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hostID := r.URL.Query().Get("host")
+	sig := r.URL.Query().Get("sig")
+	if hostID == "" || !hmac.Equal([]byte(sig), []byte(unsubscribeSignature(hostID))) {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := SetReportOptOut(ctx, hostID, true); err != nil {
+		logf(ctx, "SetReportOptOut error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("会議のまとめの配信を停止しました。\n"))
+}
+
+// handleReportSubscription lets a host turn their report emails back on or
+// off with enabled=true|false.
+func handleReportSubscription(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := SetReportOptOut(ctx, zCtx.UID, r.FormValue("enabled") != "true"); err != nil {
+		logf(ctx, "SetReportOptOut error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts mail without STARTTLS or auth and sends each message's
+// data to the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	msgs := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			conn.Write([]byte("220 fake\r\n"))
+			var data strings.Builder
+			for inData := false; ; {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				switch {
+				case inData && line == ".\r\n":
+					inData = false
+					msgs <- data.String()
+					conn.Write([]byte("250 queued\r\n"))
+				case inData:
+					data.WriteString(line)
+				case strings.HasPrefix(line, "EHLO"):
+					conn.Write([]byte("250 fake\r\n"))
+				case strings.HasPrefix(line, "DATA"):
+					inData = true
+					conn.Write([]byte("354 go ahead\r\n"))
+				case strings.HasPrefix(line, "QUIT"):
+					conn.Write([]byte("221 bye\r\n"))
+					conn.Close()
+				default:
+					conn.Write([]byte("250 ok\r\n"))
+				}
+			}
+		}
+	}()
+	return ln.Addr().String(), msgs
+}
+
+func TestReportDelivery(t *testing.T) {
+	useRedis = false
+	addr, mails := fakeSMTP(t)
+	chats := make(chan string, 4)
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		chats <- msg.Text
+	}))
+	defer chat.Close()
+
+	configureReportDelivery(&Config{
+		ReportSMTPURL:        "smtp://" + addr,
+		ReportEmailFrom:      "hotaru@example.com",
+		ReportChatWebhookURL: chat.URL,
+		PublicURL:            "https://hotaru.example.com/",
+	})
+	t.Cleanup(func() { configureReportDelivery(&Config{}) })
+
+	start := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	r := &MeetingReport{Topic: "定例MTG", StartedAt: start, TriggeredAt: start.Add(45 * time.Minute), Participants: 4, Votes: 2}
+	info := &MeetingInfo{HostID: "host-1", HostEmail: "host@example.com"}
+	ctx := context.Background()
+
+	deliverReport(ctx, r, info)
+	var mail string
+	select {
+	case mail = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail was sent")
+	}
+	for _, want := range []string{"To: host@example.com", "定例MTG", "会議時間: 45分", "投票率: 50% (2 / 4)", "List-Unsubscribe: <https://hotaru.example.com/api/reports/unsubscribe?"} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("mail is missing %q:\n%s", want, mail)
+		}
+	}
+	if text := <-chats; !strings.Contains(text, "会議時間: 45分") {
+		t.Errorf("chat text: %s", text)
+	}
+
+	// The link in the mail unsubscribes the host from both
+	link := mail[strings.Index(mail, "https://hotaru.example.com"):]
+	link = link[:strings.IndexAny(link, ">\r\n")]
+	u, _ := url.Parse(link)
+	w := httptest.NewRecorder()
+	handleReportUnsubscribe(w, httptest.NewRequest(http.MethodPost, u.RequestURI(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unsubscribe: %d %s", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	handleReportUnsubscribe(w, httptest.NewRequest(http.MethodGet, "/api/reports/unsubscribe?host=host-2&sig="+u.Query().Get("sig"), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("forged unsubscribe: %d", w.Code)
+	}
+	t.Cleanup(func() { SetReportOptOut(ctx, "host-1", false) })
+
+	deliverReport(ctx, r, info)
+	select {
+	case mail := <-mails:
+		t.Errorf("unsubscribed host got mail:\n%s", mail)
+	case text := <-chats:
+		t.Errorf("unsubscribed host's report was posted: %s", text)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Tenants can turn reports off
+	useTenants(t, []*Tenant{{ID: "quiet", Reports: &ReportDelivery{Disabled: true}}})
+	r.Tenant = "quiet"
+	if d := reportDeliveryFor("quiet"); !d.Disabled || d.SMTPURL != "" {
+		t.Errorf("tenant override: %+v", d)
+	}
+}
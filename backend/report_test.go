@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeetingReportFigures(t *testing.T) {
+	start := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	r := &MeetingReport{
+		StartedAt:    start,
+		TriggeredAt:  start.Add(72 * time.Minute),
+		Scheduled:    time.Hour,
+		Participants: 6,
+		Votes:        4,
+	}
+	if r.Length() != 72*time.Minute || r.Overrun() != 12*time.Minute || r.TimeToMajority() != 0 {
+		t.Errorf("length %v overrun %v to majority %v", r.Length(), r.Overrun(), r.TimeToMajority())
+	}
+
+	// Without a recorded first vote the vote timings are left out
+	got := generateReportHTML(defaultLocale, r)
+	if !strings.Contains(got, "72分（12分超過）") || !strings.Contains(got, "67%") || strings.Contains(got, "最初の投票") {
+		t.Errorf("report without first vote: %s", got)
+	}
+
+	r.FirstVoteAt = start.Add(65 * time.Minute)
+	got = generateReportHTML("en", r)
+	if !strings.Contains(got, "65 min in") || !strings.Contains(got, "<dd>7 min</dd>") {
+		t.Errorf("english report: %s", got)
+	}
+}
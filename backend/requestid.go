@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type ctxKey string
+
+const requestIDKey ctxKey = "requestID"
+
+// newRequestID returns a random 16 hex character identifier.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short alphanumeric IDs from an upstream proxy.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware assigns every request an ID (reusing a valid incoming
+// X-Request-ID), stores it in the context, and echoes it in the response.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// logf logs with the request ID of ctx so one user's requests can be correlated.
+func logf(ctx context.Context, format string, args ...any) {
+	if id := requestIDFrom(ctx); id != "" {
+		format = "[req=" + id + "] " + format
+	}
+	log.Output(2, fmt.Sprintf(format, args...))
+}
+
+// httpError is http.Error with the request ID appended for support.
+func httpError(w http.ResponseWriter, ctx context.Context, msg string, code int) {
+	if id := requestIDFrom(ctx); id != "" {
+		msg += " (request id: " + id + ")"
+	}
+	http.Error(w, msg, code)
+}
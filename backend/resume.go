@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// resumeHeader carries the resume token: set on the response that first
+// verified a Zoom context, sent back by the panel on every request after.
+const resumeHeader = "X-Resume-Token"
+
+// resumeTTL is how long a resume token outlives the panel's last request,
+// so a panel that reloads or drops off the network for less than that
+// gets back in without its Zoom context being verified again. 0 disables
+// resume tokens.
+var resumeTTL = 60 * time.Second
+
+// resumeSession is what a resume token stands for: the identity from the
+// verified Zoom context, and a hash of that context so the token only
+// works alongside it.
+type resumeSession struct {
+	UID     string `json:"uid"`
+	Mid     string `json:"mid"`
+	Role    string `json:"role,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Context string `json:"ctx"`
+}
+
+// hashToken is how tokens and contexts are stored, so reading the store
+// gives nothing that can be replayed.
+func hashToken(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// issueResumeToken stores a new token for a freshly verified context and
+// returns it, or "" if it could not be stored.
+func issueResumeToken(ctx context.Context, zCtx *ZoomAuthContext, appContext string) string {
+	b := make([]byte, 18)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	s := &resumeSession{UID: zCtx.UID, Mid: zCtx.Mid, Role: zCtx.Role, Tenant: zCtx.Tenant, Context: hashToken(appContext)}
+	if err := SaveResumeSession(ctx, hashToken(token), s, resumeTTL); err != nil {
+		logf(ctx, "SaveResumeSession error: %v", err)
+		return ""
+	}
+	metrics.Add("resume_tokens_issued", 1)
+	return token
+}
+
+// resumeContext returns the context of the request's resume token and
+// extends the token's life, or nil if the request has no live token for
+// its Zoom context.
+func resumeContext(r *http.Request, appContext string) *ZoomAuthContext {
+	token := r.Header.Get(resumeHeader)
+	if token == "" || resumeTTL <= 0 {
+		return nil
+	}
+	s, err := ResumeSession(r.Context(), hashToken(token), resumeTTL)
+	if err != nil {
+		logf(r.Context(), "ResumeSession error: %v", err)
+		return nil
+	}
+	if s == nil || s.Context != hashToken(appContext) {
+		return nil
+	}
+	metrics.Add("sessions_resumed", 1)
+	return &ZoomAuthContext{UID: s.UID, Mid: s.Mid, Role: s.Role, Tenant: s.Tenant}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResumeToken(t *testing.T) {
+	ts := newTestServer(t)
+	devBypass = false
+	appContext, _ := EncryptZoomContext("test_secret", []byte(`{"uid":"z1","mid":"resume-room"}`))
+	otherContext, _ := EncryptZoomContext("test_secret", []byte(`{"uid":"z2","mid":"resume-room"}`))
+
+	get := func(appContext, token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/state", nil)
+		req.Header.Set("x-zoom-app-context", appContext)
+		if token != "" {
+			req.Header.Set(resumeHeader, token)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get(appContext, "")
+	token := resp.Header.Get(resumeHeader)
+	if resp.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("first request: %d, token %q", resp.StatusCode, token)
+	}
+	// Resumed requests are not handed another token
+	if resp := get(appContext, token); resp.StatusCode != http.StatusOK || resp.Header.Get(resumeHeader) != "" {
+		t.Errorf("resumed request: %d, token %q", resp.StatusCode, resp.Header.Get(resumeHeader))
+	}
+
+	// After a secret rotation the context no longer verifies, but the live
+	// session still resumes
+	t.Setenv("ZOOM_CLIENT_SECRET", "rotated_secret")
+	if resp := get(appContext, token); resp.StatusCode != http.StatusOK {
+		t.Errorf("resume after rotation: %d", resp.StatusCode)
+	}
+	if resp := get(appContext, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token after rotation: %d", resp.StatusCode)
+	}
+	if resp := get(otherContext, token); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token with another context: %d", resp.StatusCode)
+	}
+	if resp := get(appContext, "forged"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("forged token: %d", resp.StatusCode)
+	}
+
+	// Each use extends the token; it lapses resumeTTL after the last one
+	ts.mr.FastForward(resumeTTL - time.Second)
+	if resp := get(appContext, token); resp.StatusCode != http.StatusOK {
+		t.Errorf("resume within the TTL: %d", resp.StatusCode)
+	}
+	ts.mr.FastForward(resumeTTL + time.Second)
+	if resp := get(appContext, token); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("resume after the TTL: %d", resp.StatusCode)
+	}
+}
+
+func TestMemResumeSessions(t *testing.T) {
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+
+	SaveResumeSession(ctx, "hash", &resumeSession{UID: "u1", Mid: "m1", Tenant: "acme"}, time.Minute)
+	fc.Advance(50 * time.Second)
+	if s, _ := ResumeSession(ctx, "hash", time.Minute); s == nil || s.Tenant != "acme" {
+		t.Fatalf("session = %+v", s)
+	}
+	fc.Advance(50 * time.Second)
+	local.sweep(ctx)
+	if s, _ := ResumeSession(ctx, "hash", time.Minute); s == nil {
+		t.Error("session not extended by its last use")
+	}
+	fc.Advance(61 * time.Second)
+	local.sweep(ctx)
+	if memDocStored(docResume, "hash") {
+		t.Error("expired session not swept")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// roomClock is the meeting line of a room, and the warning about the
+// host's next meeting, as of one minute on the server's clock. Every panel
+// in the room polls every two seconds; they all get the lines rendered
+// once for the minute, so the elapsed and remaining minutes tick over
+// together and never depend on a participant's clock.
+type roomClock struct {
+	mu     sync.Mutex
+	minute time.Time
+	info   *MeetingInfo      // what the lines were rendered from
+	start  time.Time         // the room's opening, without a schedule
+	lines  map[string]string // locale -> meeting line
+}
+
+var roomClocks sync.Map // room ID -> *roomClock
+
+// roomClockHTML returns the room's meeting line for the current minute.
+// Without a schedule from Zoom or a calendar, the meeting is taken to have
+// started when the first panel opened.
+func roomClockHTML(ctx context.Context, zCtx *ZoomAuthContext, info *MeetingInfo) string {
+	val, _ := roomClocks.LoadOrStore(zCtx.RoomID(), &roomClock{})
+	rc := val.(*roomClock)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	minute := clock.Now().Truncate(time.Minute)
+	if !minute.Equal(rc.minute) || info != rc.info {
+		rc.minute, rc.info, rc.lines = minute, info, map[string]string{}
+		if info == nil || info.StartTime.IsZero() {
+			opened, _, err := RoomTimeline(ctx, zCtx.RoomID())
+			if err != nil {
+				logf(ctx, "RoomTimeline error: %v", err)
+			}
+			rc.start = opened
+		}
+	}
+	if line, ok := rc.lines[zCtx.Locale]; ok {
+		return line
+	}
+
+	shown := info
+	if (info == nil || info.StartTime.IsZero()) && !rc.start.IsZero() {
+		opened := MeetingInfo{StartTime: rc.start}
+		if info != nil {
+			opened = *info
+			opened.StartTime = rc.start
+		}
+		shown = &opened
+	}
+	line := ""
+	if shown != nil {
+		line = generateMeetingInfoHTML(zCtx.Locale, shown, minute)
+		if shown.nextMeetingSoon(minute) {
+			line += generateNextMeetingWarningHTML(zCtx.Locale, shown.NextMeeting.Sub(minute))
+		}
+	}
+	rc.lines[zCtx.Locale] = line
+	return line
+}
+
+// sweepRoomClocks drops the lines of rooms nobody polled for a few minutes.
+func sweepRoomClocks() {
+	cutoff := clock.Now().Add(-5 * time.Minute)
+	roomClocks.Range(func(key, val any) bool {
+		rc := val.(*roomClock)
+		rc.mu.Lock()
+		stale := rc.minute.Before(cutoff)
+		rc.mu.Unlock()
+		if stale {
+			roomClocks.CompareAndDelete(key, rc)
+		}
+		return true
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomClockTicksByMinute(t *testing.T) {
+	fc := useFakeClock(t)
+	fc.now = time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	zCtx := &ZoomAuthContext{UID: "u1", Mid: "mid-clock", Locale: defaultLocale}
+	PurgeRoom(ctx, zCtx.RoomID())
+	roomClocks.Delete(zCtx.RoomID())
+	if _, err := renderState(ctx, zCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	// No schedule: counted from the opening, in whole minutes of the
+	// server's clock
+	fc.Advance(61 * time.Second)
+	first := roomClockHTML(ctx, zCtx, nil)
+	if !strings.Contains(first, "経過 1分") {
+		t.Fatalf("meeting line: %q", first)
+	}
+	fc.Advance(58 * time.Second)
+	if got := roomClockHTML(ctx, zCtx, nil); got != first {
+		t.Errorf("line changed within the minute: %q", got)
+	}
+	fc.Advance(2 * time.Second)
+	if got := roomClockHTML(ctx, zCtx, nil); !strings.Contains(got, "経過 2分") {
+		t.Errorf("next minute: %q", got)
+	}
+
+	// Zoom knows the length but not the start, as for instant meetings
+	info := &MeetingInfo{Topic: "朝会", Duration: 15 * time.Minute}
+	if got := roomClockHTML(ctx, zCtx, info); !strings.Contains(got, "朝会 — 予定 15分 / 経過 2分（残り 13分）") {
+		t.Errorf("with a length: %q", got)
+	}
+	zCtx.Locale = "en"
+	if got := roomClockHTML(ctx, zCtx, info); !strings.Contains(got, "(13 min left)") {
+		t.Errorf("in English: %q", got)
+	}
+
+	fc.Advance(10 * time.Minute)
+	sweepRoomClocks()
+	if _, ok := roomClocks.Load(zCtx.RoomID()); ok {
+		t.Error("idle room's clock kept")
+	}
+}
+
+// staticCalendar is a calendar that never changes.
+type staticCalendar []calendarEvent
+
+func (c staticCalendar) Events(ctx context.Context, host string, from, to time.Time) ([]calendarEvent, error) {
+	return c, nil
+}
+
+func TestNextMeetingWarning(t *testing.T) {
+	z := newZoomFake(t)
+	fc := useFakeClock(t)
+	ctx := context.Background()
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	fc.now = start.Add(40 * time.Minute)
+	z.addMeeting("mid-next", fakeMeeting{Topic: "週次定例", Number: 81234567890, HostEmail: "host@example.com", Start: start, Duration: time.Hour})
+
+	useTenants(t, []*Tenant{{ID: "acme", Secrets: []string{"s"}, Settings: &RoomSettings{NextMeetingThresholdPercent: 30}}})
+	tenantZoomAPIs.Store("acme", defaultZoomAPI.Load())
+	tenantCalendars.Store("acme", staticCalendar{
+		{Start: start, End: start.Add(time.Hour), Text: "https://example.zoom.us/j/81234567890"},
+		{Start: start.Add(time.Hour), End: start.Add(90 * time.Minute)},
+	})
+	t.Cleanup(func() {
+		tenantZoomAPIs.Delete("acme")
+		tenantCalendars.Delete("acme")
+	})
+	zCtx := &ZoomAuthContext{UID: "u1", Mid: "mid-next", Tenant: "acme", Locale: defaultLocale}
+	roomClocks.Delete(zCtx.RoomID())
+
+	info := meetingInfo(ctx, zCtx)
+	if got := roomClockHTML(ctx, zCtx, info); strings.Contains(got, "next-meeting-warning") {
+		t.Errorf("warning 20 minutes ahead: %s", got)
+	}
+	if s := roomSettings(ctx, zCtx); s.ThresholdPercent != 50 {
+		t.Errorf("threshold %v 20 minutes ahead, want 50", s.ThresholdPercent)
+	}
+
+	fc.now = start.Add(55 * time.Minute)
+	if got := roomClockHTML(ctx, zCtx, info); !strings.Contains(got, "5分後にホストの次の予定が始まります") {
+		t.Errorf("5 minutes ahead: %s", got)
+	}
+	if s := roomSettings(ctx, zCtx); s.ThresholdPercent != 30 {
+		t.Errorf("threshold %v 5 minutes ahead, want 30", s.ThresholdPercent)
+	}
+	fc.now = start.Add(61 * time.Minute)
+	if got := roomClockHTML(ctx, zCtx, info); !strings.Contains(got, "ホストの次の予定が始まっています") {
+		t.Errorf("after the next meeting started: %s", got)
+	}
+
+	// Rooms without a calendar keep their threshold
+	plain := &ZoomAuthContext{UID: "u1", Mid: "mid-plain", Tenant: "acme"}
+	if s := roomSettings(ctx, plain); s.ThresholdPercent != 50 {
+		t.Errorf("threshold %v without a next meeting, want 50", s.ThresholdPercent)
+	}
+	if err := (&RoomSettings{NextMeetingThresholdPercent: 120}).validate(); err == nil {
+		t.Error("next_meeting_threshold_percent 120 accepted")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Closing rooms: leaves only take effect when a panel polls, so a room
+// whose last participant has left would keep its keys until they expire.
+// Every instance looks at the rooms whose participants have all left once
+// the last leave's grace is over; the one that claims a room closes it,
+// archiving it like the archive job with ARCHIVE_URL set, purging it from
+// the store and telling every instance to drop what it keeps of the room.
+// The meeting report stays for the report API, as with the archive job.
+
+// roomCloseInterval is how often an instance looks for rooms to close.
+var roomCloseInterval = 5 * time.Second
+
+// dynamoCloseInterval is how often it looks with DynamoDB, where each look
+// scans the table.
+const dynamoCloseInterval = time.Minute
+
+// roomCloseBatch is the most rooms one look closes; the rest wait.
+const roomCloseBatch = 500
+
+// roomClosedChannel carries the IDs of closed rooms to every instance.
+func roomClosedChannel() string {
+	return keyPrefix + "room-closed"
+}
+
+// startRoomCloser looks for rooms to close every roomCloseInterval.
+// Without Redis other instances are not told, and drop what they keep of
+// a closed room as it goes unused.
+func startRoomCloser() {
+	if roomCloseInterval <= 0 {
+		return
+	}
+	interval := roomCloseInterval
+	if currentStore().Name() == storeDynamo {
+		interval = max(interval, dynamoCloseInterval)
+	}
+	if useRedis {
+		goSafe("room-closed", func() {
+			sub := rdb.Subscribe(context.Background(), roomClosedChannel())
+			defer sub.Close()
+			for msg := range sub.Channel() {
+				forgetRoom(msg.Payload)
+			}
+		})
+	}
+	goSafe("room-closer", func() {
+		for range time.Tick(interval) {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := closeEmptyRooms(ctx); err != nil {
+				log.Printf("Closing rooms: %v", err)
+			}
+			cancel()
+		}
+	})
+}
+
+// closeEmptyRooms closes the rooms whose participants have all left.
+func closeEmptyRooms(ctx context.Context) error {
+	now := clock.Now()
+	rooms, err := ClosingRooms(ctx, now, roomCloseBatch)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, room := range rooms {
+		empty, uids, err := ClaimEmptyRoom(ctx, room, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("room %q: %w", room, err))
+			continue
+		}
+		if !empty {
+			continue
+		}
+		if err := closeRoom(ctx, room, uids, now); err != nil {
+			metrics.Add("room_close_errors", 1)
+			errs = append(errs, fmt.Errorf("room %q: %w", room, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeRoom closes a room its participants, given as pseudonymized uids,
+// have all left.
+func closeRoom(ctx context.Context, room string, uids []string, now time.Time) error {
+	zCtx := roomAuthContext(room)
+	for _, uid := range uids {
+		exportRoomEvent(zCtx, exportLeave, uid, "", 0, 0)
+	}
+	var err error
+	if a := archiver; a != nil {
+		err = a.archiveRoom(ctx, room, now)
+	} else {
+		err = PurgeRoom(ctx, room)
+	}
+	if err != nil {
+		return err
+	}
+	forgetRoom(room)
+	if useRedis {
+		if err := rdb.Publish(ctx, roomClosedChannel(), room).Err(); err != nil {
+			logf(ctx, "Room closed notification error: %v", err)
+		}
+	}
+	metrics.Add("rooms_closed", 1)
+	return nil
+}
+
+// roomAuthContext is the tenant and meeting of a room ID, for what is
+// done to the room without a request.
+func roomAuthContext(room string) *ZoomAuthContext {
+	if tenant, mid, ok := strings.Cut(room, "/"); ok && tenantByID(tenant) != nil {
+		return &ZoomAuthContext{Tenant: tenant, Mid: mid}
+	}
+	return &ZoomAuthContext{Mid: room}
+}
+
+// forgetRoom drops what this instance keeps of a closed room.
+func forgetRoom(room string) {
+	forgetRoomStatus(room)
+	dropRoomStatuses(room)
+	roomClocks.Delete(room)
+	roomSamplers.Delete(room)
+	meetingInfos.Delete(room)
+	roomHashKeys.Delete(room)
+	meteredPanels.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), room+" ") {
+			meteredPanels.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCloseEmptyRooms(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			fc := useFakeClock(t)
+			useMemoryStore(t) // without other tests' leaves
+			ctx := context.Background()
+
+			room, back := "closing-"+store, "back-"+store
+			for _, uid := range []string{"u1", "u2"} {
+				AddParticipant(ctx, room, uid)
+			}
+			Vote(ctx, room, "u1")
+			SaveReport(ctx, room, &MeetingReport{RoomID: room, TriggeredAt: clock.Now()})
+			roomClocks.Store(room, &roomClock{})
+			AddParticipant(ctx, back, "u1")
+
+			// u2 is still there
+			LeaveParticipant(ctx, room, "u1", clock.Now().Add(leaveGrace))
+			fc.Advance(leaveGrace)
+			if err := closeEmptyRooms(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if total, _, _, _ := CheckTriggerStatus(ctx, room); total != 2 {
+				t.Fatalf("room closed with a participant left, %d participants", total)
+			}
+
+			// The last leave closes the room once its grace is over; the
+			// participant who came back keeps theirs open
+			LeaveParticipant(ctx, room, "u2", clock.Now().Add(leaveGrace))
+			LeaveParticipant(ctx, back, "u1", clock.Now().Add(leaveGrace))
+			AddParticipant(ctx, back, "u1")
+			fc.Advance(leaveGrace / 2)
+			closeEmptyRooms(ctx)
+			if total, _, _, _ := CheckTriggerStatus(ctx, room); total != 2 {
+				t.Fatalf("room closed during the grace, %d participants", total)
+			}
+			fc.Advance(leaveGrace / 2)
+			if err := closeEmptyRooms(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if total, votes, _, _ := CheckTriggerStatus(ctx, room); total != 0 || votes != 0 {
+				t.Errorf("closed room has %d participants and %d votes", total, votes)
+			}
+			if total, _, _, _ := CheckTriggerStatus(ctx, back); total != 1 {
+				t.Errorf("room with a participant back was closed")
+			}
+			if _, ok := roomClocks.Load(room); ok {
+				t.Error("closed room's clock kept")
+			}
+			if rooms, _ := ClosingRooms(ctx, clock.Now().Add(time.Hour), 10); len(rooms) != 0 {
+				t.Errorf("rooms still closing: %v", rooms)
+			}
+			if store == "redis" {
+				if r, _ := RoomReport(ctx, room); r == nil {
+					t.Error("report purged with the room")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week, each as a bit set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// As in cron, a restricted day of month and day of week match either
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses "30 3 * * *" style expressions. Fields take *, numbers,
+// ranges a-b, steps */n or a-b/n, and comma separated lists of those. Day of
+// week is 0-6 from Sunday, 7 is also Sunday.
+func parseCron(expr string) (*cronSchedule, error) {
+	if m, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields", expr)
+	}
+	var c cronSchedule
+	var err error
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, b := range bounds {
+		if *b.bits, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = r, n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad range in %q", part)
+				}
+			} else if step > 1 {
+				hi = max // "5/15" is 5-max/15
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	domOK := c.dom&(1<<t.Day()) != 0
+	dowOK := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny || c.dowAny:
+		return domOK && dowOK
+	default:
+		return domOK || dowOK
+	}
+}
+
+// Next returns the first matching minute after t, in t's location, or the
+// zero time if none comes within five years (e.g. "0 0 30 2 *").
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<int(t.Month())) == 0 || !c.matchesDay(t) {
+			y, m, d := t.Date()
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			y, m, d := t.Date()
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// ScheduledJob runs one of schedulerJobs on a cron schedule, in the
+// server's time zone.
+type ScheduledJob struct {
+	// Name identifies the job in logs and run locks; defaults to Job
+	Name     string `json:"name,omitempty"`
+	Job      string `json:"job"`
+	Schedule string `json:"schedule"`
+
+	// Retention is how long the purge job keeps reports, e.g. "720h"
+	Retention string `json:"retention,omitempty"`
+
+	cron      *cronSchedule
+	retention time.Duration
+	next      time.Time
+}
+
+// schedulerJobs are the jobs a schedule file can name.
+var schedulerJobs = map[string]func(ctx context.Context, j *ScheduledJob) error{
+	"digest":    runWeeklyDigest,
+	"rollup":    runRollup,
+	"purge":     runRetentionPurge,
+	"benchmark": runBenchmark,
+	"archive":   runArchive,
+	"billing":   runBilling,
+}
+
+// defaultSchedule is used without SCHEDULE_FILE: digests on Monday
+// morning, rollups, the benchmark pool and the purge at night, the archive
+// every quarter hour and billing every hour.
+const defaultSchedule = `[
+	{"job": "digest", "schedule": "0 9 * * 1"},
+	{"job": "rollup", "schedule": "10 0 * * *"},
+	{"job": "benchmark", "schedule": "20 0 * * *"},
+	{"job": "purge", "schedule": "30 3 * * *"},
+	{"job": "archive", "schedule": "*/15 * * * *"},
+	{"job": "billing", "schedule": "5 * * * *"}
+]`
+
+// parseSchedule parses a JSON array of scheduled jobs.
+func parseSchedule(b []byte) ([]*ScheduledJob, error) {
+	var jobs []*ScheduledJob
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, j := range jobs {
+		if _, ok := schedulerJobs[j.Job]; !ok {
+			return nil, fmt.Errorf("unknown job %q", j.Job)
+		}
+		if j.Name == "" {
+			j.Name = j.Job
+		}
+		if names[j.Name] {
+			return nil, fmt.Errorf("job name %q used twice", j.Name)
+		}
+		names[j.Name] = true
+		var err error
+		if j.cron, err = parseCron(j.Schedule); err != nil {
+			return nil, fmt.Errorf("job %q: %w", j.Name, err)
+		}
+		j.retention = reportTTL
+		if j.Retention != "" {
+			if j.retention, err = time.ParseDuration(j.Retention); err != nil || j.retention < 7*24*time.Hour {
+				return nil, fmt.Errorf("job %q: retention must be a duration of at least 168h", j.Name)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// readScheduleFile reads path, or the default schedule when path is empty.
+func readScheduleFile(path string) ([]*ScheduledJob, error) {
+	if path == "" {
+		return parseSchedule([]byte(defaultSchedule))
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := parseSchedule(b)
+	if err != nil {
+		return nil, fmt.Errorf("schedule file %s: %w", path, err)
+	}
+	return jobs, nil
+}
+
+// Leadership is renewed every leaderRenew and lapses after leaderTTL, so a
+// crashed leader is replaced within half a minute.
+const (
+	leaderTTL   = 30 * time.Second
+	leaderRenew = 10 * time.Second
+)
+
+// scheduler runs jobs on the instance holding the leader lock. In-memory
+// deployments are a single instance and always lead.
+type scheduler struct {
+	id     string
+	jobs   []*ScheduledJob
+	leader bool
+}
+
+func newScheduler(jobs []*ScheduledJob) *scheduler {
+	s := &scheduler{id: newRequestID(), jobs: jobs}
+	now := clock.Now().In(time.Local)
+	for _, j := range jobs {
+		j.next = j.cron.Next(now)
+	}
+	return s
+}
+
+// tick renews leadership and runs the jobs that came due. Followers skip
+// due runs; on a leader change the run lock keeps a job from running twice.
+func (s *scheduler) tick(ctx context.Context) {
+	leader, err := AcquireLeadership(ctx, s.id, leaderTTL)
+	if err != nil {
+		log.Printf("Scheduler leader election failed: %v", err)
+		leader = false
+	}
+	if leader != s.leader {
+		s.leader = leader
+		if leader {
+			log.Printf("Scheduler: this instance (%s) is now the leader", s.id)
+		} else {
+			log.Printf("Scheduler: this instance (%s) lost leadership", s.id)
+		}
+	}
+
+	now := clock.Now().In(time.Local)
+	for _, j := range s.jobs {
+		if j.next.IsZero() || now.Before(j.next) {
+			continue
+		}
+		due := j.next
+		j.next = j.cron.Next(now)
+		if !leader {
+			continue
+		}
+		claimed, err := ClaimJobRun(ctx, j.Name, due)
+		if err != nil {
+			log.Printf("Scheduler: claiming %s failed: %v", j.Name, err)
+			continue
+		}
+		if claimed {
+			s.run(ctx, j)
+		}
+	}
+}
+
+func (s *scheduler) run(ctx context.Context, j *ScheduledJob) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	start := time.Now()
+	var err error
+	runRecovered("job "+j.Name, func() { err = schedulerJobs[j.Job](ctx, j) })
+	if err != nil {
+		metrics.Add("scheduled_job_errors", 1)
+		log.Printf("Scheduled job %s failed after %s: %v", j.Name, time.Since(start).Round(time.Millisecond), err)
+		return
+	}
+	metrics.Add("scheduled_jobs_run", 1)
+	log.Printf("Scheduled job %s done in %s", j.Name, time.Since(start).Round(time.Millisecond))
+}
+
+// startScheduler loads the schedule and starts checking it.
+func startScheduler(path string) error {
+	jobs, err := readScheduleFile(path)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	s := newScheduler(jobs)
+	log.Printf("Scheduler started with %d job(s)", len(jobs))
+	goSafe("scheduler", func() {
+		for range time.Tick(leaderRenew) {
+			s.tick(context.Background())
+		}
+	})
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2026, 4, 1, 18, 0, 30, 0, time.UTC) // a Wednesday
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "2026-04-01 18:01"},
+		{"*/15 * * * *", "2026-04-01 18:15"},
+		{"30 3 * * *", "2026-04-02 03:30"},
+		{"0 9 * * 1", "2026-04-06 09:00"},
+		{"0 9 * * 7", "2026-04-05 09:00"},
+		{"0 0 1 * *", "2026-05-01 00:00"},
+		{"0 0 1,15 * 1", "2026-04-06 00:00"}, // day of month or Monday
+		{"5/20 8-10 * 6 *", "2026-06-01 08:05"},
+		{"@daily", "2026-04-02 00:00"},
+	} {
+		c, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if got := c.Next(start).Format("2006-01-02 15:04"); got != tc.want {
+			t.Errorf("%q next = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+
+	if c, _ := parseCron("0 0 30 2 *"); !c.Next(start).IsZero() {
+		t.Error("February 30th matched")
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	jobs, err := readScheduleFile("")
+	if err != nil || len(jobs) != 6 {
+		t.Fatalf("default schedule = %v, %v", jobs, err)
+	}
+	jobs, err = parseSchedule([]byte(`[{"name": "long-purge", "job": "purge", "schedule": "@daily", "retention": "2160h"}]`))
+	if err != nil || jobs[0].retention != 90*24*time.Hour {
+		t.Errorf("retention = %v, %v", jobs, err)
+	}
+	for _, bad := range []string{
+		`[{"job": "backup", "schedule": "@daily"}]`,
+		`[{"job": "purge", "schedule": "daily"}]`,
+		`[{"job": "purge", "schedule": "@daily", "retention": "1h"}]`,
+		`[{"job": "purge", "schedule": "@daily"}, {"job": "purge", "schedule": "@hourly"}]`,
+	} {
+		if _, err := parseSchedule([]byte(bad)); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestSchedulerLeaderElection(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	defer func() { mr.Close(); client.Close(); rdb = nil; useRedis = false }()
+	fc := useFakeClock(t)
+	ctx := context.Background()
+
+	runs := map[string]int{}
+	var current string
+	schedulerJobs["test"] = func(context.Context, *ScheduledJob) error {
+		runs[current]++
+		return nil
+	}
+	defer delete(schedulerJobs, "test")
+
+	newInstance := func() *scheduler {
+		jobs, err := parseSchedule([]byte(`[{"job": "test", "schedule": "* * * * *"}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newScheduler(jobs)
+	}
+	a, b := newInstance(), newInstance()
+	tickBoth := func() {
+		current = "a"
+		a.tick(ctx)
+		current = "b"
+		b.tick(ctx)
+	}
+
+	tickBoth() // nothing due yet, a becomes the leader
+	if !a.leader || b.leader {
+		t.Fatalf("leaders: a %t, b %t", a.leader, b.leader)
+	}
+	fc.Advance(time.Minute)
+	tickBoth()
+	if runs["a"] != 1 || runs["b"] != 0 {
+		t.Errorf("runs after one minute = %v", runs)
+	}
+
+	// a stops renewing; once its lock lapses b takes over
+	mr.FastForward(leaderTTL)
+	fc.Advance(time.Minute)
+	current = "b"
+	b.tick(ctx)
+	if !b.leader || runs["b"] != 1 {
+		t.Errorf("b after failover: leader %t, runs %v", b.leader, runs)
+	}
+
+	// A returning instance neither leads nor repeats b's run
+	current = "a"
+	a.tick(ctx)
+	if a.leader || runs["a"] != 1 {
+		t.Errorf("a after failover: leader %t, runs %v", a.leader, runs)
+	}
+	if ok, _ := ClaimJobRun(ctx, "test", b.jobs[0].next.Add(-time.Minute)); ok {
+		t.Error("run claimed twice")
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+	tenants.Store(&[]*Tenant{{ID: "jobs-acme"}, {ID: "jobs-quiet"}})
+	defer tenants.Store(nil)
+
+	today := startOfDay(fc.now)
+	for i, length := range []time.Duration{60 * time.Minute, 90 * time.Minute} {
+		start := today.AddDate(0, 0, -1).Add(time.Duration(9+i) * time.Hour)
+		SaveReport(ctx, "jobs-acme/meeting", &MeetingReport{
+			Tenant:       "jobs-acme",
+			StartedAt:    start,
+			TriggeredAt:  start.Add(length),
+			Scheduled:    time.Hour,
+			Participants: 4,
+			Votes:        2,
+			Samples:      []VoteSample{{At: start}},
+		})
+	}
+	old := today.AddDate(0, 0, -40)
+	SaveReport(ctx, "jobs-acme/old", &MeetingReport{Tenant: "jobs-acme", StartedAt: old, TriggeredAt: old.Add(time.Hour)})
+
+	if err := runRollup(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	rollups, _ := Rollups(ctx, "jobs-acme")
+	want := ReportRollup{
+		Date:              today.AddDate(0, 0, -1).Format("2006-01-02"),
+		Held:              2,
+		Meetings:          2,
+		Overruns:          1,
+		LengthBuckets:     []int{0, 0, 0, 0, 1, 1, 0},
+		AvgLengthMinutes:  75,
+		AvgOverrunMinutes: 15,
+		AvgParticipation:  0.5,
+	}
+	if len(rollups) != 1 || !reflect.DeepEqual(rollups[0], want) {
+		t.Errorf("rollups = %+v", rollups)
+	}
+	if rollups, _ := Rollups(ctx, "jobs-quiet"); len(rollups) != 0 {
+		t.Errorf("quiet tenant rollups = %+v", rollups)
+	}
+
+	chats := make(chan string, 4)
+	chat := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		chats <- msg.Text
+	}))
+	defer chat.Close()
+	configureReportDelivery(&Config{ReportChatWebhookURL: chat.URL})
+	defer configureReportDelivery(&Config{})
+	if err := runWeeklyDigest(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case text := <-chats:
+		if !strings.Contains(text, "成立した会議: 2") || !strings.Contains(text, "予定を超過した会議: 1") {
+			t.Errorf("digest = %q", text)
+		}
+	default:
+		t.Fatal("no digest posted")
+	}
+	if len(chats) != 0 {
+		t.Error("digest posted for a tenant without meetings")
+	}
+
+	jobs, _ := parseSchedule([]byte(`[{"job": "purge", "schedule": "@daily"}]`))
+	if err := runRetentionPurge(ctx, jobs[0]); err != nil {
+		t.Fatal(err)
+	}
+	reports, _ := ReportsBetween(ctx, "jobs-acme", old.AddDate(-1, 0, 0), fc.now)
+	if len(reports) != 2 || reports[0].Samples != nil {
+		t.Errorf("after purge: %d reports", len(reports))
+	}
+}
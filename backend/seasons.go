@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Season switches rooms to its settings between From and To each year,
+// e.g. the bonenkai theme in December. Dates are MM-DD in the server's time
+// zone, both inclusive; a range may wrap over the new year.
+type Season struct {
+	Name     string       `json:"name"`
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Settings RoomSettings `json:"settings"`
+
+	from, to int // month*100 + day
+}
+
+var (
+	seasons      atomic.Pointer[[]*Season]
+	activeSeason atomic.Pointer[Season]
+
+	seasonScheduler sync.Once
+)
+
+func parseMonthDay(s string) (int, error) {
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		return 0, fmt.Errorf("date %q: want MM-DD", s)
+	}
+	return int(t.Month())*100 + t.Day(), nil
+}
+
+// readSeasonsFile parses a JSON array of seasons. The first season whose
+// dates match wins.
+func readSeasonsFile(path string) ([]*Season, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Season
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("seasons file %s: %w", path, err)
+	}
+	for _, s := range list {
+		if s.from, err = parseMonthDay(s.From); err != nil {
+			return nil, fmt.Errorf("season %q: %w", s.Name, err)
+		}
+		if s.to, err = parseMonthDay(s.To); err != nil {
+			return nil, fmt.Errorf("season %q: %w", s.Name, err)
+		}
+		if err := s.Settings.validate(); err != nil {
+			return nil, fmt.Errorf("season %q: %w", s.Name, err)
+		}
+	}
+	return list, nil
+}
+
+// seasonAt returns the season covering now, or nil.
+func seasonAt(list []*Season, now time.Time) *Season {
+	md := int(now.Month())*100 + now.Day()
+	for _, s := range list {
+		if s.from <= s.to && md >= s.from && md <= s.to {
+			return s
+		}
+		if s.from > s.to && (md >= s.from || md <= s.to) {
+			return s
+		}
+	}
+	return nil
+}
+
+// updateActiveSeason switches to the season covering the current date.
+func updateActiveSeason() {
+	var list []*Season
+	if p := seasons.Load(); p != nil {
+		list = *p
+	}
+	next := seasonAt(list, clock.Now().In(time.Local))
+	if prev := activeSeason.Swap(next); prev != next {
+		switch {
+		case next != nil:
+			log.Printf("Season %q started", next.Name)
+		case prev != nil:
+			log.Printf("Season %q ended", prev.Name)
+		}
+	}
+}
+
+// startSeasonScheduler loads path and checks every minute whether a season
+// starts or ends.
+func startSeasonScheduler(path string) error {
+	list, err := readSeasonsFile(path)
+	if err != nil {
+		return err
+	}
+	useSeasons(list)
+	log.Printf("Loaded %d season(s) from %s", len(list), path)
+	return nil
+}
+
+// useSeasons replaces the season list, starting the scheduler the first
+// time there is one.
+func useSeasons(list []*Season) {
+	seasons.Store(&list)
+	updateActiveSeason()
+	if list != nil {
+		seasonScheduler.Do(func() {
+			goSafe("season-scheduler", func() {
+				for range time.Tick(time.Minute) {
+					updateActiveSeason()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSeasons(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seasons.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "april-fools", "from": "04-01", "to": "04-01", "settings": {"theme": "minimal", "ending_text": "嘘です"}},
+		{"name": "year-end", "from": "12-20", "to": "01-05", "settings": {"theme": "bonenkai", "ending_text": "良いお年を"}}
+	]`), 0o600)
+	list, err := readSeasonsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(month time.Month, day int) string {
+		if s := seasonAt(list, time.Date(2026, month, day, 12, 0, 0, 0, time.UTC)); s != nil {
+			return s.Name
+		}
+		return ""
+	}
+	for _, c := range []struct {
+		month time.Month
+		day   int
+		want  string
+	}{{4, 1, "april-fools"}, {4, 2, ""}, {12, 19, ""}, {12, 20, "year-end"}, {12, 31, "year-end"}, {1, 5, "year-end"}, {1, 6, ""}} {
+		if got := at(c.month, c.day); got != c.want {
+			t.Errorf("%d-%d: season %q, want %q", c.month, c.day, got, c.want)
+		}
+	}
+
+	// The active season sits between the tenant defaults and room overrides
+	useRedis = false
+	ctx := context.Background()
+	activeSeason.Store(list[1])
+	t.Cleanup(func() { activeSeason.Store(nil) })
+	useTenants(t, []*Tenant{{ID: "optout", Secrets: []string{"s"}, Settings: &RoomSettings{Features: map[string]bool{"seasonal_themes": false}}}})
+
+	if s := roomSettings(ctx, &ZoomAuthContext{Mid: "seasonRoom"}); s.Theme != "bonenkai" || s.EndingText != "良いお年を" {
+		t.Errorf("season not applied: %+v", s)
+	}
+	SetRoomSettings(ctx, "seasonRoom", RoomSettings{Theme: "dark"}, -1)
+	if s := roomSettings(ctx, &ZoomAuthContext{Mid: "seasonRoom"}); s.Theme != "dark" || s.EndingText != "良いお年を" {
+		t.Errorf("room theme should win over the season: %+v", s)
+	}
+	if s := roomSettings(ctx, &ZoomAuthContext{Mid: "seasonRoom", Tenant: "optout"}); s.Theme != "classic" {
+		t.Errorf("opted-out tenant got theme %q", s.Theme)
+	}
+
+	os.WriteFile(path, []byte(`[{"name": "bad", "from": "13-01", "to": "01-05"}]`), 0o600)
+	if _, err := readSeasonsFile(path); err == nil {
+		t.Error("invalid date accepted")
+	}
+}
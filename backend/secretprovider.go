@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider fetches named secrets (ZOOM_CLIENT_SECRET, ...) from an
+// external store so they don't have to live in plain env vars.
+type SecretProvider interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// providedSecretNames are fetched from the provider on every refresh.
+// Features that need another secret add its name here and read it with
+// secretValue.
+var providedSecretNames = []string{"ZOOM_CLIENT_SECRET", "UID_HASH_KEY", "ZOOM_API_CLIENT_SECRET", "TRIGGER_WEBHOOK_SECRET", "REPORT_SMTP_PASSWORD", "GOOGLE_CALENDAR_CREDENTIALS", "MS_GRAPH_CLIENT_SECRET", "STRIPE_API_KEY", "ADMIN_TOKEN"}
+
+// optionalSecretNames may be missing from the provider; secretValue then
+// falls back to the env var.
+var optionalSecretNames = map[string]bool{"UID_HASH_KEY": true, "ZOOM_API_CLIENT_SECRET": true, "TRIGGER_WEBHOOK_SECRET": true, "REPORT_SMTP_PASSWORD": true, "GOOGLE_CALENDAR_CREDENTIALS": true, "MS_GRAPH_CLIENT_SECRET": true, "STRIPE_API_KEY": true, "ADMIN_TOKEN": true}
+
+var providedSecrets sync.Map // name -> string
+
+// secretValue returns the provider's value for name when one is known,
+// otherwise the env var of the same name.
+func secretValue(name string) string {
+	if v, ok := providedSecrets.Load(name); ok {
+		return v.(string)
+	}
+	return envOr(name, "")
+}
+
+var secretHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// newSecretProvider builds the provider selected by SECRET_PROVIDER from its
+// own env vars. An empty kind means secrets come from env vars only.
+func newSecretProvider(kind string) (SecretProvider, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "vault":
+		p := &vaultProvider{
+			addr:      strings.TrimRight(envOr("VAULT_ADDR", ""), "/"),
+			token:     envOr("VAULT_TOKEN", ""),
+			path:      strings.Trim(envOr("VAULT_SECRET_PATH", ""), "/"),
+			namespace: envOr("VAULT_NAMESPACE", ""),
+		}
+		if p.addr == "" || p.token == "" || p.path == "" {
+			return nil, fmt.Errorf("vault: VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH are required")
+		}
+		return p, nil
+	case "aws":
+		p := &awsSecretsProvider{
+			region:       envOr("AWS_REGION", envOr("AWS_DEFAULT_REGION", "")),
+			secretID:     envOr("AWS_SECRET_ID", ""),
+			accessKey:    envOr("AWS_ACCESS_KEY_ID", ""),
+			secretKey:    envOr("AWS_SECRET_ACCESS_KEY", ""),
+			sessionToken: envOr("AWS_SESSION_TOKEN", ""),
+		}
+		if p.region == "" || p.secretID == "" || p.accessKey == "" || p.secretKey == "" {
+			return nil, fmt.Errorf("aws: AWS_REGION, AWS_SECRET_ID, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+		}
+		p.endpoint = envOr("AWS_SECRETS_ENDPOINT", "https://secretsmanager."+p.region+".amazonaws.com")
+		return p, nil
+	case "gcp":
+		p := &gcpSecretsProvider{
+			project:  envOr("GCP_PROJECT", ""),
+			prefix:   envOr("GCP_SECRET_PREFIX", ""),
+			gcpToken: gcpToken{staticToken: envOr("GCP_ACCESS_TOKEN", "")},
+			endpoint: "https://secretmanager.googleapis.com",
+		}
+		if p.project == "" {
+			return nil, fmt.Errorf("gcp: GCP_PROJECT is required")
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q (want vault, aws or gcp)", kind)
+	}
+}
+
+// refreshSecrets fetches every provided secret once. Values that fail to
+// load keep their previous value.
+func refreshSecrets(ctx context.Context, p SecretProvider) error {
+	var firstErr error
+	for _, name := range providedSecretNames {
+		v, err := p.GetSecret(ctx, name)
+		if err != nil {
+			if optionalSecretNames[name] {
+				continue
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("secret %s: %w", name, err)
+			}
+			continue
+		}
+		providedSecrets.Store(name, v)
+
+		if name == "ZOOM_CLIENT_SECRET" {
+			// May hold several lines, current first, for rotation
+			if secrets := parseSecretLines(v); len(secrets) > 0 {
+				managedSecrets.Store(&secrets)
+			}
+		}
+	}
+	return firstErr
+}
+
+// startSecretRefresher loads all secrets (failing if the first load fails)
+// and refreshes them every interval.
+func startSecretRefresher(p SecretProvider, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := refreshSecrets(ctx, p)
+	cancel()
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded secrets from external provider, refreshing every %v", interval)
+
+	goSafe("secret-refresher", func() {
+		for range time.Tick(interval) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := refreshSecrets(ctx, p); err != nil {
+				log.Printf("Secret refresh failed, keeping previous values: %v", err)
+			}
+			cancel()
+		}
+	})
+	return nil
+}
+
+func doSecretRequest(req *http.Request, out any) error {
+	resp, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// vaultProvider reads keys of one KV secret (v2, or v1 as a fallback).
+// VAULT_SECRET_PATH is the full API path, e.g. "secret/data/hotaru".
+type vaultProvider struct {
+	addr, token, path, namespace string
+}
+
+func (p *vaultProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+p.path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := doSecretRequest(req, &resp); err != nil {
+		return "", err
+	}
+
+	data := resp.Data
+	if inner, ok := data["data"]; ok {
+		// KV v2 nests the key/value map under data.data
+		var kv map[string]json.RawMessage
+		if json.Unmarshal(inner, &kv) == nil {
+			data = kv
+		}
+	}
+	var v string
+	if raw, ok := data[name]; !ok || json.Unmarshal(raw, &v) != nil {
+		return "", fmt.Errorf("vault: key %s not found at %s", name, p.path)
+	}
+	return v, nil
+}
+
+// awsSecretsProvider reads keys of one Secrets Manager secret whose
+// SecretString is a JSON object. Credentials come from the standard AWS_*
+// env vars (instance roles are not queried).
+type awsSecretsProvider struct {
+	endpoint, region, secretID         string
+	accessKey, secretKey, sessionToken string
+}
+
+func (p *awsSecretsProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"SecretId": p.secretID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+	}
+	signAWSRequest(req, body, p.accessKey, p.secretKey, p.region, "secretsmanager", clock.Now())
+
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := doSecretRequest(req, &resp); err != nil {
+		return "", err
+	}
+
+	var kv map[string]string
+	if err := json.Unmarshal([]byte(resp.SecretString), &kv); err != nil {
+		return "", fmt.Errorf("aws: secret %s is not a JSON object: %w", p.secretID, err)
+	}
+	v, ok := kv[name]
+	if !ok {
+		return "", fmt.Errorf("aws: key %s not found in %s", name, p.secretID)
+	}
+	return v, nil
+}
+
+// gcpSecretsProvider reads the latest version of one Secret Manager secret
+// per name (GCP_SECRET_PREFIX + name).
+type gcpSecretsProvider struct {
+	endpoint, project, prefix string
+	gcpToken
+}
+
+// gcpToken is an OAuth access token for Google APIs, from GCP_ACCESS_TOKEN
+// or the metadata server (GCE, Cloud Run, GKE).
+type gcpToken struct {
+	staticToken string
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+var gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+func (p *gcpToken) accessToken(ctx context.Context) (string, error) {
+	if p.staticToken != "" {
+		return p.staticToken, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && clock.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doSecretRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("gcp metadata token: %w", err)
+	}
+	p.token = resp.AccessToken
+	p.tokenExpiry = clock.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return p.token, nil
+}
+
+func (p *gcpSecretsProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s/v1/projects/%s/secrets/%s/versions/latest:access",
+		p.endpoint, url.PathEscape(p.project), url.PathEscape(p.prefix+name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(req, &resp); err != nil {
+		return "", err
+	}
+	v, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("gcp: secret %s payload: %w", name, err)
+	}
+	return string(v), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVaultProviderKV2(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/hotaru" || r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"ZOOM_CLIENT_SECRET":"new\nold","ADMIN_TOKEN":"admin"},"metadata":{"version":3}}}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("VAULT_SECRET_PATH", "secret/data/hotaru")
+	p, err := newSecretProvider("vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { managedSecrets.Store(nil); providedSecrets.Clear() })
+	if err := refreshSecrets(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if got := zoomClientSecrets(); len(got) != 2 || got[0] != "new" || got[1] != "old" {
+		t.Fatalf("zoomClientSecrets() = %q", got)
+	}
+	if got := secretValue("ZOOM_CLIENT_SECRET"); got != "new\nold" {
+		t.Fatalf("secretValue = %q", got)
+	}
+	if got := secretValue("ADMIN_TOKEN"); got != "admin" {
+		t.Fatalf("ADMIN_TOKEN = %q", got)
+	}
+
+	if _, err := p.GetSecret(context.Background(), "MISSING"); err == nil {
+		t.Fatal("expected an error for a missing key")
+	}
+}
+
+func TestGCPProviderDecodesPayload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p1/secrets/hotaru-ZOOM_CLIENT_SECRET/versions/latest:access" ||
+			r.Header.Get("Authorization") != "Bearer tok" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"payload":{"data":"` + base64.StdEncoding.EncodeToString([]byte("s3cret")) + `"}}`))
+	}))
+	defer srv.Close()
+
+	p := &gcpSecretsProvider{endpoint: srv.URL, project: "p1", prefix: "hotaru-", gcpToken: gcpToken{staticToken: "tok"}}
+	got, err := p.GetSecret(context.Background(), "ZOOM_CLIENT_SECRET")
+	if err != nil || got != "s3cret" {
+		t.Fatalf("GetSecret = %q, %v", got, err)
+	}
+}
+
+// Vector "get-vanilla" from the AWS SigV4 test suite.
+func TestSignAWSRequestVector(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	auth := req.Header.Get("Authorization")
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth != want {
+		t.Fatalf("Authorization =\n%s\nwant\n%s", auth, want)
+	}
+}
+
+func TestSecretProviderConfig(t *testing.T) {
+	if _, err := newSecretProvider("keychain"); err == nil {
+		t.Fatal("expected unknown provider error")
+	}
+	t.Setenv("VAULT_ADDR", "")
+	if _, err := newSecretProvider("vault"); err == nil || !strings.Contains(err.Error(), "VAULT_ADDR") {
+		t.Fatalf("expected missing VAULT_ADDR error, got %v", err)
+	}
+	cfg := &Config{Environment: "development", SecretProvider: "vault", ZoomSecretsFile: "secrets.txt"}
+	if err := cfg.validateEnvironment(); err == nil {
+		t.Fatal("provider and secrets file together should be rejected")
+	}
+}
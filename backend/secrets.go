@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Zoom client secrets, current first. Contexts encrypted with any of them are
+// accepted so rotating ZOOM_CLIENT_SECRET does not break in-flight panels.
+//
+//	ZOOM_CLIENT_SECRET            current secret
+//	ZOOM_CLIENT_PREVIOUS_SECRETS  comma separated secrets still accepted
+//	ZOOM_CLIENT_SECRETS_FILE      one secret per line, current first; when set
+//	                              it replaces the env vars and is reloaded on
+//	                              change or SIGHUP without a restart
+//
+// managedSecrets holds the set loaded from the secrets file or an external
+// SecretProvider; when present it takes precedence over the env vars.
+var managedSecrets atomic.Pointer[[]string]
+
+const devDummySecret = "dummy_secret_for_local_dev"
+
+var warnDummySecret sync.Once
+
+// zoomClientSecrets returns the accepted secrets, current first. Falls back to
+// a dummy secret for local development when nothing is configured.
+func zoomClientSecrets() []string {
+	if p := managedSecrets.Load(); p != nil && len(*p) > 0 {
+		return *p
+	}
+
+	var secrets []string
+	if s := strings.TrimSpace(os.Getenv("ZOOM_CLIENT_SECRET")); s != "" {
+		secrets = append(secrets, s)
+	}
+	secrets = append(secrets, splitList(os.Getenv("ZOOM_CLIENT_PREVIOUS_SECRETS"))...)
+
+	if len(secrets) == 0 {
+		// In production, this MUST be set
+		warnDummySecret.Do(func() {
+			log.Println("WARNING: ZOOM_CLIENT_SECRET is not set. Using dummy secret for development.")
+		})
+		return []string{devDummySecret}
+	}
+	return secrets
+}
+
+// getZoomClientSecret returns the current secret, used when encrypting.
+func getZoomClientSecret() string {
+	return zoomClientSecrets()[0]
+}
+
+// parseSecretLines splits a multi-line secret value, current first. Blank
+// lines and # comments are ignored.
+func parseSecretLines(value string) []string {
+	var secrets []string
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			secrets = append(secrets, line)
+		}
+	}
+	return secrets
+}
+
+func readSecretsFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secrets := parseSecretLines(string(b))
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("secrets file %s contains no secrets", path)
+	}
+	return secrets, nil
+}
+
+// loadSecretsFile replaces the active secret set from path.
+func loadSecretsFile(path string) error {
+	secrets, err := readSecretsFile(path)
+	if err != nil {
+		return err
+	}
+	managedSecrets.Store(&secrets)
+	log.Printf("Loaded %d Zoom client secret(s) from %s", len(secrets), path)
+	return nil
+}
+
+// watchSecretsFile loads path and keeps reloading it when its mtime changes
+// or the process receives SIGHUP. A failed reload keeps the previous set.
+func watchSecretsFile(path string) error {
+	if err := loadSecretsFile(path); err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	goSafe("secrets-reloader", func() {
+		var lastMod time.Time
+		if info, err := os.Stat(path); err == nil {
+			lastMod = info.ModTime()
+		}
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hup:
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil || info.ModTime().Equal(lastMod) {
+					continue
+				}
+				lastMod = info.ModTime()
+			}
+			if err := loadSecretsFile(path); err != nil {
+				log.Printf("Secret reload failed, keeping previous secrets: %v", err)
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// contentSecurityPolicy allows the HTMX and Zoom Apps SDK scripts, same-origin
+// API calls, and embedding only by Zoom. Inline styles are needed for the
+// gauge width; inline scripts are not allowed. assetOrigin is the CDN the
+// frontend files come from, if any.
+func contentSecurityPolicy(assetOrigin string) string {
+	assets := "'self'"
+	if assetOrigin != "" {
+		assets += " " + assetOrigin
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src " + assets + " https://unpkg.com https://appssdk.zoom.us",
+		"style-src " + assets + " 'unsafe-inline'",
+		"img-src " + assets + " data:",
+		"media-src " + assets,
+		"connect-src 'self' https://appssdk.zoom.us",
+		"frame-ancestors 'self' https://*.zoom.us https://*.zoom.com",
+		"base-uri 'self'",
+		"form-action 'self'",
+	}, "; ")
+}
+
+// SecurityHeadersMiddleware sets the headers Zoom's OWASP checks expect.
+// X-Frame-Options is intentionally not sent: it cannot express the Zoom
+// exception, so framing is controlled by CSP frame-ancestors instead.
+func SecurityHeadersMiddleware(csp string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", csp)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		if r.TLS != nil || fromTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https" {
+			h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
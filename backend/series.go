@@ -0,0 +1,168 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// SeriesEntry is one occurrence of a meeting series. Occurrences are per
+// day, recorded when the first panel opens and filled in on trigger.
+type SeriesEntry struct {
+	Date           string  `json:"date"` // YYYY-MM-DD in the server's time zone
+	Triggered      bool    `json:"triggered"`
+	LengthMinutes  float64 `json:"length_minutes,omitempty"`
+	OverrunMinutes float64 `json:"overrun_minutes,omitempty"`
+	Participants   int     `json:"participants,omitempty"`
+}
+
+// seriesID identifies the meetings a room's stats are aggregated over:
+// all occurrences of a recurring Zoom meeting when the API tells us it is
+// one, otherwise the room itself, which covers recurring meetings reusing
+// the same meeting ID.
+func seriesID(zCtx *ZoomAuthContext, info *MeetingInfo) string {
+	if info == nil || info.SeriesID == "" {
+		return zCtx.RoomID()
+	}
+	if zCtx.Tenant == "" {
+		return "series:" + info.SeriesID
+	}
+	return zCtx.Tenant + "/series:" + info.SeriesID
+}
+
+// seenOccurrences remembers the series recorded today by this instance so
+// polls do not write to the store.
+var seenOccurrences sync.Map // series ID -> date
+
+// recordOccurrence notes that the room's series met today.
+func recordOccurrence(ctx context.Context, zCtx *ZoomAuthContext, info *MeetingInfo) {
+	series, date := seriesID(zCtx, info), clock.Now().Format("2006-01-02")
+	if prev, ok := seenOccurrences.Swap(series, date); ok && prev == date {
+		return
+	}
+	added, err := RecordSeriesEntry(ctx, series, SeriesEntry{Date: date}, false)
+	if err != nil {
+		seenOccurrences.Delete(series)
+		logf(ctx, "RecordSeriesEntry error: %v", err)
+		return
+	}
+	if added {
+		countHeldMeeting(ctx, zCtx.Tenant, date)
+	}
+}
+
+// sweepOccurrences forgets occurrences recorded before today.
+func sweepOccurrences() {
+	today := clock.Now().Format("2006-01-02")
+	seenOccurrences.Range(func(key, val any) bool {
+		if val != today {
+			seenOccurrences.CompareAndDelete(key, val)
+		}
+		return true
+	})
+}
+
+// recordTriggeredOccurrence fills in today's occurrence from a report.
+func recordTriggeredOccurrence(ctx context.Context, r *MeetingReport) {
+	e := SeriesEntry{
+		Date:           r.TriggeredAt.Format("2006-01-02"),
+		Triggered:      true,
+		LengthMinutes:  r.Length().Minutes(),
+		OverrunMinutes: r.Overrun().Minutes(),
+		Participants:   r.Participants,
+	}
+	added, err := RecordSeriesEntry(ctx, r.SeriesID, e, true)
+	if err != nil {
+		logf(ctx, "RecordSeriesEntry error: %v", err)
+	} else if added {
+		countHeldMeeting(ctx, r.Tenant, e.Date)
+	}
+}
+
+// SeriesStats aggregates a series' occurrences.
+type SeriesStats struct {
+	SeriesID    string  `json:"series_id"`
+	Occurrences int     `json:"occurrences"`
+	Triggered   int     `json:"triggered"`
+	TriggerRate float64 `json:"trigger_rate"`
+
+	// Averages over the occurrences that triggered
+	AverageLengthMinutes  float64 `json:"average_length_minutes"`
+	AverageOverrunMinutes float64 `json:"average_overrun_minutes"`
+
+	// OverrunTrendMinutes is the average overrun of the newer half of the
+	// triggered occurrences minus that of the older half; negative means
+	// the meeting is improving
+	OverrunTrendMinutes float64 `json:"overrun_trend_minutes"`
+
+	Recent []SeriesEntry `json:"recent"` // newest first
+}
+
+const seriesRecent = 20
+
+// seriesStats aggregates entries in any order.
+func seriesStats(series string, entries []SeriesEntry) SeriesStats {
+	slices.SortFunc(entries, func(a, b SeriesEntry) int { return cmp.Compare(b.Date, a.Date) })
+	st := SeriesStats{SeriesID: series, Occurrences: len(entries), Recent: entries[:min(len(entries), seriesRecent)]}
+
+	var triggered []SeriesEntry
+	for _, e := range entries {
+		if e.Triggered {
+			triggered = append(triggered, e)
+			st.AverageLengthMinutes += e.LengthMinutes
+			st.AverageOverrunMinutes += e.OverrunMinutes
+		}
+	}
+	st.Triggered = len(triggered)
+	if st.Occurrences > 0 {
+		st.TriggerRate = float64(st.Triggered) / float64(st.Occurrences)
+	}
+	if st.Triggered > 0 {
+		st.AverageLengthMinutes /= float64(st.Triggered)
+		st.AverageOverrunMinutes /= float64(st.Triggered)
+	}
+	if half := len(triggered) / 2; half > 0 {
+		avg := func(es []SeriesEntry) float64 {
+			sum := 0.0
+			for _, e := range es {
+				sum += e.OverrunMinutes
+			}
+			return sum / float64(len(es))
+		}
+		// triggered is newest first
+		st.OverrunTrendMinutes = avg(triggered[:half]) - avg(triggered[len(triggered)-half:])
+	}
+	return st
+}
+
+// handleRoomSeries serves GET /api/rooms/{id}/series, the stats of the
+// room's meeting series, to its hosts.
+func handleRoomSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() || r.PathValue("id") != zCtx.Mid {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	series := seriesID(zCtx, meetingInfo(ctx, zCtx))
+	entries, err := SeriesEntries(ctx, series)
+	if err != nil {
+		logf(ctx, "SeriesEntries error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(seriesStats(series, entries))
+}
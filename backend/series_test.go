@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSeriesStats(t *testing.T) {
+	st := seriesStats("s", []SeriesEntry{
+		{Date: "2026-09-01", Triggered: true, LengthMinutes: 80, OverrunMinutes: 20},
+		{Date: "2026-09-22", Triggered: true, LengthMinutes: 62, OverrunMinutes: 2},
+		{Date: "2026-09-08", Triggered: true, LengthMinutes: 75, OverrunMinutes: 15},
+		{Date: "2026-09-15"},
+		{Date: "2026-09-29", Triggered: true, LengthMinutes: 60, OverrunMinutes: 0},
+	})
+	if st.Occurrences != 5 || st.Triggered != 4 || st.TriggerRate != 0.8 {
+		t.Errorf("counts: %+v", st)
+	}
+	if st.AverageLengthMinutes != 69.25 || st.AverageOverrunMinutes != 9.25 {
+		t.Errorf("averages: %+v", st)
+	}
+	// Newer half averages 1 minute over, older half 17.5
+	if math.Abs(st.OverrunTrendMinutes-(-16.5)) > 1e-9 {
+		t.Errorf("trend = %v", st.OverrunTrendMinutes)
+	}
+	if st.Recent[0].Date != "2026-09-29" || st.Recent[4].Date != "2026-09-01" {
+		t.Errorf("recent not newest first: %+v", st.Recent)
+	}
+}
+
+func TestSeriesID(t *testing.T) {
+	zCtx := &ZoomAuthContext{Mid: "m1", Tenant: "acme"}
+	if got := seriesID(zCtx, nil); got != "acme/m1" {
+		t.Errorf("without meeting info: %q", got)
+	}
+	if got := seriesID(zCtx, &MeetingInfo{SeriesID: "85746065432"}); got != "acme/series:85746065432" {
+		t.Errorf("recurring meeting: %q", got)
+	}
+}
+
+func TestSeriesOccurrences(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			fc := useFakeClock(t)
+			fc.now = time.Date(2026, 10, 5, 10, 0, 0, 0, time.Local)
+			ctx := context.Background()
+			zCtx := &ZoomAuthContext{Mid: "weekly-" + store}
+
+			// Polls record the day once; a trigger fills it in
+			recordOccurrence(ctx, zCtx, nil)
+			recordOccurrence(ctx, zCtx, nil)
+			recordTriggeredOccurrence(ctx, &MeetingReport{SeriesID: zCtx.RoomID(), StartedAt: fc.now, TriggeredAt: fc.now.Add(70 * time.Minute), Scheduled: time.Hour})
+			recordOccurrence(ctx, zCtx, nil)
+
+			fc.Advance(7 * 24 * time.Hour)
+			sweepOccurrences()
+			recordOccurrence(ctx, zCtx, nil)
+
+			entries, err := SeriesEntries(ctx, zCtx.RoomID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := seriesStats(zCtx.RoomID(), entries)
+			if st.Occurrences != 2 || st.Triggered != 1 || st.AverageOverrunMinutes != 10 {
+				t.Errorf("stats: %+v", st)
+			}
+		})
+	}
+}
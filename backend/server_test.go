@@ -0,0 +1,888 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testServer runs the full mux against miniredis.
+type testServer struct {
+	*httptest.Server
+	t  *testing.T
+	mr *miniredis.Miniredis
+}
+
+func enableDevBypass(t testing.TB) {
+	devBypass = true
+	t.Cleanup(func() { devBypass = false })
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	t.Setenv("ZOOM_CLIENT_SECRET", "test_secret")
+
+	enableDevBypass(t)
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() {
+		client.Close()
+		mr.Close()
+		rdb = nil
+		useRedis = false
+	})
+
+	handler, err := newHandler(&Config{DevTools: true, FrontendDir: "../frontend"})
+	if err != nil {
+		t.Fatalf("newHandler: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, t: t, mr: mr}
+}
+
+// testClient is one panel, identified either by query params (fallback auth)
+// or by an encrypted Zoom context header.
+type testClient struct {
+	ts         *testServer
+	room, pid  string
+	role       string
+	lang       string
+	appContext string
+
+	// lastEventID is sent as Last-Event-ID when set
+	lastEventID string
+}
+
+func (ts *testServer) queryClient(room, pid string) *testClient {
+	return &testClient{ts: ts, room: room, pid: pid}
+}
+
+func (ts *testServer) zoomClient(mid, uid string) *testClient {
+	ts.t.Helper()
+	appContext, err := EncryptZoomContext("test_secret", []byte(`{"uid":"`+uid+`","mid":"`+mid+`"}`))
+	if err != nil {
+		ts.t.Fatalf("encrypt failed: %v", err)
+	}
+	// Query params deliberately point elsewhere; the verified context must win
+	return &testClient{ts: ts, room: "wrong-room", pid: "wrong-pid", appContext: appContext}
+}
+
+func (c *testClient) do(method, path string) string {
+	c.ts.t.Helper()
+	q := url.Values{"roomId": {c.room}, "pid": {c.pid}}
+	if c.role != "" {
+		q.Set("role", c.role)
+	}
+	if c.lang != "" {
+		q.Set("lang", c.lang)
+	}
+	req, _ := http.NewRequest(method, c.ts.URL+path+"?"+q.Encode(), nil)
+	if c.appContext != "" {
+		req.Header.Set("x-zoom-app-context", c.appContext)
+	}
+	if c.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", c.lastEventID)
+	}
+	resp, err := c.ts.Client().Do(req)
+	if err != nil {
+		c.ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		c.ts.t.Fatalf("%s %s: status %d: %s", method, path, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+// post sends form to path and returns the status and body without failing
+// on errors, for tests that expect them.
+func (c *testClient) post(path string, form url.Values) (int, string) {
+	c.ts.t.Helper()
+	q := url.Values{"roomId": {c.room}, "pid": {c.pid}, "role": {c.role}}
+	req, _ := http.NewRequest(http.MethodPost, c.ts.URL+path+"?"+q.Encode(), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.appContext != "" {
+		req.Header.Set("x-zoom-app-context", c.appContext)
+	}
+	resp, err := c.ts.Client().Do(req)
+	if err != nil {
+		c.ts.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func (c *testClient) poll() string { return c.do(http.MethodGet, "/api/state") }
+func (c *testClient) vote() string { return c.do(http.MethodPost, "/api/vote") }
+
+func assertGauge(t *testing.T, who, body, width string, triggered bool) {
+	t.Helper()
+	if !strings.Contains(body, `style="width: `+width+`;"`) {
+		t.Errorf("%s: expected gauge width %s, got:\n%s", who, width, body)
+	}
+	isTriggered := strings.Contains(body, `data-triggered="true"`)
+	if isTriggered != triggered {
+		t.Errorf("%s: expected triggered=%t, got:\n%s", who, triggered, body)
+	}
+	if triggered && !strings.Contains(body, "本日の営業は終了しました") {
+		t.Errorf("%s: expected ending screen, got:\n%s", who, body)
+	}
+}
+
+func TestEndToEndTrigger(t *testing.T) {
+	ts := newTestServer(t)
+
+	clients := []*testClient{
+		ts.queryClient("e2e-room", "p1"),
+		ts.queryClient("e2e-room", "p2"),
+		ts.zoomClient("e2e-room", "z1"),
+		ts.zoomClient("e2e-room", "z2"),
+		ts.zoomClient("e2e-room", "z3"),
+	}
+	bystander := ts.queryClient("other-room", "p1")
+
+	// Everyone joins by polling once
+	for _, c := range clients {
+		c.poll()
+	}
+	bystander.poll()
+
+	for _, c := range clients {
+		assertGauge(t, c.pid, c.poll(), "0.0%", false)
+	}
+
+	// 2/5 votes: not triggered yet, every client sees 40%
+	clients[0].vote()
+	assertGauge(t, "z1 vote", clients[2].vote(), "40.0%", false)
+	for _, c := range clients {
+		assertGauge(t, c.pid, c.poll(), "40.0%", false)
+	}
+
+	// Repeated votes from the same verified identity are deduplicated
+	assertGauge(t, "z1 revote", clients[2].vote(), "40.0%", false)
+
+	// 3/5 = ceil(5/2): the voter and every other client get the ending screen
+	assertGauge(t, "z2 vote", clients[3].vote(), "100.0%", true)
+	for _, c := range clients {
+		assertGauge(t, c.pid, c.poll(), "100.0%", true)
+	}
+
+	// Other rooms are unaffected
+	assertGauge(t, "bystander", bystander.poll(), "0.0%", false)
+}
+
+func TestEndToEndDevContextCookie(t *testing.T) {
+	ts := newTestServer(t)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(ts.URL + "/dev/zoom-context?uid=cookie-user&mid=cookie-room")
+	if err != nil {
+		t.Fatalf("dev context: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Post(ts.URL+"/api/vote", "", nil)
+	if err != nil {
+		t.Fatalf("vote: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The only participant in cookie-room voted, so it triggers immediately
+	assertGauge(t, "cookie", string(body), "100.0%", true)
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	ts := newTestServer(t)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/vote", nil)
+	req.Header.Set("X-Request-ID", "support-ticket-42")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if got := resp.Header.Get("X-Request-ID"); got != "support-ticket-42" {
+		t.Errorf("expected incoming request id to be echoed, got %q", got)
+	}
+	if !strings.Contains(string(body), "request id: support-ticket-42") {
+		t.Errorf("expected request id in error body, got %q", body)
+	}
+
+	// Invalid incoming IDs are replaced
+	req.Header.Set("X-Request-ID", "<script>")
+	resp, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Request-ID"); !validRequestID(got) || got == "<script>" {
+		t.Errorf("expected a generated request id, got %q", got)
+	}
+}
+
+func TestAccessLogPrivacy(t *testing.T) {
+	enableDevBypass(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, redact := range []bool{false, true} {
+		buf.Reset()
+		h := AccessLogMiddleware(redact, AuthMiddleware(handleGetState))
+		req := httptest.NewRequest(http.MethodGet, "/api/state?roomId=r1&pid=secret-pid&zoom_context=abcdef", nil)
+		req.RemoteAddr = "127.0.0.1:50000"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		line := buf.String()
+		if !strings.Contains(line, `path="/api/state" status=200`) {
+			t.Errorf("redact=%t: unexpected access line %q", redact, line)
+		}
+		for _, leak := range []string{"secret-pid", "abcdef"} {
+			if strings.Contains(line, leak) {
+				t.Errorf("redact=%t: access log leaks %q: %q", redact, leak, line)
+			}
+		}
+		if hashed := "uid=" + hashID("secret-pid"); strings.Contains(line, hashed) == redact {
+			t.Errorf("redact=%t: expected hashed uid present=%t in %q", redact, !redact, line)
+		}
+		// Keyed, so a known uid's plain hash does not find it
+		if plain := sha256.Sum256([]byte("secret-pid")); strings.Contains(line, hex.EncodeToString(plain[:6])) {
+			t.Errorf("redact=%t: access log has the unkeyed hash: %q", redact, line)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, err := ts.Client().Get(ts.URL + "/style.css")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	csp := resp.Header.Get("Content-Security-Policy")
+	for _, want := range []string{"https://appssdk.zoom.us", "frame-ancestors 'self' https://*.zoom.us"} {
+		if !strings.Contains(csp, want) {
+			t.Errorf("expected CSP to contain %q, got %q", want, csp)
+		}
+	}
+	if strings.Contains(csp, "script-src 'self' 'unsafe-inline'") {
+		t.Errorf("CSP must not allow inline scripts: %q", csp)
+	}
+	if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("expected nosniff, got %q", got)
+	}
+	if got := resp.Header.Get("X-Frame-Options"); got != "" {
+		t.Errorf("X-Frame-Options would block Zoom embedding, got %q", got)
+	}
+}
+
+func TestDevBypassGuardrails(t *testing.T) {
+	h := AuthMiddleware(handleGetState)
+	call := func(remote string, header ...string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/state?roomId=r1&pid=p1", nil)
+		req.RemoteAddr = remote
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w.Code
+	}
+
+	if code := call("127.0.0.1:50000"); code != http.StatusUnauthorized {
+		t.Errorf("bypass disabled: expected 401, got %d", code)
+	}
+
+	enableDevBypass(t)
+	if code := call("127.0.0.1:50000"); code != http.StatusOK {
+		t.Errorf("bypass from loopback: expected 200, got %d", code)
+	}
+	if code := call("203.0.113.9:50000"); code != http.StatusUnauthorized {
+		t.Errorf("bypass from remote client: expected 401, got %d", code)
+	}
+	// ngrok on the same machine connects from loopback for anyone
+	for _, header := range [][]string{
+		{"X-Forwarded-For", "203.0.113.9"},
+		{"X-Forwarded-For", "127.0.0.1"},
+		{"Forwarded", "for=203.0.113.9;proto=https"},
+		{"Ngrok-Skip-Browser-Warning", "1"},
+	} {
+		if code := call("127.0.0.1:50000", header...); code != http.StatusUnauthorized {
+			t.Errorf("bypass forwarded with %s: expected 401, got %d", header[0], code)
+		}
+	}
+
+	t.Setenv("ENVIRONMENT", "development")
+	t.Setenv("DEV_BYPASS", "")
+	cfg, err := loadConfigArgs("serve", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DevBypass {
+		t.Errorf("expected DEV_BYPASS to be off unless set")
+	}
+
+	prod := &Config{Environment: "production", DevBypass: true}
+	if err := prod.validateEnvironment(); err == nil {
+		t.Errorf("expected DEV_BYPASS to be refused in production")
+	}
+	prod.DevBypass = false
+	if err := prod.validateEnvironment(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	ts := newTestServer(t)
+	t.Cleanup(func() { setRedisHealthy(true); shuttingDown.Store(false) })
+
+	status := func(path string) int {
+		resp, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status("/readyz"); got != http.StatusOK {
+		t.Errorf("/readyz = %d, want 200", got)
+	}
+	setRedisHealthy(false)
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("/readyz with Redis down = %d, want 503", got)
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("/healthz with Redis down = %d, want 200", got)
+	}
+	setRedisHealthy(true)
+	shuttingDown.Store(true)
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while shutting down = %d, want 503", got)
+	}
+}
+
+func TestHostPanelAndReset(t *testing.T) {
+	ts := newTestServer(t)
+
+	host := ts.queryClient("role-room", "host")
+	host.role = "host"
+	guest := ts.queryClient("role-room", "guest")
+
+	host.poll()
+	guest.vote()
+	body := host.poll()
+	if !strings.Contains(body, `id="host-panel"`) || !strings.Contains(body, "参加者 2 人 / 帰りたい 1 人") {
+		t.Errorf("host should see exact counts, got:\n%s", body)
+	}
+	if body := guest.poll(); strings.Contains(body, "host-panel") {
+		t.Errorf("participant must not see the host panel, got:\n%s", body)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/reset?roomId=role-room&pid=guest", nil)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("participant reset: status %d, want 403", resp.StatusCode)
+	}
+
+	assertGauge(t, "host after reset", host.do(http.MethodPost, "/api/reset"), "0.0%", false)
+}
+
+func TestNamedModeListsConsentingVoters(t *testing.T) {
+	ts := newTestServer(t)
+
+	host := ts.queryClient("named-room", "host")
+	host.role = "host"
+	alice := ts.queryClient("named-room", "alice")
+	bob := ts.queryClient("named-room", "bob")
+	for _, c := range []*testClient{host, alice, bob} {
+		c.poll()
+	}
+
+	postForm := func(c *testClient, path string, form url.Values) string {
+		t.Helper()
+		q := url.Values{"roomId": {c.room}, "pid": {c.pid}, "role": {c.role}}
+		resp, err := ts.Client().PostForm(ts.URL+path+"?"+q.Encode(), form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s: status %d: %s", path, resp.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	// Names sent before the host opts in are not kept
+	postForm(alice, "/api/vote", url.Values{"display_name": {"Alice"}})
+	if body := host.poll(); strings.Contains(body, "voter-list") {
+		t.Fatalf("voter list shown before named mode, got:\n%s", body)
+	}
+
+	postForm(host, "/api/named", url.Values{"enabled": {"true"}})
+	postForm(alice, "/api/vote", url.Values{"display_name": {"<b>Alice</b>"}})
+	bob.vote()
+
+	body := alice.poll()
+	if !strings.Contains(body, "帰りたい人: &lt;b&gt;Alice&lt;/b&gt; ほか匿名 1 人") {
+		t.Errorf("expected escaped name and anonymous count, got:\n%s", body)
+	}
+
+	postForm(host, "/api/named", url.Values{"enabled": {"false"}})
+	if body := host.poll(); strings.Contains(body, "Alice") {
+		t.Errorf("names must be dropped when named mode is turned off, got:\n%s", body)
+	}
+}
+
+func TestSidePollLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+
+	host := ts.queryClient("poll-room", "host")
+	host.role = "host"
+	a := ts.queryClient("poll-room", "a")
+	b := ts.queryClient("poll-room", "b")
+	for _, c := range []*testClient{host, a, b} {
+		c.poll()
+	}
+
+	if status, _ := a.post("/api/vote", url.Values{"poll": {"break"}}); status != http.StatusConflict {
+		t.Errorf("vote on a poll that is not running: status %d, want 409", status)
+	}
+	if status, _ := a.post("/api/poll", url.Values{"poll": {"break"}}); status != http.StatusForbidden {
+		t.Errorf("participant starting a poll: status %d, want 403", status)
+	}
+
+	host.post("/api/poll", url.Values{"poll": {"break"}})
+	if body := b.poll(); !strings.Contains(body, `data-poll="break" data-passed="false"`) {
+		t.Fatalf("side poll not shown, got:\n%s", body)
+	}
+
+	a.post("/api/vote", url.Values{"poll": {"break"}})
+	_, body := b.post("/api/vote", url.Values{"poll": {"break"}})
+	if !strings.Contains(body, "休憩しましょう！") {
+		t.Errorf("side poll should pass with 2 of 3, got:\n%s", body)
+	}
+	// Side poll votes never move the main gauge
+	assertGauge(t, "main gauge", body, "0.0%", false)
+
+	host.post("/api/poll", url.Values{"poll": {""}})
+	if body := a.poll(); strings.Contains(body, "side-poll") {
+		t.Errorf("closed side poll still shown, got:\n%s", body)
+	}
+}
+
+func TestRoomActions(t *testing.T) {
+	ts := newTestServer(t)
+
+	var events []TriggerEvent
+	received := make(chan *http.Request, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmacSHA256([]byte("hook_secret"), string(body))
+		if r.Header.Get("X-Hotaru-Signature") != "sha256="+hex.EncodeToString(mac) {
+			t.Errorf("bad signature %q", r.Header.Get("X-Hotaru-Signature"))
+		}
+		var ev TriggerEvent
+		json.Unmarshal(body, &ev)
+		events = append(events, ev)
+		received <- r
+	}))
+	defer hook.Close()
+	t.Setenv("TRIGGER_WEBHOOK_SECRET", "hook_secret")
+	old := actionSettings
+	t.Cleanup(func() { actionSettings = old })
+	actionSettings.webhookURL = hook.URL
+
+	host := ts.queryClient("actions-room", "host")
+	host.role = "host"
+	a := ts.queryClient("actions-room", "a")
+	host.poll()
+	a.poll()
+
+	if status, _ := a.post("/api/actions", url.Values{"actions": {"webhook"}}); status != http.StatusForbidden {
+		t.Errorf("participant setting actions: status %d, want 403", status)
+	}
+	if status, _ := host.post("/api/actions", url.Values{"actions": {"webhook,explode"}}); status != http.StatusBadRequest {
+		t.Errorf("unknown action: status %d, want 400", status)
+	}
+	if status, body := host.post("/api/actions", url.Values{"actions": {"webhook"}}); status != http.StatusOK {
+		t.Fatalf("set actions: status %d: %s", status, body)
+	}
+
+	// Half the room is enough for the default majority
+	body := a.vote()
+	if strings.Contains(body, "本日の営業は終了しました") || strings.Contains(body, `data-triggered="true"`) {
+		t.Errorf("room without the ending action shows the ending screen:\n%s", body)
+	}
+	if !strings.Contains(body, "投票が成立しました") {
+		t.Errorf("expected passed text, got:\n%s", body)
+	}
+
+	select {
+	case r := <-received:
+		if r.Header.Get("X-Hotaru-Event") != "room.triggered" {
+			t.Errorf("event header %q", r.Header.Get("X-Hotaru-Event"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	if events[0].MeetingID != "actions-room" || events[0].Votes != 1 || events[0].Participants != 2 {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+
+	// Further votes and polls must not fire a second time
+	host.vote()
+	a.poll()
+	select {
+	case <-received:
+		t.Error("webhook called twice for one trigger")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Back to the default list, which shows the ending screen
+	host.post("/api/actions", url.Values{"reset": {"true"}})
+	assertGauge(t, "default actions", a.poll(), "100.0%", true)
+}
+
+func TestSnoozePostponesEnding(t *testing.T) {
+	ts := newTestServer(t)
+	fc := useFakeClock(t)
+
+	host := ts.queryClient("snooze-room", "host")
+	host.role = "host"
+	a := ts.queryClient("snooze-room", "a")
+	b := ts.queryClient("snooze-room", "b")
+	for _, c := range []*testClient{host, a, b} {
+		c.poll()
+	}
+
+	if status, _ := a.post("/api/vote", url.Values{"poll": {"snooze"}}); status != http.StatusConflict {
+		t.Errorf("snooze before the trigger: status %d, want 409", status)
+	}
+
+	a.vote()
+	body := b.vote()
+	assertGauge(t, "triggered", body, "100.0%", true)
+	if !strings.Contains(body, "あと5分") {
+		t.Fatalf("ending screen without snooze button:\n%s", body)
+	}
+
+	// A majority snoozes the ending
+	a.post("/api/vote", url.Values{"poll": {"snooze"}})
+	_, body = b.post("/api/vote", url.Values{"poll": {"snooze"}})
+	if strings.Contains(body, `data-triggered="true"`) || !strings.Contains(body, "5:00") {
+		t.Fatalf("expected snooze countdown, got:\n%s", body)
+	}
+
+	fc.Advance(3*time.Minute + 30*time.Second)
+	if body := host.poll(); !strings.Contains(body, "1:30") {
+		t.Errorf("expected 1:30 left, got:\n%s", body)
+	}
+
+	fc.Advance(2 * time.Minute)
+	assertGauge(t, "snooze over", a.poll(), "100.0%", true)
+
+	// Snooze votes start over, so one earlier voter is not enough again
+	_, body = a.post("/api/vote", url.Values{"poll": {"snooze"}})
+	assertGauge(t, "one snooze vote", body, "100.0%", true)
+
+	if status, _ := a.post("/api/snooze", nil); status != http.StatusForbidden {
+		t.Errorf("participant snooze: status %d, want 403", status)
+	}
+	if _, body := host.post("/api/snooze", nil); !strings.Contains(body, "5:00") {
+		t.Errorf("host snooze not applied:\n%s", body)
+	}
+}
+
+func TestRoomSettingsAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	host := ts.queryClient("settings-room", "host")
+	host.role = "host"
+	a := ts.queryClient("settings-room", "a")
+	a.poll()
+
+	send := func(c *testClient, method, path, ifMatch, body string) (int, settingsResponse) {
+		t.Helper()
+		q := url.Values{"roomId": {c.room}, "pid": {c.pid}, "role": {c.role}}
+		req, _ := http.NewRequest(method, ts.URL+path+"?"+q.Encode(), strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out settingsResponse
+		if resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&out)
+		}
+		return resp.StatusCode, out
+	}
+	const path = "/api/rooms/settings-room/settings"
+
+	status, got := send(host, http.MethodGet, path, "", "")
+	if status != http.StatusOK || got.Version != 0 || got.Effective.EndingText != defaultSettings.Load().EndingText {
+		t.Fatalf("GET: status %d, %+v", status, got)
+	}
+	if status, _ := send(a, http.MethodGet, path, "", ""); status != http.StatusForbidden {
+		t.Errorf("participant GET: status %d, want 403", status)
+	}
+	if status, _ := send(host, http.MethodGet, "/api/rooms/other-room/settings", "", ""); status != http.StatusForbidden {
+		t.Errorf("GET of another meeting: status %d, want 403", status)
+	}
+
+	update := `{"gauge_stages":[{"percent":0,"text":"まだ早い"},{"percent":26,"text":"そろそろ…"}]}`
+	status, got = send(host, http.MethodPut, path, `"0"`, update)
+	if status != http.StatusOK || got.Version != 1 {
+		t.Fatalf("PUT: status %d, %+v", status, got)
+	}
+	if body := a.poll(); !strings.Contains(body, "まだ早い") {
+		t.Errorf("panel did not pick up the new settings:\n%s", body)
+	}
+
+	if status, _ := send(host, http.MethodPut, path, `"0"`, update); status != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: status %d, want 412", status)
+	}
+	if status, _ := send(host, http.MethodPut, path, "", `{"gauge_stages":[{"percent":50,"text":"x"}]}`); status != http.StatusBadRequest {
+		t.Errorf("unordered stages: status %d, want 400", status)
+	}
+	if status, _ := send(host, http.MethodPut, path, "", `{"colour":"red"}`); status != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d, want 400", status)
+	}
+}
+
+func TestFeatureFlagsDisableFeatures(t *testing.T) {
+	ts := newTestServer(t)
+
+	host := ts.queryClient("flags-room", "host")
+	host.role = "host"
+	a := ts.queryClient("flags-room", "a")
+	host.poll()
+	a.poll()
+	if _, err := SetRoomSettings(t.Context(), "flags-room", RoomSettings{Features: map[string]bool{"snooze": false, "named_mode": false}}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := host.poll(); strings.Contains(body, "名前表示をオンにする") {
+		t.Errorf("named mode toggle shown while disabled:\n%s", body)
+	}
+	if status, _ := host.post("/api/named", url.Values{"enabled": {"true"}}); status != http.StatusForbidden {
+		t.Errorf("enable named mode: status %d, want 403", status)
+	}
+
+	body := a.vote()
+	assertGauge(t, "triggered", body, "100.0%", true)
+	if strings.Contains(body, "あと5分") {
+		t.Errorf("snooze offered while disabled:\n%s", body)
+	}
+	if status, _ := host.post("/api/snooze", nil); status != http.StatusForbidden {
+		t.Errorf("host snooze: status %d, want 403", status)
+	}
+}
+
+func TestThemes(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, err := ts.Client().Get(ts.URL + "/api/themes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []Theme
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 4 || list[0].ID != "classic" || list[3].Label != "忘年会スペシャル" {
+		t.Errorf("themes = %+v", list)
+	}
+
+	a := ts.queryClient("theme-room", "a")
+	if body := a.poll(); !strings.Contains(body, `class="theme theme-classic"`) {
+		t.Errorf("default theme not rendered:\n%s", body)
+	}
+
+	SetRoomSettings(t.Context(), "theme-room", RoomSettings{Theme: "minimal"}, -1)
+	body := a.poll()
+	if !strings.Contains(body, `data-theme="minimal" data-audio=""`) {
+		t.Errorf("minimal theme should render without music:\n%s", body)
+	}
+
+	s := RoomSettings{Theme: "vaporwave"}
+	if err := s.validate(); err == nil {
+		t.Error("unknown theme accepted")
+	}
+}
+
+func TestInitialPanelState(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.zoomClient("index-room", "z1")
+	c.vote()
+
+	// The page arrives with the room's state and the context for zoom-init.js
+	page := c.do(http.MethodGet, "/")
+	assertGauge(t, "index", page, "100.0%", true)
+	if !strings.Contains(page, `<meta name="zoom-app-context" content="`+c.appContext+`">`) {
+		t.Errorf("context not echoed:\n%s", page)
+	}
+	if strings.Contains(page, "Zoom連携待機中") || strings.Contains(page, "{{") {
+		t.Errorf("unexpected placeholder:\n%s", page)
+	}
+
+	// Without a context the placeholder stays, and a bogus header is escaped
+	devBypass = false
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/index.html", nil)
+	req.Header.Set("x-zoom-app-context", `"><script>alert(1)</script>`)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Zoom連携待機中") {
+		t.Errorf("anonymous index: %d\n%s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), "<script>alert") {
+		t.Errorf("context header was not escaped:\n%s", body)
+	}
+}
+
+func TestLocalizedPanel(t *testing.T) {
+	ts := newTestServer(t)
+	ja := ts.queryClient("locale-room", "p1")
+	en := ts.queryClient("locale-room", "p2")
+	en.lang, en.role = "en-US", "host"
+
+	// Each client keeps its own language in the same room
+	if body := ja.poll(); !strings.Contains(body, "待機中") || !strings.Contains(body, `lang="ja"`) {
+		t.Errorf("expected a Japanese panel, got:\n%s", body)
+	}
+	if body := en.poll(); !strings.Contains(body, "Waiting") || !strings.Contains(body, "2 participants / 0 want to leave") || !strings.Contains(body, `lang="en"`) {
+		t.Errorf("expected an English panel, got:\n%s", body)
+	}
+	if page := en.do(http.MethodGet, "/"); !strings.Contains(page, `<html lang="en">`) || !strings.Contains(page, ">Leave</button>") {
+		t.Errorf("expected an English page, got:\n%s", page)
+	}
+}
+
+func TestAccessibilityPrefs(t *testing.T) {
+	ts := newTestServer(t)
+	get := func(query string, header ...string) string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/state?roomId=a11y-room&pid=p1&"+query, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	body := get("")
+	for _, want := range []string{`role="progressbar"`, `aria-valuenow="0"`, `role="status"`, `class="sr-only"> 0% が帰りたい`, `data-audio="hotaru-piano.mp3?v=`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in:\n%s", want, body)
+		}
+	}
+	if body := get("audio=off"); !strings.Contains(body, `data-audio=""`) {
+		t.Errorf("muted panel still has audio:\n%s", body)
+	}
+
+	// One of three participants votes: 33% reaches the fireflies stage
+	ts.queryClient("a11y-room", "p3").poll()
+	ts.queryClient("a11y-room", "p2").vote()
+	if body := get(""); !strings.Contains(body, "ambience-fireflies") {
+		t.Errorf("expected fireflies:\n%s", body)
+	}
+	if body := get("", "Sec-CH-Prefers-Reduced-Motion", "reduce"); strings.Contains(body, "ambience") {
+		t.Errorf("reduced motion panel has animations:\n%s", body)
+	}
+	ts.queryClient("a11y-room", "p3").vote()
+	if body := get("motion=reduce"); !strings.Contains(body, `role="alert"`) {
+		t.Errorf("ending is not announced:\n%s", body)
+	}
+}
+
+func TestMeetingReport(t *testing.T) {
+	ts := newTestServer(t)
+	fc := useFakeClock(t)
+
+	host := ts.queryClient("report-room", "host")
+	host.role = "host"
+	a := ts.queryClient("report-room", "a")
+	b := ts.queryClient("report-room", "b")
+	for _, c := range []*testClient{host, a, b, ts.queryClient("report-room", "c")} {
+		c.poll()
+	}
+	if status, _ := host.post("/api/rooms/report-room/report", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("POST report: status %d", status)
+	}
+
+	fc.Advance(40 * time.Minute)
+	a.vote()
+	fc.Advance(5 * time.Minute)
+	body := b.vote()
+	assertGauge(t, "triggered", body, "100.0%", true)
+	for _, want := range []string{`id="report"`, "45分", "開始から 40分", "<dd>5分</dd>", "<dd>50%</dd>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report is missing %q:\n%s", want, body)
+		}
+	}
+
+	var report struct {
+		Participants          int     `json:"participants"`
+		Votes                 int     `json:"votes"`
+		LengthMinutes         float64 `json:"length_minutes"`
+		TimeToMajorityMinutes float64 `json:"time_to_majority_minutes"`
+		ParticipationRate     float64 `json:"participation_rate"`
+	}
+	if err := json.Unmarshal([]byte(host.do(http.MethodGet, "/api/rooms/report-room/report")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Participants != 4 || report.Votes != 2 || report.LengthMinutes != 45 || report.TimeToMajorityMinutes != 5 || report.ParticipationRate != 0.5 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	var series SeriesStats
+	if err := json.Unmarshal([]byte(host.do(http.MethodGet, "/api/rooms/report-room/series")), &series); err != nil {
+		t.Fatal(err)
+	}
+	if series.Occurrences != 1 || series.Triggered != 1 || series.AverageLengthMinutes != 45 {
+		t.Errorf("unexpected series %+v", series)
+	}
+
+	// Reports are for hosts only
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/rooms/report-room/report?roomId=report-room&pid=a", nil)
+	if resp, err := ts.Client().Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("participant report access: %v %v", resp.Status, err)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// GaugeStage is the status text shown once the gauge reaches Percent.
+type GaugeStage struct {
+	Percent float64 `json:"percent"`
+	Text    string  `json:"text"`
+}
+
+// RoomSettings control when a room triggers and how its panel is rendered.
+// Rooms and tenants store only the fields they override; empty fields fall
+// back to the tenant's settings, then to defaultSettings.
+type RoomSettings struct {
+	// ThresholdPercent of participants must vote 帰る to trigger the room
+	ThresholdPercent float64 `json:"threshold_percent,omitempty"`
+
+	// MinPresenceSeconds a participant must have been in the room before
+	// their 帰る vote counts, so a drive-by join cannot tip a small room
+	MinPresenceSeconds int `json:"min_presence_seconds,omitempty"`
+
+	// HoldSeconds the threshold must stay met before the room triggers
+	HoldSeconds int `json:"hold_seconds,omitempty"`
+
+	// DepartureRule is what happens when participants leave and the
+	// smaller count passes the threshold: departureFire or departureVote
+	DepartureRule string `json:"departure_rule,omitempty"`
+
+	// AfterHours lower the threshold late in the day, read in TimeZone
+	AfterHours []AfterHoursStage `json:"after_hours,omitempty"`
+
+	// TimeZone is the IANA name of the zone the room's times of day are
+	// in, e.g. Asia/Tokyo; empty for the server's
+	TimeZone string `json:"time_zone,omitempty"`
+
+	// HolidayThresholdPercent replaces a higher threshold on weekends, on
+	// the holidays of HolidayCalendar and on ExtraHolidays, where
+	// HolidayText greets the room instead of the first gauge stage
+	HolidayThresholdPercent float64  `json:"holiday_threshold_percent,omitempty"`
+	HolidayCalendar         string   `json:"holiday_calendar,omitempty"`
+	ExtraHolidays           []string `json:"extra_holidays,omitempty"`
+	HolidayText             string   `json:"holiday_text,omitempty"`
+
+	// NextMeetingThresholdPercent replaces a higher threshold once the
+	// host's next meeting on the calendar is 5 minutes away
+	NextMeetingThresholdPercent float64 `json:"next_meeting_threshold_percent,omitempty"`
+
+	GaugeStages    []GaugeStage    `json:"gauge_stages,omitempty"`
+	AmbienceStages []AmbienceStage `json:"ambience_stages,omitempty"`
+	EndingText     string          `json:"ending_text,omitempty"`
+	EndingSubtext  string          `json:"ending_subtext,omitempty"`
+
+	// Theme is the ID of the panel theme, see themes
+	Theme string `json:"theme,omitempty"`
+
+	// Audio is the ID of an uploaded ending audio played instead of the
+	// theme's music, see handleAudio
+	Audio string `json:"audio,omitempty"`
+
+	// Features switch feature flags on or off, merged flag by flag
+	Features map[string]bool `json:"features,omitempty"`
+}
+
+// builtinSettings are the defaults before any configuration.
+var builtinSettings = RoomSettings{
+	ThresholdPercent: 50,
+	GaugeStages:      []GaugeStage{{0, "待機中"}, {1, "そろそろ…"}},
+	AmbienceStages:   []AmbienceStage{{25, "fireflies"}, {40, "dim"}},
+	EndingText:       "本日の営業は終了しました",
+	EndingSubtext:    "速やかにご退出ください",
+	HolidayCalendar:  holidayCalendarJapan,
+	HolidayText:      "休日の会議、おつかれさまです",
+	Theme:            "classic",
+}
+
+// defaultSettings are the deployment defaults, set from Config by serve
+// and replaced on a config reload.
+var defaultSettings atomic.Pointer[RoomSettings]
+
+func init() {
+	defaultSettings.Store(&builtinSettings)
+}
+
+// configureDefaultSettings applies the settings defaults from cfg.
+func configureDefaultSettings(cfg *Config) error {
+	gauge, err := parseGaugeStages(cfg.GaugeStages)
+	if err != nil {
+		return err
+	}
+	ambience, err := parseAmbienceStages(cfg.AmbienceStages)
+	if err != nil {
+		return err
+	}
+	rollout, err := parseFeatureFlags(cfg.FeatureFlags)
+	if err != nil {
+		return err
+	}
+	afterHours, err := parseAfterHours(cfg.AfterHours)
+	if err != nil {
+		return err
+	}
+	holidays := splitList(cfg.ExtraHolidays)
+	if cfg.TriggerThreshold <= 0 {
+		return fmt.Errorf("TRIGGER_THRESHOLD must be in (0, 100]")
+	}
+	if cfg.MinPresence < 0 {
+		return fmt.Errorf("MIN_PRESENCE must not be negative")
+	}
+	if cfg.TriggerHold < 0 {
+		return fmt.Errorf("TRIGGER_HOLD must not be negative")
+	}
+	s := RoomSettings{
+		ThresholdPercent:   cfg.TriggerThreshold,
+		MinPresenceSeconds: int(cfg.MinPresence / time.Second),
+		HoldSeconds:        int(cfg.TriggerHold / time.Second),
+		DepartureRule:      cfg.DepartureRule,
+		AfterHours:         afterHours,
+		TimeZone:           cfg.TimeZone,
+
+		HolidayThresholdPercent: cfg.HolidayThreshold,
+		HolidayCalendar:         cfg.HolidayCalendar,
+		ExtraHolidays:           holidays,
+		HolidayText:             cfg.HolidayText,
+
+		NextMeetingThresholdPercent: cfg.NextMeetingThreshold,
+
+		GaugeStages:    gauge,
+		AmbienceStages: ambience,
+		EndingText:     cfg.EndingText,
+		EndingSubtext:  cfg.EndingSubtext,
+		Theme:          cfg.Theme,
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+	merged := s.over(builtinSettings)
+	featureRollout.Store(&rollout)
+	defaultSettings.Store(&merged)
+	return nil
+}
+
+const maxSettingText = 64 // runes
+
+// Departure rules
+const (
+	departureFire = "fire" // trigger as soon as the threshold is passed
+	departureVote = "vote" // wait for one more vote
+)
+
+const (
+	maxMinPresence = 3600 // seconds
+	maxHold        = 600  // seconds
+)
+
+// parseGaugeStages parses "0:待機中,1:そろそろ…".
+func parseGaugeStages(s string) ([]GaugeStage, error) {
+	var stages []GaugeStage
+	for _, item := range splitList(s) {
+		pct, text, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("gauge stage %q: want percent:text", item)
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil {
+			return nil, fmt.Errorf("gauge stage %q: bad percent", item)
+		}
+		stages = append(stages, GaugeStage{Percent: p, Text: strings.TrimSpace(text)})
+	}
+	return stages, validateGaugeStages(stages)
+}
+
+// validateGaugeStages requires stages to start at 0% and strictly increase
+// below 100%, so every fill has exactly one text.
+func validateGaugeStages(stages []GaugeStage) error {
+	for i, st := range stages {
+		if i == 0 && st.Percent != 0 {
+			return fmt.Errorf("the first gauge stage must start at 0%%")
+		}
+		if st.Percent < 0 || st.Percent >= 100 {
+			return fmt.Errorf("gauge stage %q: percent must be in [0, 100)", st.Text)
+		}
+		if i > 0 && st.Percent <= stages[i-1].Percent {
+			return fmt.Errorf("gauge stage %q: percentages must increase", st.Text)
+		}
+		if err := validateSettingText("gauge stage text", st.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSettingText(what, s string) error {
+	if strings.TrimSpace(s) == "" {
+		return fmt.Errorf("%s must not be empty", what)
+	}
+	if utf8.RuneCountInString(s) > maxSettingText {
+		return fmt.Errorf("%s must be at most %d characters", what, maxSettingText)
+	}
+	return nil
+}
+
+// validate checks the fields that are set.
+func (s *RoomSettings) validate() error {
+	if s.ThresholdPercent < 0 || s.ThresholdPercent > 100 {
+		return fmt.Errorf("threshold_percent must be in (0, 100]")
+	}
+	if s.MinPresenceSeconds < 0 || s.MinPresenceSeconds > maxMinPresence {
+		return fmt.Errorf("min_presence_seconds must be in [0, %d]", maxMinPresence)
+	}
+	if s.HoldSeconds < 0 || s.HoldSeconds > maxHold {
+		return fmt.Errorf("hold_seconds must be in [0, %d]", maxHold)
+	}
+	switch s.DepartureRule {
+	case "", departureFire, departureVote:
+	default:
+		return fmt.Errorf("departure_rule must be %q or %q", departureFire, departureVote)
+	}
+	if err := validateAfterHours(s.AfterHours); err != nil {
+		return err
+	}
+	if err := validateTimeZone(s.TimeZone); err != nil {
+		return err
+	}
+	if err := s.validateHolidays(); err != nil {
+		return err
+	}
+	if s.NextMeetingThresholdPercent < 0 || s.NextMeetingThresholdPercent > 100 {
+		return fmt.Errorf("next_meeting_threshold_percent must be in [0, 100]")
+	}
+	if err := validateGaugeStages(s.GaugeStages); err != nil {
+		return err
+	}
+	if err := validateAmbienceStages(s.AmbienceStages); err != nil {
+		return err
+	}
+	if err := validateTheme(s.Theme); err != nil {
+		return err
+	}
+	if err := validateAudio(s.Audio); err != nil {
+		return err
+	}
+	if err := validateFeatures(s.Features); err != nil {
+		return err
+	}
+	if s.EndingText != "" {
+		if err := validateSettingText("ending text", s.EndingText); err != nil {
+			return err
+		}
+	}
+	if s.EndingSubtext != "" {
+		if err := validateSettingText("ending subtext", s.EndingSubtext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// over returns s with its empty fields filled from base.
+func (s RoomSettings) over(base RoomSettings) RoomSettings {
+	if s.ThresholdPercent == 0 {
+		s.ThresholdPercent = base.ThresholdPercent
+	}
+	if s.MinPresenceSeconds == 0 {
+		s.MinPresenceSeconds = base.MinPresenceSeconds
+	}
+	if s.HoldSeconds == 0 {
+		s.HoldSeconds = base.HoldSeconds
+	}
+	if s.DepartureRule == "" {
+		s.DepartureRule = base.DepartureRule
+	}
+	if s.AfterHours == nil {
+		s.AfterHours = base.AfterHours
+	}
+	if s.TimeZone == "" {
+		s.TimeZone = base.TimeZone
+	}
+	if s.HolidayThresholdPercent == 0 {
+		s.HolidayThresholdPercent = base.HolidayThresholdPercent
+	}
+	if s.HolidayCalendar == "" {
+		s.HolidayCalendar = base.HolidayCalendar
+	}
+	if s.ExtraHolidays == nil {
+		s.ExtraHolidays = base.ExtraHolidays
+	}
+	if s.HolidayText == "" {
+		s.HolidayText = base.HolidayText
+	}
+	if s.NextMeetingThresholdPercent == 0 {
+		s.NextMeetingThresholdPercent = base.NextMeetingThresholdPercent
+	}
+	if s.GaugeStages == nil {
+		s.GaugeStages = base.GaugeStages
+	}
+	if s.AmbienceStages == nil {
+		s.AmbienceStages = base.AmbienceStages
+	}
+	if s.EndingText == "" {
+		s.EndingText = base.EndingText
+	}
+	if s.EndingSubtext == "" {
+		s.EndingSubtext = base.EndingSubtext
+	}
+	if s.Theme == "" {
+		s.Theme = base.Theme
+	}
+	if s.Audio == "" {
+		s.Audio = base.Audio
+	}
+	if base.Features != nil {
+		merged := maps.Clone(base.Features)
+		maps.Copy(merged, s.Features)
+		s.Features = merged
+	}
+	return s
+}
+
+// gaugeText returns the status text for fill.
+func (s *RoomSettings) gaugeText(fill float64) string {
+	text := ""
+	for _, st := range s.GaugeStages {
+		if fill < st.Percent {
+			break
+		}
+		text = st.Text
+	}
+	return text
+}
+
+// triggerPoll returns the room's 帰る poll with its threshold applied.
+func (s *RoomSettings) triggerPoll() *Poll {
+	if s.ThresholdPercent == 50 && s.MinPresenceSeconds == 0 && s.HoldSeconds == 0 {
+		return defaultPoll
+	}
+	p := *defaultPoll
+	if s.ThresholdPercent != 50 {
+		p.Threshold = percentThreshold(s.ThresholdPercent)
+	}
+	p.MinPresence = time.Duration(s.MinPresenceSeconds) * time.Second
+	p.Hold = time.Duration(s.HoldSeconds) * time.Second
+	return &p
+}
+
+// baseSettings are the settings rooms of tenant inherit: the tenant's
+// organization defaults over the deployment defaults.
+func baseSettings(tenant string) RoomSettings {
+	if t := tenantByID(tenant); t != nil && t.Settings != nil {
+		return t.Settings.over(*defaultSettings.Load())
+	}
+	return *defaultSettings.Load()
+}
+
+// roomSettings resolves the room's effective settings: room overrides, the
+// active season unless the room or tenant opted out, the tenant defaults,
+// then the deployment defaults, with the threshold lowered after hours, on
+// days off and before the host's next meeting.
+// Store errors fall back to the defaults so the panel keeps rendering.
+func roomSettings(ctx context.Context, zCtx *ZoomAuthContext) RoomSettings {
+	s, _, err := RoomSettingsOverride(ctx, zCtx.RoomID())
+	if err != nil {
+		logf(ctx, "RoomSettings error: %v", err)
+	}
+	base := baseSettings(zCtx.Tenant)
+	if season := activeSeason.Load(); season != nil {
+		if merged := s.over(base); merged.feature(zCtx.RoomID(), "seasonal_themes") {
+			base = season.Settings.over(base)
+		}
+	}
+	merged := s.over(base)
+	now := clock.Now()
+	merged.ThresholdPercent = merged.thresholdAt(now)
+	merged.applyDayOff(now)
+	if merged.NextMeetingThresholdPercent > 0 && meetingInfo(ctx, zCtx).nextMeetingSoon(now) {
+		merged.ThresholdPercent = min(merged.ThresholdPercent, merged.NextMeetingThresholdPercent)
+	}
+	return merged
+}
+
+// settingsResponse is the body of GET and PUT /api/rooms/{id}/settings.
+type settingsResponse struct {
+	Version   int64        `json:"version"`
+	Settings  RoomSettings `json:"settings"`  // the room's overrides
+	Effective RoomSettings `json:"effective"` // overrides merged over the defaults
+}
+
+func writeSettings(w http.ResponseWriter, zCtx *ZoomAuthContext, s RoomSettings, version int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+	json.NewEncoder(w).Encode(settingsResponse{Version: version, Settings: s, Effective: s.over(baseSettings(zCtx.Tenant))})
+}
+
+// handleRoomSettings serves GET and PUT /api/rooms/{id}/settings for the
+// host of meeting id. PUT replaces the overrides; send If-Match with the
+// version from GET to avoid overwriting another host's change. Panels pick
+// up the new settings on their next poll.
+func handleRoomSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() || r.PathValue("id") != zCtx.Mid {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s, version, err := RoomSettingsOverride(ctx, zCtx.RoomID())
+		if err != nil {
+			logf(ctx, "RoomSettings error: %v", err)
+			httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		writeSettings(w, zCtx, s, version)
+
+	case http.MethodPut:
+		var s RoomSettings
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&s); err != nil {
+			httpError(w, ctx, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.validate(); err != nil {
+			httpError(w, ctx, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.Audio != "" {
+			if audioFiles == nil {
+				httpError(w, ctx, "Bad Request: audio uploads are not enabled", http.StatusBadRequest)
+				return
+			}
+			if _, err := audioFiles.get(ctx, zCtx.Tenant, s.Audio); errors.Is(err, os.ErrNotExist) {
+				httpError(w, ctx, "Bad Request: audio "+s.Audio+" was not uploaded", http.StatusBadRequest)
+				return
+			} else if err != nil {
+				logf(ctx, "Audio read error: %v", err)
+				httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		expect := int64(-1)
+		if m := r.Header.Get("If-Match"); m != "" {
+			v, err := strconv.ParseInt(strings.Trim(m, `"`), 10, 64)
+			if err != nil {
+				httpError(w, ctx, "Bad Request: If-Match must be a settings version", http.StatusBadRequest)
+				return
+			}
+			expect = v
+		}
+
+		version, err := SetRoomSettings(ctx, zCtx.RoomID(), s, expect)
+		if errors.Is(err, errSettingsConflict) {
+			httpError(w, ctx, "Precondition Failed: settings were changed, reload them", http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			logf(ctx, "SetRoomSettings error: %v", err)
+			httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		logf(ctx, "Room settings updated to version %d", version)
+		writeSettings(w, zCtx, s, version)
+
+	default:
+		httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
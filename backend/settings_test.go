@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGaugeStages(t *testing.T) {
+	stages, err := parseGaugeStages("0:まだ早い, 26:そろそろ…,50:帰宅")
+	if err != nil || len(stages) != 3 || stages[1].Percent != 26 || stages[2].Text != "帰宅" {
+		t.Fatalf("parseGaugeStages = %+v, %v", stages, err)
+	}
+	s := RoomSettings{GaugeStages: stages}
+	for fill, want := range map[float64]string{0: "まだ早い", 25.9: "まだ早い", 26: "そろそろ…", 80: "帰宅"} {
+		if got := s.gaugeText(fill); got != want {
+			t.Errorf("gaugeText(%v) = %q, want %q", fill, got, want)
+		}
+	}
+
+	for _, bad := range []string{"10:a,20:b", "0:a,50:b,30:c", "0:a,0:b", "0:a,100:b", "0:", "0:" + strings.Repeat("長", 65), "x:a"} {
+		if _, err := parseGaugeStages(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestRoomSettingsOverride(t *testing.T) {
+	mr, client := setupTestRedis()
+	defer mr.Close()
+	defer client.Close()
+	rdb = client
+	defer func() { useRedis = false; rdb = nil }()
+	ctx := context.Background()
+
+	if s := roomSettings(ctx, &ZoomAuthContext{Mid: "settingsRoom"}); s.EndingText != defaultSettings.Load().EndingText || len(s.GaugeStages) != len(defaultSettings.Load().GaugeStages) {
+		t.Errorf("room without overrides = %+v", s)
+	}
+
+	override := RoomSettings{EndingText: "おつかれさまでした", GaugeStages: []GaugeStage{{0, "まだ早い"}, {26, "そろそろ…"}}}
+	if _, err := SetRoomSettings(ctx, "settingsRoom", override, -1); err != nil {
+		t.Fatal(err)
+	}
+	s := roomSettings(ctx, &ZoomAuthContext{Mid: "settingsRoom"})
+	if s.EndingText != "おつかれさまでした" || s.gaugeText(30) != "そろそろ…" {
+		t.Errorf("override not applied: %+v", s)
+	}
+	if s.EndingSubtext != defaultSettings.Load().EndingSubtext || len(s.AmbienceStages) != len(defaultSettings.Load().AmbienceStages) {
+		t.Errorf("unset fields should use the defaults: %+v", s)
+	}
+}
+
+func TestTenantSettings(t *testing.T) {
+	useRedis = false
+	ctx := context.Background()
+	useTenants(t, []*Tenant{{ID: "acme", Secrets: []string{"s"}, Settings: &RoomSettings{ThresholdPercent: 75, EndingText: "acme の終業"}}})
+
+	zCtx := &ZoomAuthContext{Mid: "orgRoom", Tenant: "acme"}
+	s := roomSettings(ctx, zCtx)
+	if s.EndingText != "acme の終業" || s.EndingSubtext != defaultSettings.Load().EndingSubtext {
+		t.Errorf("tenant defaults not applied: %+v", s)
+	}
+	if p := s.triggerPoll(); p.Threshold(4, 2) || !p.Threshold(4, 3) {
+		t.Errorf("75%% threshold: 2 of 4 and 3 of 4 evaluated wrong")
+	}
+
+	// Room overrides win over the organization defaults
+	SetRoomSettings(ctx, zCtx.RoomID(), RoomSettings{EndingText: "また明日"}, -1)
+	if s := roomSettings(ctx, zCtx); s.EndingText != "また明日" || s.ThresholdPercent != 75 {
+		t.Errorf("room override: %+v", s)
+	}
+
+	// Other tenants keep the deployment defaults
+	if s := roomSettings(ctx, &ZoomAuthContext{Mid: "orgRoom", Tenant: "globex"}); s.ThresholdPercent != 50 {
+		t.Errorf("other tenant threshold = %v", s.ThresholdPercent)
+	}
+}
+
+func TestAfterHoursThreshold(t *testing.T) {
+	stages, err := parseAfterHours("18:00=40, 20:00=30")
+	if err != nil || len(stages) != 2 || stages[1].From != "20:00" || stages[1].ThresholdPercent != 30 {
+		t.Fatalf("parseAfterHours = %+v, %v", stages, err)
+	}
+	for _, bad := range []string{"18:00", "18:00=0", "18:00=101", "25:00=40", "20:00=30,18:00=40", "18:00=40,18:00=30", "6pm=40"} {
+		if _, err := parseAfterHours(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+	if err := (&RoomSettings{TimeZone: "Mars/Olympus_Mons"}).validate(); err == nil {
+		t.Error("unknown time zone accepted")
+	}
+
+	// The organization works in Tokyo; the server runs in UTC
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+	useTenants(t, []*Tenant{{ID: "acme", Secrets: []string{"s"}, Settings: &RoomSettings{TimeZone: "Asia/Tokyo", AfterHours: stages}}})
+	zCtx := &ZoomAuthContext{Mid: "lateRoom", Tenant: "acme"}
+	PurgeRoom(ctx, zCtx.RoomID()) // left over from an earlier -count run
+	for _, c := range []struct {
+		utc  string
+		want float64
+	}{
+		{"08:59", 50}, // 17:59 in Tokyo
+		{"09:00", 40},
+		{"11:30", 30},
+		{"14:59", 30}, // 23:59
+		{"15:00", 50}, // a new day
+	} {
+		at, _ := time.Parse("15:04", c.utc)
+		fc.now = time.Date(2026, 10, 16, at.Hour(), at.Minute(), 0, 0, time.UTC)
+		if got := roomSettings(ctx, zCtx).ThresholdPercent; got != c.want {
+			t.Errorf("%s UTC: threshold %v, want %v", c.utc, got, c.want)
+		}
+	}
+
+	// A room asking for less than the stage keeps its own threshold
+	SetRoomSettings(ctx, zCtx.RoomID(), RoomSettings{ThresholdPercent: 25}, -1)
+	fc.now = time.Date(2026, 10, 16, 11, 30, 0, 0, time.UTC)
+	if got := roomSettings(ctx, zCtx).ThresholdPercent; got != 25 {
+		t.Errorf("room threshold %v, want 25", got)
+	}
+
+	// 1 of 3 is no majority, but enough at 20:30 in Tokyo
+	SetRoomSettings(ctx, zCtx.RoomID(), RoomSettings{}, -1)
+	for _, uid := range []string{"a", "b", "c"} {
+		AddParticipant(ctx, zCtx.RoomID(), uid)
+	}
+	Vote(ctx, zCtx.RoomID(), "a")
+	passedAt := func(hour, minute int) bool {
+		fc.now = time.Date(2026, 10, 16, hour, minute, 0, 0, time.UTC)
+		s := roomSettings(ctx, zCtx)
+		_, _, passed, _ := PollStatus(ctx, zCtx.RoomID(), s.triggerPoll())
+		return passed
+	}
+	if passedAt(8, 0) {
+		t.Error("passed at 17:00")
+	}
+	if !passedAt(11, 30) {
+		t.Error("not passed at 20:30")
+	}
+}
+
+func TestJapaneseHolidays(t *testing.T) {
+	// From the Cabinet Office's list
+	for year, want := range map[int][]int{
+		2024: {101, 108, 211, 212, 223, 320, 429, 503, 504, 505, 506, 715, 811, 812, 916, 922, 923, 1014, 1103, 1104, 1123},
+		2026: {101, 112, 211, 223, 320, 429, 503, 504, 505, 506, 720, 811, 921, 922, 923, 1012, 1103, 1123},
+		2021: {101, 111, 211, 223, 320, 429, 503, 504, 505, 722, 723, 808, 809, 920, 923, 1103, 1123},
+	} {
+		var got []int
+		for md := range japaneseHolidays(year) {
+			got = append(got, md)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%d: %v, want %v", year, got, want)
+		}
+	}
+}
+
+func TestHolidayThreshold(t *testing.T) {
+	for _, bad := range []RoomSettings{
+		{HolidayCalendar: "us"},
+		{ExtraHolidays: []string{"12/29"}},
+		{HolidayThresholdPercent: 101},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+
+	// Tokyo again, with the company's year end off
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+	useTenants(t, []*Tenant{{ID: "acme", Secrets: []string{"s"}, Settings: &RoomSettings{
+		TimeZone:                "Asia/Tokyo",
+		HolidayThresholdPercent: 30,
+		ExtraHolidays:           []string{"12-29", "2026-10-30"},
+	}}})
+	zCtx := &ZoomAuthContext{Mid: "holidayRoom", Tenant: "acme"}
+	PurgeRoom(ctx, zCtx.RoomID()) // left over from an earlier -count run
+	for _, c := range []struct {
+		at   string // UTC
+		want float64
+	}{
+		{"2026-10-16T01:00", 50}, // Friday
+		{"2026-10-16T15:00", 30}, // Saturday in Tokyo
+		{"2026-10-19T01:00", 50},
+		{"2026-11-03T01:00", 30}, // 文化の日
+		{"2026-09-22T01:00", 30}, // 国民の休日
+		{"2026-05-06T01:00", 30}, // 振替休日
+		{"2026-12-29T01:00", 30},
+		{"2027-12-29T01:00", 30},
+		{"2026-10-30T01:00", 30},
+		{"2027-10-29T01:00", 50},
+	} {
+		fc.now, _ = time.Parse("2006-01-02T15:04", c.at)
+		s := roomSettings(ctx, zCtx)
+		if s.ThresholdPercent != c.want {
+			t.Errorf("%s UTC: threshold %v, want %v", c.at, s.ThresholdPercent, c.want)
+		}
+		if text := s.gaugeText(0); (text == "休日の会議、おつかれさまです") != (c.want == 30) {
+			t.Errorf("%s UTC: gauge says %q", c.at, text)
+		}
+		if text := s.gaugeText(10); text != "そろそろ…" {
+			t.Errorf("%s UTC: gauge at 10%% says %q", c.at, text)
+		}
+	}
+
+	// Without the national calendar only weekends and extra days are off
+	SetRoomSettings(ctx, zCtx.RoomID(), RoomSettings{HolidayCalendar: holidayCalendarNone}, -1)
+	fc.now = time.Date(2026, 11, 3, 1, 0, 0, 0, time.UTC)
+	if got := roomSettings(ctx, zCtx).ThresholdPercent; got != 50 {
+		t.Errorf("文化の日 without a calendar: threshold %v", got)
+	}
+	// The defaults' gauge stages are not rewritten
+	if builtinSettings.GaugeStages[0].Text != "待機中" {
+		t.Errorf("builtin gauge stages changed: %+v", builtinSettings.GaugeStages)
+	}
+}
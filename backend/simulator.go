@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// simTenant keeps simulated rooms apart from real ones. Their room IDs,
+// reports and trigger action payloads carry it, and it has no Zoom API
+// credentials, so nothing is looked up for the made-up meeting IDs.
+const simTenant = "simulator"
+
+const (
+	// simTick matches the panel's polling interval
+	simTick = 2 * time.Second
+	// simBreak is the pause between one simulated meeting and the next
+	simBreak = time.Minute
+	// simLeaveSpread is how long participants take to close their panels
+	// once the ending shows
+	simLeaveSpread = time.Minute
+	// simAbstain is the share of participants that never vote
+	simAbstain = 0.2
+)
+
+// simParticipant is one scripted panel. Times are from the meeting start;
+// a negative voteAt never votes.
+type simParticipant struct {
+	zCtx           *ZoomAuthContext
+	joinAt, voteAt time.Duration
+	leaveAt        time.Time
+	joined, voted  bool
+	left           bool
+}
+
+// simMeeting is one run of a simulated room. Participants join early on,
+// most of them vote around three quarters into the meeting, and everyone
+// leaves once the ending shows or the meeting has run well over.
+type simMeeting struct {
+	rng          *rand.Rand
+	start        time.Time
+	length       time.Duration
+	participants []*simParticipant
+	ending       bool
+}
+
+func newSimMeeting(rng *rand.Rand, room string, run, size int, length time.Duration, start time.Time) *simMeeting {
+	m := &simMeeting{rng: rng, start: start, length: length}
+	n := 2 + rng.Intn(max(size-1, 1))
+	for i := range n {
+		p := &simParticipant{
+			zCtx: &ZoomAuthContext{
+				UID:    fmt.Sprintf("sim-%d-%d", run, i),
+				Mid:    room,
+				Tenant: simTenant,
+				Role:   "attendee",
+				Locale: "ja",
+			},
+			// Front-loaded: most panels open in the first minutes
+			joinAt: time.Duration(rng.Float64() * rng.Float64() * 0.3 * float64(length)),
+			voteAt: -1,
+		}
+		if i == 0 {
+			p.zCtx.Role, p.joinAt = "host", 0
+		}
+		if rng.Float64() >= simAbstain {
+			at := min(max(0.75+0.15*rng.NormFloat64(), 0.1), 1.2)
+			p.voteAt = max(time.Duration(at*float64(length)), p.joinAt)
+		}
+		m.participants = append(m.participants, p)
+	}
+	return m
+}
+
+// endAll schedules every panel to close within simLeaveSpread of now.
+func (m *simMeeting) endAll(now time.Time) {
+	m.ending = true
+	for _, p := range m.participants {
+		p.leaveAt = now.Add(time.Duration(m.rng.Int63n(int64(simLeaveSpread))))
+	}
+}
+
+// step plays the meeting up to now through the same store and rendering
+// paths as real panels, and reports whether everyone has left.
+func (m *simMeeting) step(ctx context.Context, now time.Time) bool {
+	at := now.Sub(m.start)
+	done := true
+	for _, p := range m.participants {
+		if p.left {
+			continue
+		}
+		done = false
+		if !p.joined && (at < p.joinAt || m.ending) {
+			p.left = m.ending
+			continue
+		}
+		p.joined = true
+		room := p.zCtx.RoomID()
+		if m.ending && !now.Before(p.leaveAt) {
+			if err := LeaveParticipant(ctx, room, p.zCtx.UID, now); err != nil {
+				logf(ctx, "Simulator LeaveParticipant error: %v", err)
+			}
+			p.left = true
+			continue
+		}
+		if !p.voted && p.voteAt >= 0 && at >= p.voteAt {
+			if _, err := PollVote(ctx, room, defaultPoll, p.zCtx.UID); err != nil && !errors.Is(err, errPollPassed) {
+				logf(ctx, "Simulator vote error: %v", err)
+			}
+			p.voted = true
+		}
+		if _, err := renderState(ctx, p.zCtx); err != nil {
+			logf(ctx, "Simulator renderState error: %v", err)
+		}
+	}
+	if done || m.ending {
+		return done
+	}
+
+	host := m.participants[0].zCtx
+	settings := roomSettings(ctx, host)
+	_, _, triggered, err := checkTrigger(ctx, host, &settings)
+	if err != nil {
+		logf(ctx, "Simulator checkTrigger error: %v", err)
+	}
+	if triggered || at > m.length*13/10 {
+		m.endAll(now)
+	}
+	return false
+}
+
+// runSimRoom plays meeting after meeting in one simulated room.
+func runSimRoom(room string, size int, length time.Duration, seed int64) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(seed))
+	// Spread the rooms out so they do not all trigger at once
+	time.Sleep(time.Duration(rng.Int63n(int64(length))))
+	ticker := time.NewTicker(simTick)
+	defer ticker.Stop()
+	for run := 0; ; run++ {
+		roomID := simTenant + "/" + room
+		if err := ResetRoom(ctx, roomID); err != nil {
+			logf(ctx, "Simulator ResetRoom error: %v", err)
+		}
+		metrics.Add("simulated_meetings", 1)
+		m := newSimMeeting(rng, room, run, size, length, clock.Now())
+		for range ticker.C {
+			if m.step(ctx, clock.Now()) {
+				break
+			}
+		}
+		time.Sleep(simBreak)
+	}
+}
+
+// startSimulator starts cfg.SimulateRooms simulated rooms, to give staging
+// and its dashboards traffic that looks like real meetings.
+func startSimulator(cfg *Config) error {
+	if cfg.SimulateRooms <= 0 {
+		return nil
+	}
+	if cfg.SimulateMeetingLength < time.Minute {
+		return fmt.Errorf("SIMULATE_MEETING_LENGTH must be at least 1m, got %s", cfg.SimulateMeetingLength)
+	}
+	if cfg.SimulateParticipants < 2 {
+		return fmt.Errorf("SIMULATE_PARTICIPANTS must be at least 2, got %d", cfg.SimulateParticipants)
+	}
+	log.Printf("WARNING: simulator enabled, %d fake room(s) under tenant %q", cfg.SimulateRooms, simTenant)
+	seed := time.Now().UnixNano()
+	for i := range cfg.SimulateRooms {
+		room := fmt.Sprintf("sim-room-%d", i)
+		goSafe("simulator "+room, func() {
+			runSimRoom(room, cfg.SimulateParticipants, cfg.SimulateMeetingLength, seed+int64(i))
+		})
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSimulatedMeeting(t *testing.T) {
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+
+	m := newSimMeeting(rand.New(rand.NewSource(1)), "sim-test", 0, 12, 10*time.Minute, fc.now)
+	if len(m.participants) < 2 || m.participants[0].zCtx.Role != "host" {
+		t.Fatalf("participants = %d, first %+v", len(m.participants), m.participants[0].zCtx)
+	}
+	roomID := m.participants[0].zCtx.RoomID()
+	if roomID != "simulator/sim-test" {
+		t.Errorf("room ID = %q", roomID)
+	}
+
+	steps := 0
+	for !m.step(ctx, fc.now) {
+		if steps++; steps > 1000 {
+			t.Fatal("meeting never ended")
+		}
+		fc.Advance(simTick)
+	}
+	events, _ := RoomEventsSince(ctx, roomID, "0-0")
+	if len(events) == 0 || events[0].Type != eventTriggered {
+		t.Errorf("events = %+v", events)
+	}
+	if fc.now.Sub(m.start) > 13*time.Minute+simLeaveSpread {
+		t.Errorf("meeting ran %s", fc.now.Sub(m.start))
+	}
+	for _, p := range m.participants {
+		if p.joined && !p.left {
+			t.Errorf("%s never left", p.zCtx.UID)
+		}
+	}
+
+	prod := &Config{Environment: "production", SimulateRooms: 1}
+	if err := prod.validateEnvironment(); err == nil {
+		t.Error("simulator allowed in production")
+	}
+}
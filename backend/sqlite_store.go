@@ -0,0 +1,130 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTable keeps a localStore's documents in a SQLite database. The
+// driver is pure Go, so it builds without cgo like boltTable.
+type sqliteTable struct {
+	db *sql.DB
+}
+
+func openSQLiteTable(path string) (*sqliteTable, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS docs (
+		key TEXT PRIMARY KEY,
+		data BLOB NOT NULL,
+		expires_ms INTEGER NOT NULL,
+		version INTEGER NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteTable{db: db}, nil
+}
+
+// expiresMs is an expiry as stored, 0 for never.
+func expiresMs(expires time.Time) int64 {
+	if expires.IsZero() {
+		return 0
+	}
+	return expires.UnixMilli()
+}
+
+func sqliteItem(data []byte, ms, version int64) tableItem {
+	it := tableItem{data: data, version: version}
+	if ms != 0 {
+		it.expires = time.UnixMilli(ms)
+	}
+	return it
+}
+
+func (t *sqliteTable) get(ctx context.Context, key string) (tableItem, error) {
+	var data []byte
+	var ms, version int64
+	err := t.db.QueryRowContext(ctx, `SELECT data, expires_ms, version FROM docs WHERE key = ?`, key).Scan(&data, &ms, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tableItem{}, nil
+	}
+	if err != nil {
+		return tableItem{}, err
+	}
+	return sqliteItem(data, ms, version), nil
+}
+
+// changed returns errVersionChanged unless the statement wrote a row.
+func changed(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return cmp.Or(err, errVersionChanged)
+	}
+	return nil
+}
+
+func (t *sqliteTable) put(ctx context.Context, key string, data []byte, expires time.Time, version int64) error {
+	if version == 0 {
+		return changed(t.db.ExecContext(ctx, `INSERT INTO docs (key, data, expires_ms, version) VALUES (?, ?, ?, 1)
+			ON CONFLICT(key) DO NOTHING`, key, data, expiresMs(expires)))
+	}
+	return changed(t.db.ExecContext(ctx, `UPDATE docs SET data = ?, expires_ms = ?, version = version + 1
+		WHERE key = ? AND version = ?`, data, expiresMs(expires), key, version))
+}
+
+func (t *sqliteTable) del(ctx context.Context, key string, version int64) error {
+	return changed(t.db.ExecContext(ctx, `DELETE FROM docs WHERE key = ? AND version = ?`, key, version))
+}
+
+func (t *sqliteTable) scan(ctx context.Context, prefix string, fn func(key string, it tableItem) bool) error {
+	// A range rather than LIKE, so a room's participants are read from
+	// the key's index; no UTF-8 key has a 0xff byte
+	rows, err := t.db.QueryContext(ctx, `SELECT key, data, expires_ms, version FROM docs WHERE key >= ? AND key < ? ORDER BY key`,
+		prefix, prefix+"\xff")
+	if err != nil {
+		return err
+	}
+	// Read them all first: the one connection is busy until rows is closed
+	var keys []string
+	var items []tableItem
+	for rows.Next() {
+		var key string
+		var data []byte
+		var ms, version int64
+		if err := rows.Scan(&key, &data, &ms, &version); err != nil {
+			rows.Close()
+			return err
+		}
+		keys, items = append(keys, key), append(items, sqliteItem(data, ms, version))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if !fn(key, items[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (t *sqliteTable) sweep(ctx context.Context, now time.Time) error {
+	_, err := t.db.ExecContext(ctx, `DELETE FROM docs WHERE expires_ms != 0 AND expires_ms <= ?`, now.UnixMilli())
+	return err
+}
+
+func (t *sqliteTable) close() error {
+	return t.db.Close()
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// staticAsset is a frontend file held in memory with its validators and
+// precompressed variants.
+type staticAsset struct {
+	modTime time.Time
+	size    int64
+	hash    string // short content hash, used for ETags and ?v= fingerprints
+	ctype   string
+	raw     []byte
+	gz      []byte // nil when compression does not help
+	br      []byte
+}
+
+// assetServer serves files from root with ETags, Cache-Control, and gzip/br.
+// Files are loaded lazily and reloaded when their mtime or size changes, so
+// editing the frontend does not need a restart. Access goes through os.Root,
+// so neither ".." nor symlinks can escape the asset directory.
+type assetServer struct {
+	root *os.Root
+	// baseURL is the CDN fingerprinted URLs point to, empty for this server
+	baseURL string
+
+	mu     sync.RWMutex
+	assets map[string]*staticAsset // the manifest, by slash path
+}
+
+func newAssetServer(dir string) (*assetServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("asset root: %w", err)
+	}
+	return &assetServer{root: root, assets: make(map[string]*staticAsset)}, nil
+}
+
+// hiddenPath reports whether any segment of p is a dotfile or dot directory
+// (.git, .env, ...). Those are never served.
+func hiddenPath(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if strings.HasPrefix(seg, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports whether a content type benefits from gzip/br.
+func compressible(ctype string) bool {
+	return strings.HasPrefix(ctype, "text/") ||
+		strings.Contains(ctype, "javascript") ||
+		strings.Contains(ctype, "json") ||
+		strings.Contains(ctype, "svg")
+}
+
+// load returns the asset for a slash path such as "/style.css". A directory
+// falls back to its index.html; directory listings are never produced.
+func (s *assetServer) load(name string) (*staticAsset, error) {
+	if hiddenPath(name) {
+		return nil, os.ErrNotExist
+	}
+	file := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if file == "" {
+		file = "."
+	}
+	info, err := s.root.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		file = path.Join(file, "index.html")
+		if info, err = s.root.Stat(file); err != nil {
+			return nil, err
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return nil, os.ErrNotExist
+	}
+
+	s.mu.RLock()
+	a, ok := s.assets[name]
+	s.mu.RUnlock()
+	if ok && a.modTime.Equal(info.ModTime()) && a.size == info.Size() {
+		return a, nil
+	}
+
+	raw, err := s.root.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	a = &staticAsset{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    hex.EncodeToString(sum[:8]),
+		ctype:   mime.TypeByExtension(path.Ext(file)),
+		raw:     raw,
+	}
+	if a.ctype == "" {
+		a.ctype = http.DetectContentType(raw)
+	}
+
+	if compressible(a.ctype) {
+		var buf bytes.Buffer
+		gw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		gw.Write(raw)
+		gw.Close()
+		if buf.Len() < len(raw) {
+			a.gz = bytes.Clone(buf.Bytes())
+		}
+
+		buf.Reset()
+		bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		bw.Write(raw)
+		bw.Close()
+		if buf.Len() < len(raw) {
+			a.br = bytes.Clone(buf.Bytes())
+		}
+	}
+
+	s.mu.Lock()
+	s.assets[name] = a
+	s.mu.Unlock()
+	return a, nil
+}
+
+// fingerprint returns name with a ?v= content hash so it can be cached
+// forever, on the CDN when there is one.
+func (s *assetServer) fingerprint(name string) string {
+	a, err := s.load("/" + strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return name
+	}
+	return s.baseURL + strings.TrimPrefix(name, "/") + "?v=" + a.hash
+}
+
+// preload hashes every frontend file at startup, so the first pages and
+// fragments already reference fingerprinted URLs, and returns how many
+// there are. Files changed later are hashed again when next used.
+func (s *assetServer) preload() (int, error) {
+	n := 0
+	err := fs.WalkDir(s.root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if _, err := s.load("/" + p); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// parseAssetBaseURL checks ASSET_BASE_URL and returns it ending in a slash,
+// with its origin for the CSP.
+func parseAssetBaseURL(raw string) (base, origin string, err error) {
+	if raw == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+		return "", "", fmt.Errorf("ASSET_BASE_URL must be an http(s) URL without a query, got %q", raw)
+	}
+	base = raw
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base, u.Scheme + "://" + u.Host, nil
+}
+
+var localAssetRef = regexp.MustCompile(`(href|src)="([\w\-./]+\.(?:css|js|mp3|png|svg))"`)
+
+// fingerprintRefs rewrites relative asset references in an HTML document.
+func (s *assetServer) fingerprintRefs(html string) string {
+	return localAssetRef.ReplaceAllStringFunc(html, func(m string) string {
+		sub := localAssetRef.FindStringSubmatch(m)
+		return sub[1] + `="` + s.fingerprint(sub[2]) + `"`
+	})
+}
+
+// acceptsEncoding is a simple Accept-Encoding check: enc is accepted when
+// listed without q=0. Other q-values are not weighed.
+func acceptsEncoding(r *http.Request, enc string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(name) == enc && !refusedByQ(params) {
+			return true
+		}
+	}
+	return false
+}
+
+// refusedByQ reports whether the parameters of an Accept-Encoding entry
+// carry a q-value of 0, which refuses the encoding.
+func refusedByQ(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, val, _ := strings.Cut(param, "=")
+		if strings.TrimSpace(key) != "q" {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return err == nil && q == 0
+	}
+	return false
+}
+
+func (s *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, err := s.load(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if t, ok := audioTypes[strings.TrimPrefix(path.Ext(r.URL.Path), ".")]; ok {
+		s.serveAudio(w, r, a, t.contentType)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", a.ctype)
+	h.Set("Vary", "Accept-Encoding")
+	if v := r.URL.Query().Get("v"); v != "" && v == a.hash {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	body, etag := a.raw, a.hash
+	switch {
+	case a.br != nil && acceptsEncoding(r, "br"):
+		body, etag = a.br, a.hash+"-br"
+		h.Set("Content-Encoding", "br")
+	case a.gz != nil && acceptsEncoding(r, "gzip"):
+		body, etag = a.gz, a.hash+"-gz"
+		h.Set("Content-Encoding", "gzip")
+	}
+	h.Set("ETag", `"`+etag+`"`)
+
+	// ServeContent handles If-None-Match and Range requests
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(body))
+}
+
+// serveAudio serves the ending music. Media players fetch it in ranges and
+// some webviews refuse audio without an audio/* type, which the system
+// MIME table of slim images lacks, so it gets its own type and is never
+// compressed. Fingerprinted URLs are cached for good, so the music starts
+// from the cache when the room triggers.
+func (s *assetServer) serveAudio(w http.ResponseWriter, r *http.Request, a *staticAsset, ctype string) {
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Accept-Ranges", "bytes")
+	if v := r.URL.Query().Get("v"); v != "" && v == a.hash {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("ETag", `"`+a.hash+`"`)
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(a.raw))
+}
+
+// frontendAssets is the asset server of the frontend, for fragments that
+// reference its files.
+var frontendAssets atomic.Pointer[assetServer]
+
+// assetURL returns the fingerprinted URL of a frontend file, or name when
+// it is not one. Templates call it as asset.
+func assetURL(name string) string {
+	if s := frontendAssets.Load(); s != nil && name != "" {
+		return s.fingerprint(name)
+	}
+	return name
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssetServerCaching(t *testing.T) {
+	dir := t.TempDir()
+	css := strings.Repeat("body { color: red; }\n", 50)
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte(css), 0o644)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte(`<link rel="stylesheet" href="style.css"><script src="https://unpkg.com/x.js"></script>`), 0o644)
+
+	s, err := newAssetServer(dir)
+	if err != nil {
+		t.Fatalf("newAssetServer: %v", err)
+	}
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/style.css")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != css || etag == "" {
+		t.Fatalf("unexpected response %d etag=%q", w.Code, etag)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("expected unversioned asset to revalidate, got %q", cc)
+	}
+
+	if w := get("/style.css", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, got %d", w.Code)
+	}
+
+	var zr *gzip.Reader
+	w = get("/style.css", "Accept-Encoding", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("ETag") == etag {
+		t.Fatalf("expected gzip variant with its own ETag, got %v", w.Header())
+	}
+	zr, err = gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	if plain, _ := io.ReadAll(zr); string(plain) != css {
+		t.Errorf("gzip body does not round-trip")
+	}
+
+	if w := get("/style.css", "Accept-Encoding", "gzip, br"); w.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("expected br to be preferred, got %q", w.Header().Get("Content-Encoding"))
+	}
+
+	ref := s.fingerprint("style.css")
+	if w := get("/" + ref); !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("expected fingerprinted asset to be immutable, got %q", w.Header().Get("Cache-Control"))
+	}
+	if w := get("/style.css?v=stale"); w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected stale fingerprint to revalidate")
+	}
+
+	index, _ := s.load("/index.html")
+	html := s.fingerprintRefs(string(index.raw))
+	if !strings.Contains(html, `href="`+ref+`"`) || !strings.Contains(html, `src="https://unpkg.com/x.js"`) {
+		t.Errorf("unexpected fingerprinted index: %s", html)
+	}
+
+	// Edits are picked up without a restart
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte("p {}"), 0o644)
+	os.Chtimes(filepath.Join(dir, "style.css"), index.modTime, index.modTime.Add(1))
+	if w := get("/style.css"); w.Body.String() != "p {}" {
+		t.Errorf("expected reloaded asset, got %q", w.Body.String())
+	}
+}
+
+func TestAssetServerHardening(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "public")
+	os.MkdirAll(filepath.Join(dir, "docs"), 0o755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0o755)
+	os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("ZOOM_CLIENT_SECRET=x"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".git", "config"), []byte("[core]"), 0o644)
+	os.WriteFile(filepath.Join(dir, "docs", "index.html"), []byte("docs"), 0o644)
+	os.Symlink(filepath.Join(parent, "secret.txt"), filepath.Join(dir, "link.txt"))
+
+	s, err := newAssetServer(dir)
+	if err != nil {
+		t.Fatalf("newAssetServer: %v", err)
+	}
+
+	for _, target := range []string{"/.env", "/.git/config", "/../secret.txt", "/docs/../../secret.txt", "/link.txt", "/%2e%2e/secret.txt"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = target
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d %q", target, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "docs" {
+		t.Errorf("expected directory index fallback, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestAssetServerAudio(t *testing.T) {
+	dir := t.TempDir()
+	track := []byte("ID3" + strings.Repeat("\xff\xfb\x90\x00", 64))
+	os.WriteFile(filepath.Join(dir, "hotaru-piano.mp3"), track, 0o644)
+	s, err := newAssetServer(dir)
+	if err != nil {
+		t.Fatalf("newAssetServer: %v", err)
+	}
+
+	url := s.fingerprint("hotaru-piano.mp3")
+	r := httptest.NewRequest(http.MethodGet, "/"+url, nil)
+	r.Header.Set("Range", "bytes=3-6")
+	r.Header.Set("Accept-Encoding", "gzip, br")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	h := w.Header()
+	if w.Code != http.StatusPartialContent || w.Body.String() != "\xff\xfb\x90\x00" || h.Get("Content-Range") != "bytes 3-6/259" {
+		t.Fatalf("range: %d %v %q", w.Code, h, w.Body.String())
+	}
+	if h.Get("Content-Type") != "audio/mpeg" || h.Get("Content-Encoding") != "" || h.Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("headers = %v", h)
+	}
+
+	// Themes reference the fingerprinted file
+	frontendAssets.Store(s)
+	t.Cleanup(func() { frontendAssets.Store(nil) })
+	if got := wrapTheme("ja", themeByID("classic"), ""); !strings.Contains(got, `data-audio="`+url+`"`) {
+		t.Errorf("theme = %s", got)
+	}
+}
+
+func TestAssetBaseURL(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0o644)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte(`<link rel="stylesheet" href="style.css">`), 0o644)
+	os.Mkdir(filepath.Join(dir, ".git"), 0o755)
+	os.WriteFile(filepath.Join(dir, ".git", "config"), nil, 0o644)
+	s, err := newAssetServer(dir)
+	if err != nil {
+		t.Fatalf("newAssetServer: %v", err)
+	}
+	if n, err := s.preload(); err != nil || n != 2 {
+		t.Fatalf("preload = %d, %v", n, err)
+	}
+
+	var origin string
+	s.baseURL, origin, err = parseAssetBaseURL("https://cdn.example.com/hotaru")
+	if err != nil || origin != "https://cdn.example.com" {
+		t.Fatalf("parse = %q, %q, %v", s.baseURL, origin, err)
+	}
+	css, _ := s.load("/style.css")
+	if got := s.fingerprintRefs(`<link href="style.css">`); got != `<link href="https://cdn.example.com/hotaru/style.css?v=`+css.hash+`">` {
+		t.Errorf("refs = %s", got)
+	}
+	if csp := contentSecurityPolicy(origin); !strings.Contains(csp, "style-src 'self' https://cdn.example.com ") || !strings.Contains(csp, "media-src 'self' https://cdn.example.com;") {
+		t.Errorf("csp = %s", csp)
+	}
+	for _, bad := range []string{"cdn.example.com", "ftp://cdn.example.com/", "https://cdn.example.com/?a=1"} {
+		if _, _, err := parseAssetBaseURL(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for header, want := range map[string]bool{
+		"gzip":               true,
+		"br, gzip":           true,
+		"gzip;q=0.5":         true,
+		"gzip; q=0.001":      true,
+		"gzip;q=1.0, br;q=0": true,
+		"gzip;q=0":           false,
+		"gzip;q=0.0":         false,
+		"gzip ; q=0.000, br": false,
+		"deflate, identity":  false,
+		"":                   false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/style.css", nil)
+		r.Header.Set("Accept-Encoding", header)
+		if got := acceptsEncoding(r, "gzip"); got != want {
+			t.Errorf("%q: accepts gzip = %t, want %t", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The status dashboard: /admin/status returns what on-call looks at first
+// (the live rooms, each instance's open connections, the health of Redis,
+// the region bus and the event export, and the latest triggers) and
+// /admin/dashboard renders the same as a page that refreshes itself.
+
+const (
+	// instanceHeartbeat is how often each instance records its status in
+	// the store for the others' dashboards.
+	instanceHeartbeat = 10 * time.Second
+	// instanceStale is when an instance that stopped beating is dropped.
+	instanceStale = 3 * instanceHeartbeat
+	// dashboardRooms is the most rooms the status lists.
+	dashboardRooms = 200
+)
+
+var (
+	instanceID      = newRequestID()
+	instanceStarted = time.Now()
+
+	// openConns counts the server's client connections, see trackConn.
+	openConns atomic.Int64
+)
+
+// trackConn is the server's ConnState hook, counting open connections.
+func trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		openConns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		openConns.Add(-1)
+	}
+}
+
+// instanceStatus is the heartbeat of one instance.
+type instanceStatus struct {
+	ID          string    `json:"id"`
+	Region      string    `json:"region,omitempty"`
+	Started     time.Time `json:"started"`
+	Seen        time.Time `json:"seen"`
+	Connections int64     `json:"connections"`
+	Ready       bool      `json:"ready"`
+}
+
+func selfStatus() instanceStatus {
+	return instanceStatus{
+		ID:          instanceID,
+		Region:      region,
+		Started:     instanceStarted,
+		Seen:        clock.Now(),
+		Connections: openConns.Load(),
+		Ready:       !shuttingDown.Load() && (!useRedis || redisHealthy.Load()),
+	}
+}
+
+// startInstanceHeartbeat records this instance's status in the store every
+// instanceHeartbeat, when it is Redis or DynamoDB. Otherwise there is no
+// other instance and the dashboard only shows this one.
+func startInstanceHeartbeat() {
+	if !useRedis && currentStore().Name() != storeDynamo {
+		return
+	}
+	goSafe("instance-heartbeat", func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			st := selfStatus()
+			if err := PutInstanceStatus(ctx, &st, st.Seen.Add(-instanceStale)); err != nil {
+				log.Printf("Instance heartbeat error: %v", err)
+			}
+			cancel()
+			time.Sleep(instanceHeartbeat)
+		}
+	})
+}
+
+// componentHealth is the state of one dependency.
+type componentHealth struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// checkHealth checks the dependencies this instance uses. Region peers are
+// pinged; Redis is what monitorRedis last saw.
+func checkHealth(ctx context.Context) []componentHealth {
+	var list []componentHealth
+	switch {
+	case useRedis:
+		h := componentHealth{Name: "redis", OK: redisHealthy.Load()}
+		if !h.OK {
+			h.Detail = "unreachable"
+		}
+		list = append(list, h)
+	case local.Name() == storeMemory:
+		list = append(list, componentHealth{Name: "store", OK: true, Detail: "memory, this instance only"})
+	default:
+		list = append(list, componentHealth{Name: "store", OK: true, Detail: local.Name()})
+	}
+	for _, peer := range regionPeers {
+		pctx, cancel := context.WithTimeout(ctx, time.Second)
+		err := peer.client.Ping(pctx).Err()
+		cancel()
+		h := componentHealth{Name: "region bus " + peer.name, OK: err == nil}
+		if err != nil {
+			h.Detail = err.Error()
+		}
+		list = append(list, h)
+	}
+	if e := exporter.Load(); e != nil {
+		queued := len(e.queue)
+		list = append(list, componentHealth{
+			Name:   "event export",
+			OK:     queued < cap(e.queue),
+			Detail: fmt.Sprintf("%d of %d queued, %d dropped", queued, cap(e.queue), metricInt("export_dropped")),
+		})
+	}
+	return list
+}
+
+// systemStatus is the body of /admin/status.
+type systemStatus struct {
+	Instance  string            `json:"instance"`
+	At        time.Time         `json:"at"`
+	Instances []instanceStatus  `json:"instances"`
+	Health    []componentHealth `json:"health"`
+	Rooms     []liveRoom        `json:"rooms"`
+	MoreRooms bool              `json:"more_rooms,omitempty"`
+	Triggers  []TriggerEvent    `json:"triggers"`
+	// Errors are the parts that could not be read
+	Errors []string `json:"errors,omitempty"`
+}
+
+// collectStatus gathers the status. Parts that fail are left empty and
+// named in Errors, so a Redis outage still shows the rest.
+func collectStatus(ctx context.Context) *systemStatus {
+	self := selfStatus()
+	st := &systemStatus{Instance: instanceID, At: self.Seen, Health: checkHealth(ctx)}
+	fail := func(part string, err error) {
+		logf(ctx, "Status %s error: %v", part, err)
+		st.Errors = append(st.Errors, part+": "+err.Error())
+	}
+
+	instances, err := InstanceStatuses(ctx)
+	if err != nil {
+		fail("instances", err)
+	}
+	cutoff := self.Seen.Add(-instanceStale)
+	st.Instances = []instanceStatus{self}
+	for _, inst := range instances {
+		if inst.ID != instanceID && !inst.Seen.Before(cutoff) {
+			st.Instances = append(st.Instances, inst)
+		}
+	}
+	slices.SortFunc(st.Instances[1:], func(a, b instanceStatus) int {
+		return cmp.Or(strings.Compare(a.Region, b.Region), strings.Compare(a.ID, b.ID))
+	})
+
+	if st.Rooms, st.MoreRooms, err = LiveRooms(ctx, dashboardRooms); err != nil {
+		fail("rooms", err)
+	}
+	if st.Triggers, err = RecentTriggers(ctx); err != nil {
+		fail("triggers", err)
+	}
+	return st
+}
+
+// handleAdminStatus serves GET /admin/status.
+func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(collectStatus(r.Context()))
+}
+
+const dashboardCookie = "hotaru_admin"
+
+// dashboardSession is the cookie value of a signed-in dashboard, derived
+// from the token so changing ADMIN_TOKEN signs everyone out.
+func dashboardSession(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("hotaru admin dashboard"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleAdminDashboard serves /admin/dashboard. Browsers cannot send a
+// bearer token, so GET without one shows a form; posting the admin token
+// to it sets a cookie good for this page only.
+func handleAdminDashboard(configured string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		token := adminToken(configured)
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		session := dashboardSession(token)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.Method {
+		case http.MethodGet:
+			c, err := r.Cookie(dashboardCookie)
+			if !hasAdminToken(r, token) && (err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(session)) != 1) {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(renderFragment(defaultLocale, "dashboard_login", false)))
+				return
+			}
+			w.Write([]byte(renderFragment(defaultLocale, "dashboard", collectStatus(ctx))))
+
+		case http.MethodPost:
+			r.Body = http.MaxBytesReader(w, r.Body, 4096)
+			if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(token)) != 1 {
+				metrics.Add("admin_unauthorized", 1)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(renderFragment(defaultLocale, "dashboard_login", true)))
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     dashboardCookie,
+				Value:    session,
+				Path:     "/admin/dashboard",
+				MaxAge:   int((12 * time.Hour).Seconds()),
+				HttpOnly: true,
+				Secure:   r.TLS != nil || fromTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https",
+				SameSite: http.SameSiteStrictMode,
+			})
+			logf(ctx, "Admin signed in to the dashboard")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+
+		default:
+			httpError(w, ctx, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
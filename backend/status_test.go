@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveRoomsAndTriggers(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				mr, client := setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			useMemoryStore(t)
+			useFakeClock(t)
+			ctx := context.Background()
+			room := "status-" + store
+
+			for _, uid := range []string{"u1", "u2", "u3"} {
+				if _, err := JoinRoom(ctx, room, uid, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			Vote(ctx, room, "u1")
+			rooms, _, err := LiveRooms(ctx, 10000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := slices.IndexFunc(rooms, func(r liveRoom) bool { return r.Room == room })
+			if i < 0 {
+				t.Fatalf("room missing from %+v", rooms)
+			}
+			if r := rooms[i]; r.Participants != 3 || r.Votes != 1 || r.Triggered || r.OpenedAt.IsZero() {
+				t.Errorf("room = %+v", r)
+			}
+
+			for i := range maxRecentTriggers + 5 {
+				RecordTrigger(ctx, TriggerEvent{RoomID: room, Votes: i, At: clock.Now()})
+			}
+			events, err := RecentTriggers(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != maxRecentTriggers || events[0].Votes != maxRecentTriggers+4 {
+				t.Errorf("recent triggers: %d, newest %+v", len(events), events[0])
+			}
+		})
+	}
+}
+
+func TestInstanceHeartbeats(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	fc := useFakeClock(t)
+	ctx := context.Background()
+
+	old := instanceStatus{ID: "gone", Seen: clock.Now()}
+	PutInstanceStatus(ctx, &old, clock.Now().Add(-instanceStale))
+	fc.Advance(time.Minute)
+	other := instanceStatus{ID: "other", Region: "tokyo", Seen: clock.Now(), Connections: 7}
+	if err := PutInstanceStatus(ctx, &other, clock.Now().Add(-instanceStale)); err != nil {
+		t.Fatal(err)
+	}
+	list, err := InstanceStatuses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "other" || list[0].Connections != 7 {
+		t.Errorf("instances = %+v", list)
+	}
+
+	st := collectStatus(ctx)
+	if len(st.Instances) != 2 || st.Instances[0].ID != instanceID || st.Instances[1].ID != "other" {
+		t.Errorf("status instances = %+v", st.Instances)
+	}
+	if len(st.Health) != 1 || st.Health[0].Name != "redis" || !st.Health[0].OK {
+		t.Errorf("health = %+v", st.Health)
+	}
+}
+
+func TestAdminDashboard(t *testing.T) {
+	handler, err := newHandler(&Config{FrontendDir: "../frontend", AdminToken: "admin-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecordTrigger(context.Background(), TriggerEvent{RoomID: "dashboard-room", Votes: 3, Participants: 4, At: clock.Now()})
+
+	// The JSON API takes the bearer token only
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	handler.ServeHTTP(rec, req)
+	var st systemStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("status: %d %s", rec.Code, rec.Body)
+	}
+	if st.Instance != instanceID || len(st.Triggers) == 0 {
+		t.Errorf("status = %+v", st)
+	}
+
+	get := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/dashboard", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	signIn := func(token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/dashboard", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(nil); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `name="token"`) {
+		t.Errorf("signed out: %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(&http.Cookie{Name: dashboardCookie, Value: "forged"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged cookie: %d", rec.Code)
+	}
+	if rec := signIn("wrong"); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Wrong admin token") {
+		t.Errorf("wrong token: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = signIn("admin-secret")
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusSeeOther || len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].Path != "/admin/dashboard" {
+		t.Fatalf("sign in: %d %+v", rec.Code, cookies)
+	}
+	if strings.Contains(cookies[0].Value, "admin-secret") {
+		t.Error("the cookie carries the token")
+	}
+	rec = get(cookies[0])
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || !strings.Contains(string(body), "dashboard-room") || !strings.Contains(string(body), instanceID) {
+		t.Errorf("dashboard: %d %s", rec.Code, body)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// statusCacheTTL is how long an instance answers a poll's status from
+// memory instead of reading Redis again. Every panel polls every two
+// seconds, so without it a busy room reads the same counts many times a
+// second. Writes drop the cached status on the instances caching the room,
+// see invalidateRoomStatus; the TTL bounds what changes without a write,
+// such as votes coming of age under MinPresence. 0 disables the cache.
+var statusCacheTTL = time.Second
+
+type pollStatus struct {
+	total, votes int
+	passed       bool
+	expires      time.Time
+}
+
+// roomStatuses caches the status of a room's polls. gen counts the
+// invalidations, so a status read from Redis before a write is not cached
+// after it. watched is set for the rooms of this region, whose status
+// channel the instance listens to while it caches them; pending until
+// Redis confirms the subscription, when nothing is cached.
+type roomStatuses struct {
+	mu      sync.Mutex
+	gen     uint64
+	polls   map[string]pollStatus
+	watched bool
+	pending bool
+}
+
+var roomStatusCache sync.Map // mid -> *roomStatuses
+
+// roomStatusesFor returns the cached statuses of a room of this region,
+// listening to the room's status channel from the first use.
+func roomStatusesFor(mid string) *roomStatuses {
+	if val, ok := roomStatusCache.Load(mid); ok {
+		return val.(*roomStatuses)
+	}
+	rs := &roomStatuses{polls: map[string]pollStatus{}, watched: true, pending: true}
+	val, loaded := roomStatusCache.LoadOrStore(mid, rs)
+	if !loaded && watchRoomStatus(mid) {
+		rs.mu.Lock()
+		// Statuses read before the subscription may miss an invalidation
+		rs.gen++
+		rs.pending = false
+		rs.mu.Unlock()
+	}
+	return val.(*roomStatuses)
+}
+
+// peerStatusesFor returns the cached statuses of a room in another
+// region, kept under key. The region bus invalidates them.
+func peerStatusesFor(key string) *roomStatuses {
+	val, _ := roomStatusCache.LoadOrStore(key, &roomStatuses{polls: map[string]pollStatus{}})
+	return val.(*roomStatuses)
+}
+
+// get returns the cached status of pollID if it is fresh, and the
+// generation to store a new one with.
+func (rs *roomStatuses) get(pollID string) (pollStatus, uint64, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	st, ok := rs.polls[pollID]
+	return st, rs.gen, ok && clock.Now().Before(st.expires)
+}
+
+// put caches st unless the room was invalidated since generation gen.
+func (rs *roomStatuses) put(pollID string, gen uint64, st pollStatus) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.gen == gen && !rs.pending {
+		st.expires = clock.Now().Add(policyFor(st.total).StatusTTL)
+		rs.polls[pollID] = st
+	}
+}
+
+// forgetRoomStatus drops this instance's cached status of the room.
+func forgetRoomStatus(mid string) {
+	if val, ok := roomStatusCache.Load(mid); ok {
+		rs := val.(*roomStatuses)
+		rs.mu.Lock()
+		rs.gen++
+		clear(rs.polls)
+		rs.mu.Unlock()
+	}
+}
+
+// statusChannel carries the invalidations of one room's status to the
+// instances caching it.
+func statusChannel(mid string) string {
+	return keyPrefix + "room-status:" + mid
+}
+
+// invalidateRoomStatus drops the cached status of the room after a write
+// that changes it, here and, through the room's status channel, on every
+// instance caching it.
+func invalidateRoomStatus(ctx context.Context, mid string) {
+	forgetRoomStatus(mid)
+	if statusCacheTTL <= 0 {
+		return
+	}
+	if err := rdb.Publish(ctx, statusChannel(mid), mid).Err(); err != nil {
+		logf(ctx, "Room status invalidation error: %v", err)
+	}
+	sendRegionEvent(ctx, busStatus, mid, nil)
+}
+
+// statusSub listens to the status channels of the rooms this instance
+// caches, nil without Redis or the cache. statusWatchMu orders the
+// subscribes and unsubscribes of a room with its cache entry.
+// statusSubscribed holds, by channel, what a subscribe waiting for
+// Redis's confirmation waits on.
+var (
+	statusSub        atomic.Pointer[redis.PubSub]
+	statusWatchMu    sync.Mutex
+	statusSubscribed sync.Map // channel -> chan struct{}
+)
+
+// statusSubscribeTimeout bounds the wait for Redis to confirm a room's
+// subscription. A room left unconfirmed is not cached until it is swept
+// and subscribed again.
+const statusSubscribeTimeout = 5 * time.Second
+
+// subscribeRoomStatus forgets the rooms other instances invalidate. There
+// is no channel for every room: an instance only hears of the rooms it
+// caches. Messages missed while the subscription reconnects only leave a
+// status stale for statusCacheTTL.
+func subscribeRoomStatus() {
+	if statusCacheTTL <= 0 {
+		return
+	}
+	sub := rdb.Subscribe(context.Background())
+	statusSub.Store(sub)
+	goSafe("room-status-invalidation", func() {
+		for msg := range sub.ChannelWithSubscriptions() {
+			switch msg := msg.(type) {
+			case *redis.Subscription:
+				if msg.Kind != "subscribe" {
+					break
+				}
+				if confirmed, ok := statusSubscribed.LoadAndDelete(msg.Channel); ok {
+					close(confirmed.(chan struct{}))
+				}
+			case *redis.Message:
+				forgetRoomStatus(msg.Payload)
+			}
+		}
+	})
+}
+
+// watchRoomStatus subscribes to the status channel of a room that has just
+// been cached, reporting whether Redis confirmed the subscription: from
+// then on no invalidation of the room is missed. Subscribe only sends the
+// command, and the confirmation comes through the subscriber started by
+// subscribeRoomStatus, which reads everything the subscription receives.
+func watchRoomStatus(mid string) bool {
+	sub := statusSub.Load()
+	if sub == nil {
+		return true
+	}
+	channel := statusChannel(mid)
+	confirmed := make(chan struct{})
+	statusSubscribed.Store(channel, confirmed)
+	defer statusSubscribed.CompareAndDelete(channel, confirmed)
+	statusWatchMu.Lock()
+	err := sub.Subscribe(context.Background(), channel)
+	statusWatchMu.Unlock()
+	if err != nil {
+		log.Printf("Room status subscription error: %v", err)
+		return false
+	}
+	select {
+	case <-confirmed:
+		return true
+	case <-time.After(statusSubscribeTimeout):
+		log.Printf("Room status subscription of %s not confirmed", mid)
+		return false
+	}
+}
+
+// dropRoomStatuses drops the cached statuses of a room and, unless the room
+// was cached again meanwhile, the subscription to its status channel.
+func dropRoomStatuses(mid string) {
+	val, ok := roomStatusCache.LoadAndDelete(mid)
+	sub := statusSub.Load()
+	if !ok || !val.(*roomStatuses).watched || sub == nil {
+		return
+	}
+	statusWatchMu.Lock()
+	defer statusWatchMu.Unlock()
+	if _, ok := roomStatusCache.Load(mid); ok {
+		return
+	}
+	if err := sub.Unsubscribe(context.Background(), statusChannel(mid)); err != nil {
+		log.Printf("Room status subscription error: %v", err)
+	}
+}
+
+// sweepRoomStatuses drops rooms whose cached statuses have all expired.
+func sweepRoomStatuses() {
+	now := clock.Now()
+	roomStatusCache.Range(func(key, val any) bool {
+		rs := val.(*roomStatuses)
+		rs.mu.Lock()
+		live := false
+		for _, st := range rs.polls {
+			live = live || now.Before(st.expires)
+		}
+		rs.mu.Unlock()
+		if !live {
+			dropRoomStatuses(key.(string))
+		}
+		return true
+	})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRoomStatusCache(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	fc := useFakeClock(t)
+	ctx := context.Background()
+	const room = "status-cache-room"
+
+	AddParticipant(ctx, room, "a")
+	AddParticipant(ctx, room, "b")
+	AddParticipant(ctx, room, "c")
+	if total, votes, _, _ := CheckTriggerStatus(ctx, room); total != 3 || votes != 0 {
+		t.Fatalf("status = %d/%d", votes, total)
+	}
+
+	// A change behind the store's back shows once the cached status expires
+	mr.SAdd(roomKey(room, "votes"), "outside")
+	if _, votes, _, _ := CheckTriggerStatus(ctx, room); votes != 0 {
+		t.Errorf("cached votes = %d", votes)
+	}
+	fc.Advance(statusCacheTTL)
+	if _, votes, _, _ := CheckTriggerStatus(ctx, room); votes != 1 {
+		t.Errorf("votes after the TTL = %d", votes)
+	}
+
+	// Writes through the store show right away
+	Vote(ctx, room, "a")
+	if _, votes, triggered, _ := CheckTriggerStatus(ctx, room); votes != 2 || !triggered {
+		t.Errorf("after a vote: %d votes, triggered %t", votes, triggered)
+	}
+	ResetRoom(ctx, room)
+	if _, votes, triggered, _ := CheckTriggerStatus(ctx, room); votes != 0 || triggered {
+		t.Errorf("after a reset: %d votes, triggered %t", votes, triggered)
+	}
+
+	// Another instance's write reaches this one through the room's channel,
+	// listened to from when the room is cached
+	useStatusSubscription(t)
+	dropRoomStatuses(room)
+	CheckTriggerStatus(ctx, room)
+	if n := client.PubSubNumSub(ctx, statusChannel(room)).Val()[statusChannel(room)]; n != 1 {
+		t.Fatalf("room status channel has %d subscribers", n)
+	}
+	mr.SAdd(roomKey(room, "votes"), "elsewhere")
+	client.Publish(ctx, statusChannel(room), room)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, votes, _, _ := CheckTriggerStatus(ctx, room); votes == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("invalidation from another instance not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fc.Advance(statusCacheTTL)
+	sweepRoomStatuses()
+	if _, ok := roomStatusCache.Load(room); ok {
+		t.Error("expired statuses not swept")
+	}
+	eventually(t, "the swept room's channel to be left", func() bool {
+		return client.PubSubNumSub(ctx, statusChannel(room)).Val()[statusChannel(room)] == 0
+	})
+}
+
+func TestRoomStatusUnconfirmed(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	useFakeClock(t)
+	ctx := context.Background()
+	const room = "unconfirmed-room"
+
+	// Without Redis confirming the room's subscription, an invalidation
+	// could be missed, so nothing is cached
+	useStatusSubscription(t)
+	statusSub.Load().Close()
+	AddParticipant(ctx, room, "a")
+	CheckTriggerStatus(ctx, room)
+	mr.SAdd(roomKey(room, "votes"), "elsewhere")
+	if _, votes, _, _ := CheckTriggerStatus(ctx, room); votes != 1 {
+		t.Errorf("status cached without a subscription, %d votes", votes)
+	}
+}
+
+// useStatusSubscription starts the status subscriber for the test.
+func useStatusSubscription(t *testing.T) {
+	subscribeRoomStatus()
+	t.Cleanup(func() {
+		if sub := statusSub.Swap(nil); sub != nil {
+			sub.Close()
+		}
+	})
+}
+
+// roundTripHook counts commands and pipelines sent to Redis.
+type roundTripHook struct{ n *int }
+
+func (h roundTripHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+func (h roundTripHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		*h.n++
+		return next(ctx, cmd)
+	}
+}
+func (h roundTripHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		*h.n++
+		return next(ctx, cmds)
+	}
+}
+
+func TestJoinRoom(t *testing.T) {
+	mr, client := setupTestRedis()
+	rdb = client
+	t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+	useFakeClock(t)
+	ctx := context.Background()
+	const room = "join-room"
+	var trips int
+	client.AddHook(roundTripHook{&trips})
+
+	sub := client.Subscribe(ctx, statusChannel(room))
+	defer sub.Close()
+	sub.Receive(ctx)
+
+	// A join and the status check that follows take one round trip
+	JoinRoom(ctx, room, "a", defaultPoll)
+	JoinRoom(ctx, room, "b", defaultPoll)
+	trips = 0
+	JoinRoom(ctx, room, "c", defaultPoll)
+	total, votes, passed, fired, err := evaluatePoll(ctx, room, defaultPoll)
+	if total != 3 || votes != 0 || passed || fired || err != nil {
+		t.Errorf("status = %d/%d %t %t %v", votes, total, passed, fired, err)
+	}
+	if trips != 1 {
+		t.Errorf("round trips = %d", trips)
+	}
+	for _, uid := range []string{"a", "b", "c"} {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil || msg.Payload != room {
+			t.Fatalf("join of %s announced as %v, %v", uid, msg, err)
+		}
+	}
+
+	// Counts that meet the threshold are left for evaluatePoll to fire on
+	Vote(ctx, room, "a")
+	Vote(ctx, room, "b")
+	JoinRoom(ctx, room, "c", defaultPoll)
+	if _, votes, passed, fired, _ := evaluatePoll(ctx, room, defaultPoll); votes != 2 || !passed || !fired {
+		t.Errorf("after the deciding vote: %d votes, passed %t, fired %t", votes, passed, fired)
+	}
+
+	// Presence-counted polls are never cached by a join
+	presence := *defaultPoll
+	presence.MinPresence = time.Minute
+	ResetRoom(ctx, room)
+	JoinRoom(ctx, room, "a", &presence)
+	trips = 0
+	evaluatePoll(ctx, room, &presence)
+	if trips == 0 {
+		t.Error("presence-counted status answered from the join")
+	}
+}
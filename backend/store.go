@@ -0,0 +1,621 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Store backends, see Config.StoreBackend
+const (
+	storeMemory = "memory"
+	storeRedis  = "redis"
+	storeDynamo = "dynamodb"
+	storeSQLite = "sqlite"
+	storeBolt   = "bolt"
+)
+
+// storeBackend resolves StoreBackend and the file it keeps state in.
+func (c *Config) storeBackend() (backend, path string, err error) {
+	backend, path = c.StoreBackend, c.StorePath
+	if backend == "" {
+		switch {
+		case c.RedisURL != "":
+			backend = storeRedis
+		case c.SQLitePath != "":
+			backend = storeSQLite
+		default:
+			backend = storeMemory
+		}
+	}
+	if backend == storeSQLite && path == "" {
+		path = c.SQLitePath
+	}
+	switch backend {
+	case storeMemory:
+	case storeRedis:
+		if c.RedisURL == "" {
+			return "", "", fmt.Errorf("STORE_BACKEND=redis needs REDIS_URL")
+		}
+	case storeDynamo:
+		if c.DynamoTable == "" {
+			return "", "", fmt.Errorf("STORE_BACKEND=dynamodb needs DYNAMODB_TABLE")
+		}
+	case storeSQLite, storeBolt:
+		if path == "" {
+			return "", "", fmt.Errorf("STORE_BACKEND=%s needs STORE_PATH", backend)
+		}
+	default:
+		return "", "", fmt.Errorf("STORE_BACKEND must be memory, redis, dynamodb, sqlite or bolt, got %q", backend)
+	}
+	return backend, path, nil
+}
+
+// Store keeps the state shared by every instance: rooms, reports, usage
+// and the scheduler's claims. Each method is the package function of the
+// same name, with participant uids already pseudonymized. redisStore
+// keeps it in Redis; localStore keeps it as documents in memory, in a
+// SQLite or bbolt file, or in a DynamoDB table.
+type Store interface {
+	// Name is the store's STORE_BACKEND.
+	Name() string
+
+	JoinRoom(ctx context.Context, mid, uid string, p *Poll) (bool, error)
+	RemoveParticipant(ctx context.Context, mid, uid string) error
+	LeaveParticipant(ctx context.Context, mid, uid string, at time.Time) error
+	SettleLeaves(ctx context.Context, mid string, now time.Time) ([]string, error)
+	PollVote(ctx context.Context, mid string, p *Poll, uid string) (bool, error)
+	EvaluatePoll(ctx context.Context, mid string, p *Poll) (total, votes int, passed, fired bool, err error)
+	AwaitVote(ctx context.Context, mid, pollID string) error
+	ActivePoll(ctx context.Context, mid string) (string, error)
+	StartPoll(ctx context.Context, mid string, p *Poll) error
+	ResetRoom(ctx context.Context, mid string) error
+	SetNamedMode(ctx context.Context, mid string, on bool) error
+	// VoterNames returns whether the room is in named mode, the display
+	// names by uid and the voters of the default poll.
+	VoterNames(ctx context.Context, mid string) (named bool, names map[string]string, votes map[string]bool, err error)
+	SetVoterName(ctx context.Context, mid, uid, name string) error
+	DenyParticipant(ctx context.Context, mid, uid string) error
+	IsDenied(ctx context.Context, mid, uid string) (bool, error)
+	ClearDenied(ctx context.Context, mid string) error
+	RoomActions(ctx context.Context, mid string) ([]string, error)
+	SetRoomActions(ctx context.Context, mid string, actions []string) error
+	SnoozedUntil(ctx context.Context, mid string) (time.Time, error)
+	Snooze(ctx context.Context, mid string, until time.Time) error
+	RoomSettingsOverride(ctx context.Context, mid string) (RoomSettings, int64, error)
+	SetRoomSettings(ctx context.Context, mid string, s RoomSettings, expect int64) (int64, error)
+	RoomTimeline(ctx context.Context, mid string) (opened, firstVote time.Time, err error)
+	AddVoteSample(ctx context.Context, mid string, s VoteSample, interval time.Duration) (bool, error)
+	VoteSamples(ctx context.Context, mid string) ([]VoteSample, error)
+	AppendRoomEvent(ctx context.Context, mid, typ string, at time.Time) (string, error)
+	RoomEventsSince(ctx context.Context, mid, since string) ([]RoomEvent, error)
+	CountAction(ctx context.Context, mid, kind, uid string, window time.Duration) (int, time.Duration, error)
+	ClaimVoteKey(ctx context.Context, mid, uid, key string, ttl time.Duration) (string, bool, error)
+	SettleVoteKey(ctx context.Context, mid, uid, key, ack string) error
+
+	// SaveReport is given the report as logged too, without its samples.
+	SaveReport(ctx context.Context, mid string, r, logged *MeetingReport) error
+	RoomReport(ctx context.Context, mid string) (*MeetingReport, error)
+	ReportsBetween(ctx context.Context, tenant string, from, to time.Time) ([]*MeetingReport, error)
+	PurgeReports(ctx context.Context, tenant string, cutoff time.Time) (int64, error)
+	// ReportOptOut and SetReportOptOut take the pseudonymized host ID.
+	ReportOptOut(ctx context.Context, id string) (bool, error)
+	SetReportOptOut(ctx context.Context, id string, out bool) error
+	RecordSeriesEntry(ctx context.Context, series string, e SeriesEntry, overwrite bool) (bool, error)
+	SeriesEntries(ctx context.Context, series string) ([]SeriesEntry, error)
+	SaveRollup(ctx context.Context, tenant string, ru ReportRollup) error
+	Rollups(ctx context.Context, tenant string) ([]ReportRollup, error)
+	PurgeRollups(ctx context.Context, tenant, date string) (int64, error)
+	PutBenchmarkDay(ctx context.Context, date string, day *BenchmarkDay) error
+	BenchmarkDays(ctx context.Context) ([]BenchmarkDay, error)
+	PurgeBenchmarkDays(ctx context.Context, date string) (int64, error)
+	IncrHeldMeetings(ctx context.Context, tenant, date string) error
+	HeldMeetings(ctx context.Context, tenant, date string) (int, error)
+	MarkConnected(ctx context.Context, tenant, mid, uid string, at, idleBefore time.Time) error
+	TenantConnections(ctx context.Context, tenant, mid, uid string, since time.Time) (ConnectedUsage, error)
+	IncrMonthlyTriggers(ctx context.Context, tenant, month string) error
+	MonthlyTriggers(ctx context.Context, tenant, month string) (int, error)
+	SaveResumeSession(ctx context.Context, tokenHash string, s *resumeSession, ttl time.Duration) error
+	ResumeSession(ctx context.Context, tokenHash string, ttl time.Duration) (*resumeSession, error)
+
+	AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	ClaimJobRun(ctx context.Context, job string, due time.Time) (bool, error)
+	ArchiveCandidates(ctx context.Context, idleBefore time.Time, limit int) ([]string, error)
+	PurgeRoom(ctx context.Context, mid string) error
+	ClosingRooms(ctx context.Context, now time.Time, limit int) ([]string, error)
+	ClaimEmptyRoom(ctx context.Context, mid string, now time.Time) (bool, []string, error)
+	LiveRooms(ctx context.Context, limit int) ([]liveRoom, bool, error)
+	PutInstanceStatus(ctx context.Context, st *instanceStatus, staleBefore time.Time) error
+	InstanceStatuses(ctx context.Context) ([]instanceStatus, error)
+	RecordTrigger(ctx context.Context, ev TriggerEvent) error
+	RecentTriggers(ctx context.Context) ([]TriggerEvent, error)
+}
+
+// local is the store when Redis is not used. It starts out in memory, for
+// tests and for runs without STORE_BACKEND.
+var local = newLocalStore(storeMemory, newMemKV())
+
+// currentStore returns the store the package functions use. Work that
+// outlives its request should take the store before it starts.
+func currentStore() Store {
+	if useRedis {
+		return redisStore{rdb: rdb}
+	}
+	return local
+}
+
+// openStoreFile keeps the store in the SQLite or bbolt file at path until
+// closeStore.
+func openStoreFile(backend, path string) error {
+	var t table
+	var err error
+	switch backend {
+	case storeSQLite:
+		t, err = openSQLiteTable(path)
+	case storeBolt:
+		t, err = openBoltTable(path)
+	default:
+		return fmt.Errorf("store backend %q keeps no file", backend)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", backend, err)
+	}
+	local = newLocalStore(backend, newTableKV(t))
+	log.Printf("Keeping the store in %s at %s.", backend, path)
+	return nil
+}
+
+// closeStore closes the file or table the store is kept in.
+func closeStore() {
+	if err := local.close(); err != nil {
+		log.Printf("store: close: %v", err)
+	}
+}
+
+// AddParticipant marks uid as present in the room.
+func AddParticipant(ctx context.Context, mid, uid string) error {
+	_, err := JoinRoom(ctx, mid, uid, nil)
+	return err
+}
+
+// JoinRoom is AddParticipant in one round trip that also caches the
+// status of poll p, so the evaluatePoll that follows a panel's poll does
+// not go to Redis again. Polls counting votes by presence are not cached,
+// as that needs every voter's join time. It reports whether uid was not
+// a participant before.
+func JoinRoom(ctx context.Context, mid, uid string, p *Poll) (bool, error) {
+	return currentStore().JoinRoom(ctx, mid, pseudonymize(mid, uid), p)
+}
+
+func RemoveParticipant(ctx context.Context, mid, uid string) error {
+	return currentStore().RemoveParticipant(ctx, mid, pseudonymize(mid, uid))
+}
+
+// LeaveParticipant schedules uid to stop counting as a participant at
+// the given time. Adding it again before then cancels the leave.
+func LeaveParticipant(ctx context.Context, mid, uid string, at time.Time) error {
+	return currentStore().LeaveParticipant(ctx, mid, pseudonymize(mid, uid), at)
+}
+
+// SettleLeaves removes the participants whose leave has taken effect and
+// returns their pseudonymized uids. Each is returned by one call only.
+func SettleLeaves(ctx context.Context, mid string) ([]string, error) {
+	return currentStore().SettleLeaves(ctx, mid, clock.Now())
+}
+
+// Vote records uid's vote in the room's default poll.
+func Vote(ctx context.Context, mid, uid string) (bool, error) {
+	return PollVote(ctx, mid, defaultPoll, uid)
+}
+
+// CheckTriggerStatus evaluates the room's default poll and reports
+// participants, votes, and whether the ending has been triggered.
+func CheckTriggerStatus(ctx context.Context, mid string) (int, int, bool, error) {
+	return PollStatus(ctx, mid, defaultPoll)
+}
+
+// PollVote records uid's vote in poll p and reports whether it is new.
+// Votes are refused with errPollPassed once the poll has passed. Like the
+// participants set, votes only ever hold pseudonymized uids.
+func PollVote(ctx context.Context, mid string, p *Poll, uid string) (bool, error) {
+	return currentStore().PollVote(ctx, mid, p, pseudonymize(mid, uid))
+}
+
+// PollStatus returns participants and votes for poll p and whether it has
+// passed, marking it passed once p.Threshold is met. A passed poll stays
+// passed until the room or poll is reset.
+func PollStatus(ctx context.Context, mid string, p *Poll) (int, int, bool, error) {
+	total, votes, passed, _, err := evaluatePoll(ctx, mid, p)
+	return total, votes, passed, err
+}
+
+// evaluatePoll is PollStatus that also reports whether this call is the one
+// that marked the poll passed. Exactly one caller across all instances sees
+// fired, so trigger actions run once per room.
+func evaluatePoll(ctx context.Context, mid string, p *Poll) (total, votes int, passed, fired bool, err error) {
+	return currentStore().EvaluatePoll(ctx, mid, p)
+}
+
+// AwaitVote keeps poll pollID from passing until someone votes in it
+// again, whatever its count.
+func AwaitVote(ctx context.Context, mid, pollID string) error {
+	return currentStore().AwaitVote(ctx, mid, pollID)
+}
+
+// ActivePoll returns the side poll the host has started in the room, or nil.
+func ActivePoll(ctx context.Context, mid string) (*Poll, error) {
+	id, err := currentStore().ActivePoll(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+	return polls[id], nil
+}
+
+// StartPoll makes p the room's side poll with fresh votes, replacing any
+// previous one. A nil p closes the side poll.
+func StartPoll(ctx context.Context, mid string, p *Poll) error {
+	return currentStore().StartPoll(ctx, mid, p)
+}
+
+// ResetRoom clears the votes and the triggered flag so the room can vote
+// again. Participants are kept.
+func ResetRoom(ctx context.Context, mid string) error {
+	return currentStore().ResetRoom(ctx, mid)
+}
+
+// SetNamedMode turns the room's voter list on or off. Turning it off forgets
+// all display names.
+func SetNamedMode(ctx context.Context, mid string, on bool) error {
+	return currentStore().SetNamedMode(ctx, mid, on)
+}
+
+// NamedParticipants returns the pseudonymized uids that shared the given
+// display name in named mode.
+func NamedParticipants(ctx context.Context, mid, name string) ([]string, error) {
+	_, byUID, _, err := currentStore().VoterNames(ctx, mid)
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for uid, n := range byUID {
+		if n == name {
+			uids = append(uids, uid)
+		}
+	}
+	slices.Sort(uids)
+	return uids, nil
+}
+
+// DenyParticipant removes the participant with pseudonymized uid from the
+// room, with its votes in every poll and its display name, and keeps it
+// from joining again until the room expires or ClearDenied.
+func DenyParticipant(ctx context.Context, mid, uid string) error {
+	return currentStore().DenyParticipant(ctx, mid, uid)
+}
+
+// IsDenied reports whether the host removed uid from the room.
+func IsDenied(ctx context.Context, mid, uid string) (bool, error) {
+	return currentStore().IsDenied(ctx, mid, pseudonymize(mid, uid))
+}
+
+// ClearDenied lets every removed participant join the room again.
+func ClearDenied(ctx context.Context, mid string) error {
+	return currentStore().ClearDenied(ctx, mid)
+}
+
+// SetVoterName records the display name uid consented to share. It is a
+// no-op unless the room is in named mode.
+func SetVoterName(ctx context.Context, mid, uid, name string) error {
+	return currentStore().SetVoterName(ctx, mid, pseudonymize(mid, uid), name)
+}
+
+// VoterNames reports whether the room is in named mode and, if so, the
+// display names of voters who shared one, sorted.
+func VoterNames(ctx context.Context, mid string) ([]string, bool, error) {
+	named, byUID, votes, err := currentStore().VoterNames(ctx, mid)
+	if err != nil || !named {
+		return nil, false, err
+	}
+	var names []string
+	for uid, name := range byUID {
+		if votes[uid] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, true, nil
+}
+
+// RoomActions returns the room's trigger action list, or nil if the room
+// uses the deployment default.
+func RoomActions(ctx context.Context, mid string) ([]string, error) {
+	return currentStore().RoomActions(ctx, mid)
+}
+
+// SetRoomActions overrides the room's trigger actions; nil restores the
+// default. An empty non-nil list means no actions.
+func SetRoomActions(ctx context.Context, mid string, actions []string) error {
+	return currentStore().SetRoomActions(ctx, mid, actions)
+}
+
+// SnoozedUntil returns when the room's snooze ends, or the zero time.
+func SnoozedUntil(ctx context.Context, mid string) (time.Time, error) {
+	return currentStore().SnoozedUntil(ctx, mid)
+}
+
+// Snooze postpones the room's ending until until and clears the snooze
+// poll, so snoozing again takes a fresh majority.
+func Snooze(ctx context.Context, mid string, until time.Time) error {
+	return currentStore().Snooze(ctx, mid, until)
+}
+
+// RoomSettingsOverride returns the settings the room overrides, with unset
+// fields empty, and their version (0 if never set).
+func RoomSettingsOverride(ctx context.Context, mid string) (RoomSettings, int64, error) {
+	return currentStore().RoomSettingsOverride(ctx, mid)
+}
+
+// SetRoomSettings replaces the room's overrides and returns the new
+// version. With expect >= 0 the write only happens if the stored version
+// still equals expect. The zero RoomSettings restores the defaults.
+func SetRoomSettings(ctx context.Context, mid string, s RoomSettings, expect int64) (int64, error) {
+	return currentStore().SetRoomSettings(ctx, mid, s, expect)
+}
+
+// RoomTimeline returns when the room's panel was first opened and when the
+// first vote of the default poll came in; zero when unknown.
+func RoomTimeline(ctx context.Context, mid string) (opened, firstVote time.Time, err error) {
+	return currentStore().RoomTimeline(ctx, mid)
+}
+
+// SaveReport stores the room's latest meeting report and adds it, without
+// its samples, to the tenant's report log.
+func SaveReport(ctx context.Context, mid string, r *MeetingReport) error {
+	logged := *r
+	logged.Samples = nil
+	return currentStore().SaveReport(ctx, mid, r, &logged)
+}
+
+// ReportsBetween returns the tenant's reports triggered in [from, to),
+// oldest first.
+func ReportsBetween(ctx context.Context, tenant string, from, to time.Time) ([]*MeetingReport, error) {
+	return currentStore().ReportsBetween(ctx, tenant, from, to)
+}
+
+// PurgeReports drops the tenant's logged reports triggered before cutoff
+// and returns how many it dropped.
+func PurgeReports(ctx context.Context, tenant string, cutoff time.Time) (int64, error) {
+	return currentStore().PurgeReports(ctx, tenant, cutoff)
+}
+
+// RoomReport returns the room's latest meeting report, or nil.
+func RoomReport(ctx context.Context, mid string) (*MeetingReport, error) {
+	return currentStore().RoomReport(ctx, mid)
+}
+
+// ReportOptOut reports whether the host unsubscribed from meeting reports.
+// Host IDs are stored pseudonymized like participant uids.
+func ReportOptOut(ctx context.Context, hostID string) (bool, error) {
+	return currentStore().ReportOptOut(ctx, pseudonymize("reports", hostID))
+}
+
+// SetReportOptOut unsubscribes the host from meeting reports, or
+// resubscribes them.
+func SetReportOptOut(ctx context.Context, hostID string, out bool) error {
+	return currentStore().SetReportOptOut(ctx, pseudonymize("reports", hostID), out)
+}
+
+// RecordSeriesEntry stores e as the series' occurrence on e.Date. Without
+// overwrite an existing entry for that date is kept. Reports whether the
+// date had no entry before.
+func RecordSeriesEntry(ctx context.Context, series string, e SeriesEntry, overwrite bool) (bool, error) {
+	return currentStore().RecordSeriesEntry(ctx, series, e, overwrite)
+}
+
+// SeriesEntries returns the series' occurrences in no particular order.
+func SeriesEntries(ctx context.Context, series string) ([]SeriesEntry, error) {
+	return currentStore().SeriesEntries(ctx, series)
+}
+
+// AddVoteSample appends s to the room's vote timeline unless a sample was
+// taken less than interval ago, and reports whether it did. The timeline
+// keeps the newest maxSamples.
+func AddVoteSample(ctx context.Context, mid string, s VoteSample, interval time.Duration) (bool, error) {
+	return currentStore().AddVoteSample(ctx, mid, s, interval)
+}
+
+// VoteSamples returns the room's vote timeline, oldest first.
+func VoteSamples(ctx context.Context, mid string) ([]VoteSample, error) {
+	return currentStore().VoteSamples(ctx, mid)
+}
+
+// SaveRollup stores ru as the tenant's rollup of ru.Date, replacing any
+// earlier one.
+func SaveRollup(ctx context.Context, tenant string, ru ReportRollup) error {
+	return currentStore().SaveRollup(ctx, tenant, ru)
+}
+
+// Rollups returns the tenant's daily rollups in no particular order.
+func Rollups(ctx context.Context, tenant string) ([]ReportRollup, error) {
+	return currentStore().Rollups(ctx, tenant)
+}
+
+// PurgeRollups drops the tenant's rollups of days before date (YYYY-MM-DD)
+// and returns how many it dropped.
+func PurgeRollups(ctx context.Context, tenant, date string) (int64, error) {
+	return currentStore().PurgeRollups(ctx, tenant, date)
+}
+
+// AcquireLeadership reports whether instance id leads the scheduler for
+// the next ttl, taking or renewing the lock.
+func AcquireLeadership(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return currentStore().AcquireLeadership(ctx, id, ttl)
+}
+
+// ClaimJobRun reports whether the caller is the first to run job for its
+// run due at the given time.
+func ClaimJobRun(ctx context.Context, job string, due time.Time) (bool, error) {
+	return currentStore().ClaimJobRun(ctx, job, due)
+}
+
+// IncrHeldMeetings counts one more meeting of the tenant on date.
+func IncrHeldMeetings(ctx context.Context, tenant, date string) error {
+	return currentStore().IncrHeldMeetings(ctx, tenant, date)
+}
+
+// HeldMeetings returns how many meetings of the tenant were held on date.
+func HeldMeetings(ctx context.Context, tenant, date string) (int, error) {
+	return currentStore().HeldMeetings(ctx, tenant, date)
+}
+
+// MarkConnected records the panel of uid in meeting mid as connected at
+// at, and forgets rooms and panels not connected since idleBefore.
+func MarkConnected(ctx context.Context, tenant, mid, uid string, at, idleBefore time.Time) error {
+	return currentStore().MarkConnected(ctx, tenant, mid, pseudonymize(mid, uid), at, idleBefore)
+}
+
+// TenantConnections counts the tenant's rooms and panels connected since
+// since, and whether meeting mid and uid's panel in it are among them.
+func TenantConnections(ctx context.Context, tenant, mid, uid string, since time.Time) (ConnectedUsage, error) {
+	if uid != "" {
+		uid = pseudonymize(mid, uid)
+	}
+	return currentStore().TenantConnections(ctx, tenant, mid, uid, since)
+}
+
+// IncrMonthlyTriggers counts one more trigger of the tenant in month,
+// YYYY-MM.
+func IncrMonthlyTriggers(ctx context.Context, tenant, month string) error {
+	return currentStore().IncrMonthlyTriggers(ctx, tenant, month)
+}
+
+// MonthlyTriggers returns how many times the tenant's rooms triggered in
+// month.
+func MonthlyTriggers(ctx context.Context, tenant, month string) (int, error) {
+	return currentStore().MonthlyTriggers(ctx, tenant, month)
+}
+
+// PutBenchmarkDay replaces the pool's figures for date, or removes them
+// when day is nil.
+func PutBenchmarkDay(ctx context.Context, date string, day *BenchmarkDay) error {
+	return currentStore().PutBenchmarkDay(ctx, date, day)
+}
+
+// BenchmarkDays returns the pool's days in no particular order.
+func BenchmarkDays(ctx context.Context) ([]BenchmarkDay, error) {
+	return currentStore().BenchmarkDays(ctx)
+}
+
+// PurgeBenchmarkDays drops the pool's days before date (YYYY-MM-DD).
+func PurgeBenchmarkDays(ctx context.Context, date string) (int64, error) {
+	return currentStore().PurgeBenchmarkDays(ctx, date)
+}
+
+// SaveResumeSession stores s under the token hash for ttl.
+func SaveResumeSession(ctx context.Context, tokenHash string, s *resumeSession, ttl time.Duration) error {
+	return currentStore().SaveResumeSession(ctx, tokenHash, s, ttl)
+}
+
+// ResumeSession returns the live session of the token hash and extends it
+// by ttl in the same step, or nil.
+func ResumeSession(ctx context.Context, tokenHash string, ttl time.Duration) (*resumeSession, error) {
+	return currentStore().ResumeSession(ctx, tokenHash, ttl)
+}
+
+// AppendRoomEvent adds an event to the room's log, keeping the newest
+// maxRoomEvents, and returns its ID.
+func AppendRoomEvent(ctx context.Context, mid, typ string, at time.Time) (string, error) {
+	return currentStore().AppendRoomEvent(ctx, mid, typ, at)
+}
+
+// RoomEventsSince returns the room's events after the event with ID since,
+// oldest first. With an empty since it returns only the newest event, so
+// a new panel learns where to start.
+func RoomEventsSince(ctx context.Context, mid, since string) ([]RoomEvent, error) {
+	return currentStore().RoomEventsSince(ctx, mid, since)
+}
+
+// CountAction counts one action of the given kind (vote, leave) by uid in
+// the room and returns how many it made in the current window, the first
+// of which started it, and the time left in the window. Shared by all
+// instances through the store.
+func CountAction(ctx context.Context, mid, kind, uid string, window time.Duration) (int, time.Duration, error) {
+	return currentStore().CountAction(ctx, mid, kind, pseudonymize(mid, uid), window)
+}
+
+// ClaimVoteKey takes the idempotency key of uid's vote in the room for
+// ttl and reports whether it is new. If it is not, the ack the first vote
+// with it got is returned, empty while that vote is still being taken.
+func ClaimVoteKey(ctx context.Context, mid, uid, key string, ttl time.Duration) (string, bool, error) {
+	return currentStore().ClaimVoteKey(ctx, mid, pseudonymize(mid, uid), key, ttl)
+}
+
+// SettleVoteKey records the ack of the vote that claimed key, or lets the
+// key go with an empty ack, so the vote can be tried again.
+func SettleVoteKey(ctx context.Context, mid, uid, key, ack string) error {
+	return currentStore().SettleVoteKey(ctx, mid, pseudonymize(mid, uid), key, ack)
+}
+
+// ArchiveCandidates returns up to limit rooms with a history that were
+// last used before idleBefore.
+func ArchiveCandidates(ctx context.Context, idleBefore time.Time, limit int) ([]string, error) {
+	return currentStore().ArchiveCandidates(ctx, idleBefore, limit)
+}
+
+// PurgeRoom deletes all of the room's state, once it is archived. The
+// meeting report stays until reportTTL for the report API.
+func PurgeRoom(ctx context.Context, mid string) error {
+	return currentStore().PurgeRoom(ctx, mid)
+}
+
+// ClosingRooms returns up to limit rooms whose last leave took effect by
+// now.
+func ClosingRooms(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return currentStore().ClosingRooms(ctx, now, limit)
+}
+
+// ClaimEmptyRoom reports whether every participant of the room has left
+// by now, and if so returns their pseudonymized uids. Of the instances
+// looking at the room at once, only one gets true.
+func ClaimEmptyRoom(ctx context.Context, mid string, now time.Time) (bool, []string, error) {
+	return currentStore().ClaimEmptyRoom(ctx, mid, now)
+}
+
+// LiveRooms returns up to limit of the rooms still in the store, newest
+// first, and whether there were more. The rooms past limit are an
+// arbitrary cut.
+func LiveRooms(ctx context.Context, limit int) ([]liveRoom, bool, error) {
+	rooms, more, err := currentStore().LiveRooms(ctx, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	slices.SortFunc(rooms, func(a, b liveRoom) int {
+		return cmp.Or(b.OpenedAt.Compare(a.OpenedAt), strings.Compare(a.Room, b.Room))
+	})
+	return rooms, more, nil
+}
+
+// PutInstanceStatus records st as its instance's heartbeat and drops the
+// instances that stopped beating before staleBefore.
+func PutInstanceStatus(ctx context.Context, st *instanceStatus, staleBefore time.Time) error {
+	return currentStore().PutInstanceStatus(ctx, st, staleBefore)
+}
+
+// InstanceStatuses returns the instances' last heartbeats.
+func InstanceStatuses(ctx context.Context) ([]instanceStatus, error) {
+	return currentStore().InstanceStatuses(ctx)
+}
+
+// RecordTrigger adds ev to the recent trigger events.
+func RecordTrigger(ctx context.Context, ev TriggerEvent) error {
+	return currentStore().RecordTrigger(ctx, ev)
+}
+
+// RecentTriggers returns the newest trigger events, newest first.
+func RecentTriggers(ctx context.Context) ([]TriggerEvent, error) {
+	return currentStore().RecentTriggers(ctx)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useMemoryStore gives the test an empty in-memory store.
+func useMemoryStore(t *testing.T) {
+	prev := local
+	local = newLocalStore(storeMemory, newMemKV())
+	t.Cleanup(func() { local = prev })
+}
+
+// memDocStored reports whether the in-memory store still holds the
+// document, expired or not.
+func memDocStored(kind, id string) bool {
+	return local.kv.(*memKV).lookup(kind, id) != nil
+}
+
+// memDocsStored returns the IDs of the documents of kind starting with
+// prefix that the in-memory store still holds, expired or not.
+func memDocsStored(kind, prefix string) []string {
+	var ids []string
+	local.kv.(*memKV).groups(kind).Range(func(_, val any) bool {
+		found, _ := val.(*memGroup).list(prefix)
+		ids = append(ids, found...)
+		return true
+	})
+	return ids
+}
+
+func TestStoreFilesKeepRooms(t *testing.T) {
+	open := map[string]func(string) (table, error){
+		storeSQLite: func(path string) (table, error) { return openSQLiteTable(path) },
+		storeBolt:   func(path string) (table, error) { return openBoltTable(path) },
+	}
+	for backend, openTable := range open {
+		t.Run(backend, func(t *testing.T) {
+			useRedis = false
+			useMemoryStore(t)
+			fc := useFakeClock(t)
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "store.db")
+			var tbl table
+			prevKey := uidHashKey.Load()
+			t.Cleanup(func() { uidHashKey.Store(prevKey) })
+			restarts := 0
+			reopen := func() {
+				t.Helper()
+				var err error
+				if tbl, err = openTable(path); err != nil {
+					t.Fatal(err)
+				}
+				local = newLocalStore(backend, newTableKV(tbl))
+				// Each process starts with its own random key
+				restarts++
+				setUIDHashKey(fmt.Sprint("process ", restarts))
+				if err := useStoredUIDHashKey(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reopen()
+			AddParticipant(ctx, "store-room", "u1")
+			AddParticipant(ctx, "store-room", "u2")
+			Vote(ctx, "store-room", "u1")
+			SaveReport(ctx, "store-room", &MeetingReport{Tenant: "t1", RoomID: "store-room", TriggeredAt: clock.Now()})
+			SaveResumeSession(ctx, "hash", &resumeSession{UID: "u1", Mid: "store-room"}, time.Hour)
+			AddParticipant(ctx, "store-gone", "u1")
+			PurgeRoom(ctx, "store-gone")
+			fc.Advance(time.Minute)
+			local.close()
+
+			// A restart finds everything as it was, but not the purged room
+			reopen()
+			if total, votes, _, _ := CheckTriggerStatus(ctx, "store-room"); total != 2 || votes != 1 {
+				t.Errorf("after restart: %d/%d", votes, total)
+			}
+			// and the same participants by the key kept in the file
+			if added, _ := Vote(ctx, "store-room", "u1"); added {
+				t.Error("u1 voted twice across a restart")
+			}
+			if joined, _ := JoinRoom(ctx, "store-room", "u2", nil); joined {
+				t.Error("u2 joined twice across a restart")
+			}
+			if r, _ := RoomReport(ctx, "store-room"); r == nil {
+				t.Error("report lost")
+			}
+			if reports, _ := ReportsBetween(ctx, "t1", time.Time{}, clock.Now()); len(reports) != 1 {
+				t.Errorf("%d logged reports", len(reports))
+			}
+			if s, _ := ResumeSession(ctx, "hash", time.Hour); s == nil || s.UID != "u1" {
+				t.Errorf("session = %+v", s)
+			}
+			if rooms, _, _ := LiveRooms(ctx, 10); len(rooms) != 1 {
+				t.Errorf("live rooms = %+v", rooms)
+			}
+
+			// Expired documents are swept from the file
+			fc.Advance(reportTTL)
+			if err := local.sweep(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var left []string
+			tbl.scan(ctx, keyPrefix, func(key string, _ tableItem) bool {
+				if key != keyPrefix+docUIDHashKey+":key" {
+					left = append(left, key)
+				}
+				return true
+			})
+			if len(left) != 1 || left[0] != keyPrefix+docReportLog+":"+reportLogID("t1", clock.Now().Add(-reportTTL-time.Minute), "store-room") {
+				t.Errorf("left after the sweep: %q", left)
+			}
+			local.close()
+		})
+	}
+}
+
+func TestStoreBackend(t *testing.T) {
+	for _, tc := range []struct {
+		cfg           Config
+		backend, path string
+	}{
+		{Config{}, storeMemory, ""},
+		{Config{RedisURL: "redis://r"}, storeRedis, ""},
+		{Config{SQLitePath: "rooms.db"}, storeSQLite, "rooms.db"},
+		{Config{RedisURL: "redis://r", StoreBackend: "bolt", StorePath: "rooms.bolt"}, storeBolt, "rooms.bolt"},
+	} {
+		if backend, path, err := tc.cfg.storeBackend(); err != nil || backend != tc.backend || path != tc.path {
+			t.Errorf("%+v: %s %q, %v", tc.cfg, backend, path, err)
+		}
+	}
+	for _, cfg := range []Config{{StoreBackend: "redis"}, {StoreBackend: "bolt"}, {StoreBackend: "etcd"}} {
+		if _, _, err := cfg.storeBackend(); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
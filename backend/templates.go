@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The HTMX fragments are html/template definitions embedded in the binary.
+// TEMPLATE_DIR can point at a directory of *.html files whose definitions
+// replace the embedded ones of the same name.
+//
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// fragments holds one template set per locale, each with its own "t".
+var fragments atomic.Pointer[map[string]*template.Template]
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	// asset is the fingerprinted URL of a frontend file, see assetURL
+	"asset": assetURL,
+	"t":     func(msg string) string { return msg },
+}
+
+func init() {
+	t, err := parseTemplates("")
+	if err != nil {
+		panic(err)
+	}
+	sets, err := localizeTemplates(t)
+	if err != nil {
+		panic(err)
+	}
+	fragments.Store(&sets)
+}
+
+// parseTemplates parses the embedded templates, then dir's on top of them.
+func parseTemplates(dir string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(embeddedTemplates, "templates/*.html")
+	if err != nil || dir == "" {
+		return t, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(files) == 0 {
+		return t, err
+	}
+	return t.ParseFiles(files...)
+}
+
+// localizeTemplates clones t for every locale with "t" translating into it.
+// t itself is never executed, so it stays cloneable for the next reload.
+func localizeTemplates(t *template.Template) (map[string]*template.Template, error) {
+	sets := make(map[string]*template.Template)
+	for _, lang := range append([]string{defaultLocale}, slices.Collect(maps.Keys(messages))...) {
+		c, err := t.Clone()
+		if err != nil {
+			return nil, err
+		}
+		sets[lang] = c.Funcs(template.FuncMap{"t": func(msg string) string { return translate(lang, msg) }})
+	}
+	return sets, nil
+}
+
+// renderBuffers are reused across renderFragment calls: every panel's
+// poll renders a dozen fragments, every two seconds.
+var renderBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// renderFragment executes the named template in lang. A broken override
+// renders nothing rather than failing the whole state response.
+func renderFragment(lang, name string, data any) string {
+	sets := *fragments.Load()
+	t, ok := sets[lang]
+	if !ok {
+		t = sets[defaultLocale]
+	}
+	b := renderBuffers.Get().(*bytes.Buffer)
+	b.Reset()
+	defer renderBuffers.Put(b)
+	if err := t.ExecuteTemplate(b, name, data); err != nil {
+		log.Printf("Template %s failed: %v", name, err)
+		metrics.Add("template_errors", 1)
+	}
+	return b.String()
+}
+
+// loadTemplateDir parses dir over the embedded templates.
+func loadTemplateDir(dir string) error {
+	t, err := parseTemplates(dir)
+	if err != nil {
+		return err
+	}
+	sets, err := localizeTemplates(t)
+	if err != nil {
+		return err
+	}
+	fragments.Store(&sets)
+	log.Printf("Loaded template overrides from %s", dir)
+	return nil
+}
+
+// templateDirStamp changes whenever a template in dir is added, removed or
+// modified.
+func templateDirStamp(dir string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+	var b strings.Builder
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			b.WriteString(f + info.ModTime().String() + ";")
+		}
+	}
+	return b.String()
+}
+
+// watchTemplateDir re-parses dir whenever it changes so designers can
+// iterate without restarting. A template that fails to parse keeps the
+// previous set.
+func watchTemplateDir(dir string, interval time.Duration) {
+	goSafe("template-reloader", func() {
+		last := templateDirStamp(dir)
+		for range time.Tick(interval) {
+			stamp := templateDirStamp(dir)
+			if stamp == last {
+				continue
+			}
+			last = stamp
+			if err := loadTemplateDir(dir); err != nil {
+				log.Printf("Template reload failed, keeping previous templates: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateOverrides(t *testing.T) {
+	orig := fragments.Load()
+	t.Cleanup(func() { fragments.Store(orig) })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "voter_list.html")
+	os.WriteFile(path, []byte(`{{define "voter_list"}}<ul id="voter-list">{{range .Names}}<li>{{.}}</li>{{end}}</ul>{{end}}`), 0o600)
+	if err := loadTemplateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := generateVoterListHTML(defaultLocale, []string{"<b>佐藤</b>"}, 1); got != `<ul id="voter-list"><li>&lt;b&gt;佐藤&lt;/b&gt;</li></ul>` {
+		t.Errorf("overridden voter list = %q", got)
+	}
+	// Fragments that are not overridden keep the embedded definition
+	if got := generateSnoozeBannerHTML(defaultLocale, 90*time.Second); !strings.Contains(got, "1:30") {
+		t.Errorf("snooze banner = %q", got)
+	}
+
+	if err := loadTemplateDir(writeTemplate(t, `{{define "voter_list"}}{{.Broken}`)); err == nil {
+		t.Error("broken template was accepted")
+	}
+
+	watchTemplateDir(dir, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	os.WriteFile(path, []byte(`{{define "voter_list"}}<p>reloaded</p>{{end}}`), 0o600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if got := generateVoterListHTML(defaultLocale, nil, 0); got == "<p>reloaded</p>" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("template was not reloaded, got %q", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A syntax error keeps the previous templates
+	os.WriteFile(path, []byte(`{{define "voter_list"}}{{.Broken}`), 0o600)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if got := generateVoterListHTML(defaultLocale, nil, 0); got != "<p>reloaded</p>" {
+		t.Errorf("after a broken edit got %q", got)
+	}
+}
+
+func writeTemplate(t *testing.T, body string) string {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "t.html"), []byte(body), 0o600)
+	return dir
+}
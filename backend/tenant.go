@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Tenant is one Zoom app (and so one organization) served by this
+// deployment. Rooms are namespaced by ID, see ZoomAuthContext.RoomID.
+type Tenant struct {
+	ID       string   `json:"id"`
+	ClientID string   `json:"client_id"`
+	Hosts    []string `json:"hosts"`   // Home URL hostnames of the app
+	Secrets  []string `json:"secrets"` // client secrets, current first
+
+	// ZoomAPI are the tenant's Server-to-Server OAuth credentials, optional
+	ZoomAPI *zoomAPICredentials `json:"zoom_api"`
+
+	// GoogleCalendar is a Google credentials file the tenant's meeting
+	// schedules are read with, optional
+	GoogleCalendar *googleCalendarCredentials `json:"google_calendar"`
+
+	// MicrosoftGraph reads Outlook calendars instead, optional
+	MicrosoftGraph *graphCredentials `json:"microsoft_graph"`
+
+	// ICSURL is an iCalendar feed of the tenant's meetings, for when no
+	// calendar API access can be granted, optional
+	ICSURL string `json:"ics_url"`
+
+	// Settings are the organization's defaults for all its rooms, optional
+	Settings *RoomSettings `json:"settings"`
+
+	// Reports overrides where post-meeting reports are delivered, optional
+	Reports *ReportDelivery `json:"reports"`
+
+	// Quota caps the tenant's usage according to its plan, optional
+	Quota *UsageQuota `json:"quota"`
+
+	// StripeCustomer is the Stripe customer the tenant's usage is billed
+	// to, optional
+	StripeCustomer string `json:"stripe_customer"`
+
+	// Benchmark opts the tenant into contributing anonymized aggregates to
+	// the cross-tenant benchmark, and into comparing itself against it
+	Benchmark bool `json:"benchmark"`
+}
+
+// tenants is set from TENANTS_FILE. When nil the deployment is single-tenant
+// and the global ZOOM_CLIENT_SECRET set is used.
+var tenants atomic.Pointer[[]*Tenant]
+
+// readTenantsFile parses a JSON array of tenants and checks that IDs, client
+// IDs and hosts are unique.
+func readTenantsFile(path string) ([]*Tenant, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Tenant
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("tenants file %s: %w", path, err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("tenants file %s contains no tenants", path)
+	}
+
+	seen := map[string]string{}
+	claim := func(kind, key, id string) error {
+		if key == "" {
+			return nil
+		}
+		if other, ok := seen[kind+":"+key]; ok {
+			return fmt.Errorf("tenants %q and %q share %s %q", other, id, kind, key)
+		}
+		seen[kind+":"+key] = id
+		return nil
+	}
+	for _, t := range list {
+		if t.ID == "" || strings.ContainsAny(t.ID, "/: ") {
+			return nil, fmt.Errorf("tenant id %q must be non-empty without '/', ':' or spaces", t.ID)
+		}
+		if len(t.Secrets) == 0 {
+			return nil, fmt.Errorf("tenant %q has no secrets", t.ID)
+		}
+		if t.Settings != nil {
+			if err := t.Settings.validate(); err != nil {
+				return nil, fmt.Errorf("tenant %q settings: %w", t.ID, err)
+			}
+		}
+		if t.Quota != nil {
+			if err := t.Quota.validate(); err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", t.ID, err)
+			}
+		}
+		for i, h := range t.Hosts {
+			t.Hosts[i] = strings.ToLower(h)
+		}
+		if err := claim("id", t.ID, t.ID); err != nil {
+			return nil, err
+		}
+		if err := claim("client id", t.ClientID, t.ID); err != nil {
+			return nil, err
+		}
+		for _, h := range t.Hosts {
+			if err := claim("host", h, t.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return list, nil
+}
+
+func loadTenantsFile(path string) error {
+	list, err := readTenantsFile(path)
+	if err != nil {
+		return err
+	}
+	calendars := map[string]calendarSource{}
+	for _, t := range list {
+		var cal calendarSource
+		var err error
+		switch {
+		case !atMostOne(t.GoogleCalendar != nil, t.MicrosoftGraph != nil, t.ICSURL != ""):
+			err = fmt.Errorf("google_calendar, microsoft_graph and ics_url are exclusive")
+		case t.ICSURL != "":
+			cal = icsFeed(t.ICSURL)
+		case t.GoogleCalendar != nil:
+			cal, err = newGoogleCalendar(*t.GoogleCalendar)
+		case t.MicrosoftGraph != nil:
+			cal, err = newGraphCalendar(*t.MicrosoftGraph)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", t.ID, err)
+		}
+		calendars[t.ID] = cal
+	}
+	tenants.Store(&list)
+	for id, cal := range calendars {
+		tenantCalendars.Store(id, cal)
+	}
+	for _, t := range list {
+		if t.ZoomAPI != nil {
+			if api := newZoomAPIClient(*t.ZoomAPI); api != nil {
+				tenantZoomAPIs.Store(t.ID, api)
+			}
+		}
+	}
+	log.Printf("Loaded %d tenant(s) from %s", len(list), path)
+	return nil
+}
+
+// tenantByID returns the tenant with id, or nil.
+func tenantByID(id string) *Tenant {
+	p := tenants.Load()
+	if p == nil || id == "" {
+		return nil
+	}
+	for _, t := range *p {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// requestHost returns the lowercased Host of r without its port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// tenantsFor returns the tenants a request may belong to: the one owning its
+// hostname, else the one named by the X-Zoom-Client-Id header or client_id
+// query param, else all of them. Returns nil in single-tenant mode.
+func tenantsFor(r *http.Request) []*Tenant {
+	p := tenants.Load()
+	if p == nil {
+		return nil
+	}
+
+	host := requestHost(r)
+	clientID := r.Header.Get("X-Zoom-Client-Id")
+	if clientID == "" {
+		clientID = r.URL.Query().Get("client_id")
+	}
+	for _, t := range *p {
+		for _, h := range t.Hosts {
+			if h == host {
+				return []*Tenant{t}
+			}
+		}
+	}
+	if clientID != "" {
+		for _, t := range *p {
+			if t.ClientID == clientID {
+				return []*Tenant{t}
+			}
+		}
+	}
+	return *p
+}
+
+// verifyTenantContext verifies appContext against each candidate tenant's
+// secrets and tags the result with the tenant whose secret opened it. With no
+// candidates the global secret set is used.
+func verifyTenantContext(appContext string, candidates []*Tenant) (*ZoomAuthContext, error) {
+	if candidates == nil {
+		return VerifyZoomContext(appContext)
+	}
+	var err error
+	for _, t := range candidates {
+		var zCtx *ZoomAuthContext
+		if zCtx, err = verifyZoomContextWith(appContext, t.Secrets); err == nil {
+			zCtx.Tenant = t.ID
+			return zCtx, nil
+		}
+	}
+	return nil, err
+}
+
+// secretForRequest returns the secret used to encrypt contexts for r: the
+// matching tenant's current secret, or the global one.
+func secretForRequest(r *http.Request) (string, error) {
+	candidates := tenantsFor(r)
+	switch len(candidates) {
+	case 0:
+		return getZoomClientSecret(), nil
+	case 1:
+		return candidates[0].Secrets[0], nil
+	default:
+		return "", fmt.Errorf("tenant is ambiguous; use a tenant hostname or pass client_id")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func useTenants(t *testing.T, list []*Tenant) {
+	t.Helper()
+	tenants.Store(&list)
+	t.Cleanup(func() { tenants.Store(nil) })
+}
+
+func TestTenantRoomsAreIsolated(t *testing.T) {
+	ts := newTestServer(t)
+	useTenants(t, []*Tenant{
+		{ID: "acme", Secrets: []string{"acme_secret"}},
+		{ID: "globex", Secrets: []string{"globex_secret", "globex_old"}},
+	})
+
+	client := func(secret, uid string) *testClient {
+		appContext, err := EncryptZoomContext(secret, []byte(`{"uid":"`+uid+`","mid":"m1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &testClient{ts: ts, appContext: appContext}
+	}
+	acme := client("acme_secret", "u1")
+	globex := client("globex_old", "u1")
+
+	globex.poll()
+	assertGauge(t, "acme", acme.vote(), "100.0%", true)
+	// Same meeting ID and uid, but a different app: its room is untouched
+	assertGauge(t, "globex", globex.poll(), "0.0%", false)
+
+	// The global secret no longer opens anything once tenants are configured
+	appContext, _ := EncryptZoomContext("test_secret", []byte(`{"uid":"u1","mid":"m1"}`))
+	zCtx, err := verifyTenantContext(appContext, *tenants.Load())
+	if err == nil {
+		t.Fatalf("global secret accepted for tenant %q", zCtx.Tenant)
+	}
+}
+
+func TestTenantsFor(t *testing.T) {
+	acme := &Tenant{ID: "acme", ClientID: "cid-acme", Hosts: []string{"acme.example.com"}, Secrets: []string{"a"}}
+	globex := &Tenant{ID: "globex", ClientID: "cid-globex", Secrets: []string{"g"}}
+	useTenants(t, []*Tenant{acme, globex})
+
+	r := httptest.NewRequest("GET", "https://ACME.example.com:8443/api/state", nil)
+	if got := tenantsFor(r); len(got) != 1 || got[0] != acme {
+		t.Errorf("host match: got %v", got)
+	}
+	r = httptest.NewRequest("GET", "/api/state?client_id=cid-globex", nil)
+	if got := tenantsFor(r); len(got) != 1 || got[0] != globex {
+		t.Errorf("client_id match: got %v", got)
+	}
+	r = httptest.NewRequest("GET", "/api/state", nil)
+	if got := tenantsFor(r); len(got) != 2 {
+		t.Errorf("no hint: got %d candidates, want all", len(got))
+	}
+	if _, err := secretForRequest(r); err == nil {
+		t.Error("expected ambiguous tenant error")
+	}
+}
+
+func TestReadTenantsFileValidates(t *testing.T) {
+	cases := map[string]string{
+		"duplicate host": `[{"id":"a","hosts":["x.example"],"secrets":["s"]},{"id":"b","hosts":["X.example"],"secrets":["s"]}]`,
+		"no secrets":     `[{"id":"a"}]`,
+		"bad id":         `[{"id":"a/b","secrets":["s"]}]`,
+		"empty":          `[]`,
+		"bad settings":   `[{"id":"a","secrets":["s"],"settings":{"gauge_stages":[{"percent":30,"text":"x"}]}}]`,
+	}
+	for name, content := range cases {
+		path := filepath.Join(t.TempDir(), "tenants.json")
+		os.WriteFile(path, []byte(content), 0o600)
+		if _, err := readTenantsFile(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
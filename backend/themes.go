@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// Theme changes the panel's look and ending music. The state response wraps
+// its fragments in the theme's class; style.css styles each one.
+type Theme struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Audio string `json:"audio,omitempty"` // ending music, empty for none
+}
+
+// themes are the selectable themes in display order.
+var themes = []*Theme{
+	{ID: "classic", Label: "蛍の光", Audio: "hotaru-piano.mp3"},
+	{ID: "minimal", Label: "ミニマル"},
+	{ID: "dark", Label: "ダーク", Audio: "hotaru-piano.mp3"},
+	{ID: "bonenkai", Label: "忘年会スペシャル", Audio: "hotaru-piano.mp3"},
+}
+
+func themeByID(id string) *Theme {
+	for _, th := range themes {
+		if th.ID == id {
+			return th
+		}
+	}
+	return nil
+}
+
+func validateTheme(id string) error {
+	if id != "" && themeByID(id) == nil {
+		return fmt.Errorf("unknown theme %q", id)
+	}
+	return nil
+}
+
+// wrapTheme wraps the state fragments in the theme container the frontend
+// reads the music from.
+func wrapTheme(lang string, th *Theme, body string) string {
+	return renderFragment(lang, "theme", map[string]any{"Theme": th, "Lang": lang, "Body": template.HTML(body)})
+}
+
+// handleThemes lists the themes a room can choose in its settings.
+func handleThemes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(themes)
+}
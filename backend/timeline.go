@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VoteSample is the default poll's state at one point in time.
+type VoteSample struct {
+	At           time.Time `json:"t"`
+	Participants int       `json:"p"`
+	Votes        int       `json:"v"`
+}
+
+const (
+	// sampleInterval is how often a room's votes are sampled while anyone
+	// polls it
+	sampleInterval = 30 * time.Second
+	// maxSamples bounds the ring buffer, two hours at sampleInterval
+	maxSamples = 240
+)
+
+// roomSampler caches a room's samples in this instance between samples,
+// so polls only touch the store once per sampleInterval.
+type roomSampler struct {
+	mu      sync.Mutex
+	next    time.Time
+	samples []VoteSample
+}
+
+var roomSamplers sync.Map // room ID -> *roomSampler
+
+// sampleVotes records the room's current votes at most once per
+// sampleInterval and returns its samples, oldest first. The store picks
+// one instance per interval, so several instances do not multiply samples.
+func sampleVotes(ctx context.Context, roomID string, participants, votes int) []VoteSample {
+	val, _ := roomSamplers.LoadOrStore(roomID, &roomSampler{})
+	rs := val.(*roomSampler)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := clock.Now()
+	if now.Before(rs.next) {
+		return rs.samples
+	}
+	rs.next = now.Add(sampleInterval)
+	if _, err := AddVoteSample(ctx, roomID, VoteSample{At: now, Participants: participants, Votes: votes}, sampleInterval); err != nil {
+		logf(ctx, "AddVoteSample error: %v", err)
+		return rs.samples
+	}
+	samples, err := VoteSamples(ctx, roomID)
+	if err != nil {
+		logf(ctx, "VoteSamples error: %v", err)
+		return rs.samples
+	}
+	rs.samples = samples
+	return samples
+}
+
+// sweepRoomSamplers drops samplers of rooms nobody polled for a while.
+func sweepRoomSamplers() {
+	cutoff := clock.Now().Add(-10 * sampleInterval)
+	roomSamplers.Range(func(key, val any) bool {
+		rs := val.(*roomSampler)
+		rs.mu.Lock()
+		stale := rs.next.Before(cutoff)
+		rs.mu.Unlock()
+		if stale {
+			roomSamplers.CompareAndDelete(key, rs)
+		}
+		return true
+	})
+}
+
+// sparklinePoints maps samples to SVG polyline points in a 100x20 box, the
+// vote share over time.
+func sparklinePoints(samples []VoteSample) string {
+	if len(samples) < 2 {
+		return ""
+	}
+	start, span := samples[0].At, samples[len(samples)-1].At.Sub(samples[0].At)
+	if span <= 0 {
+		return ""
+	}
+	points := make([]string, len(samples))
+	for i, s := range samples {
+		share := 0.0
+		if s.Participants > 0 {
+			share = min(float64(s.Votes)/float64(s.Participants), 1)
+		}
+		x := float64(s.At.Sub(start)) / float64(span) * 100
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, 20-share*20)
+	}
+	return strings.Join(points, " ")
+}
+
+// generateSparklineHTML renders the vote share over time under the gauge.
+func generateSparklineHTML(lang string, samples []VoteSample) string {
+	points := sparklinePoints(samples)
+	if points == "" {
+		return ""
+	}
+	return renderFragment(lang, "sparkline", map[string]any{"Points": points})
+}
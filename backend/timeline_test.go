@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestSparklinePoints(t *testing.T) {
+	start := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	samples := []VoteSample{
+		{At: start, Participants: 4, Votes: 0},
+		{At: start.Add(time.Minute), Participants: 4, Votes: 1},
+		{At: start.Add(4 * time.Minute), Participants: 0, Votes: 0},
+		{At: start.Add(4 * time.Minute), Participants: 2, Votes: 3},
+	}
+	if got := sparklinePoints(samples); got != "0.0,20.0 25.0,15.0 100.0,20.0 100.0,0.0" {
+		t.Errorf("points = %q", got)
+	}
+	if got := sparklinePoints(samples[:1]); got != "" {
+		t.Errorf("one sample = %q", got)
+	}
+}
+
+func TestVoteSampling(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			var mr *miniredis.Miniredis
+			if store == "redis" {
+				var client *redis.Client
+				mr, client = setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			fc := useFakeClock(t)
+			advance := func(d time.Duration) {
+				fc.Advance(d)
+				if mr != nil {
+					mr.FastForward(d) // expire the sample lock
+				}
+			}
+			ctx := context.Background()
+			room := "sampled-" + store
+
+			sampleVotes(ctx, room, 3, 0)
+			advance(10 * time.Second)
+			if got := sampleVotes(ctx, room, 3, 1); len(got) != 1 {
+				t.Errorf("sampled within the interval: %+v", got)
+			}
+			advance(sampleInterval)
+			got := sampleVotes(ctx, room, 3, 2)
+			if len(got) != 2 || got[0].Votes != 0 || got[1].Votes != 2 {
+				t.Errorf("samples = %+v", got)
+			}
+
+			// Another instance within the interval does not add a sample
+			if ok, err := AddVoteSample(ctx, room, VoteSample{At: clock.Now(), Votes: 9}, sampleInterval); ok || err != nil {
+				t.Errorf("second instance sampled: %v %v", ok, err)
+			}
+
+			for range maxSamples + 5 {
+				advance(sampleInterval)
+				AddVoteSample(ctx, room, VoteSample{At: clock.Now()}, sampleInterval)
+			}
+			if got, _ := VoteSamples(ctx, room); len(got) != maxSamples || !got[0].At.Before(got[1].At) {
+				t.Errorf("ring buffer holds %d samples", len(got))
+			}
+			if html := generateSparklineHTML(defaultLocale, got); !strings.Contains(html, "<polyline") {
+				t.Errorf("sparkline = %s", html)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// validateTLS checks that at most one TLS mode is configured, and completely.
+func (c *Config) validateTLS() error {
+	static := c.TLSCertFile != "" || c.TLSKeyFile != ""
+	if static && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+		return fmt.Errorf("tls: both cert and key files must be set")
+	}
+	if static && c.AutocertHosts != "" {
+		return fmt.Errorf("tls: static certificate and autocert are mutually exclusive")
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// configureTLS prepares server for the configured TLS mode and returns the
+// function that serves on the listener. For autocert it also returns the plain HTTP
+// server answering HTTP-01 challenges (and redirecting everything else to
+// HTTPS), which the caller must run and shut down.
+func configureTLS(cfg *Config, server *http.Server) (serve func(net.Listener) error, challenge *http.Server, err error) {
+	if err := cfg.validateTLS(); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case cfg.AutocertHosts != "":
+		hosts := splitList(cfg.AutocertHosts)
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig = m.TLSConfig()
+		challenge = &http.Server{
+			Addr:    ":" + cfg.HTTPChallengePort,
+			Handler: m.HTTPHandler(nil),
+		}
+		log.Printf("TLS enabled with Let's Encrypt autocert for %v", hosts)
+		return func(ln net.Listener) error { return server.ServeTLS(ln, "", "") }, challenge, nil
+
+	case cfg.TLSCertFile != "":
+		log.Println("TLS enabled with static certificate " + cfg.TLSCertFile)
+		return func(ln net.Listener) error { return server.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile) }, nil, nil
+
+	default:
+		return server.Serve, nil, nil
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UsageQuota caps what a tenant may use, so the service can be sold in
+// plans. Zero fields are unlimited.
+type UsageQuota struct {
+	// ActiveMeetings is how many of the tenant's meetings may have panels
+	// open at once
+	ActiveMeetings int `json:"active_meetings,omitempty"`
+	// Participants is how many panels may be open across them
+	Participants int `json:"participants,omitempty"`
+	// TriggersPerMonth is how many times the tenant's rooms may trigger
+	// in a calendar month (UTC) before new meetings are turned away
+	TriggersPerMonth int `json:"triggers_per_month,omitempty"`
+}
+
+func (q *UsageQuota) validate() error {
+	if q.ActiveMeetings < 0 || q.Participants < 0 || q.TriggersPerMonth < 0 {
+		return fmt.Errorf("quota must not be negative")
+	}
+	return nil
+}
+
+var (
+	// usageWindow is how long a panel counts as connected after its last
+	// poll
+	usageWindow = 2 * time.Minute
+	// usageRecordEvery is how often a connected panel's poll is recorded;
+	// the polls in between are not metered again
+	usageRecordEvery = 30 * time.Second
+
+	meteredPanels sync.Map // room ID + " " + uid -> time.Time recorded
+)
+
+// usageMonth is the metering month of t, YYYY-MM in UTC.
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// tenantQuota returns the tenant's quota, or nil when it is unlimited.
+func tenantQuota(tenant string) *UsageQuota {
+	if t := tenantByID(tenant); t != nil {
+		return t.Quota
+	}
+	return nil
+}
+
+// admitConnection meters the caller's panel and reports whether the
+// tenant's quota lets it connect. Panels already connected are never cut
+// off; a meeting joins only while the tenant is under its meeting and
+// monthly trigger quotas, and a panel only while under its participant
+// quota. Store errors let the panel in.
+func admitConnection(ctx context.Context, zCtx *ZoomAuthContext) bool {
+	now := clock.Now()
+	key := zCtx.RoomID() + " " + zCtx.UID
+	if last, ok := meteredPanels.Load(key); ok && now.Sub(last.(time.Time)) < usageRecordEvery {
+		return true
+	}
+	if q := tenantQuota(zCtx.Tenant); q != nil {
+		cu, err := TenantConnections(ctx, zCtx.Tenant, zCtx.Mid, zCtx.UID, now.Add(-usageWindow))
+		if err != nil {
+			logf(ctx, "TenantConnections error: %v", err)
+			return true
+		}
+		if !cu.Connected && !q.admits(ctx, zCtx.Tenant, cu, now) {
+			metrics.Add("quota_rejections", 1)
+			return false
+		}
+	}
+	if err := MarkConnected(ctx, zCtx.Tenant, zCtx.Mid, zCtx.UID, now, now.Add(-usageWindow)); err != nil {
+		logf(ctx, "MarkConnected error: %v", err)
+	}
+	meteredPanels.Store(key, now)
+	return true
+}
+
+// admits reports whether a panel that is not connected yet fits in q.
+func (q *UsageQuota) admits(ctx context.Context, tenant string, cu ConnectedUsage, now time.Time) bool {
+	if q.Participants > 0 && cu.Participants >= q.Participants {
+		return false
+	}
+	if cu.RoomActive {
+		return true
+	}
+	if q.ActiveMeetings > 0 && cu.Meetings >= q.ActiveMeetings {
+		return false
+	}
+	if q.TriggersPerMonth > 0 {
+		n, err := MonthlyTriggers(ctx, tenant, usageMonth(now))
+		if err != nil {
+			logf(ctx, "MonthlyTriggers error: %v", err)
+			return true
+		}
+		return n < q.TriggersPerMonth
+	}
+	return true
+}
+
+// countTrigger meters a trigger of the tenant's room.
+func countTrigger(ctx context.Context, tenant string, at time.Time) {
+	if err := IncrMonthlyTriggers(ctx, tenant, usageMonth(at)); err != nil {
+		logf(ctx, "IncrMonthlyTriggers error: %v", err)
+	}
+}
+
+// sweepMeteredPanels forgets panels that stopped polling.
+func sweepMeteredPanels() {
+	cutoff := clock.Now().Add(-usageWindow)
+	meteredPanels.Range(func(key, val any) bool {
+		if val.(time.Time).Before(cutoff) {
+			meteredPanels.CompareAndDelete(key, val)
+		}
+		return true
+	})
+}
+
+// TenantUsage is the body of GET /api/usage and an entry of GET
+// /admin/usage.
+type TenantUsage struct {
+	Tenant         string      `json:"tenant,omitempty"`
+	Month          string      `json:"month"`
+	ActiveMeetings int         `json:"active_meetings"`
+	Participants   int         `json:"participants"`
+	Triggers       int         `json:"triggers"`
+	Quota          *UsageQuota `json:"quota,omitempty"`
+}
+
+// tenantUsage reads the tenant's usage now and its triggers in month.
+func tenantUsage(ctx context.Context, tenant, month string) (TenantUsage, error) {
+	u := TenantUsage{Tenant: tenant, Month: month, Quota: tenantQuota(tenant)}
+	cu, err := TenantConnections(ctx, tenant, "", "", clock.Now().Add(-usageWindow))
+	if err != nil {
+		return u, err
+	}
+	u.ActiveMeetings, u.Participants = cu.Meetings, cu.Participants
+	u.Triggers, err = MonthlyTriggers(ctx, tenant, month)
+	return u, err
+}
+
+// usageMonthParam returns the month query parameter, or this month.
+func usageMonthParam(r *http.Request) (string, bool) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		return usageMonth(clock.Now()), true
+	}
+	_, err := time.Parse("2006-01", month)
+	return month, err == nil
+}
+
+// handleUsage serves GET /api/usage to hosts: their organization's usage
+// and quota, with the triggers of ?month=YYYY-MM or this month.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	zCtx, ok := ctx.Value("zoomCtx").(*ZoomAuthContext)
+	if !ok {
+		httpError(w, ctx, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !zCtx.IsHost() {
+		httpError(w, ctx, "Forbidden", http.StatusForbidden)
+		return
+	}
+	month, ok := usageMonthParam(r)
+	if !ok {
+		httpError(w, ctx, "month must be YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	u, err := tenantUsage(ctx, zCtx.Tenant, month)
+	if err != nil {
+		logf(ctx, "usage error: %v", err)
+		httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(u)
+}
+
+// handleAdminUsage serves GET /admin/usage: the usage of every tenant.
+func handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r.Context(), "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	month, ok := usageMonthParam(r)
+	if !ok {
+		httpError(w, ctx, "month must be YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	list := []TenantUsage{}
+	for _, tenant := range scheduledTenants() {
+		u, err := tenantUsage(ctx, tenant, month)
+		if err != nil {
+			logf(ctx, "usage error: %v", err)
+			httpError(w, ctx, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		list = append(list, u)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(list)
+}
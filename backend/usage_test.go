@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestUsageQuota(t *testing.T) {
+	for _, store := range []string{"memory", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			useRedis = false
+			if store == "redis" {
+				var mr *miniredis.Miniredis
+				var client *redis.Client
+				mr, client = setupTestRedis()
+				rdb = client
+				t.Cleanup(func() { mr.Close(); client.Close(); rdb = nil; useRedis = false })
+			}
+			useMemoryStore(t)
+			meteredPanels.Clear()
+			fc := useFakeClock(t)
+			fc.now = time.Date(2026, 10, 30, 12, 0, 0, 0, time.UTC)
+			ctx := context.Background()
+			useTenants(t, []*Tenant{{ID: "plan", Secrets: []string{"s"}, Quota: &UsageQuota{ActiveMeetings: 1, Participants: 2, TriggersPerMonth: 1}}})
+			panel := func(mid, uid string) *ZoomAuthContext {
+				return &ZoomAuthContext{Tenant: "plan", Mid: mid, UID: uid}
+			}
+			admits := func(zCtx *ZoomAuthContext, want bool) {
+				t.Helper()
+				if got := admitConnection(ctx, zCtx); got != want {
+					t.Errorf("%s in %s admitted %t, want %t", zCtx.UID, zCtx.Mid, got, want)
+				}
+			}
+
+			admits(panel("m1", "u1"), true)
+			admits(panel("m1", "u2"), true)
+			admits(panel("m1", "u3"), false) // two participants
+			admits(panel("m2", "u4"), false) // one meeting
+			// Whoever is connected stays so
+			fc.Advance(usageRecordEvery)
+			admits(panel("m1", "u1"), true)
+
+			u, err := tenantUsage(ctx, "plan", usageMonth(fc.now))
+			if err != nil || u.ActiveMeetings != 1 || u.Participants != 2 || u.Triggers != 0 {
+				t.Errorf("usage = %+v, %v", u, err)
+			}
+			if store == "redis" {
+				panels, _ := rdb.ZRange(ctx, usageKey("plan", "panels"), 0, -1).Result()
+				if len(panels) != 2 {
+					t.Errorf("panels = %q", panels)
+				}
+				for _, member := range panels {
+					if member == "m1 u1" || member == "m1 u2" {
+						t.Errorf("raw uid kept in the panels: %q", member)
+					}
+				}
+			}
+
+			// Once the panels are gone, the month's trigger is all there was
+			countTrigger(ctx, "plan", fc.now)
+			fc.Advance(usageWindow + time.Second)
+			admits(panel("m2", "u4"), false)
+			if u, _ := tenantUsage(ctx, "plan", usageMonth(fc.now)); u.ActiveMeetings != 0 || u.Triggers != 1 {
+				t.Errorf("usage after the meeting = %+v", u)
+			}
+			fc.Advance(48 * time.Hour) // November
+			admits(panel("m2", "u4"), true)
+
+			// Tenants without a quota are metered but never turned away
+			for i := range 3 {
+				admits(&ZoomAuthContext{Tenant: "other", Mid: "m", UID: string(rune('a' + i))}, true)
+			}
+			if u, _ := tenantUsage(ctx, "other", usageMonth(fc.now)); u.Participants != 3 || u.Quota != nil {
+				t.Errorf("unlimited usage = %+v", u)
+			}
+			if err := (&UsageQuota{Participants: -1}).validate(); err == nil {
+				t.Error("negative quota accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// voteAckHeader tells the voter what became of their vote, so the panel
+// can keep or roll back the pressed state it showed right away.
+const voteAckHeader = "X-Vote-Ack"
+
+// Vote acknowledgments
+const (
+	ackAccepted      = "accepted"       // a new vote
+	ackDuplicate     = "duplicate"      // the voter had already voted
+	ackRoomTriggered = "room_triggered" // the poll had already passed
+	ackRateLimited   = "rate_limited"   // too many votes, try again later
+)
+
+// Each voter may make voteBurst vote actions per voteWindow in a room,
+// across all instances. Votes are idempotent, but every one is answered
+// with a fresh state, so this keeps button mashing off the store.
+const (
+	voteBurst  = 5
+	voteWindow = time.Minute
+)
+
+// idempotencyKeyHeader carries a key the panel makes up for each vote.
+// The Zoom webview sometimes sends a request again after reconnecting; a
+// vote with a key already seen within voteKeyTTL is not taken again but
+// answered with the first one's ack and the current state.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+const voteKeyTTL = 5 * time.Minute
+
+// idempotencyKey returns the request's idempotency key for the poll, or
+// "" when it has none or one too odd to keep.
+func idempotencyKey(r *http.Request, p *Poll) string {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" || len(key) > 64 || strings.ContainsFunc(key, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_')
+	}) {
+		return ""
+	}
+	return p.ID + ":" + key
+}
+
+// allowVote counts a vote action and reports whether it is within the
+// limit, and if not, how long until it would be. If the count cannot be
+// read the vote goes through.
+func allowVote(ctx context.Context, roomID, uid string) (bool, time.Duration) {
+	n, left, err := CountAction(ctx, roomID, "vote", uid, voteWindow)
+	if err != nil {
+		logf(ctx, "CountAction error: %v", err)
+		return true, 0
+	}
+	return n <= voteBurst, left
+}
+
+// retryAfter formats d as whole seconds for a Retry-After header.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestVoteAck(t *testing.T) {
+	ts := newTestServer(t)
+	useFakeClock(t)
+
+	vote := func(pid string) *http.Response {
+		q := url.Values{"roomId": {"ack-room"}, "pid": {pid}}
+		resp, err := ts.Client().Post(ts.URL+"/api/vote?"+q.Encode(), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	ack := func(pid string) string { return vote(pid).Header.Get(voteAckHeader) }
+
+	ts.queryClient("ack-room", "a").poll()
+	ts.queryClient("ack-room", "b").poll()
+	ts.queryClient("ack-room", "c").poll()
+
+	if got := ack("a"); got != ackAccepted {
+		t.Errorf("first vote: %q", got)
+	}
+	if got := ack("a"); got != ackDuplicate {
+		t.Errorf("second vote: %q", got)
+	}
+	ack("b")
+	if got := ack("c"); got != ackRoomTriggered && got != ackAccepted {
+		t.Errorf("deciding vote: %q", got)
+	}
+	if got := ack("c"); got != ackRoomTriggered {
+		t.Errorf("vote after the room triggered: %q", got)
+	}
+
+	// Button mashing is turned away until the window is over
+	for range voteBurst - 2 {
+		vote("a")
+	}
+	resp := vote("a")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get(voteAckHeader) != ackRateLimited || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("over the limit: %d, ack %q, Retry-After %q", resp.StatusCode, resp.Header.Get(voteAckHeader), resp.Header.Get("Retry-After"))
+	}
+	ts.mr.FastForward(voteWindow)
+	if resp := vote("a"); resp.StatusCode != http.StatusOK {
+		t.Errorf("next window: %d", resp.StatusCode)
+	}
+}
+
+func TestMemActions(t *testing.T) {
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		if n, left, _ := CountAction(ctx, "mem-actions", "vote", "u1", voteWindow); n != i || left != voteWindow {
+			t.Errorf("action %d: n=%d, left=%s", i, n, left)
+		}
+	}
+	fc.Advance(20 * time.Second)
+	if n, left, _ := CountAction(ctx, "mem-actions", "vote", "u1", voteWindow); n != 4 || left != 40*time.Second {
+		t.Errorf("later action: n=%d, left=%s", n, left)
+	}
+	if n, _, _ := CountAction(ctx, "mem-actions", "vote", "u2", voteWindow); n != 1 {
+		t.Errorf("other voter: n=%d", n)
+	}
+	fc.Advance(voteWindow)
+	if n, _, _ := CountAction(ctx, "mem-actions", "vote", "u1", voteWindow); n != 1 {
+		t.Errorf("next window: n=%d", n)
+	}
+	fc.Advance(voteWindow)
+	local.sweep(ctx)
+	if ids := memDocsStored(docAction, "mem-actions "); len(ids) != 0 {
+		t.Errorf("windows %q not swept", ids)
+	}
+}
+
+func TestVoteIdempotencyKey(t *testing.T) {
+	ts := newTestServer(t)
+	useFakeClock(t)
+	ctx := context.Background()
+
+	vote := func(pid, key string) *http.Response {
+		t.Helper()
+		q := url.Values{"roomId": {"key-room"}, "pid": {pid}}
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/vote?"+q.Encode(), nil)
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	for _, pid := range []string{"a", "b", "c"} {
+		ts.queryClient("key-room", pid).poll()
+	}
+
+	if got := vote("a", "k1").Header.Get(voteAckHeader); got != ackAccepted {
+		t.Fatalf("first vote: %q", got)
+	}
+	if _, votes, _, _ := CheckTriggerStatus(ctx, "key-room"); votes != 1 {
+		t.Fatalf("%d votes after the first", votes)
+	}
+	// A replay after the host reset the room does not vote again, and
+	// replays do not count against the rate limit
+	ResetRoom(ctx, "key-room")
+	for range voteBurst + 1 {
+		if resp := vote("a", "k1"); resp.StatusCode != http.StatusOK || resp.Header.Get(voteAckHeader) != ackAccepted {
+			t.Fatalf("replay: %d, ack %q", resp.StatusCode, resp.Header.Get(voteAckHeader))
+		}
+	}
+	if _, votes, _, _ := CheckTriggerStatus(ctx, "key-room"); votes != 0 {
+		t.Errorf("replay voted again, %d votes", votes)
+	}
+
+	// A new key is a new vote; keys that are not tokens are ignored
+	if got := vote("a", "k2").Header.Get(voteAckHeader); got != ackAccepted {
+		t.Errorf("vote with a new key: %q", got)
+	}
+	if got := vote("a", "not a token!").Header.Get(voteAckHeader); got != ackDuplicate {
+		t.Errorf("vote with a bad key: %q", got)
+	}
+	// Keys are the voter's own
+	if got := vote("b", "k2").Header.Get(voteAckHeader); got != ackAccepted {
+		t.Errorf("other voter with the same key: %q", got)
+	}
+}
+
+func TestMemVoteKeys(t *testing.T) {
+	useRedis = false
+	fc := useFakeClock(t)
+	ctx := context.Background()
+
+	if _, first, _ := ClaimVoteKey(ctx, "mem-keys", "u1", "k", voteKeyTTL); !first {
+		t.Fatal("new key not claimed")
+	}
+	if ack, first, _ := ClaimVoteKey(ctx, "mem-keys", "u1", "k", voteKeyTTL); first || ack != "" {
+		t.Errorf("key in flight: ack %q, first %t", ack, first)
+	}
+	SettleVoteKey(ctx, "mem-keys", "u1", "k", ackAccepted)
+	if ack, first, _ := ClaimVoteKey(ctx, "mem-keys", "u1", "k", voteKeyTTL); first || ack != ackAccepted {
+		t.Errorf("settled key: ack %q, first %t", ack, first)
+	}
+	// A vote that failed can be tried again with its key
+	ClaimVoteKey(ctx, "mem-keys", "u2", "k", voteKeyTTL)
+	SettleVoteKey(ctx, "mem-keys", "u2", "k", "")
+	if _, first, _ := ClaimVoteKey(ctx, "mem-keys", "u2", "k", voteKeyTTL); !first {
+		t.Error("released key not claimed again")
+	}
+
+	fc.Advance(voteKeyTTL)
+	if _, first, _ := ClaimVoteKey(ctx, "mem-keys", "u1", "k", voteKeyTTL); !first {
+		t.Error("expired key not claimed again")
+	}
+	fc.Advance(voteKeyTTL)
+	local.sweep(ctx)
+	if ids := memDocsStored(docVoteKey, "mem-keys "); len(ids) != 0 {
+		t.Errorf("keys %q not swept", ids)
+	}
+}
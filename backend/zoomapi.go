@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// zoomAPICredentials are the Server-to-Server OAuth app credentials used for
+// Zoom REST API calls. This is a separate Marketplace app from the Zoom App
+// whose client secret decrypts contexts.
+type zoomAPICredentials struct {
+	AccountID    string `json:"account_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+var (
+	zoomAPIBaseURL  = "https://api.zoom.us/v2"
+	zoomOAuthURL    = "https://zoom.us/oauth/token"
+	zoomAPIHTTP     = &http.Client{Timeout: 5 * time.Second}
+	defaultZoomAPI  atomic.Pointer[zoomAPIClient]
+	tenantZoomAPIs  sync.Map // tenant ID -> *zoomAPIClient
+	meetingInfos    sync.Map // room ID -> *meetingInfoEntry
+	meetingInfoTTL  = 10 * time.Minute
+	meetingRetryTTL = time.Minute
+)
+
+// zoomAPIClient calls the Zoom REST API with an account-credentials token,
+// cached until shortly before it expires.
+type zoomAPIClient struct {
+	creds zoomAPICredentials
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newZoomAPIClient(creds zoomAPICredentials) *zoomAPIClient {
+	if creds.AccountID == "" || creds.ClientID == "" || creds.ClientSecret == "" {
+		return nil
+	}
+	return &zoomAPIClient{creds: creds}
+}
+
+// zoomAPIFor returns the API client for a tenant, or the deployment-wide one.
+// Nil means no API credentials are configured.
+func zoomAPIFor(tenant string) *zoomAPIClient {
+	if tenant != "" {
+		if c, ok := tenantZoomAPIs.Load(tenant); ok {
+			return c.(*zoomAPIClient)
+		}
+		return nil
+	}
+	return defaultZoomAPI.Load()
+}
+
+func (c *zoomAPIClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && clock.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	q := url.Values{"grant_type": {"account_credentials"}, "account_id": {c.creds.AccountID}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, zoomOAuthURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.creds.ClientID, c.creds.ClientSecret)
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doZoomRequest(req, &resp); err != nil {
+		return "", fmt.Errorf("zoom oauth: %w", err)
+	}
+	c.token = resp.AccessToken
+	c.tokenExpiry = clock.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+func (c *zoomAPIClient) get(ctx context.Context, path string, out any) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, zoomAPIBaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doZoomRequest(req, out)
+}
+
+// put sends a JSON body; Zoom answers 204 No Content on success.
+func (c *zoomAPIClient) put(ctx context.Context, path string, body any) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, zoomAPIBaseURL+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := zoomAPIHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return nil
+}
+
+func doZoomRequest(req *http.Request, out any) error {
+	resp, err := zoomAPIHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// escapeMeetingID encodes a meeting ID or UUID for a Zoom API path. UUIDs
+// that start with "/" or contain "//" must be encoded twice.
+func escapeMeetingID(mid string) string {
+	escaped := url.PathEscape(mid)
+	if strings.HasPrefix(mid, "/") || strings.Contains(mid, "//") {
+		escaped = url.PathEscape(escaped)
+	}
+	return escaped
+}
+
+// MeetingInfo is the scheduling data shown in the panel, and the host the
+// meeting report is sent to.
+type MeetingInfo struct {
+	Topic     string
+	Duration  time.Duration // scheduled length, 0 if unscheduled
+	StartTime time.Time     // zero if unknown
+	HostID    string
+	HostEmail string
+
+	// SeriesID is the meeting number shared by all occurrences of a
+	// recurring meeting, empty for other meetings
+	SeriesID string
+
+	// Number is the meeting number people join with
+	Number string
+
+	// NextMeeting is when the host's next calendar event starts, zero if
+	// unknown; see applyCalendar
+	NextMeeting time.Time
+	// BackToBack is set when the next event starts right as this one
+	// ends, or before
+	BackToBack bool
+}
+
+// Meeting fetches the topic and schedule of a meeting.
+func (c *zoomAPIClient) Meeting(ctx context.Context, mid string) (*MeetingInfo, error) {
+	var resp struct {
+		ID        int64  `json:"id"`
+		Type      int    `json:"type"` // 3 and 8 are recurring
+		Topic     string `json:"topic"`
+		Duration  int    `json:"duration"` // minutes
+		StartTime string `json:"start_time"`
+		HostID    string `json:"host_id"`
+		HostEmail string `json:"host_email"`
+	}
+	if err := c.get(ctx, "/meetings/"+escapeMeetingID(mid), &resp); err != nil {
+		return nil, err
+	}
+	info := &MeetingInfo{
+		Topic:     resp.Topic,
+		Duration:  time.Duration(resp.Duration) * time.Minute,
+		HostID:    resp.HostID,
+		HostEmail: resp.HostEmail,
+	}
+	if resp.ID != 0 {
+		info.Number = strconv.FormatInt(resp.ID, 10)
+	}
+	if resp.Type == 3 || resp.Type == 8 {
+		info.SeriesID = strconv.FormatInt(resp.ID, 10)
+	}
+	if t, err := time.Parse(time.RFC3339, resp.StartTime); err == nil {
+		info.StartTime = t
+	}
+	return info, nil
+}
+
+type meetingInfoEntry struct {
+	mu      sync.Mutex
+	info    *MeetingInfo
+	expires time.Time
+}
+
+// meetingInfo returns the cached scheduling data for the caller's meeting,
+// fetching it at most every meetingInfoTTL, with the schedule from the
+// host's calendar when one is connected. Returns nil when the API is not
+// configured or the meeting cannot be read; failures are retried after
+// meetingRetryTTL so a poll never waits on a broken API for long.
+func meetingInfo(ctx context.Context, zCtx *ZoomAuthContext) *MeetingInfo {
+	api := zoomAPIFor(zCtx.Tenant)
+	if api == nil {
+		return nil
+	}
+
+	val, _ := meetingInfos.LoadOrStore(zCtx.RoomID(), &meetingInfoEntry{})
+	e := val.(*meetingInfoEntry)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := clock.Now()
+	if now.Before(e.expires) {
+		return e.info
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	info, err := api.Meeting(fetchCtx, zCtx.Mid)
+	if err != nil {
+		logf(ctx, "Zoom meeting lookup failed: %v", err)
+		e.expires = now.Add(meetingRetryTTL)
+		return e.info // keep showing the last good value
+	}
+	if cal := calendarFor(zCtx.Tenant); cal != nil {
+		calCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		if err := applyCalendar(calCtx, cal, info, now); err != nil {
+			logf(ctx, "Calendar lookup failed: %v", err) // Zoom's schedule will do
+		}
+	}
+	e.info, e.expires = info, now.Add(meetingInfoTTL)
+	return info
+}
+
+// sweepMeetingInfos drops cache entries that have not been refreshed for a
+// while, i.e. meetings nobody is polling any more.
+func sweepMeetingInfos() {
+	cutoff := clock.Now().Add(-meetingInfoTTL)
+	meetingInfos.Range(func(key, val any) bool {
+		e := val.(*meetingInfoEntry)
+		e.mu.Lock()
+		stale := e.expires.Before(cutoff)
+		e.mu.Unlock()
+		if stale {
+			meetingInfos.CompareAndDelete(key, e)
+		}
+		return true
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeetingInfoFetchedAndCached(t *testing.T) {
+	z := newZoomFake(t)
+	fc := useFakeClock(t)
+	fc.now = time.Date(2026, 10, 16, 10, 48, 0, 0, time.UTC)
+	z.addMeeting("mid-info", fakeMeeting{Topic: "定例MTG", Start: time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC), Duration: time.Hour})
+	lookups := func() int { z.mu.Lock(); defer z.mu.Unlock(); return z.lookups }
+
+	zCtx := &ZoomAuthContext{UID: "u1", Mid: "mid-info"}
+	for range 3 {
+		info := meetingInfo(context.Background(), zCtx)
+		if info == nil {
+			t.Fatal("expected meeting info")
+		}
+		got := generateMeetingInfoHTML(defaultLocale, info, clock.Now())
+		if !strings.Contains(got, "定例MTG — 予定 60分 / 経過 48分") {
+			t.Fatalf("unexpected meeting line: %s", got)
+		}
+	}
+	if n := lookups(); n != 1 {
+		t.Errorf("meeting endpoint called %d times, want 1 (cached)", n)
+	}
+
+	fc.Advance(meetingInfoTTL + 5*time.Minute)
+	info := meetingInfo(context.Background(), zCtx)
+	if n := lookups(); n != 2 {
+		t.Errorf("meeting endpoint called %d times after TTL, want 2", n)
+	}
+	if got := generateMeetingInfoHTML(defaultLocale, info, clock.Now()); !strings.Contains(got, "経過 63分（3分超過）") {
+		t.Errorf("expected overtime, got: %s", got)
+	}
+}
+
+func TestEscapeMeetingID(t *testing.T) {
+	cases := map[string]string{
+		"85746065432":              "85746065432",
+		"4444AAAiAAAAAiAiAiiAii==": "4444AAAiAAAAAiAiAiiAii==",
+		"/ajXp112QmuoKj4854875==":  "%252FajXp112QmuoKj4854875==",
+		"ab//cd==":                 "ab%252F%252Fcd==",
+	}
+	for in, want := range cases {
+		if got := escapeMeetingID(in); got != want {
+			t.Errorf("escapeMeetingID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestZoomTriggerActions runs a meeting through the fake Zoom: panels
+// authenticated by signed app contexts, the meeting info, and the
+// end_meeting and chat actions once the room triggers.
+func TestZoomTriggerActions(t *testing.T) {
+	ts := newTestServer(t)
+	z := newZoomFake(t)
+	fc := useFakeClock(t)
+	old := actionSettings
+	t.Cleanup(func() { actionSettings = old })
+	actionSettings.chatURL = z.chatURL()
+	z.addMeeting("zoom-mtg", fakeMeeting{Topic: "週次定例", Start: clock.Now(), Duration: time.Hour})
+
+	host := z.client(ts, "zoom-mtg", "h1", "host")
+	guest := z.client(ts, "zoom-mtg", "g1", "attendee")
+	if status, body := host.post("/api/actions", url.Values{"actions": {"ending,end_meeting,chat"}}); status != http.StatusOK {
+		t.Fatalf("set actions: %d %s", status, body)
+	}
+	if body := host.poll(); !strings.Contains(body, "週次定例") {
+		t.Errorf("no meeting info:\n%s", body)
+	}
+	assertGauge(t, "guest", guest.vote(), "100.0%", true)
+
+	z.wait("the meeting to end", func() bool { return slices.Contains(z.ended, "zoom-mtg") })
+	z.wait("the chat message", func() bool { return len(z.chat) == 1 && strings.Contains(z.chat[0], "2 人中 1 人") })
+	if n := z.issued(); n != 1 {
+		t.Errorf("%d tokens issued, want the first one reused", n)
+	}
+
+	// Once it expires, the next call fetches a new token
+	fc.Advance(2 * time.Hour)
+	z.addMeeting("zoom-mtg-2", fakeMeeting{Topic: "振り返り"})
+	if body := z.client(ts, "zoom-mtg-2", "h1", "host").poll(); !strings.Contains(body, "振り返り") {
+		t.Errorf("no meeting info after the token expired:\n%s", body)
+	}
+	if n := z.issued(); n != 2 {
+		t.Errorf("%d tokens issued after expiry, want 2", n)
+	}
+
+	// With the credentials refused the meeting stays on, and the chat
+	// message is sent all the same
+	z.mu.Lock()
+	z.refuse = true
+	z.mu.Unlock()
+	fc.Advance(2 * time.Hour)
+	host = z.client(ts, "zoom-mtg-2", "h1", "host")
+	host.post("/api/actions", url.Values{"actions": {"end_meeting,chat"}})
+	host.poll()
+	z.client(ts, "zoom-mtg-2", "g1", "attendee").vote()
+	z.wait("the second chat message", func() bool { return len(z.chat) == 2 })
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if slices.Contains(z.ended, "zoom-mtg-2") {
+		t.Error("ended a meeting without a token")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The Server-to-Server OAuth app the fake accepts.
+const (
+	fakeZoomAccount      = "acct"
+	fakeZoomClientID     = "cid"
+	fakeZoomClientSecret = "csecret"
+)
+
+// fakeMeeting is a meeting the fake Zoom API knows.
+type fakeMeeting struct {
+	Topic     string
+	Type      int // 2 scheduled, 8 recurring with a fixed time
+	Number    int64
+	Start     time.Time
+	Duration  time.Duration
+	HostEmail string
+}
+
+// zoomFake stands in for the parts of Zoom the server talks to: the
+// account credentials token endpoint, the meeting endpoints, a Team Chat
+// incoming webhook, and the Zoom client, which signs the app context the
+// panel sends with the Zoom App's client secret.
+type zoomFake struct {
+	*httptest.Server
+	t *testing.T
+
+	// secret is the Zoom App's client secret, the one the server was
+	// started with
+	secret string
+
+	mu       sync.Mutex
+	meetings map[string]fakeMeeting
+	token    string // the token that is valid now
+	tokens   int    // tokens issued
+	lookups  int    // meeting lookups answered
+	ended    []string
+	chat     []string
+	// refuse makes the token endpoint reject the credentials
+	refuse bool
+}
+
+// newZoomFake starts the fake and points the Zoom API client at it, with
+// the app credentials it accepts.
+func newZoomFake(t *testing.T) *zoomFake {
+	t.Helper()
+	z := &zoomFake{t: t, secret: "test_secret", meetings: map[string]fakeMeeting{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /oauth/token", z.handleToken)
+	mux.HandleFunc("GET /v2/meetings/{id}", z.authorized(z.handleMeeting))
+	mux.HandleFunc("PUT /v2/meetings/{id}/status", z.authorized(z.handleMeetingStatus))
+	mux.HandleFunc("POST /chat/webhooks/incoming/{hook}", z.handleIncomingWebhook)
+	z.Server = httptest.NewServer(mux)
+	t.Cleanup(z.Close)
+
+	oldBase, oldOAuth := zoomAPIBaseURL, zoomOAuthURL
+	zoomAPIBaseURL, zoomOAuthURL = z.URL+"/v2", z.URL+"/oauth/token"
+	defaultZoomAPI.Store(newZoomAPIClient(zoomAPICredentials{AccountID: fakeZoomAccount, ClientID: fakeZoomClientID, ClientSecret: fakeZoomClientSecret}))
+	t.Cleanup(func() {
+		zoomAPIBaseURL, zoomOAuthURL = oldBase, oldOAuth
+		defaultZoomAPI.Store(nil)
+		meetingInfos.Clear()
+	})
+	return z
+}
+
+// addMeeting makes the meeting known to the API.
+func (z *zoomFake) addMeeting(mid string, m fakeMeeting) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.meetings[mid] = m
+}
+
+// client is a panel of the Zoom App in the meeting, authenticated by the
+// app context the Zoom client hands it.
+func (z *zoomFake) client(ts *testServer, mid, uid, role string) *testClient {
+	z.t.Helper()
+	payload, _ := json.Marshal(map[string]any{"uid": uid, "mid": mid, "role": role, "ts": clock.Now().UnixMilli()})
+	appContext, err := EncryptZoomContext(z.secret, payload)
+	if err != nil {
+		z.t.Fatal(err)
+	}
+	// Query params deliberately point elsewhere; the verified context must win
+	return &testClient{ts: ts, room: "wrong-room", pid: "wrong-pid", appContext: appContext}
+}
+
+// chatURL is the address of a Team Chat incoming webhook.
+func (z *zoomFake) chatURL() string {
+	return z.URL + "/chat/webhooks/incoming/hook"
+}
+
+// issued returns how many tokens the fake issued.
+func (z *zoomFake) issued() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.tokens
+}
+
+// wait fails the test unless cond holds within five seconds. cond runs
+// with the fake locked.
+func (z *zoomFake) wait(what string, cond func() bool) {
+	z.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		z.mu.Lock()
+		ok := cond()
+		z.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			z.t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// zoomError answers like the Zoom API does.
+func zoomError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"code": code, "message": msg})
+}
+
+func (z *zoomFake) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	q := r.URL.Query()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	switch {
+	case q.Get("grant_type") != "account_credentials":
+		zoomError(w, http.StatusBadRequest, 4001, "unsupported grant type")
+	case z.refuse || id != fakeZoomClientID || secret != fakeZoomClientSecret || q.Get("account_id") != fakeZoomAccount:
+		zoomError(w, http.StatusUnauthorized, 4711, "invalid client")
+	default:
+		z.tokens++
+		z.token = fmt.Sprintf("tok-%d", z.tokens)
+		json.NewEncoder(w).Encode(map[string]any{"access_token": z.token, "token_type": "bearer", "expires_in": 3600})
+	}
+}
+
+// authorized lets requests with the current token through to h, with the
+// fake locked.
+func (z *zoomFake) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		z.mu.Lock()
+		defer z.mu.Unlock()
+		if z.token == "" || r.Header.Get("Authorization") != "Bearer "+z.token {
+			zoomError(w, http.StatusUnauthorized, 124, "Invalid access token.")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (z *zoomFake) handleMeeting(w http.ResponseWriter, r *http.Request) {
+	m, ok := z.meetings[r.PathValue("id")]
+	if !ok {
+		zoomError(w, http.StatusNotFound, 3001, "Meeting does not exist.")
+		return
+	}
+	z.lookups++
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":         m.Number,
+		"type":       cmp.Or(m.Type, 2),
+		"topic":      m.Topic,
+		"duration":   int(m.Duration.Minutes()),
+		"start_time": m.Start.Format(time.RFC3339),
+		"host_email": m.HostEmail,
+	})
+}
+
+func (z *zoomFake) handleMeetingStatus(w http.ResponseWriter, r *http.Request) {
+	mid := r.PathValue("id")
+	var body struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Action != "end" {
+		zoomError(w, http.StatusBadRequest, 300, "Invalid action.")
+		return
+	}
+	if _, ok := z.meetings[mid]; !ok || slices.Contains(z.ended, mid) {
+		zoomError(w, http.StatusNotFound, 3001, "Meeting does not exist.")
+		return
+	}
+	z.ended = append(z.ended, mid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (z *zoomFake) handleIncomingWebhook(w http.ResponseWriter, r *http.Request) {
+	var msg struct {
+		Text string `json:"text"`
+	}
+	if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&msg) != nil || msg.Text == "" {
+		zoomError(w, http.StatusBadRequest, 300, "Invalid message.")
+		return
+	}
+	z.mu.Lock()
+	z.chat = append(z.chat, msg.Text)
+	z.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,6 +2,7 @@ document.addEventListener("DOMContentLoaded", async () => {
     let roomId = "default";
     let pid = "local-" + Math.floor(Math.random() * 10000);
     let zoomContextStr = "";
+    let screenName = "";
 
     // Fetch authentication context generated by Go backend in HTML
     const metaCtx = document.querySelector('meta[name="zoom-app-context"]');
@@ -10,16 +11,19 @@ document.addEventListener("DOMContentLoaded", async () => {
     }
 
     const btn = document.getElementById("vote-btn");
-    const statusText = document.querySelector(".status-text");
 
     try {
         // Zoom Apps SDK Init (Safe capabilities only)
         if (typeof zoomSdk !== 'undefined') {
             const configResponse = await zoomSdk.config({
                 popoutSize: { width: 480, height: 360 },
-                capabilities: ['getMeetingContext']
+                capabilities: ['getMeetingContext', 'getUserContext']
             });
             console.log("Zoom SDK Configured:", configResponse);
+
+            // Display name, only sent if the user opts in below
+            const userContext = await zoomSdk.getUserContext();
+            screenName = userContext.screenName || "";
         } else {
             console.warn("Warning: Not running in Zoom Client");
         }
@@ -38,20 +42,163 @@ document.addEventListener("DOMContentLoaded", async () => {
     const urlParams = new URLSearchParams(window.location.search);
     roomId = urlParams.get('roomId') || "test-room";
     pid = urlParams.get('pid') || pid;
+    screenName = screenName || urlParams.get('name') || "";
 
-    let pollingUrl = `${protocol}//${host}/api/state?roomId=${encodeURIComponent(roomId)}&pid=${encodeURIComponent(pid)}`;
-    let voteUrl = `${protocol}//${host}/api/vote?roomId=${encodeURIComponent(roomId)}&pid=${encodeURIComponent(pid)}`;
+    const shareName = document.getElementById("share-name");
+    if (screenName) {
+        document.getElementById("share-name-label").hidden = false;
+    }
 
+    // The Zoom client's UI language picks the panel language
+    const authParams = new URLSearchParams({ roomId, pid, lang: urlParams.get('lang') || navigator.language });
+    // Accessibility preferences: the server leaves out animated fragments
+    // and the ending music for panels that ask for it
+    if (window.matchMedia("(prefers-reduced-motion: reduce)").matches) {
+        authParams.set('motion', 'reduce');
+    }
+    if (urlParams.get('audio') === 'off') {
+        authParams.set('audio', 'off');
+    }
+    if (urlParams.get('role')) {
+        authParams.set('role', urlParams.get('role'));
+    }
     if (zoomContextStr) {
-        pollingUrl += `&zoom_context=${encodeURIComponent(zoomContextStr)}`;
-        voteUrl += `&zoom_context=${encodeURIComponent(zoomContextStr)}`;
+        authParams.set('zoom_context', zoomContextStr);
+    }
+    const authQuery = authParams.toString();
+
+    let pollingUrl = `${protocol}//${host}/api/state?${authQuery}`;
+    let voteUrl = `${protocol}//${host}/api/vote?${authQuery}`;
+
+    // The server hands out a resume token with the first verified request;
+    // sending it back lets a reloaded or reconnecting panel skip the Zoom
+    // context check for a while. Kept per tab, and only for this context.
+    const resumeKey = "hotaru-resume:" + zoomContextStr.slice(-32);
+    let resumeToken = sessionStorage.getItem(resumeKey) || "";
+    let shownETag = "";
+    let voteKey = "";
+    document.body.addEventListener("htmx:afterRequest", (evt) => {
+        const token = evt.detail.xhr && evt.detail.xhr.getResponseHeader("X-Resume-Token");
+        if (token) {
+            resumeToken = token;
+            sessionStorage.setItem(resumeKey, token);
+        }
+    });
+
+    // When the user last touched the panel. The server warns panels idle
+    // for too long and then drops them from the participants, so one left
+    // open after its user walked away stops counting.
+    let lastInput = Date.now();
+    for (const type of ["pointerdown", "pointermove", "keydown", "wheel"]) {
+        document.addEventListener(type, () => { lastInput = Date.now(); }, { passive: true });
     }
 
+    // Server-rendered controls (e.g. the host panel) use bare /api/ paths;
+    // give them the same identity as the polling requests
+    document.body.addEventListener("htmx:configRequest", (evt) => {
+        if (evt.detail.path.startsWith("/api/") && !evt.detail.path.includes("?")) {
+            evt.detail.path += "?" + authQuery;
+        }
+        evt.detail.headers["X-Idle-Seconds"] = String(Math.floor((Date.now() - lastInput) / 1000));
+        if (resumeToken && zoomContextStr) {
+            evt.detail.headers["X-Resume-Token"] = resumeToken;
+        }
+        // The newest room event this panel has seen, so the server can
+        // replay what it missed while away
+        const events = document.getElementById("room-events");
+        if (events && events.dataset.lastEventId) {
+            evt.detail.headers["Last-Event-ID"] = events.dataset.lastEventId;
+        }
+        // Large rooms answer a poll for the state already shown with 204
+        if (evt.detail.verb === "get" && evt.detail.path.includes("/api/state") && shownETag) {
+            evt.detail.headers["If-None-Match"] = shownETag;
+        }
+        if (evt.detail.path.includes("/api/vote") && shareName.checked && screenName) {
+            evt.detail.parameters["display_name"] = screenName;
+        }
+        // One key per vote until the server answers it, so a vote the
+        // webview sends again after reconnecting is only taken once
+        if (evt.detail.verb === "post" && evt.detail.path.includes("/api/vote")) {
+            voteKey = voteKey || crypto.randomUUID();
+            evt.detail.headers["Idempotency-Key"] = voteKey;
+        }
+    });
+    document.body.addEventListener("htmx:afterRequest", (evt) => {
+        if (evt.detail.xhr && evt.detail.xhr.status && evt.detail.pathInfo.requestPath.includes("/api/vote")) {
+            voteKey = "";
+        }
+    });
+
+    // Show the vote as cast right away, then keep or roll that back once
+    // the server says what became of it
+    const keptAcks = ["accepted", "duplicate", "room_triggered"];
+    btn.addEventListener("htmx:beforeRequest", () => {
+        btn.classList.add("voted");
+        btn.setAttribute("aria-pressed", "true");
+    });
+    btn.addEventListener("htmx:afterRequest", (evt) => {
+        const ack = evt.detail.xhr && evt.detail.xhr.getResponseHeader("X-Vote-Ack");
+        if (!keptAcks.includes(ack)) {
+            btn.classList.remove("voted");
+            btn.setAttribute("aria-pressed", "false");
+        }
+    });
+
+    // Failed requests come back with an error frame saying why; show it
+    // instead of dropping the response. Frames that may clear on a retry
+    // go once a request succeeds, the others after a while.
+    const errorFrame = document.getElementById("error-frame");
+    document.body.addEventListener("htmx:beforeSwap", (evt) => {
+        if (evt.detail.isError && evt.detail.xhr.getResponseHeader("X-Error-Code")) {
+            evt.detail.shouldSwap = true;
+            evt.detail.isError = false;
+            evt.detail.target = errorFrame;
+        }
+    });
+    document.body.addEventListener("htmx:afterSwap", () => {
+        const frame = errorFrame.querySelector(".error-frame");
+        if (frame && !frame.dataset.shown && frame.dataset.retryable !== "true") {
+            frame.dataset.shown = "true";
+            setTimeout(() => frame.remove(), 8000);
+        }
+    });
+    document.body.addEventListener("htmx:afterRequest", (evt) => {
+        const frame = errorFrame.querySelector(".error-frame");
+        if (evt.detail.successful && !evt.detail.xhr.getResponseHeader("X-Error-Code") &&
+            frame && frame.dataset.retryable === "true") {
+            errorFrame.innerHTML = "";
+        }
+    });
+
+    // Tell the server the panel is closing. It keeps counting us for a
+    // short while, so a reload or reconnect does not move the gauge.
+    window.addEventListener("pagehide", () => {
+        navigator.sendBeacon(`${protocol}//${host}/api/leave?${authQuery}`);
+    });
+
     // Configure HTMX Polling on the gauge container wrapper
     pollingWrapper.setAttribute("hx-get", pollingUrl);
-    pollingWrapper.setAttribute("hx-trigger", "every 2s");
+    pollingWrapper.setAttribute("hx-trigger", "load, every 2s");
     pollingWrapper.setAttribute("hx-swap", "innerHTML");
 
+    // The server picks how often to poll by the size of the room, and
+    // tags the state of large rooms so unchanged polls can be skipped
+    let pollSeconds = "2";
+    document.body.addEventListener("htmx:afterRequest", (evt) => {
+        const xhr = evt.detail.xhr;
+        if (!xhr) return;
+        // Whatever else was swapped in is not the tagged state
+        if (xhr.status !== 204) {
+            shownETag = (evt.detail.successful && xhr.getResponseHeader("ETag")) || "";
+        }
+        const seconds = xhr.getResponseHeader("X-Poll-Interval");
+        if (seconds && seconds !== pollSeconds) {
+            pollSeconds = seconds;
+            pollingWrapper.setAttribute("hx-trigger", `every ${seconds}s`);
+            htmx.process(pollingWrapper);
+        }
+    });
+
     // Configure HTMX POST on the vote button (Immediate UI Update)
     btn.setAttribute("hx-post", voteUrl);
     btn.setAttribute("hx-target", "#polling-wrapper");
@@ -60,14 +207,37 @@ document.addEventListener("DOMContentLoaded", async () => {
     // HTMX Initialization Request - Make HTMX parse our new polling attributes
     htmx.process(appContainer);
 
-    // Initial UI Setup
+    // Initial UI Setup; the status text comes from the first poll
     btn.removeAttribute("disabled");
-    statusText.innerHTML = "待機中 <span class='anonym-info'>(匿名)</span>";
 
-    // Global Audio Setup for autoplay bypass
-    window.hotaruAudio = new Audio('hotaru-piano.mp3');
+    // Global Audio Setup for autoplay bypass. The source is the theme's
+    // fingerprinted track, set on the first swap and preloaded so the
+    // music starts from the cache when the room triggers.
+    window.hotaruAudio = new Audio();
+    window.hotaruAudio.preload = "auto";
     window.hotaruAudio.loop = true;
 
+    // Start the ending music when the server marks the room as triggered,
+    // and stop it again while the room is snoozed. The theme picks the
+    // track; themes without one stay silent.
+    document.body.addEventListener("htmx:afterSwap", () => {
+        const gauge = document.getElementById("gauge-container");
+        if (!gauge) return;
+        const theme = document.getElementById("theme");
+        const audio = theme ? theme.dataset.audio : "hotaru-piano.mp3";
+        if (audio && window.hotaruAudio.src !== new URL(audio, document.baseURI).href) {
+            window.hotaruAudio.pause();
+            window.hotaruAudio.src = audio;
+        }
+        if (!audio) {
+            window.hotaruAudio.pause();
+        } else if (gauge.dataset.triggered === "true" && window.hotaruAudio.paused) {
+            window.hotaruAudio.play().catch(e => console.warn("Audio play failed:", e));
+        } else if (gauge.dataset.triggered !== "true" && !window.hotaruAudio.paused) {
+            window.hotaruAudio.pause();
+        }
+    });
+
     // Handle audio autoplay policy workaround
     btn.addEventListener('click', () => {
         // Unlock with dummy play